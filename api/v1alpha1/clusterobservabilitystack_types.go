@@ -0,0 +1,108 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterNodeExporterSpec defines the configuration for the shared node-exporter
+type ClusterNodeExporterSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=false
+	Enabled bool `json:"enabled"`
+
+	// +kubebuilder:validation:Optional
+	Resources ResourceRequirements `json:"resources,omitempty"`
+
+	// Version of the node-exporter image, from the operator's supported
+	// versions. Empty deploys the operator's default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^v?[0-9]+\.[0-9]+\.[0-9]+$`
+	Version string `json:"version,omitempty"`
+
+	ComponentManagement `json:",inline"`
+	PodPlacement        `json:",inline"`
+	PodSecurity         `json:",inline"`
+}
+
+// ClusterObservabilityStackSpec defines the desired state of ClusterObservabilityStack
+type ClusterObservabilityStackSpec struct {
+	// Namespace the shared collectors run in. It must exist.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="namespace is immutable"
+	Namespace string `json:"namespace"`
+
+	// Namespaces whose ObservabilityStacks may reference this one. The
+	// shared Promtail pushes the logs of every namespace to their Loki, so
+	// only list namespaces trusted with all of them. Empty admits no stack.
+	// +kubebuilder:validation:Optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// Sizing profile for collectors without explicit resources
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=small
+	Profile ResourceProfile `json:"profile,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	NodeExporter ClusterNodeExporterSpec `json:"nodeExporter,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	KubeStateMetrics KubeStateMetricsSpec `json:"kubeStateMetrics,omitempty"`
+
+	// Promtail ships logs to the Loki of every stack referencing this one
+	// +kubebuilder:validation:Optional
+	Promtail PromtailSpec `json:"promtail,omitempty"`
+}
+
+// ClusterObservabilityStackStatus defines the observed state of ClusterObservabilityStack
+type ClusterObservabilityStackStatus struct {
+	// Conditions represent the latest available observations
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Stacks referencing this one from an allowed namespace, as
+	// namespace/name
+	Stacks []string `json:"stacks,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+
+// ClusterObservabilityStack is the Schema for the clusterobservabilitystacks API.
+// It runs the per-cluster collectors once for all the ObservabilityStacks
+// that reference it.
+type ClusterObservabilityStack struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterObservabilityStackSpec   `json:"spec,omitempty"`
+	Status ClusterObservabilityStackStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterObservabilityStackList contains a list of ClusterObservabilityStack
+type ClusterObservabilityStackList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterObservabilityStack `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterObservabilityStack{}, &ClusterObservabilityStackList{})
+}
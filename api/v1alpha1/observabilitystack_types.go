@@ -19,6 +19,7 @@ package v1alpha1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // NodeExporterSpec defines the configuration for node-exporter
@@ -26,9 +27,57 @@ type NodeExporterSpec struct {
 	Enabled bool `json:"enabled"`
 }
 
+// KubeStateMetricsResource is a resource kube-state-metrics is granted to
+// report on
+// +kubebuilder:validation:Enum=configmaps;cronjobs;daemonsets;deployments;endpoints;ingresses;jobs;limitranges;namespaces;nodes;persistentvolumeclaims;persistentvolumes;pods;replicasets;replicationcontrollers;resourcequotas;secrets;services;statefulsets;storageclasses
+type KubeStateMetricsResource string
+
 // KubeStateMetricsSpec defines the configuration for kube-state-metrics
+// +kubebuilder:validation:XValidation:rule="!has(self.resourceAllowlist) || !has(self.resourceDenylist)",message="set at most one of resourceAllowlist and resourceDenylist"
 type KubeStateMetricsSpec struct {
 	Enabled bool `json:"enabled"`
+
+	// Resources to report on. Empty reports on every resource
+	// kube-state-metrics is granted; the grants follow the list.
+	// +kubebuilder:validation:Optional
+	// +listType=set
+	ResourceAllowlist []KubeStateMetricsResource `json:"resourceAllowlist,omitempty"`
+
+	// Resources not to report on, nor to grant
+	// +kubebuilder:validation:Optional
+	// +listType=set
+	ResourceDenylist []KubeStateMetricsResource `json:"resourceDenylist,omitempty"`
+
+	// Kubernetes labels exposed as metric labels, by resource: pods: [app,
+	// team]. "*" exposes every label of the resource.
+	// +kubebuilder:validation:Optional
+	MetricLabelsAllowlist map[string][]string `json:"metricLabelsAllowlist,omitempty"`
+
+	// Custom resource state configuration of kube-state-metrics, a
+	// CustomResourceStateMetrics document reporting on custom resources.
+	// kube-state-metrics is granted to list and watch the kinds it names.
+	// +kubebuilder:validation:Optional
+	CustomResourceStateConfig string `json:"customResourceStateConfig,omitempty"`
+
+	// Number of shards splitting the objects between them. More than one
+	// runs a StatefulSet whose pods each report on their share.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	Shards *int32 `json:"shards,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Resources ResourceRequirements `json:"resources,omitempty"`
+
+	// Version of the component's image, from the operator's supported
+	// versions. Empty deploys the operator's default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^v?[0-9]+\.[0-9]+\.[0-9]+$`
+	Version string `json:"version,omitempty"`
+
+	ComponentManagement `json:",inline"`
+	PodPlacement        `json:",inline"`
+	PodSecurity         `json:",inline"`
 }
 
 // PrometheusSpec defines the configuration for Prometheus
@@ -40,17 +89,157 @@ type PrometheusSpec struct {
 
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:Pattern=`^[0-9]+[GM]i$`
-	Storage string `json:"storage,omitempty"`
+	Storage         string `json:"storage,omitempty"`
+	StorageSettings `json:",inline"`
 
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:Pattern=`^[0-9]+[hdw]$`
 	Retention string `json:"retention,omitempty"`
 
+	// +kubebuilder:validation:Optional
+	Resources ResourceRequirements `json:"resources,omitempty"`
+
 	NodeExporter     NodeExporterSpec     `json:"nodeExporter,omitempty"`
 	KubeStateMetrics KubeStateMetricsSpec `json:"kubeStateMetrics,omitempty"`
+
+	// Recording rules evaluated by Prometheus
+	// +kubebuilder:validation:Optional
+	Rules PrometheusRulesSpec `json:"rules,omitempty"`
+
+	// Thanos sidecar, Query and Store Gateway for global queries and
+	// long-term storage of metrics
+	// +kubebuilder:validation:Optional
+	Thanos ThanosSpec `json:"thanos,omitempty"`
+
+	// Version of the component's image, from the operator's supported
+	// versions. Empty deploys the operator's default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^v?[0-9]+\.[0-9]+\.[0-9]+$`
+	Version string `json:"version,omitempty"`
+
+	ComponentManagement `json:",inline"`
+	PodPlacement        `json:",inline"`
+	PodSecurity         `json:",inline"`
+}
+
+// ThanosSpec runs a Thanos sidecar next to Prometheus. With an object
+// storage configuration the sidecar uploads every completed TSDB block, and
+// the Store Gateway serves the uploaded blocks back to Thanos Query.
+// +kubebuilder:validation:XValidation:rule="!has(self.storeGateway) || !self.storeGateway.enabled || has(self.objectStorageConfig)",message="storeGateway needs objectStorageConfig"
+type ThanosSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=false
+	Enabled bool `json:"enabled"`
+
+	// Key of a Secret in the stack's namespace holding a Thanos object
+	// storage configuration (objstore.yml). Without it the sidecar uploads
+	// nothing and only serves the data Prometheus holds.
+	// +kubebuilder:validation:Optional
+	ObjectStorageConfig *corev1.SecretKeySelector `json:"objectStorageConfig,omitempty"`
+
+	// Version of the Thanos image, from the operator's supported versions.
+	// Empty deploys the operator's default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^v?[0-9]+\.[0-9]+\.[0-9]+$`
+	Version string `json:"version,omitempty"`
+
+	// Resources of the sidecar container
+	// +kubebuilder:validation:Optional
+	Resources ResourceRequirements `json:"resources,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Query ThanosQuerySpec `json:"query,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	StoreGateway ThanosStoreGatewaySpec `json:"storeGateway,omitempty"`
+}
+
+// ThanosQuerySpec configures Thanos Query, which answers PromQL queries from
+// the sidecar and the Store Gateway together
+type ThanosQuerySpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=false
+	Enabled bool `json:"enabled"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Resources ResourceRequirements `json:"resources,omitempty"`
+
+	PodPlacement `json:",inline"`
+	PodSecurity  `json:",inline"`
+}
+
+// ThanosStoreGatewaySpec configures the Thanos Store Gateway, which serves
+// the blocks in object storage
+type ThanosStoreGatewaySpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=false
+	Enabled bool `json:"enabled"`
+
+	// Size of the volume caching block indexes
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+[GM]i$`
+	Storage         string `json:"storage,omitempty"`
+	StorageSettings `json:",inline"`
+
+	// +kubebuilder:validation:Optional
+	Resources ResourceRequirements `json:"resources,omitempty"`
+
+	PodPlacement `json:",inline"`
+	PodSecurity  `json:",inline"`
+}
+
+// PrometheusRulesSpec lists the rule groups Prometheus evaluates, given in the
+// spec or in labelled ConfigMaps
+type PrometheusRulesSpec struct {
+	// Recording rule groups
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	Groups []PrometheusRuleGroup `json:"groups,omitempty"`
+
+	// Selects the ConfigMaps in the stack's namespace that hold rule files.
+	// Every key ending in .yaml or .yml is a file of Prometheus rule groups.
+	// No selector selects no ConfigMaps.
+	// +kubebuilder:validation:Optional
+	RuleSelector *metav1.LabelSelector `json:"ruleSelector,omitempty"`
+}
+
+// PrometheusRuleGroup is a group of recording rules evaluated together
+type PrometheusRuleGroup struct {
+	Name string `json:"name"`
+
+	// How often the group is evaluated. Defaults to the global evaluation
+	// interval.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^([0-9]+(ms|s|m|h|d|w|y))+$`
+	Interval string `json:"interval,omitempty"`
+
+	// +kubebuilder:validation:MinItems=1
+	Rules []PrometheusRecordingRule `json:"rules"`
+}
+
+// PrometheusRecordingRule stores the result of a PromQL expression as a new
+// series
+type PrometheusRecordingRule struct {
+	// Name of the recorded metric
+	Record string `json:"record"`
+
+	// PromQL expression
+	Expr string `json:"expr"`
+
+	// Labels added to the recorded series
+	// +kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // GrafanaSpec defines the configuration for Grafana
+// +kubebuilder:validation:XValidation:rule="!has(self.replicas) || self.replicas <= 1 || has(self.database)",message="more than one Grafana replica needs a database"
+// +kubebuilder:validation:XValidation:rule="!has(self.pluginBundle) || !has(self.plugins) || self.plugins.all(p, !has(p.url))",message="plugins installed from a pluginBundle cannot have a url"
 type GrafanaSpec struct {
 	// Whether Grafana is enabled
 	Enabled bool `json:"enabled"`
@@ -60,11 +249,571 @@ type GrafanaSpec struct {
 	ServiceType string `json:"serviceType,omitempty"`
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:Pattern=`^[0-9]+[GM]i$`
-	Storage string `json:"storage,omitempty"`
+	Storage         string `json:"storage,omitempty"`
+	StorageSettings `json:",inline"`
 	// Default dashboards to create
 	DefaultDashboards bool `json:"defaultDashboards,omitempty"`
 	// Additional datasources to configure
 	AdditionalDataSources []GrafanaDataSource `json:"additionalDataSources,omitempty"`
+	// +kubebuilder:validation:Optional
+	Resources ResourceRequirements `json:"resources,omitempty"`
+
+	// Number of Grafana pods. More than one needs a database.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// External database replacing Grafana's built-in SQLite, so that
+	// dashboards, users, sessions and alert state outlive the pods
+	// +kubebuilder:validation:Optional
+	Database *GrafanaDatabaseSpec `json:"database,omitempty"`
+
+	// Identity providers users sign in with, besides the admin user
+	// +kubebuilder:validation:Optional
+	Auth GrafanaAuthSpec `json:"auth,omitempty"`
+
+	// Organizations the operator creates through Grafana's HTTP API, with
+	// their teams, folders and service accounts. Removing an entry leaves
+	// what was created in Grafana.
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	Organizations []GrafanaOrganization `json:"organizations,omitempty"`
+
+	// Plugins installed when Grafana's pods start. Changing the list rolls
+	// the pods, which then hold exactly the plugins listed.
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=id
+	Plugins []GrafanaPlugin `json:"plugins,omitempty"`
+
+	// Volume holding the plugins' archives, installed from there instead of
+	// the internet
+	// +kubebuilder:validation:Optional
+	PluginBundle *GrafanaPluginBundle `json:"pluginBundle,omitempty"`
+
+	// Unified alerting resources provisioned in Grafana's main organization
+	// +kubebuilder:validation:Optional
+	Alerting GrafanaAlertingSpec `json:"alerting,omitempty"`
+
+	// Version of the component's image, from the operator's supported
+	// versions. Empty deploys the operator's default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^v?[0-9]+\.[0-9]+\.[0-9]+$`
+	Version string `json:"version,omitempty"`
+
+	ComponentManagement `json:",inline"`
+	PodPlacement        `json:",inline"`
+	PodSecurity         `json:",inline"`
+}
+
+// GrafanaDatabaseSpec points Grafana at a PostgreSQL or MySQL database
+type GrafanaDatabaseSpec struct {
+	// +kubebuilder:validation:Enum=postgres;mysql
+	Type string `json:"type"`
+
+	// Address of the database server as host:port
+	// +kubebuilder:validation:MinLength=1
+	Host string `json:"host"`
+
+	// Name of the database
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=grafana
+	Name string `json:"name,omitempty"`
+
+	// Secret in the stack's namespace holding the "username" and "password"
+	// of the database user, such as a kubernetes.io/basic-auth Secret
+	// +kubebuilder:validation:MinLength=1
+	CredentialsSecret string `json:"credentialsSecret"`
+
+	// TLS mode of the connection: disable, require or verify-full for
+	// postgres, and false, true or skip-verify for mysql. Empty keeps
+	// Grafana's default.
+	// +kubebuilder:validation:Optional
+	SSLMode string `json:"sslMode,omitempty"`
+}
+
+// GrafanaOrganization is a Grafana organization with its teams, folders and
+// service accounts. The stack's data sources are only provisioned to the main
+// organization, not to these.
+type GrafanaOrganization struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	Teams []GrafanaTeam `json:"teams,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=uid
+	Folders []GrafanaFolder `json:"folders,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	ServiceAccounts []GrafanaServiceAccount `json:"serviceAccounts,omitempty"`
+}
+
+// GrafanaTeam is a team of an organization. Members join it in Grafana or
+// through team sync.
+type GrafanaTeam struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Optional
+	Email string `json:"email,omitempty"`
+}
+
+// GrafanaFolder is a dashboard folder of an organization
+type GrafanaFolder struct {
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-]{1,40}$`
+	UID string `json:"uid"`
+
+	// +kubebuilder:validation:MinLength=1
+	Title string `json:"title"`
+
+	// Replaces the folder's permissions. Empty keeps Grafana's defaults,
+	// which let Viewers view and Editors edit.
+	// +kubebuilder:validation:Optional
+	Permissions []GrafanaFolderPermission `json:"permissions,omitempty"`
+}
+
+// GrafanaFolderPermission grants a team or an org role a permission on a
+// folder
+// +kubebuilder:validation:XValidation:rule="has(self.team) != has(self.role)",message="set exactly one of team and role"
+type GrafanaFolderPermission struct {
+	// Team of the organization
+	// +kubebuilder:validation:Optional
+	Team string `json:"team,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Viewer;Editor
+	Role string `json:"role,omitempty"`
+
+	// +kubebuilder:validation:Enum=View;Edit;Admin
+	Permission string `json:"permission"`
+}
+
+// GrafanaServiceAccount is a service account of an organization, for
+// automation such as publishing dashboards
+type GrafanaServiceAccount struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Viewer;Editor;Admin
+	// +kubebuilder:default=Viewer
+	Role string `json:"role,omitempty"`
+
+	// Secret in the stack's namespace the operator writes a token of the
+	// service account to, under the key "token". A new token is created
+	// whenever the Secret is missing. An existing Secret the stack does not
+	// own is refused.
+	// +kubebuilder:validation:Optional
+	TokenSecret string `json:"tokenSecret,omitempty"`
+}
+
+// GrafanaPlugin is a plugin from Grafana's catalog, or a plugin archive
+// downloaded from a URL
+// +kubebuilder:validation:XValidation:rule="!(has(self.version) && has(self.url))",message="set at most one of version and url"
+type GrafanaPlugin struct {
+	// ID of the plugin, such as grafana-piechart-panel
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`
+	ID string `json:"id"`
+
+	// Version from the catalog. Empty installs the latest one.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9a-zA-Z][0-9a-zA-Z.+-]*$`
+	Version string `json:"version,omitempty"`
+
+	// URL of the plugin's zip archive, installed instead of the catalog's
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url,omitempty"`
+}
+
+// GrafanaPluginBundle installs the plugins from archives on a volume, for
+// clusters without access to the internet
+type GrafanaPluginBundle struct {
+	// PersistentVolumeClaim in the stack's namespace holding an archive per
+	// plugin, named <id>-<version>.zip, or <id>.zip for plugins without a
+	// version. Grafana's pods mount it read-only.
+	// +kubebuilder:validation:MinLength=1
+	ClaimName string `json:"claimName"`
+}
+
+// GrafanaAlertingSpec lists the unified alerting resources Grafana provisions
+// from files when it starts. Provisioned resources cannot be edited in
+// Grafana's UI.
+type GrafanaAlertingSpec struct {
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	ContactPoints []GrafanaContactPoint `json:"contactPoints,omitempty"`
+
+	// Notification policy tree, replacing Grafana's default policy
+	// +kubebuilder:validation:Optional
+	Policy *GrafanaNotificationPolicy `json:"policy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	MuteTimings []GrafanaMuteTiming `json:"muteTimings,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	RuleGroups []GrafanaAlertRuleGroup `json:"ruleGroups,omitempty"`
+}
+
+// GrafanaContactPoint is a named set of integrations notifications are sent
+// through
+type GrafanaContactPoint struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:MinItems=1
+	Receivers []GrafanaReceiver `json:"receivers"`
+}
+
+// GrafanaReceiver is an integration of a contact point
+type GrafanaReceiver struct {
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-]{1,40}$`
+	UID string `json:"uid"`
+
+	// Integration, such as email, slack, pagerduty or webhook
+	// +kubebuilder:validation:MinLength=1
+	Type string `json:"type"`
+
+	// Settings of the integration, as Grafana documents them for its type
+	// +kubebuilder:validation:Optional
+	Settings *runtime.RawExtension `json:"settings,omitempty"`
+
+	// Settings read from Secrets in the stack's namespace, such as a Slack
+	// URL or a PagerDuty integration key, by setting name
+	// +kubebuilder:validation:Optional
+	SecureSettings map[string]corev1.SecretKeySelector `json:"secureSettings,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	DisableResolveMessage bool `json:"disableResolveMessage,omitempty"`
+}
+
+// GrafanaNotificationPolicy is the root of the notification policy tree. Its
+// contact point receives the alerts no route matches.
+type GrafanaNotificationPolicy struct {
+	// Contact point of the policy
+	// +kubebuilder:validation:MinLength=1
+	Receiver string `json:"receiver"`
+
+	GrafanaNotificationTiming `json:",inline"`
+
+	// Routes alerts are matched against in order
+	// +kubebuilder:validation:Optional
+	Routes []GrafanaNotificationRoute `json:"routes,omitempty"`
+}
+
+// GrafanaNotificationRoute sends the alerts it matches to a contact point
+type GrafanaNotificationRoute struct {
+	// Contact point of the route. Empty inherits the policy's.
+	// +kubebuilder:validation:Optional
+	Receiver string `json:"receiver,omitempty"`
+
+	// Label matchers an alert must all match
+	// +kubebuilder:validation:Optional
+	Matchers []GrafanaMatcher `json:"matchers,omitempty"`
+
+	GrafanaNotificationTiming `json:",inline"`
+
+	// Mute timings during which the route sends nothing
+	// +kubebuilder:validation:Optional
+	MuteTimings []string `json:"muteTimings,omitempty"`
+
+	// Keep matching the following routes after this one
+	// +kubebuilder:validation:Optional
+	Continue bool `json:"continue,omitempty"`
+}
+
+// GrafanaNotificationTiming groups alerts into notifications and paces them.
+// Empty fields inherit the parent policy's.
+type GrafanaNotificationTiming struct {
+	// Labels alerts are grouped by into a notification; "..." groups by
+	// every label
+	// +kubebuilder:validation:Optional
+	GroupBy []string `json:"groupBy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^([0-9]+(ms|s|m|h|d|w|y))+$`
+	GroupWait string `json:"groupWait,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^([0-9]+(ms|s|m|h|d|w|y))+$`
+	GroupInterval string `json:"groupInterval,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^([0-9]+(ms|s|m|h|d|w|y))+$`
+	RepeatInterval string `json:"repeatInterval,omitempty"`
+}
+
+// GrafanaMatcher matches the value of an alert label
+type GrafanaMatcher struct {
+	// +kubebuilder:validation:MinLength=1
+	Label string `json:"label"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum="=";"!=";"=~";"!~"
+	// +kubebuilder:default="="
+	Operator string `json:"operator,omitempty"`
+
+	Value string `json:"value"`
+}
+
+// GrafanaMuteTiming is a named set of recurring periods
+type GrafanaMuteTiming struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:MinItems=1
+	TimeIntervals []GrafanaTimeInterval `json:"timeIntervals"`
+}
+
+// GrafanaTimeInterval is a recurring period. Ranges are written as
+// "<start>:<end>", such as "monday:friday" or "1:7". Empty fields match
+// any time.
+type GrafanaTimeInterval struct {
+	// +kubebuilder:validation:Optional
+	Times []GrafanaTimeRange `json:"times,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Weekdays []string `json:"weekdays,omitempty"`
+
+	// Days of the month; negative days count from the end of the month
+	// +kubebuilder:validation:Optional
+	DaysOfMonth []string `json:"daysOfMonth,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Months []string `json:"months,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Years []string `json:"years,omitempty"`
+
+	// Time zone of the interval, such as Europe/Berlin. Defaults to UTC.
+	// +kubebuilder:validation:Optional
+	Location string `json:"location,omitempty"`
+}
+
+// GrafanaTimeRange is a range of the day
+type GrafanaTimeRange struct {
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	StartTime string `json:"startTime"`
+
+	// +kubebuilder:validation:Pattern=`^(([01][0-9]|2[0-3]):[0-5][0-9]|24:00)$`
+	EndTime string `json:"endTime"`
+}
+
+// GrafanaAlertRuleGroup is a group of alert rules evaluated together
+type GrafanaAlertRuleGroup struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Title of the folder the rules are stored in, created if missing
+	// +kubebuilder:validation:MinLength=1
+	Folder string `json:"folder"`
+
+	// How often the rules are evaluated, a multiple of 10s
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^([0-9]+(s|m|h))+$`
+	// +kubebuilder:default="1m"
+	Interval string `json:"interval,omitempty"`
+
+	// +kubebuilder:validation:MinItems=1
+	Rules []GrafanaAlertRule `json:"rules"`
+}
+
+// GrafanaAlertRule fires while the last value of a query's series compares
+// to a threshold
+type GrafanaAlertRule struct {
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-]{1,40}$`
+	UID string `json:"uid"`
+
+	// +kubebuilder:validation:MinLength=1
+	Title string `json:"title"`
+
+	// Data source of the stack the query runs on
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=prometheus;loki
+	// +kubebuilder:default=prometheus
+	DataSource string `json:"dataSource,omitempty"`
+
+	// UID of another data source the query runs on instead, such as one of
+	// additionalDataSources
+	// +kubebuilder:validation:Optional
+	DataSourceUID string `json:"dataSourceUID,omitempty"`
+
+	// PromQL or LogQL query
+	// +kubebuilder:validation:MinLength=1
+	Expr string `json:"expr"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=gt;lt
+	// +kubebuilder:default=gt
+	Operator string `json:"operator,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^-?[0-9]+(\.[0-9]+)?$`
+	// +kubebuilder:default="0"
+	Threshold string `json:"threshold,omitempty"`
+
+	// How long the condition holds before the rule fires
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^([0-9]+(s|m|h))+$`
+	For string `json:"for,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=NoData;Alerting;OK
+	// +kubebuilder:default=NoData
+	NoDataState string `json:"noDataState,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Error;Alerting;OK
+	// +kubebuilder:default=Error
+	ExecErrState string `json:"execErrState,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations such as summary, which may use {{ $labels.<name> }} and
+	// {{ $values.B }}, the last value of the query
+	// +kubebuilder:validation:Optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// GrafanaAuthSpec configures how users sign in to Grafana. Any number of
+// providers can be enabled together.
+type GrafanaAuthSpec struct {
+	// External URL Grafana is reached at, which OAuth providers redirect
+	// back to
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^https?://.*`
+	RootURL string `json:"rootURL,omitempty"`
+
+	// Hide the login form of local users, leaving sign-in to the providers
+	// +kubebuilder:validation:Optional
+	DisableLoginForm bool `json:"disableLoginForm,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	GenericOAuth *GrafanaOAuthSpec `json:"genericOAuth,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	LDAP *GrafanaLDAPSpec `json:"ldap,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Proxy *GrafanaAuthProxySpec `json:"proxy,omitempty"`
+}
+
+// GrafanaOAuthSpec signs users in with a generic OAuth 2.0 or OpenID Connect
+// provider
+type GrafanaOAuthSpec struct {
+	// Name of the provider on the login page
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=OAuth
+	Name string `json:"name,omitempty"`
+
+	// +kubebuilder:validation:MinLength=1
+	ClientID string `json:"clientID"`
+
+	// Key of a Secret in the stack's namespace holding the client secret
+	ClientSecret corev1.SecretKeySelector `json:"clientSecret"`
+
+	// +kubebuilder:validation:Pattern=`^https?://.*`
+	AuthURL string `json:"authURL"`
+
+	// +kubebuilder:validation:Pattern=`^https?://.*`
+	TokenURL string `json:"tokenURL"`
+
+	// URL of the user info endpoint
+	// +kubebuilder:validation:Pattern=`^https?://.*`
+	APIURL string `json:"apiURL"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default={openid,email,profile}
+	Scopes []string `json:"scopes,omitempty"`
+
+	// JMESPath of the user's groups in the user info or ID token
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=groups
+	GroupsAttributePath string `json:"groupsAttributePath,omitempty"`
+
+	// Org roles granted to the members of groups. The first mapping whose
+	// group the user belongs to applies.
+	// +kubebuilder:validation:Optional
+	RoleMappings []GrafanaRoleMapping `json:"roleMappings,omitempty"`
+
+	// Org role of users in none of the mapped groups
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Viewer;Editor;Admin
+	// +kubebuilder:default=Viewer
+	DefaultRole string `json:"defaultRole,omitempty"`
+
+	// Create users signing in for the first time
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=true
+	AllowSignUp *bool `json:"allowSignUp,omitempty"`
+}
+
+// GrafanaRoleMapping grants an org role to the members of a group
+type GrafanaRoleMapping struct {
+	// +kubebuilder:validation:MinLength=1
+	Group string `json:"group"`
+
+	// GrafanaAdmin also makes the user a server administrator
+	// +kubebuilder:validation:Enum=Viewer;Editor;Admin;GrafanaAdmin
+	Role string `json:"role"`
+}
+
+// GrafanaLDAPSpec signs users in against LDAP servers
+type GrafanaLDAPSpec struct {
+	// Key of a Secret in the stack's namespace holding Grafana's ldap.toml,
+	// with the servers, bind credentials and group_mappings to org roles
+	Config corev1.SecretKeySelector `json:"config"`
+
+	// Create users signing in for the first time
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=true
+	AllowSignUp *bool `json:"allowSignUp,omitempty"`
+}
+
+// GrafanaAuthProxySpec trusts the user a reverse proxy in front of Grafana
+// authenticated
+// +kubebuilder:validation:XValidation:rule="has(self.whitelist) && size(self.whitelist) > 0",message="authProxy needs a whitelist of the proxies' addresses"
+type GrafanaAuthProxySpec struct {
+	// Header carrying the user
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=X-WEBAUTH-USER
+	HeaderName string `json:"headerName,omitempty"`
+
+	// Whether the header carries the user's login or email
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=username;email
+	// +kubebuilder:default=username
+	HeaderProperty string `json:"headerProperty,omitempty"`
+
+	// Header carrying the user's org role: Viewer, Editor or Admin. Empty
+	// leaves new users with Grafana's default role.
+	// +kubebuilder:validation:Optional
+	RoleHeader string `json:"roleHeader,omitempty"`
+
+	// Addresses or CIDRs of the proxies. Requests from anywhere else are
+	// refused, so clients cannot sign in by setting the header themselves.
+	// +kubebuilder:validation:Optional
+	Whitelist []string `json:"whitelist,omitempty"`
+
+	// Create users signing in for the first time
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=true
+	AutoSignUp *bool `json:"autoSignUp,omitempty"`
 }
 
 // GrafanaDataSource defines a data source configuration
@@ -84,21 +833,92 @@ type GrafanaDataSource struct {
 	IsDefault bool `json:"isDefault,omitempty"`
 }
 
+// PodPlacement defines the scheduling constraints applied to a component's pods
+type PodPlacement struct {
+	// Node labels the pods must match to be scheduled
+	// +kubebuilder:validation:Optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Spread constraints without a labelSelector select the component's own pods
+	// +kubebuilder:validation:Optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+}
+
+// StorageSettings configures the volumes holding a component's data. Changes
+// apply to volume claims created afterwards; existing claims keep their class
+// and access modes.
+type StorageSettings struct {
+	// StorageClass of the component's volume claims. Empty uses the cluster's
+	// default StorageClass.
+	// +kubebuilder:validation:Optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// Access modes of the component's volume claims. Defaults to
+	// ReadWriteOnce.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:items:Enum=ReadWriteOnce;ReadOnlyMany;ReadWriteMany;ReadWriteOncePod
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+
+	// Keep the data in an emptyDir instead of a volume claim, for clusters
+	// without persistent storage. The data is lost with the pod; storage, if
+	// set, limits the size of the emptyDir.
+	// +kubebuilder:validation:Optional
+	EmptyDir bool `json:"emptyDir,omitempty"`
+}
+
+// PodSecurity replaces the restricted security settings the operator applies
+// to a component's pods. Leave it empty to stay compatible with the
+// restricted Pod Security Standard.
+type PodSecurity struct {
+	// Replaces the generated pod-level security context
+	// +kubebuilder:validation:Optional
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// Replaces the generated security context of every container
+	// +kubebuilder:validation:Optional
+	ContainerSecurityContext *corev1.SecurityContext `json:"containerSecurityContext,omitempty"`
+}
+
+// ComponentManagement selects whether the operator manages a component
+type ComponentManagement struct {
+	// Leave the component's resources to manual edits; the operator only
+	// reports their readiness
+	// +kubebuilder:validation:Optional
+	Unmanaged bool `json:"unmanaged,omitempty"`
+}
+
+// ResourceProfile selects the default requests and limits of every component
+// +kubebuilder:validation:Enum=small;medium;large
+type ResourceProfile string
+
+const (
+	ResourceProfileSmall  ResourceProfile = "small"
+	ResourceProfileMedium ResourceProfile = "medium"
+	ResourceProfileLarge  ResourceProfile = "large"
+)
+
+// ResourceRequirements defines the CPU and memory of a component.
+// Fields left empty fall back to the stack's resource profile.
 type ResourceRequirements struct {
 	// +kubebuilder:validation:Optional
-	// +kubebuilder:default="100m"
 	CPURequest string `json:"cpuRequest,omitempty"`
 
 	// +kubebuilder:validation:Optional
-	// +kubebuilder:default="128Mi"
 	MemoryRequest string `json:"memoryRequest,omitempty"`
 
 	// +kubebuilder:validation:Optional
-	// +kubebuilder:default="200m"
 	CPULimit string `json:"cpuLimit,omitempty"`
 
 	// +kubebuilder:validation:Optional
-	// +kubebuilder:default="256Mi"
 	MemoryLimit string `json:"memoryLimit,omitempty"`
 }
 
@@ -110,31 +930,242 @@ type PromtailSpec struct {
 	// +kubebuilder:validation:Optional
 	Resources ResourceRequirements `json:"resources,omitempty"`
 
-	// +kubebuilder:validation:Optional
-	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
-
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default=true
 	ScrapeKubernetesLogs bool `json:"scrapeKubernetesLogs,omitempty"`
 
 	// +kubebuilder:validation:Optional
 	ExtraArgs []string `json:"extraArgs,omitempty"`
+
+	// Version of the component's image, from the operator's supported
+	// versions. Empty deploys the operator's default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^v?[0-9]+\.[0-9]+\.[0-9]+$`
+	Version string `json:"version,omitempty"`
+
+	ComponentManagement `json:",inline"`
+	PodPlacement        `json:",inline"`
+	PodSecurity         `json:",inline"`
 }
 
 // ObservabilityStackSpec defines the desired state of ObservabilityStack
 type ObservabilityStackSpec struct {
+	// Sizing profile for components without explicit resources
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=small
+	Profile ResourceProfile `json:"profile,omitempty"`
+
+	// Stop changing the stack's resources, as the
+	// monitoring.example.com/paused: "true" annotation does. Status is
+	// still updated.
+	// +kubebuilder:validation:Optional
+	Paused bool `json:"paused,omitempty"`
+
 	Prometheus PrometheusSpec `json:"prometheus,omitempty"`
 	Grafana    GrafanaSpec    `json:"grafana,omitempty"`
 	// +kubebuilder:validation:Optional
 	Loki     LokiSpec     `json:"loki,omitempty"`
 	Promtail PromtailSpec `json:"promtail,omitempty"`
 	Tempo    TempoSpec    `json:"tempo,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	NetworkPolicy NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	TLS TLSSpec `json:"tls,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	MultiTenancy MultiTenancySpec `json:"multiTenancy,omitempty"`
+
+	// ClusterObservabilityStack whose shared node-exporter, kube-state-metrics
+	// and Promtail feed this stack's Prometheus and Loki. The stack then runs
+	// no kube-state-metrics or Promtail of its own.
+	// +kubebuilder:validation:Optional
+	ClusterStackRef string `json:"clusterStackRef,omitempty"`
+}
+
+// MultiTenancySpec enables authentication in Loki and Tempo so that every
+// request carries a tenant in the X-Scope-OrgID header
+type MultiTenancySpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=false
+	Enabled bool `json:"enabled"`
+
+	// Tenant of logs from namespaces no tenant claims, and of Grafana's
+	// default data sources. "fake" is the tenant Loki and Tempo use when
+	// authentication is off, so existing data stays visible.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=fake
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.-]+$`
+	DefaultTenant string `json:"defaultTenant,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=name
+	Tenants []TenantSpec `json:"tenants,omitempty"`
+}
+
+// TenantSpec defines a tenant and the limits that override Loki's and
+// Tempo's defaults for it
+type TenantSpec struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.-]+$`
+	Name string `json:"name"`
+
+	// Namespaces whose logs Promtail ships under this tenant
+	// +kubebuilder:validation:Optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Per-second ingestion rate of logs and traces, in MB
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	IngestionRateMB *int32 `json:"ingestionRateMB,omitempty"`
+
+	// Ingestion burst size of logs and traces, in MB
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	IngestionBurstSizeMB *int32 `json:"ingestionBurstSizeMB,omitempty"`
+
+	// Retention of the tenant's logs and traces, in days
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	RetentionDays *int32 `json:"retentionDays,omitempty"`
+
+	// Maximum number of active log streams
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	MaxStreams *int32 `json:"maxStreams,omitempty"`
+
+	// Maximum number of traces being ingested at once
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	MaxTraces *int32 `json:"maxTraces,omitempty"`
+}
+
+// NetworkPolicySpec restricts traffic to the stack's pods to the data flows
+// between its components
+type NetworkPolicySpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=false
+	Enabled bool `json:"enabled"`
+
+	// Namespaces, besides the stack's own, allowed to reach Grafana
+	// +kubebuilder:validation:Optional
+	GrafanaAllowedNamespaces []string `json:"grafanaAllowedNamespaces,omitempty"`
+}
+
+// TLSProvider selects where serving certificates come from
+// +kubebuilder:validation:Enum=SelfSigned;CertManager
+type TLSProvider string
+
+const (
+	// TLSProviderSelfSigned issues certificates from a CA the operator keeps in a Secret
+	TLSProviderSelfSigned TLSProvider = "SelfSigned"
+	// TLSProviderCertManager requests certificates from a cert-manager issuer
+	TLSProviderCertManager TLSProvider = "CertManager"
+)
+
+// TLSSpec serves Loki, Tempo, Prometheus and Grafana over HTTPS and makes the
+// other components trust their certificates
+// +kubebuilder:validation:XValidation:rule="!has(self.provider) || self.provider != 'CertManager' || has(self.issuerRef)",message="issuerRef is required with the CertManager provider"
+type TLSSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=false
+	Enabled bool `json:"enabled"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=SelfSigned
+	Provider TLSProvider `json:"provider,omitempty"`
+
+	// Issuer that signs the certificates with the CertManager provider. It
+	// must publish its CA in the ca.crt key of issued Secrets.
+	// +kubebuilder:validation:Optional
+	IssuerRef *IssuerReference `json:"issuerRef,omitempty"`
+}
+
+// IssuerReference names a cert-manager Issuer or ClusterIssuer
+type IssuerReference struct {
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	// +kubebuilder:default=Issuer
+	Kind string `json:"kind,omitempty"`
 }
 
 // ObservabilityStackStatus defines the observed state of ObservabilityStack
 type ObservabilityStackStatus struct {
 	// Conditions represent the latest available observations
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Versions of the stack's components
+	// +listType=map
+	// +listMapKey=name
+	Components []ComponentVersion `json:"components,omitempty"`
+
+	// Prometheus rule groups left out of the loaded rules because they are
+	// invalid
+	// +kubebuilder:validation:Optional
+	InvalidRuleGroups []InvalidRuleGroup `json:"invalidRuleGroups,omitempty"`
+}
+
+// InvalidRuleGroup reports a rule group that failed validation
+type InvalidRuleGroup struct {
+	// Where the group is defined: "spec", or "<configmap>/<key>" for a rule
+	// file
+	Source string `json:"source"`
+
+	// Name of the group. Empty when the whole file could not be parsed.
+	// +kubebuilder:validation:Optional
+	Group string `json:"group,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// UpgradePhase is the state of a component's version
+type UpgradePhase string
+
+const (
+	// UpgradePhaseCurrent means the component runs its desired version
+	UpgradePhaseCurrent UpgradePhase = "Current"
+	// UpgradePhaseWaiting means the upgrade waits for components upgraded before it
+	UpgradePhaseWaiting UpgradePhase = "Waiting"
+	// UpgradePhaseUpgrading means the desired version is rolling out
+	UpgradePhaseUpgrading UpgradePhase = "Upgrading"
+	// UpgradePhaseBlocked means the configuration is incompatible with the desired version
+	UpgradePhaseBlocked UpgradePhase = "Blocked"
+	// UpgradePhaseRolledBack means the desired version did not become ready
+	// and the previous version was restored
+	UpgradePhaseRolledBack UpgradePhase = "RolledBack"
+)
+
+// ComponentVersion reports the version a component runs and the one its
+// spec asks for
+type ComponentVersion struct {
+	Name string `json:"name"`
+
+	// Version the component's workload runs
+	// +kubebuilder:validation:Optional
+	Current string `json:"current,omitempty"`
+
+	// Version the spec asks for
+	// +kubebuilder:validation:Optional
+	Desired string `json:"desired,omitempty"`
+
+	// Version before the last upgrade, restored if the upgrade fails
+	// +kubebuilder:validation:Optional
+	Previous string `json:"previous,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Phase UpgradePhase `json:"phase,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+
+	// When the component entered its phase
+	// +kubebuilder:validation:Optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
 }
 
 type LokiSpec struct {
@@ -144,12 +1175,173 @@ type LokiSpec struct {
 
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default="10Gi"
-	Storage string `json:"storage,omitempty"`
+	Storage         string `json:"storage,omitempty"`
+	StorageSettings `json:",inline"`
 
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default=14
 	// +kubebuilder:validation:Minimum=1
 	RetentionDays int32 `json:"retentionDays,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Resources ResourceRequirements `json:"resources,omitempty"`
+
+	// Schema periods of the index, oldest first. Periods already in effect
+	// cannot be changed or removed, and new ones must start in the future.
+	// Empty keeps the schema Loki was deployed with.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:MaxItems=16
+	Schemas []LokiSchemaPeriod `json:"schemas,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Compactor LokiCompactorSpec `json:"compactor,omitempty"`
+
+	// Ingestion limits of every tenant, unless overridden per tenant
+	// +kubebuilder:validation:Optional
+	Limits LokiLimits `json:"limits,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Ruler LokiRulerSpec `json:"ruler,omitempty"`
+
+	// Version of the component's image, from the operator's supported
+	// versions. Empty deploys the operator's default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^v?[0-9]+\.[0-9]+\.[0-9]+$`
+	Version string `json:"version,omitempty"`
+
+	ComponentManagement `json:",inline"`
+	PodPlacement        `json:",inline"`
+	PodSecurity         `json:",inline"`
+}
+
+// LokiSchemaPeriod is the index schema Loki uses from a date on
+type LokiSchemaPeriod struct {
+	// Date the period takes effect, in UTC
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`
+	From string `json:"from"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=v11;v12;v13
+	// +kubebuilder:default=v13
+	Schema string `json:"schema,omitempty"`
+
+	// Index store; v13 needs tsdb
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=tsdb;boltdb-shipper
+	// +kubebuilder:default=tsdb
+	Store string `json:"store,omitempty"`
+
+	// Prefix of the index tables
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=index_
+	IndexPrefix string `json:"indexPrefix,omitempty"`
+
+	// Period of the index tables; tsdb and boltdb-shipper need 24h
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+h$`
+	// +kubebuilder:default="24h"
+	IndexPeriod string `json:"indexPeriod,omitempty"`
+}
+
+// LokiCompactorSpec configures the compactor, which also applies retention
+type LokiCompactorSpec struct {
+	// How often index files are compacted
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+(s|m|h)$`
+	// +kubebuilder:default="10m"
+	CompactionInterval string `json:"compactionInterval,omitempty"`
+
+	// Delete logs past their retention. Without it, retentionDays and the
+	// stream rules are not enforced.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=true
+	RetentionEnabled *bool `json:"retentionEnabled,omitempty"`
+
+	// Delay before chunks marked for deletion are removed
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+(s|m|h)$`
+	// +kubebuilder:default="2h"
+	RetentionDeleteDelay string `json:"retentionDeleteDelay,omitempty"`
+
+	// Retention of the streams matching a selector, in place of retentionDays
+	// +kubebuilder:validation:Optional
+	StreamRetention []LokiStreamRetention `json:"streamRetention,omitempty"`
+}
+
+// LokiStreamRetention keeps the streams matching a selector for a number of days
+type LokiStreamRetention struct {
+	// LogQL stream selector, e.g. {namespace="dev"}
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^\{.*\}$`
+	Selector string `json:"selector"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	Days int32 `json:"days"`
+
+	// The rule with the highest priority applies when several match
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=1
+	Priority int32 `json:"priority,omitempty"`
+}
+
+// LokiRulerSpec configures Loki's ruler, which evaluates LogQL alerting and
+// recording rules
+type LokiRulerSpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=false
+	Enabled bool `json:"enabled"`
+
+	// Selects the ConfigMaps in the stack's namespace that hold rule files.
+	// Every key ending in .yaml or .yml is a file of Loki rule groups. No
+	// selector selects no ConfigMaps.
+	// +kubebuilder:validation:Optional
+	RuleSelector *metav1.LabelSelector `json:"ruleSelector,omitempty"`
+
+	// Alertmanager that receives the alerts. Defaults to the
+	// <stack>-alertmanager Service of the stack's namespace, if there is one.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^https?://`
+	AlertmanagerURL string `json:"alertmanagerURL,omitempty"`
+
+	// How often rule groups without their own interval are evaluated
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+(s|m|h)$`
+	// +kubebuilder:default="1m"
+	EvaluationInterval string `json:"evaluationInterval,omitempty"`
+}
+
+// LokiLimits are ingestion limits applied to every tenant
+type LokiLimits struct {
+	// Per-second ingestion rate, in MB
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	IngestionRateMB *int32 `json:"ingestionRateMB,omitempty"`
+
+	// Ingestion burst size, in MB
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	IngestionBurstSizeMB *int32 `json:"ingestionBurstSizeMB,omitempty"`
+
+	// Per-second ingestion rate of a single stream, in MB
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	PerStreamRateLimitMB *int32 `json:"perStreamRateLimitMB,omitempty"`
+
+	// Maximum number of active streams
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	MaxStreams *int32 `json:"maxStreams,omitempty"`
+
+	// Longest log line accepted, as a quantity such as "256Ki"
+	// +kubebuilder:validation:Optional
+	MaxLineSize string `json:"maxLineSize,omitempty"`
+
+	// Reject logs older than this many days
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	RejectOlderThanDays *int32 `json:"rejectOlderThanDays,omitempty"`
 }
 
 type TempoSpec struct {
@@ -159,7 +1351,8 @@ type TempoSpec struct {
 
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:Pattern=`^[0-9]+[GM]i$`
-	Storage string `json:"storage,omitempty"`
+	Storage         string `json:"storage,omitempty"`
+	StorageSettings `json:",inline"`
 
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default=7
@@ -168,6 +1361,16 @@ type TempoSpec struct {
 
 	// +kubebuilder:validation:Optional
 	Resources ResourceRequirements `json:"resources,omitempty"`
+
+	// Version of the component's image, from the operator's supported
+	// versions. Empty deploys the operator's default.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^v?[0-9]+\.[0-9]+\.[0-9]+$`
+	Version string `json:"version,omitempty"`
+
+	ComponentManagement `json:",inline"`
+	PodPlacement        `json:",inline"`
+	PodSecurity         `json:",inline"`
 }
 
 //+kubebuilder:object:root=true
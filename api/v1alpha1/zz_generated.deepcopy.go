@@ -23,55 +23,983 @@ package v1alpha1
 import (
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterNodeExporterSpec) DeepCopyInto(out *ClusterNodeExporterSpec) {
+	*out = *in
+	out.Resources = in.Resources
+	out.ComponentManagement = in.ComponentManagement
+	in.PodPlacement.DeepCopyInto(&out.PodPlacement)
+	in.PodSecurity.DeepCopyInto(&out.PodSecurity)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterNodeExporterSpec.
+func (in *ClusterNodeExporterSpec) DeepCopy() *ClusterNodeExporterSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterNodeExporterSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterObservabilityStack) DeepCopyInto(out *ClusterObservabilityStack) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterObservabilityStack.
+func (in *ClusterObservabilityStack) DeepCopy() *ClusterObservabilityStack {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterObservabilityStack)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterObservabilityStack) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterObservabilityStackList) DeepCopyInto(out *ClusterObservabilityStackList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterObservabilityStack, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterObservabilityStackList.
+func (in *ClusterObservabilityStackList) DeepCopy() *ClusterObservabilityStackList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterObservabilityStackList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterObservabilityStackList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterObservabilityStackSpec) DeepCopyInto(out *ClusterObservabilityStackSpec) {
+	*out = *in
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.NodeExporter.DeepCopyInto(&out.NodeExporter)
+	in.KubeStateMetrics.DeepCopyInto(&out.KubeStateMetrics)
+	in.Promtail.DeepCopyInto(&out.Promtail)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterObservabilityStackSpec.
+func (in *ClusterObservabilityStackSpec) DeepCopy() *ClusterObservabilityStackSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterObservabilityStackSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterObservabilityStackStatus) DeepCopyInto(out *ClusterObservabilityStackStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Stacks != nil {
+		in, out := &in.Stacks, &out.Stacks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterObservabilityStackStatus.
+func (in *ClusterObservabilityStackStatus) DeepCopy() *ClusterObservabilityStackStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterObservabilityStackStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComponentManagement) DeepCopyInto(out *ComponentManagement) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComponentManagement.
+func (in *ComponentManagement) DeepCopy() *ComponentManagement {
+	if in == nil {
+		return nil
+	}
+	out := new(ComponentManagement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComponentVersion) DeepCopyInto(out *ComponentVersion) {
+	*out = *in
+	if in.LastTransitionTime != nil {
+		in, out := &in.LastTransitionTime, &out.LastTransitionTime
+		*out = new(metav1.Time)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComponentVersion.
+func (in *ComponentVersion) DeepCopy() *ComponentVersion {
+	if in == nil {
+		return nil
+	}
+	out := new(ComponentVersion)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaAlertRule) DeepCopyInto(out *GrafanaAlertRule) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaAlertRule.
+func (in *GrafanaAlertRule) DeepCopy() *GrafanaAlertRule {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaAlertRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaAlertRuleGroup) DeepCopyInto(out *GrafanaAlertRuleGroup) {
+	*out = *in
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]GrafanaAlertRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaAlertRuleGroup.
+func (in *GrafanaAlertRuleGroup) DeepCopy() *GrafanaAlertRuleGroup {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaAlertRuleGroup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaAlertingSpec) DeepCopyInto(out *GrafanaAlertingSpec) {
+	*out = *in
+	if in.ContactPoints != nil {
+		in, out := &in.ContactPoints, &out.ContactPoints
+		*out = make([]GrafanaContactPoint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Policy != nil {
+		in, out := &in.Policy, &out.Policy
+		*out = new(GrafanaNotificationPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MuteTimings != nil {
+		in, out := &in.MuteTimings, &out.MuteTimings
+		*out = make([]GrafanaMuteTiming, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RuleGroups != nil {
+		in, out := &in.RuleGroups, &out.RuleGroups
+		*out = make([]GrafanaAlertRuleGroup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaAlertingSpec.
+func (in *GrafanaAlertingSpec) DeepCopy() *GrafanaAlertingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaAlertingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaAuthProxySpec) DeepCopyInto(out *GrafanaAuthProxySpec) {
+	*out = *in
+	if in.Whitelist != nil {
+		in, out := &in.Whitelist, &out.Whitelist
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AutoSignUp != nil {
+		in, out := &in.AutoSignUp, &out.AutoSignUp
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaAuthProxySpec.
+func (in *GrafanaAuthProxySpec) DeepCopy() *GrafanaAuthProxySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaAuthProxySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaAuthSpec) DeepCopyInto(out *GrafanaAuthSpec) {
+	*out = *in
+	if in.GenericOAuth != nil {
+		in, out := &in.GenericOAuth, &out.GenericOAuth
+		*out = new(GrafanaOAuthSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LDAP != nil {
+		in, out := &in.LDAP, &out.LDAP
+		*out = new(GrafanaLDAPSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Proxy != nil {
+		in, out := &in.Proxy, &out.Proxy
+		*out = new(GrafanaAuthProxySpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaAuthSpec.
+func (in *GrafanaAuthSpec) DeepCopy() *GrafanaAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaContactPoint) DeepCopyInto(out *GrafanaContactPoint) {
+	*out = *in
+	if in.Receivers != nil {
+		in, out := &in.Receivers, &out.Receivers
+		*out = make([]GrafanaReceiver, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaContactPoint.
+func (in *GrafanaContactPoint) DeepCopy() *GrafanaContactPoint {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaContactPoint)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GrafanaDataSource) DeepCopyInto(out *GrafanaDataSource) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaDataSource.
-func (in *GrafanaDataSource) DeepCopy() *GrafanaDataSource {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaDataSource.
+func (in *GrafanaDataSource) DeepCopy() *GrafanaDataSource {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaDataSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaDatabaseSpec) DeepCopyInto(out *GrafanaDatabaseSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaDatabaseSpec.
+func (in *GrafanaDatabaseSpec) DeepCopy() *GrafanaDatabaseSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaDatabaseSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaFolder) DeepCopyInto(out *GrafanaFolder) {
+	*out = *in
+	if in.Permissions != nil {
+		in, out := &in.Permissions, &out.Permissions
+		*out = make([]GrafanaFolderPermission, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaFolder.
+func (in *GrafanaFolder) DeepCopy() *GrafanaFolder {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaFolder)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaFolderPermission) DeepCopyInto(out *GrafanaFolderPermission) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaFolderPermission.
+func (in *GrafanaFolderPermission) DeepCopy() *GrafanaFolderPermission {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaFolderPermission)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaLDAPSpec) DeepCopyInto(out *GrafanaLDAPSpec) {
+	*out = *in
+	in.Config.DeepCopyInto(&out.Config)
+	if in.AllowSignUp != nil {
+		in, out := &in.AllowSignUp, &out.AllowSignUp
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaLDAPSpec.
+func (in *GrafanaLDAPSpec) DeepCopy() *GrafanaLDAPSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaLDAPSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaMatcher) DeepCopyInto(out *GrafanaMatcher) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaMatcher.
+func (in *GrafanaMatcher) DeepCopy() *GrafanaMatcher {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaMatcher)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaMuteTiming) DeepCopyInto(out *GrafanaMuteTiming) {
+	*out = *in
+	if in.TimeIntervals != nil {
+		in, out := &in.TimeIntervals, &out.TimeIntervals
+		*out = make([]GrafanaTimeInterval, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaMuteTiming.
+func (in *GrafanaMuteTiming) DeepCopy() *GrafanaMuteTiming {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaMuteTiming)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaNotificationPolicy) DeepCopyInto(out *GrafanaNotificationPolicy) {
+	*out = *in
+	in.GrafanaNotificationTiming.DeepCopyInto(&out.GrafanaNotificationTiming)
+	if in.Routes != nil {
+		in, out := &in.Routes, &out.Routes
+		*out = make([]GrafanaNotificationRoute, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaNotificationPolicy.
+func (in *GrafanaNotificationPolicy) DeepCopy() *GrafanaNotificationPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaNotificationPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaNotificationRoute) DeepCopyInto(out *GrafanaNotificationRoute) {
+	*out = *in
+	if in.Matchers != nil {
+		in, out := &in.Matchers, &out.Matchers
+		*out = make([]GrafanaMatcher, len(*in))
+		copy(*out, *in)
+	}
+	in.GrafanaNotificationTiming.DeepCopyInto(&out.GrafanaNotificationTiming)
+	if in.MuteTimings != nil {
+		in, out := &in.MuteTimings, &out.MuteTimings
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaNotificationRoute.
+func (in *GrafanaNotificationRoute) DeepCopy() *GrafanaNotificationRoute {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaNotificationRoute)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaNotificationTiming) DeepCopyInto(out *GrafanaNotificationTiming) {
+	*out = *in
+	if in.GroupBy != nil {
+		in, out := &in.GroupBy, &out.GroupBy
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaNotificationTiming.
+func (in *GrafanaNotificationTiming) DeepCopy() *GrafanaNotificationTiming {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaNotificationTiming)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaOAuthSpec) DeepCopyInto(out *GrafanaOAuthSpec) {
+	*out = *in
+	in.ClientSecret.DeepCopyInto(&out.ClientSecret)
+	if in.Scopes != nil {
+		in, out := &in.Scopes, &out.Scopes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RoleMappings != nil {
+		in, out := &in.RoleMappings, &out.RoleMappings
+		*out = make([]GrafanaRoleMapping, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowSignUp != nil {
+		in, out := &in.AllowSignUp, &out.AllowSignUp
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaOAuthSpec.
+func (in *GrafanaOAuthSpec) DeepCopy() *GrafanaOAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaOAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaOrganization) DeepCopyInto(out *GrafanaOrganization) {
+	*out = *in
+	if in.Teams != nil {
+		in, out := &in.Teams, &out.Teams
+		*out = make([]GrafanaTeam, len(*in))
+		copy(*out, *in)
+	}
+	if in.Folders != nil {
+		in, out := &in.Folders, &out.Folders
+		*out = make([]GrafanaFolder, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ServiceAccounts != nil {
+		in, out := &in.ServiceAccounts, &out.ServiceAccounts
+		*out = make([]GrafanaServiceAccount, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaOrganization.
+func (in *GrafanaOrganization) DeepCopy() *GrafanaOrganization {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaOrganization)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaPlugin) DeepCopyInto(out *GrafanaPlugin) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaPlugin.
+func (in *GrafanaPlugin) DeepCopy() *GrafanaPlugin {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaPlugin)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaPluginBundle) DeepCopyInto(out *GrafanaPluginBundle) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaPluginBundle.
+func (in *GrafanaPluginBundle) DeepCopy() *GrafanaPluginBundle {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaPluginBundle)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaReceiver) DeepCopyInto(out *GrafanaReceiver) {
+	*out = *in
+	if in.Settings != nil {
+		in, out := &in.Settings, &out.Settings
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecureSettings != nil {
+		in, out := &in.SecureSettings, &out.SecureSettings
+		*out = make(map[string]v1.SecretKeySelector, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaReceiver.
+func (in *GrafanaReceiver) DeepCopy() *GrafanaReceiver {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaReceiver)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaRoleMapping) DeepCopyInto(out *GrafanaRoleMapping) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaRoleMapping.
+func (in *GrafanaRoleMapping) DeepCopy() *GrafanaRoleMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaRoleMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaServiceAccount) DeepCopyInto(out *GrafanaServiceAccount) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaServiceAccount.
+func (in *GrafanaServiceAccount) DeepCopy() *GrafanaServiceAccount {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaServiceAccount)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaSpec) DeepCopyInto(out *GrafanaSpec) {
+	*out = *in
+	in.StorageSettings.DeepCopyInto(&out.StorageSettings)
+	if in.AdditionalDataSources != nil {
+		in, out := &in.AdditionalDataSources, &out.AdditionalDataSources
+		*out = make([]GrafanaDataSource, len(*in))
+		copy(*out, *in)
+	}
+	out.Resources = in.Resources
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Database != nil {
+		in, out := &in.Database, &out.Database
+		*out = new(GrafanaDatabaseSpec)
+		**out = **in
+	}
+	in.Auth.DeepCopyInto(&out.Auth)
+	if in.Organizations != nil {
+		in, out := &in.Organizations, &out.Organizations
+		*out = make([]GrafanaOrganization, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Plugins != nil {
+		in, out := &in.Plugins, &out.Plugins
+		*out = make([]GrafanaPlugin, len(*in))
+		copy(*out, *in)
+	}
+	if in.PluginBundle != nil {
+		in, out := &in.PluginBundle, &out.PluginBundle
+		*out = new(GrafanaPluginBundle)
+		**out = **in
+	}
+	in.Alerting.DeepCopyInto(&out.Alerting)
+	out.ComponentManagement = in.ComponentManagement
+	in.PodPlacement.DeepCopyInto(&out.PodPlacement)
+	in.PodSecurity.DeepCopyInto(&out.PodSecurity)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaSpec.
+func (in *GrafanaSpec) DeepCopy() *GrafanaSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaTeam) DeepCopyInto(out *GrafanaTeam) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaTeam.
+func (in *GrafanaTeam) DeepCopy() *GrafanaTeam {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaTeam)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaTimeInterval) DeepCopyInto(out *GrafanaTimeInterval) {
+	*out = *in
+	if in.Times != nil {
+		in, out := &in.Times, &out.Times
+		*out = make([]GrafanaTimeRange, len(*in))
+		copy(*out, *in)
+	}
+	if in.Weekdays != nil {
+		in, out := &in.Weekdays, &out.Weekdays
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DaysOfMonth != nil {
+		in, out := &in.DaysOfMonth, &out.DaysOfMonth
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Months != nil {
+		in, out := &in.Months, &out.Months
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Years != nil {
+		in, out := &in.Years, &out.Years
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaTimeInterval.
+func (in *GrafanaTimeInterval) DeepCopy() *GrafanaTimeInterval {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaTimeInterval)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GrafanaTimeRange) DeepCopyInto(out *GrafanaTimeRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaTimeRange.
+func (in *GrafanaTimeRange) DeepCopy() *GrafanaTimeRange {
+	if in == nil {
+		return nil
+	}
+	out := new(GrafanaTimeRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InvalidRuleGroup) DeepCopyInto(out *InvalidRuleGroup) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InvalidRuleGroup.
+func (in *InvalidRuleGroup) DeepCopy() *InvalidRuleGroup {
+	if in == nil {
+		return nil
+	}
+	out := new(InvalidRuleGroup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IssuerReference) DeepCopyInto(out *IssuerReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuerReference.
+func (in *IssuerReference) DeepCopy() *IssuerReference {
+	if in == nil {
+		return nil
+	}
+	out := new(IssuerReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeStateMetricsSpec) DeepCopyInto(out *KubeStateMetricsSpec) {
+	*out = *in
+	if in.ResourceAllowlist != nil {
+		in, out := &in.ResourceAllowlist, &out.ResourceAllowlist
+		*out = make([]KubeStateMetricsResource, len(*in))
+		copy(*out, *in)
+	}
+	if in.ResourceDenylist != nil {
+		in, out := &in.ResourceDenylist, &out.ResourceDenylist
+		*out = make([]KubeStateMetricsResource, len(*in))
+		copy(*out, *in)
+	}
+	if in.MetricLabelsAllowlist != nil {
+		in, out := &in.MetricLabelsAllowlist, &out.MetricLabelsAllowlist
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.Shards != nil {
+		in, out := &in.Shards, &out.Shards
+		*out = new(int32)
+		**out = **in
+	}
+	out.Resources = in.Resources
+	out.ComponentManagement = in.ComponentManagement
+	in.PodPlacement.DeepCopyInto(&out.PodPlacement)
+	in.PodSecurity.DeepCopyInto(&out.PodSecurity)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeStateMetricsSpec.
+func (in *KubeStateMetricsSpec) DeepCopy() *KubeStateMetricsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeStateMetricsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LokiCompactorSpec) DeepCopyInto(out *LokiCompactorSpec) {
+	*out = *in
+	if in.RetentionEnabled != nil {
+		in, out := &in.RetentionEnabled, &out.RetentionEnabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.StreamRetention != nil {
+		in, out := &in.StreamRetention, &out.StreamRetention
+		*out = make([]LokiStreamRetention, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LokiCompactorSpec.
+func (in *LokiCompactorSpec) DeepCopy() *LokiCompactorSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(GrafanaDataSource)
+	out := new(LokiCompactorSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *GrafanaSpec) DeepCopyInto(out *GrafanaSpec) {
+func (in *LokiLimits) DeepCopyInto(out *LokiLimits) {
 	*out = *in
-	if in.AdditionalDataSources != nil {
-		in, out := &in.AdditionalDataSources, &out.AdditionalDataSources
-		*out = make([]GrafanaDataSource, len(*in))
-		copy(*out, *in)
+	if in.IngestionRateMB != nil {
+		in, out := &in.IngestionRateMB, &out.IngestionRateMB
+		*out = new(int32)
+		**out = **in
+	}
+	if in.IngestionBurstSizeMB != nil {
+		in, out := &in.IngestionBurstSizeMB, &out.IngestionBurstSizeMB
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PerStreamRateLimitMB != nil {
+		in, out := &in.PerStreamRateLimitMB, &out.PerStreamRateLimitMB
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxStreams != nil {
+		in, out := &in.MaxStreams, &out.MaxStreams
+		*out = new(int32)
+		**out = **in
+	}
+	if in.RejectOlderThanDays != nil {
+		in, out := &in.RejectOlderThanDays, &out.RejectOlderThanDays
+		*out = new(int32)
+		**out = **in
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GrafanaSpec.
-func (in *GrafanaSpec) DeepCopy() *GrafanaSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LokiLimits.
+func (in *LokiLimits) DeepCopy() *LokiLimits {
 	if in == nil {
 		return nil
 	}
-	out := new(GrafanaSpec)
+	out := new(LokiLimits)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KubeStateMetricsSpec) DeepCopyInto(out *KubeStateMetricsSpec) {
+func (in *LokiRulerSpec) DeepCopyInto(out *LokiRulerSpec) {
 	*out = *in
+	if in.RuleSelector != nil {
+		in, out := &in.RuleSelector, &out.RuleSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeStateMetricsSpec.
-func (in *KubeStateMetricsSpec) DeepCopy() *KubeStateMetricsSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LokiRulerSpec.
+func (in *LokiRulerSpec) DeepCopy() *LokiRulerSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(KubeStateMetricsSpec)
+	out := new(LokiRulerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LokiSchemaPeriod) DeepCopyInto(out *LokiSchemaPeriod) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LokiSchemaPeriod.
+func (in *LokiSchemaPeriod) DeepCopy() *LokiSchemaPeriod {
+	if in == nil {
+		return nil
+	}
+	out := new(LokiSchemaPeriod)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -79,6 +1007,19 @@ func (in *KubeStateMetricsSpec) DeepCopy() *KubeStateMetricsSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LokiSpec) DeepCopyInto(out *LokiSpec) {
 	*out = *in
+	in.StorageSettings.DeepCopyInto(&out.StorageSettings)
+	out.Resources = in.Resources
+	if in.Schemas != nil {
+		in, out := &in.Schemas, &out.Schemas
+		*out = make([]LokiSchemaPeriod, len(*in))
+		copy(*out, *in)
+	}
+	in.Compactor.DeepCopyInto(&out.Compactor)
+	in.Limits.DeepCopyInto(&out.Limits)
+	in.Ruler.DeepCopyInto(&out.Ruler)
+	out.ComponentManagement = in.ComponentManagement
+	in.PodPlacement.DeepCopyInto(&out.PodPlacement)
+	in.PodSecurity.DeepCopyInto(&out.PodSecurity)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LokiSpec.
@@ -91,6 +1032,63 @@ func (in *LokiSpec) DeepCopy() *LokiSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LokiStreamRetention) DeepCopyInto(out *LokiStreamRetention) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LokiStreamRetention.
+func (in *LokiStreamRetention) DeepCopy() *LokiStreamRetention {
+	if in == nil {
+		return nil
+	}
+	out := new(LokiStreamRetention)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MultiTenancySpec) DeepCopyInto(out *MultiTenancySpec) {
+	*out = *in
+	if in.Tenants != nil {
+		in, out := &in.Tenants, &out.Tenants
+		*out = make([]TenantSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MultiTenancySpec.
+func (in *MultiTenancySpec) DeepCopy() *MultiTenancySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MultiTenancySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkPolicySpec) DeepCopyInto(out *NetworkPolicySpec) {
+	*out = *in
+	if in.GrafanaAllowedNamespaces != nil {
+		in, out := &in.GrafanaAllowedNamespaces, &out.GrafanaAllowedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkPolicySpec.
+func (in *NetworkPolicySpec) DeepCopy() *NetworkPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeExporterSpec) DeepCopyInto(out *NodeExporterSpec) {
 	*out = *in
@@ -168,11 +1166,14 @@ func (in *ObservabilityStackList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ObservabilityStackSpec) DeepCopyInto(out *ObservabilityStackSpec) {
 	*out = *in
-	out.Prometheus = in.Prometheus
+	in.Prometheus.DeepCopyInto(&out.Prometheus)
 	in.Grafana.DeepCopyInto(&out.Grafana)
-	out.Loki = in.Loki
+	in.Loki.DeepCopyInto(&out.Loki)
 	in.Promtail.DeepCopyInto(&out.Promtail)
-	out.Tempo = in.Tempo
+	in.Tempo.DeepCopyInto(&out.Tempo)
+	in.NetworkPolicy.DeepCopyInto(&out.NetworkPolicy)
+	in.TLS.DeepCopyInto(&out.TLS)
+	in.MultiTenancy.DeepCopyInto(&out.MultiTenancy)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObservabilityStackSpec.
@@ -195,6 +1196,18 @@ func (in *ObservabilityStackStatus) DeepCopyInto(out *ObservabilityStackStatus)
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Components != nil {
+		in, out := &in.Components, &out.Components
+		*out = make([]ComponentVersion, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.InvalidRuleGroups != nil {
+		in, out := &in.InvalidRuleGroups, &out.InvalidRuleGroups
+		*out = make([]InvalidRuleGroup, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObservabilityStackStatus.
@@ -207,11 +1220,155 @@ func (in *ObservabilityStackStatus) DeepCopy() *ObservabilityStackStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodPlacement) DeepCopyInto(out *PodPlacement) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(v1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]v1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodPlacement.
+func (in *PodPlacement) DeepCopy() *PodPlacement {
+	if in == nil {
+		return nil
+	}
+	out := new(PodPlacement)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodSecurity) DeepCopyInto(out *PodSecurity) {
+	*out = *in
+	if in.PodSecurityContext != nil {
+		in, out := &in.PodSecurityContext, &out.PodSecurityContext
+		*out = new(v1.PodSecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ContainerSecurityContext != nil {
+		in, out := &in.ContainerSecurityContext, &out.ContainerSecurityContext
+		*out = new(v1.SecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodSecurity.
+func (in *PodSecurity) DeepCopy() *PodSecurity {
+	if in == nil {
+		return nil
+	}
+	out := new(PodSecurity)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrometheusRecordingRule) DeepCopyInto(out *PrometheusRecordingRule) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusRecordingRule.
+func (in *PrometheusRecordingRule) DeepCopy() *PrometheusRecordingRule {
+	if in == nil {
+		return nil
+	}
+	out := new(PrometheusRecordingRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrometheusRuleGroup) DeepCopyInto(out *PrometheusRuleGroup) {
+	*out = *in
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]PrometheusRecordingRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusRuleGroup.
+func (in *PrometheusRuleGroup) DeepCopy() *PrometheusRuleGroup {
+	if in == nil {
+		return nil
+	}
+	out := new(PrometheusRuleGroup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrometheusRulesSpec) DeepCopyInto(out *PrometheusRulesSpec) {
+	*out = *in
+	if in.Groups != nil {
+		in, out := &in.Groups, &out.Groups
+		*out = make([]PrometheusRuleGroup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RuleSelector != nil {
+		in, out := &in.RuleSelector, &out.RuleSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusRulesSpec.
+func (in *PrometheusRulesSpec) DeepCopy() *PrometheusRulesSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PrometheusRulesSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PrometheusSpec) DeepCopyInto(out *PrometheusSpec) {
 	*out = *in
+	in.StorageSettings.DeepCopyInto(&out.StorageSettings)
+	out.Resources = in.Resources
 	out.NodeExporter = in.NodeExporter
-	out.KubeStateMetrics = in.KubeStateMetrics
+	in.KubeStateMetrics.DeepCopyInto(&out.KubeStateMetrics)
+	in.Rules.DeepCopyInto(&out.Rules)
+	in.Thanos.DeepCopyInto(&out.Thanos)
+	out.ComponentManagement = in.ComponentManagement
+	in.PodPlacement.DeepCopyInto(&out.PodPlacement)
+	in.PodSecurity.DeepCopyInto(&out.PodSecurity)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusSpec.
@@ -228,18 +1385,14 @@ func (in *PrometheusSpec) DeepCopy() *PrometheusSpec {
 func (in *PromtailSpec) DeepCopyInto(out *PromtailSpec) {
 	*out = *in
 	out.Resources = in.Resources
-	if in.Tolerations != nil {
-		in, out := &in.Tolerations, &out.Tolerations
-		*out = make([]v1.Toleration, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
-	}
 	if in.ExtraArgs != nil {
 		in, out := &in.ExtraArgs, &out.ExtraArgs
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	out.ComponentManagement = in.ComponentManagement
+	in.PodPlacement.DeepCopyInto(&out.PodPlacement)
+	in.PodSecurity.DeepCopyInto(&out.PodSecurity)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PromtailSpec.
@@ -267,10 +1420,59 @@ func (in *ResourceRequirements) DeepCopy() *ResourceRequirements {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageSettings) DeepCopyInto(out *StorageSettings) {
+	*out = *in
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.AccessModes != nil {
+		in, out := &in.AccessModes, &out.AccessModes
+		*out = make([]v1.PersistentVolumeAccessMode, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageSettings.
+func (in *StorageSettings) DeepCopy() *StorageSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageSettings)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TLSSpec) DeepCopyInto(out *TLSSpec) {
+	*out = *in
+	if in.IssuerRef != nil {
+		in, out := &in.IssuerRef, &out.IssuerRef
+		*out = new(IssuerReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSSpec.
+func (in *TLSSpec) DeepCopy() *TLSSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TempoSpec) DeepCopyInto(out *TempoSpec) {
 	*out = *in
+	in.StorageSettings.DeepCopyInto(&out.StorageSettings)
 	out.Resources = in.Resources
+	out.ComponentManagement = in.ComponentManagement
+	in.PodPlacement.DeepCopyInto(&out.PodPlacement)
+	in.PodSecurity.DeepCopyInto(&out.PodSecurity)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TempoSpec.
@@ -282,3 +1484,113 @@ func (in *TempoSpec) DeepCopy() *TempoSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TenantSpec) DeepCopyInto(out *TenantSpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.IngestionRateMB != nil {
+		in, out := &in.IngestionRateMB, &out.IngestionRateMB
+		*out = new(int32)
+		**out = **in
+	}
+	if in.IngestionBurstSizeMB != nil {
+		in, out := &in.IngestionBurstSizeMB, &out.IngestionBurstSizeMB
+		*out = new(int32)
+		**out = **in
+	}
+	if in.RetentionDays != nil {
+		in, out := &in.RetentionDays, &out.RetentionDays
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxStreams != nil {
+		in, out := &in.MaxStreams, &out.MaxStreams
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxTraces != nil {
+		in, out := &in.MaxTraces, &out.MaxTraces
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TenantSpec.
+func (in *TenantSpec) DeepCopy() *TenantSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TenantSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThanosQuerySpec) DeepCopyInto(out *ThanosQuerySpec) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	out.Resources = in.Resources
+	in.PodPlacement.DeepCopyInto(&out.PodPlacement)
+	in.PodSecurity.DeepCopyInto(&out.PodSecurity)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThanosQuerySpec.
+func (in *ThanosQuerySpec) DeepCopy() *ThanosQuerySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ThanosQuerySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThanosSpec) DeepCopyInto(out *ThanosSpec) {
+	*out = *in
+	if in.ObjectStorageConfig != nil {
+		in, out := &in.ObjectStorageConfig, &out.ObjectStorageConfig
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Resources = in.Resources
+	in.Query.DeepCopyInto(&out.Query)
+	in.StoreGateway.DeepCopyInto(&out.StoreGateway)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThanosSpec.
+func (in *ThanosSpec) DeepCopy() *ThanosSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ThanosSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ThanosStoreGatewaySpec) DeepCopyInto(out *ThanosStoreGatewaySpec) {
+	*out = *in
+	in.StorageSettings.DeepCopyInto(&out.StorageSettings)
+	out.Resources = in.Resources
+	in.PodPlacement.DeepCopyInto(&out.PodPlacement)
+	in.PodSecurity.DeepCopyInto(&out.PodSecurity)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ThanosStoreGatewaySpec.
+func (in *ThanosStoreGatewaySpec) DeepCopy() *ThanosStoreGatewaySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ThanosStoreGatewaySpec)
+	in.DeepCopyInto(out)
+	return out
+}
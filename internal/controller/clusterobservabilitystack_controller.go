@@ -0,0 +1,478 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	"github.com/johnwroge/kube-insight-operator/pkg/promtail"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ClusterObservabilityStackReconciler reconciles a ClusterObservabilityStack object
+type ClusterObservabilityStackReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=monitoring.monitoring.example.com,resources=clusterobservabilitystacks,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.monitoring.example.com,resources=clusterobservabilitystacks/status,verbs=get;update;patch
+
+// Reconcile runs the shared collectors of a ClusterObservabilityStack in its
+// namespace and points the shared Promtail at the Loki of every
+// ObservabilityStack that references it.
+func (r *ClusterObservabilityStackReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	clusterStack := &monitoringv1alpha1.ClusterObservabilityStack{}
+	if err := r.Get(ctx, req.NamespacedName, clusterStack); err != nil {
+		if errors.IsNotFound(err) {
+			// Owned resources are garbage collected
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	stacks, err := r.referencingStacks(ctx, clusterStack)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Disabled collectors are removed; unmanaged ones are left alone
+	switch spec := clusterStack.Spec.NodeExporter; {
+	case !spec.Enabled:
+		if err := r.deleteCollector(ctx, clusterStack, componentNodeExporter, &appsv1.DaemonSet{}, &corev1.Service{}); err != nil {
+			return r.reconcileFailed(ctx, clusterStack, componentNodeExporter, err)
+		}
+	case !spec.Unmanaged:
+		if err := r.reconcileNodeExporter(ctx, clusterStack); err != nil {
+			log.Error(err, "Failed to reconcile node-exporter")
+			return r.reconcileFailed(ctx, clusterStack, componentNodeExporter, err)
+		}
+	}
+
+	switch spec := clusterStack.Spec.KubeStateMetrics; {
+	case !spec.Enabled:
+		if err := r.deleteCollector(ctx, clusterStack, componentKubeStateMetrics, &appsv1.Deployment{}, &appsv1.StatefulSet{}, &corev1.Service{},
+			kubeStateMetricsCustomResourceConfigMap(sharedCollectorName(clusterStack, componentKubeStateMetrics), clusterStack.Spec.Namespace, nil, ""),
+			&rbacv1.ClusterRoleBinding{}, &rbacv1.ClusterRole{}, &corev1.ServiceAccount{}); err != nil {
+			return r.reconcileFailed(ctx, clusterStack, componentKubeStateMetrics, err)
+		}
+	case !spec.Unmanaged:
+		if err := r.reconcileKubeStateMetrics(ctx, clusterStack); err != nil {
+			log.Error(err, "Failed to reconcile kube-state-metrics")
+			return r.reconcileFailed(ctx, clusterStack, componentKubeStateMetrics, err)
+		}
+	}
+
+	switch spec := clusterStack.Spec.Promtail; {
+	case !spec.Enabled:
+		if err := r.deleteCollector(ctx, clusterStack, componentPromtail, &appsv1.DaemonSet{},
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: sharedCollectorName(clusterStack, componentPromtail) + "-ca"}},
+			&rbacv1.ClusterRoleBinding{}, &rbacv1.ClusterRole{}, &corev1.ServiceAccount{}); err != nil {
+			return r.reconcileFailed(ctx, clusterStack, componentPromtail, err)
+		}
+	case !spec.Unmanaged:
+		if err := r.reconcilePromtail(ctx, clusterStack, stacks); err != nil {
+			log.Error(err, "Failed to reconcile Promtail")
+			return r.reconcileFailed(ctx, clusterStack, componentPromtail, err)
+		}
+	}
+
+	names := make([]string, 0, len(stacks))
+	for _, stack := range stacks {
+		names = append(names, stack.Namespace+"/"+stack.Name)
+	}
+	clusterStack.Status.Stacks = names
+
+	meta.SetStatusCondition(&clusterStack.Status.Conditions, metav1.Condition{
+		Type:               conditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             reasonReconciled,
+		Message:            "All enabled collectors reconciled",
+		ObservedGeneration: clusterStack.Generation,
+	})
+	if err := r.Status().Update(ctx, clusterStack); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update ClusterObservabilityStack status: %w", err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterObservabilityStackReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&monitoringv1alpha1.ClusterObservabilityStack{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&appsv1.DaemonSet{}).
+		Owns(&corev1.Service{}).
+		Watches(&monitoringv1alpha1.ObservabilityStack{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				stack := obj.(*monitoringv1alpha1.ObservabilityStack)
+				if !sharesCollectors(stack) {
+					return nil
+				}
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: stack.Spec.ClusterStackRef}}}
+			})).
+		Complete(r)
+}
+
+// referencingStacks lists the ObservabilityStacks that use the collectors of
+// a ClusterObservabilityStack, sorted by namespace and name. Stacks outside
+// its allowedNamespaces are left out; they mark themselves Degraded.
+func (r *ClusterObservabilityStackReconciler) referencingStacks(ctx context.Context, clusterStack *monitoringv1alpha1.ClusterObservabilityStack) ([]monitoringv1alpha1.ObservabilityStack, error) {
+	list := &monitoringv1alpha1.ObservabilityStackList{}
+	if err := r.List(ctx, list); err != nil {
+		return nil, fmt.Errorf("failed to list ObservabilityStacks: %w", err)
+	}
+
+	var stacks []monitoringv1alpha1.ObservabilityStack
+	for _, stack := range list.Items {
+		if stack.Spec.ClusterStackRef == clusterStack.Name && stack.DeletionTimestamp.IsZero() && allowsStack(clusterStack, stack.Namespace) {
+			stacks = append(stacks, stack)
+		}
+	}
+	sort.Slice(stacks, func(i, j int) bool {
+		if stacks[i].Namespace != stacks[j].Namespace {
+			return stacks[i].Namespace < stacks[j].Namespace
+		}
+		return stacks[i].Name < stacks[j].Name
+	})
+	return stacks, nil
+}
+
+func (r *ClusterObservabilityStackReconciler) reconcileNodeExporter(ctx context.Context, clusterStack *monitoringv1alpha1.ClusterObservabilityStack) error {
+	spec := clusterStack.Spec.NodeExporter
+
+	resources, err := profileResourcesFor(clusterStack.Spec.Profile, componentNodeExporter, spec.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve node-exporter resources: %w", err)
+	}
+
+	version, err := resolveVersion(componentNodeExporter, "spec.nodeExporter.version", spec.Version)
+	if err != nil {
+		return err
+	}
+
+	daemonSet, service := nodeExporterWorkload(sharedCollectorName(clusterStack, componentNodeExporter), clusterStack.Spec.Namespace,
+		sharedCollectorLabels(clusterStack, componentNodeExporter), componentImage(componentNodeExporter, version), resources)
+
+	applyPodPlacement(&daemonSet.Spec.Template, spec.PodPlacement)
+	applyPodSecurity(&daemonSet.Spec.Template, componentNodeExporter, spec.PodSecurity)
+
+	for _, obj := range []client.Object{daemonSet, service} {
+		if err := r.createOrUpdate(ctx, clusterStack, obj); err != nil {
+			return fmt.Errorf("failed to reconcile node-exporter: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *ClusterObservabilityStackReconciler) reconcileKubeStateMetrics(ctx context.Context, clusterStack *monitoringv1alpha1.ClusterObservabilityStack) error {
+	spec := clusterStack.Spec.KubeStateMetrics
+	name := sharedCollectorName(clusterStack, componentKubeStateMetrics)
+	labels := sharedCollectorLabels(clusterStack, componentKubeStateMetrics)
+
+	customResources, err := resolveKubeStateMetrics(ctx, r.Client, spec, "spec.kubeStateMetrics", false)
+	if err != nil {
+		return err
+	}
+
+	if err := r.reconcileRBAC(ctx, clusterStack, componentKubeStateMetrics, kubeStateMetricsRulesFor(spec, false, customResources)); err != nil {
+		return err
+	}
+
+	resources, err := profileResourcesFor(clusterStack.Spec.Profile, componentKubeStateMetrics, spec.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve kube-state-metrics resources: %w", err)
+	}
+
+	version, err := resolveVersion(componentKubeStateMetrics, "spec.kubeStateMetrics.version", spec.Version)
+	if err != nil {
+		return err
+	}
+
+	deployment, service := kubeStateMetricsWorkload(name, clusterStack.Spec.Namespace, labels, componentImage(componentKubeStateMetrics, version), resources,
+		kubeStateMetricsArgs(spec, nil, customResources))
+
+	applyPodPlacement(&deployment.Spec.Template, spec.PodPlacement)
+	applyPodSecurity(&deployment.Spec.Template, componentKubeStateMetrics, spec.PodSecurity)
+
+	configMap := kubeStateMetricsCustomResourceConfigMap(name, clusterStack.Spec.Namespace, labels, spec.CustomResourceStateConfig)
+	if len(customResources) > 0 {
+		if err := r.createOrUpdate(ctx, clusterStack, configMap); err != nil {
+			return fmt.Errorf("failed to reconcile kube-state-metrics: %w", err)
+		}
+		mountCustomResourceState(&deployment.Spec.Template, configMap)
+	} else if err := r.delete(ctx, clusterStack, configMap); err != nil {
+		return err
+	}
+
+	var workload, stale client.Object = deployment, &appsv1.StatefulSet{}
+	if shards := kubeStateMetricsShards(spec); shards > 1 {
+		workload, stale = shardKubeStateMetrics(deployment, shards), &appsv1.Deployment{}
+	}
+	stale.SetName(name)
+	stale.SetNamespace(clusterStack.Spec.Namespace)
+	if err := r.delete(ctx, clusterStack, stale); err != nil {
+		return err
+	}
+
+	for _, obj := range []client.Object{workload, service} {
+		if err := r.createOrUpdate(ctx, clusterStack, obj); err != nil {
+			return fmt.Errorf("failed to reconcile kube-state-metrics: %w", err)
+		}
+	}
+	return nil
+}
+
+// reconcilePromtail runs a Promtail that ships the logs of every node to the
+// Loki of each referencing stack. Without such a stack there is nowhere to
+// ship logs to, and the DaemonSet is removed.
+func (r *ClusterObservabilityStackReconciler) reconcilePromtail(ctx context.Context, clusterStack *monitoringv1alpha1.ClusterObservabilityStack, stacks []monitoringv1alpha1.ObservabilityStack) error {
+	spec := clusterStack.Spec.Promtail
+	name := sharedCollectorName(clusterStack, componentPromtail)
+	labels := sharedCollectorLabels(clusterStack, componentPromtail)
+
+	var clients []promtailClient
+	caBundle := map[string]string{}
+	for i := range stacks {
+		stack := &stacks[i]
+		if !stack.Spec.Loki.Enabled {
+			continue
+		}
+
+		lokiClient := promtailClient{URL: clusterServiceURL(stack, componentLoki, portLokiHTTP)}
+		if multiTenant(stack) {
+			lokiClient.TenantID = defaultTenant(stack)
+		}
+		clients = append(clients, lokiClient)
+
+		if tlsEnabled(stack) {
+			ca, err := r.lokiCA(ctx, stack)
+			if err != nil {
+				return err
+			}
+			caBundle[fmt.Sprintf("%s.%s.crt", stack.Namespace, stack.Name)] = ca
+		}
+	}
+
+	if len(clients) == 0 {
+		return r.delete(ctx, clusterStack, &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: clusterStack.Spec.Namespace},
+		})
+	}
+
+	if err := r.reconcileRBAC(ctx, clusterStack, componentPromtail, promtailRules); err != nil {
+		return err
+	}
+
+	resources, err := profileResourcesFor(clusterStack.Spec.Profile, componentPromtail, spec.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Promtail resources: %w", err)
+	}
+
+	version, err := resolveVersion(componentPromtail, "spec.promtail.version", spec.Version)
+	if err != nil {
+		return err
+	}
+
+	// Default toleration for node-critical pods unless the CRD overrides it
+	tolerations := spec.Tolerations
+	if len(tolerations) == 0 {
+		tolerations = []corev1.Toleration{
+			{
+				Effect:   corev1.TaintEffectNoSchedule,
+				Operator: corev1.TolerationOpExists,
+			},
+		}
+	}
+
+	generator := promtail.NewConfigGenerator(promtail.Options{
+		Name:                 name,
+		Namespace:            clusterStack.Spec.Namespace,
+		Labels:               labels,
+		LokiURL:              clients[0].URL,
+		Resources:            resources,
+		Tolerations:          tolerations,
+		ExtraArgs:            spec.ExtraArgs,
+		ScrapeKubernetesLogs: spec.ScrapeKubernetesLogs,
+	})
+
+	configMap := generator.GenerateConfigMap()
+	for _, key := range yamlConfigKeys(configMap) {
+		if err := setPromtailClients(configMap, key, clients); err != nil {
+			return fmt.Errorf("failed to configure Promtail clients: %w", err)
+		}
+	}
+
+	if err := ctrl.SetControllerReference(clusterStack, configMap, r.Scheme); err != nil {
+		return fmt.Errorf("failed to set controller reference on configmap: %w", err)
+	}
+	if err := r.createOrUpdate(ctx, clusterStack, configMap); err != nil {
+		return fmt.Errorf("failed to reconcile Promtail ConfigMap: %w", err)
+	}
+
+	ds := generator.GenerateDaemonSet()
+	ds.Spec.Template.Spec.ServiceAccountName = name
+	setContainerImage(&ds.Spec.Template, componentImage(componentPromtail, version))
+
+	if len(spec.ExtraArgs) > 0 {
+		ds.Spec.Template.Spec.Containers[0].Args = append(ds.Spec.Template.Spec.Containers[0].Args, spec.ExtraArgs...)
+	}
+
+	if len(caBundle) > 0 {
+		bundle := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-ca", Namespace: clusterStack.Spec.Namespace, Labels: labels},
+			Data:       caBundle,
+		}
+		if err := r.createOrUpdate(ctx, clusterStack, bundle); err != nil {
+			return fmt.Errorf("failed to reconcile Promtail CA bundle: %w", err)
+		}
+		trustCABundle(&ds.Spec.Template, bundle.Name)
+	}
+
+	applyPodPlacement(&ds.Spec.Template, spec.PodPlacement)
+	applyPodSecurity(&ds.Spec.Template, componentPromtail, spec.PodSecurity)
+
+	if err := r.createOrUpdate(ctx, clusterStack, ds); err != nil {
+		return fmt.Errorf("failed to reconcile Promtail DaemonSet: %w", err)
+	}
+	return nil
+}
+
+// lokiCA returns the CA that signed the serving certificate of a stack's Loki
+func (r *ClusterObservabilityStackReconciler) lokiCA(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) (string, error) {
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: stack.Namespace, Name: tlsSecretName(stack, componentLoki)}
+	if err := r.Get(ctx, key, secret); err != nil {
+		return "", fmt.Errorf("failed to get Loki certificate of %s/%s: %w", stack.Namespace, stack.Name, err)
+	}
+
+	ca := secret.Data["ca.crt"]
+	if len(ca) == 0 {
+		return "", fmt.Errorf("Loki certificate of %s/%s has no ca.crt", stack.Namespace, stack.Name)
+	}
+	return string(ca), nil
+}
+
+// reconcileRBAC grants a collector's service account its rules cluster-wide
+func (r *ClusterObservabilityStackReconciler) reconcileRBAC(ctx context.Context, clusterStack *monitoringv1alpha1.ClusterObservabilityStack, component string, rules []rbacv1.PolicyRule) error {
+	name := sharedCollectorName(clusterStack, component)
+	labels := sharedCollectorLabels(clusterStack, component)
+
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: clusterStack.Spec.Namespace, Labels: labels},
+	}
+	cr := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Rules:      rules,
+	}
+	crb := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     name,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      name,
+			Namespace: clusterStack.Spec.Namespace,
+		}},
+	}
+
+	for _, obj := range []client.Object{sa, cr, crb} {
+		if err := r.createOrUpdate(ctx, clusterStack, obj); err != nil {
+			return fmt.Errorf("failed to reconcile %s RBAC: %w", component, err)
+		}
+	}
+	return nil
+}
+
+// createOrUpdate makes the cluster stack the controller of an object and
+// writes it, emitting an event when it changed
+func (r *ClusterObservabilityStackReconciler) createOrUpdate(ctx context.Context, clusterStack *monitoringv1alpha1.ClusterObservabilityStack, obj client.Object) error {
+	if err := ctrl.SetControllerReference(clusterStack, obj, r.Scheme); err != nil {
+		return fmt.Errorf("failed to set controller reference on %s: %w", obj.GetName(), err)
+	}
+
+	_, err := createOrUpdate(ctx, r.Client, r.Recorder, clusterStack, obj, nil)
+	return err
+}
+
+// delete removes an object of the cluster stack, if it exists
+func (r *ClusterObservabilityStackReconciler) delete(ctx context.Context, clusterStack *monitoringv1alpha1.ClusterObservabilityStack, obj client.Object) error {
+	return deleteObject(ctx, r.Client, r.Recorder, clusterStack, obj)
+}
+
+// deleteCollector removes the objects of a disabled collector. Each object
+// is named after the collector, and all but the cluster-scoped RBAC live in
+// the cluster stack's namespace.
+func (r *ClusterObservabilityStackReconciler) deleteCollector(ctx context.Context, clusterStack *monitoringv1alpha1.ClusterObservabilityStack, component string, objs ...client.Object) error {
+	name := sharedCollectorName(clusterStack, component)
+	for _, obj := range objs {
+		if obj.GetName() == "" {
+			obj.SetName(name)
+		}
+		switch obj.(type) {
+		case *rbacv1.ClusterRole, *rbacv1.ClusterRoleBinding:
+		default:
+			obj.SetNamespace(clusterStack.Spec.Namespace)
+		}
+		if err := r.delete(ctx, clusterStack, obj); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", component, err)
+		}
+	}
+	return nil
+}
+
+// reconcileFailed records a failed reconciliation of a collector. Invalid
+// spec values mark the cluster stack Degraded without a retry.
+func (r *ClusterObservabilityStackReconciler) reconcileFailed(ctx context.Context, clusterStack *monitoringv1alpha1.ClusterObservabilityStack, component string, err error) (ctrl.Result, error) {
+	return reconcileFailed(r.Recorder, clusterStack, component, err, func(reason, message string) error {
+		meta.SetStatusCondition(&clusterStack.Status.Conditions, metav1.Condition{
+			Type:               conditionDegraded,
+			Status:             metav1.ConditionTrue,
+			Reason:             reason,
+			Message:            message,
+			ObservedGeneration: clusterStack.Generation,
+		})
+		if err := r.Status().Update(ctx, clusterStack); err != nil {
+			return fmt.Errorf("failed to update ClusterObservabilityStack status: %w", err)
+		}
+		return nil
+	})
+}
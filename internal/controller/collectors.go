@@ -0,0 +1,446 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The collectors report on the whole cluster. An ObservabilityStack runs its
+// own, unless it shares those of a ClusterObservabilityStack.
+
+// sharesCollectors reports whether a stack uses the collectors of a
+// ClusterObservabilityStack instead of running kube-state-metrics and
+// Promtail itself
+func sharesCollectors(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	return stack.Spec.ClusterStackRef != ""
+}
+
+// reasonClusterStackRefused is the Degraded reason of a stack referencing a
+// ClusterObservabilityStack that does not allow its namespace
+const reasonClusterStackRefused = "ClusterStackRefused"
+
+// ClusterStackRefusedError reports a stack referencing a
+// ClusterObservabilityStack whose allowedNamespaces leave out its namespace
+type ClusterStackRefusedError struct {
+	ClusterStack string
+	Namespace    string
+}
+
+func (e *ClusterStackRefusedError) Error() string {
+	return fmt.Sprintf("ClusterObservabilityStack %s does not allow stacks in namespace %s; add it to allowedNamespaces", e.ClusterStack, e.Namespace)
+}
+
+// allowsStack reports whether a ClusterObservabilityStack admits the stacks
+// of a namespace, whose Loki then receives the logs of the whole cluster
+func allowsStack(clusterStack *monitoringv1alpha1.ClusterObservabilityStack, namespace string) bool {
+	return slices.Contains(clusterStack.Spec.AllowedNamespaces, namespace)
+}
+
+// clusterStack returns the ClusterObservabilityStack a stack references, or
+// nil when it runs its own collectors. A ClusterObservabilityStack that does not allow the
+// stack's namespace is reported as a ClusterStackRefusedError.
+func (r *ObservabilityStackReconciler) clusterStack(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) (*monitoringv1alpha1.ClusterObservabilityStack, error) {
+	if !sharesCollectors(stack) {
+		return nil, nil
+	}
+	if r.namespaced() {
+		return nil, fmt.Errorf("clusterStackRef is not supported when the operator watches selected namespaces")
+	}
+
+	clusterStack := &monitoringv1alpha1.ClusterObservabilityStack{}
+	if err := r.Get(ctx, client.ObjectKey{Name: stack.Spec.ClusterStackRef}, clusterStack); err != nil {
+		return nil, fmt.Errorf("failed to get ClusterObservabilityStack %s: %w", stack.Spec.ClusterStackRef, err)
+	}
+	if !allowsStack(clusterStack, stack.Namespace) {
+		return nil, &ClusterStackRefusedError{ClusterStack: clusterStack.Name, Namespace: stack.Namespace}
+	}
+	return clusterStack, nil
+}
+
+func sharedCollectorName(clusterStack *monitoringv1alpha1.ClusterObservabilityStack, component string) string {
+	return fmt.Sprintf("%s-%s", clusterStack.Name, component)
+}
+
+func sharedCollectorLabels(clusterStack *monitoringv1alpha1.ClusterObservabilityStack, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       component,
+		"app.kubernetes.io/instance":   clusterStack.Name,
+		"app.kubernetes.io/managed-by": "kube-insight-operator",
+	}
+}
+
+// clusterServiceURL is the address of a stack's component from another namespace
+func clusterServiceURL(stack *monitoringv1alpha1.ObservabilityStack, component string, port int) string {
+	scheme := "http"
+	if tlsEnabled(stack) {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s-%s.%s.svc:%d", scheme, stack.Name, component, stack.Namespace, port)
+}
+
+// sharedCollectorJobs scrapes the node-exporter and kube-state-metrics of a
+// ClusterObservabilityStack. Jobs of the same name are replaced.
+func sharedCollectorJobs(configMap *corev1.ConfigMap, key string, clusterStack *monitoringv1alpha1.ClusterObservabilityStack) error {
+	var jobs []map[string]interface{}
+	if clusterStack.Spec.NodeExporter.Enabled {
+		jobs = append(jobs, map[string]interface{}{
+			"job_name": componentNodeExporter,
+			"kubernetes_sd_configs": []interface{}{
+				map[string]interface{}{
+					"role":       "endpoints",
+					"namespaces": map[string]interface{}{"names": []interface{}{clusterStack.Spec.Namespace}},
+				},
+			},
+			"relabel_configs": []interface{}{
+				map[string]interface{}{
+					"source_labels": []interface{}{"__meta_kubernetes_service_name", "__meta_kubernetes_endpoint_port_name"},
+					"regex":         sharedCollectorName(clusterStack, componentNodeExporter) + ";metrics",
+					"action":        "keep",
+				},
+				map[string]interface{}{
+					"source_labels": []interface{}{"__meta_kubernetes_pod_node_name"},
+					"target_label":  "node",
+				},
+			},
+		})
+	}
+	if clusterStack.Spec.KubeStateMetrics.Enabled {
+		name := sharedCollectorName(clusterStack, componentKubeStateMetrics)
+		if kubeStateMetricsShards(clusterStack.Spec.KubeStateMetrics) > 1 {
+			jobs = append(jobs, kubeStateMetricsShardsJob(name, clusterStack.Spec.Namespace))
+		} else {
+			jobs = append(jobs, map[string]interface{}{
+				"job_name":     componentKubeStateMetrics,
+				"honor_labels": true,
+				"static_configs": []interface{}{
+					map[string]interface{}{
+						"targets": []interface{}{fmt.Sprintf("%s.%s.svc:%d", name, clusterStack.Spec.Namespace, portKubeStateHTTP)},
+					},
+				},
+			})
+		}
+	}
+
+	return replaceScrapeJobs(configMap, key, jobs)
+}
+
+// promtailClient is a Loki the shared Promtail pushes to
+type promtailClient struct {
+	URL      string
+	TenantID string
+}
+
+// setPromtailClients replaces the clients of a Promtail configuration with
+// one per Loki, keeping the settings of the generated client.
+func setPromtailClients(configMap *corev1.ConfigMap, key string, clients []promtailClient) error {
+	return patchYAMLConfig(configMap, key, func(config map[string]interface{}) {
+		template := map[string]interface{}{}
+		if generated, _ := config["clients"].([]interface{}); len(generated) > 0 {
+			template, _ = generated[0].(map[string]interface{})
+		}
+
+		var patched []interface{}
+		for _, c := range clients {
+			entry := map[string]interface{}{}
+			for k, v := range template {
+				entry[k] = v
+			}
+			entry["url"] = c.URL + "/loki/api/v1/push"
+			delete(entry, "tenant_id")
+			if c.TenantID != "" {
+				entry["tenant_id"] = c.TenantID
+			}
+			patched = append(patched, entry)
+		}
+		config["clients"] = patched
+	})
+}
+
+// trustCABundle makes the containers of a pod trust the CAs in a ConfigMap
+func trustCABundle(template *corev1.PodTemplateSpec, configMapName string) {
+	podSpec := &template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: trustedCAVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+			},
+		},
+	})
+
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      trustedCAVolume,
+			MountPath: trustedCAMountPath,
+			ReadOnly:  true,
+		})
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  "SSL_CERT_DIR",
+			Value: "/etc/ssl/certs:" + trustedCAMountPath,
+		})
+	}
+}
+
+// kubeStateMetricsRules lets kube-state-metrics list the objects it reports on
+var kubeStateMetricsRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{
+			"configmaps",
+			"secrets",
+			"nodes",
+			"pods",
+			"services",
+			"resourcequotas",
+			"replicationcontrollers",
+			"limitranges",
+			"persistentvolumeclaims",
+			"persistentvolumes",
+			"namespaces",
+			"endpoints",
+		},
+		Verbs: []string{"list", "watch"},
+	},
+	{
+		APIGroups: []string{"apps"},
+		Resources: []string{
+			"statefulsets",
+			"daemonsets",
+			"deployments",
+			"replicasets",
+		},
+		Verbs: []string{"list", "watch"},
+	},
+	{
+		APIGroups: []string{"batch"},
+		Resources: []string{
+			"cronjobs",
+			"jobs",
+		},
+		Verbs: []string{"list", "watch"},
+	},
+	{
+		APIGroups: []string{"networking.k8s.io"},
+		Resources: []string{
+			"ingresses",
+		},
+		Verbs: []string{"list", "watch"},
+	},
+	{
+		APIGroups: []string{"storage.k8s.io"},
+		Resources: []string{
+			"storageclasses",
+		},
+		Verbs: []string{"list", "watch"},
+	},
+}
+
+// promtailRules lets Promtail discover the pods whose logs it reads
+var promtailRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{
+			"nodes",
+			"nodes/proxy",
+			"services",
+			"endpoints",
+			"pods",
+		},
+		Verbs: []string{"get", "list", "watch"},
+	},
+}
+
+// kubeStateMetricsWorkload builds the kube-state-metrics Deployment and the
+// Service Prometheus scrapes it through
+func kubeStateMetricsWorkload(name, namespace string, labels map[string]string, image string, resources *corev1.ResourceRequirements, args []string) (*appsv1.Deployment, *corev1.Service) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: name,
+					Containers: []corev1.Container{
+						{
+							Name:  "kube-state-metrics",
+							Image: image,
+							Args:  args,
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          "http-metrics",
+									ContainerPort: 8080,
+								},
+								{
+									Name:          "telemetry",
+									ContainerPort: 8081,
+								},
+							},
+							Resources: *resources,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "http-metrics",
+					Port:       8080,
+					TargetPort: intstr.FromString("http-metrics"),
+				},
+				{
+					Name:       "telemetry",
+					Port:       8081,
+					TargetPort: intstr.FromString("telemetry"),
+				},
+			},
+			Selector: labels,
+		},
+	}
+
+	return deployment, service
+}
+
+// nodeExporterWorkload builds the node-exporter DaemonSet, which reads the
+// host's /proc, /sys and root filesystem read-only, and the headless Service
+// Prometheus discovers its pods through.
+func nodeExporterWorkload(name, namespace string, labels map[string]string, image string, resources *corev1.ResourceRequirements) (*appsv1.DaemonSet, *corev1.Service) {
+	// Filesystems mounted on the host later become visible under /host/root
+	hostToContainer := corev1.MountPropagationHostToContainer
+	hostPaths := []struct {
+		name        string
+		path        string
+		mountPath   string
+		propagation *corev1.MountPropagationMode
+	}{
+		{"proc", "/proc", "/host/proc", nil},
+		{"sys", "/sys", "/host/sys", nil},
+		{"root", "/", "/host/root", &hostToContainer},
+	}
+
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+	for _, hostPath := range hostPaths {
+		volumes = append(volumes, corev1.Volume{
+			Name: hostPath.name,
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: hostPath.path},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:             hostPath.name,
+			MountPath:        hostPath.mountPath,
+			ReadOnly:         true,
+			MountPropagation: hostPath.propagation,
+		})
+	}
+
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: selectorLabels(labels),
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					HostPID: true,
+					Containers: []corev1.Container{
+						{
+							Name:  "node-exporter",
+							Image: image,
+							Args: []string{
+								"--path.procfs=/host/proc",
+								"--path.sysfs=/host/sys",
+								"--path.rootfs=/host/root",
+							},
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          "metrics",
+									ContainerPort: portNodeExporter,
+								},
+							},
+							Resources:    *resources,
+							VolumeMounts: mounts,
+						},
+					},
+					Volumes: volumes,
+					// Every node is reported on, including tainted ones
+					Tolerations: []corev1.Toleration{
+						{Operator: corev1.TolerationOpExists},
+					},
+				},
+			},
+		},
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "metrics",
+					Port:       portNodeExporter,
+					TargetPort: intstr.FromString("metrics"),
+				},
+			},
+			Selector: selectorLabels(labels),
+		},
+	}
+
+	return daemonSet, service
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Shared collectors", func() {
+	var clusterStack *monitoringv1alpha1.ClusterObservabilityStack
+
+	BeforeEach(func() {
+		clusterStack = &monitoringv1alpha1.ClusterObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+			Spec: monitoringv1alpha1.ClusterObservabilityStackSpec{
+				Namespace:        "monitoring-system",
+				NodeExporter:     monitoringv1alpha1.ClusterNodeExporterSpec{Enabled: true},
+				KubeStateMetrics: monitoringv1alpha1.KubeStateMetricsSpec{Enabled: true},
+			},
+		}
+	})
+
+	It("should scrape the shared collectors in place of the stack's own", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"prometheus.yml": `scrape_configs:
+- job_name: prometheus
+  static_configs:
+  - targets: ['localhost:9090']
+- job_name: kube-state-metrics
+  static_configs:
+  - targets: ['test-kube-state-metrics:8080']
+`}}
+
+		Expect(sharedCollectorJobs(configMap, "prometheus.yml", clusterStack)).To(Succeed())
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("job_name: prometheus"))
+		Expect(configMap.Data["prometheus.yml"]).NotTo(ContainSubstring("test-kube-state-metrics"))
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("shared-kube-state-metrics.monitoring-system.svc:8080"))
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("regex: shared-node-exporter;metrics"))
+	})
+
+	It("should push logs to the Loki of every referencing stack", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"promtail.yaml": `clients:
+- url: http://team-a-loki:3100/loki/api/v1/push
+  batchwait: 1s
+`}}
+		teamA := &monitoringv1alpha1.ObservabilityStack{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Namespace: "a"}}
+		teamB := &monitoringv1alpha1.ObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-b", Namespace: "b"},
+			Spec: monitoringv1alpha1.ObservabilityStackSpec{
+				TLS: monitoringv1alpha1.TLSSpec{Enabled: true},
+			},
+		}
+
+		Expect(setPromtailClients(configMap, "promtail.yaml", []promtailClient{
+			{URL: clusterServiceURL(teamA, componentLoki, portLokiHTTP)},
+			{URL: clusterServiceURL(teamB, componentLoki, portLokiHTTP), TenantID: "b"},
+		})).To(Succeed())
+		Expect(configMap.Data["promtail.yaml"]).To(Equal(`clients:
+- batchwait: 1s
+  url: http://team-a-loki.a.svc:3100/loki/api/v1/push
+- batchwait: 1s
+  tenant_id: b
+  url: https://team-b-loki.b.svc:3100/loki/api/v1/push
+`))
+	})
+
+	It("should mount the host filesystems read-only into node-exporter", func() {
+		resources, err := profileResourcesFor("", componentNodeExporter, monitoringv1alpha1.ResourceRequirements{})
+		Expect(err).NotTo(HaveOccurred())
+
+		daemonSet, service := nodeExporterWorkload("shared-node-exporter", "monitoring-system",
+			sharedCollectorLabels(clusterStack, componentNodeExporter), componentImage(componentNodeExporter, "1.7.0"), resources)
+
+		Expect(service.Spec.ClusterIP).To(Equal(corev1.ClusterIPNone))
+		for _, mount := range daemonSet.Spec.Template.Spec.Containers[0].VolumeMounts {
+			Expect(mount.ReadOnly).To(BeTrue())
+		}
+	})
+
+	It("should remove the objects of a disabled collector and leave an unmanaged one alone", func() {
+		ns := clusterStack.Spec.Namespace
+		clusterStack.Spec.NodeExporter.Enabled = false
+		clusterStack.Spec.KubeStateMetrics.Unmanaged = true
+		existing := []client.Object{
+			clusterStack,
+			&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "shared-node-exporter", Namespace: ns}},
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "shared-node-exporter", Namespace: ns}},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "shared-kube-state-metrics", Namespace: ns}},
+			&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "shared-kube-state-metrics"}},
+		}
+		c := newFakeClient(existing...)
+		recorder := record.NewFakeRecorder(10)
+		r := &ClusterObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: recorder}
+
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(clusterStack)})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = c.Get(context.Background(), client.ObjectKey{Name: "shared-node-exporter", Namespace: ns}, &appsv1.DaemonSet{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		err = c.Get(context.Background(), client.ObjectKey{Name: "shared-node-exporter", Namespace: ns}, &corev1.Service{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(c.Get(context.Background(), client.ObjectKey{Name: "shared-kube-state-metrics", Namespace: ns}, &appsv1.Deployment{})).To(Succeed())
+		Expect(c.Get(context.Background(), client.ObjectKey{Name: "shared-kube-state-metrics"}, &rbacv1.ClusterRole{})).To(Succeed())
+
+		// Only the objects that existed are deleted
+		Expect(recorder.Events).To(HaveLen(2))
+		Expect(<-recorder.Events).To(Equal("Normal Deleted Deleted DaemonSet monitoring-system/shared-node-exporter"))
+	})
+
+	It("should refuse stacks outside the allowed namespaces", func() {
+		clusterStack.Spec.AllowedNamespaces = []string{"team-a"}
+		stack := func(namespace string) *monitoringv1alpha1.ObservabilityStack {
+			return &monitoringv1alpha1.ObservabilityStack{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: namespace},
+				Spec:       monitoringv1alpha1.ObservabilityStackSpec{ClusterStackRef: "shared"},
+			}
+		}
+		allowed, refused := stack("team-a"), stack("team-b")
+		c := newFakeClient(clusterStack, allowed, refused)
+
+		r := &ClusterObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(20)}
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(clusterStack)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(clusterStack), clusterStack)).To(Succeed())
+		Expect(clusterStack.Status.Stacks).To(Equal([]string{"team-a/test"}))
+
+		stackReconciler := &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme()}
+		Expect(stackReconciler.clusterStack(context.Background(), allowed)).NotTo(BeNil())
+		_, err = stackReconciler.clusterStack(context.Background(), refused)
+		reason, specErr := invalidSpec(err)
+		Expect(reason).To(Equal(reasonClusterStackRefused))
+		Expect(specErr).To(MatchError("ClusterObservabilityStack shared does not allow stacks in namespace team-b; add it to allowedNamespaces"))
+	})
+
+	It("should not delete the stale kube-state-metrics objects when they are absent", func() {
+		clusterStack.Spec.NodeExporter.Enabled = false
+		deletes := 0
+		c := interceptor.NewClient(newFakeClient(clusterStack).(client.WithWatch), interceptor.Funcs{
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				deletes++
+				return c.Delete(ctx, obj, opts...)
+			},
+		})
+		r := &ClusterObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(20)}
+
+		for i := 0; i < 2; i++ {
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(clusterStack)})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(c.Get(context.Background(), client.ObjectKey{Name: "shared-kube-state-metrics", Namespace: clusterStack.Spec.Namespace}, &appsv1.Deployment{})).To(Succeed())
+		Expect(deletes).To(BeZero())
+	})
+})
+
+// newFakeClient returns a client serving objs, with the operator's types and
+// status subresources registered
+func newFakeClient(objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(monitoringv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&monitoringv1alpha1.ObservabilityStack{}, &monitoringv1alpha1.ClusterObservabilityStack{}).
+		Build()
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Event reasons emitted on an ObservabilityStack or ClusterObservabilityStack
+const (
+	reasonCreated         = "Created"
+	reasonUpdated         = "Updated"
+	reasonConfigChanged   = "ConfigChanged"
+	reasonDeleted         = "Deleted"
+	reasonExpanded        = "Expanded"
+	reasonReconcileFailed = "ReconcileFailed"
+)
+
+// eventVerbs describes each object event reason in the event message
+var eventVerbs = map[string]string{
+	reasonCreated:       "Created",
+	reasonUpdated:       "Updated",
+	reasonConfigChanged: "Updated configuration in",
+	reasonDeleted:       "Deleted",
+	reasonExpanded:      "Expanded",
+}
+
+// recordObjectEvent emits a Normal event on the stack for a change the
+// operator made to one of the stack's resources.
+func (r *ObservabilityStackReconciler) recordObjectEvent(stack *monitoringv1alpha1.ObservabilityStack, reason string, obj client.Object) {
+	recordObjectEvent(r.Recorder, r.Scheme, stack, reason, obj)
+}
+
+// recordObjectEvent emits a Normal event on owner for a change the operator
+// made to one of its resources.
+func recordObjectEvent(recorder record.EventRecorder, scheme *runtime.Scheme, owner client.Object, reason string, obj client.Object) {
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if gvk, err := apiutil.GVKForObject(obj, scheme); err == nil {
+		kind = gvk.Kind
+	}
+
+	name := obj.GetName()
+	if obj.GetNamespace() != "" {
+		name = obj.GetNamespace() + "/" + name
+	}
+
+	recorder.Eventf(owner, corev1.EventTypeNormal, reason, "%s %s %s", eventVerbs[reason], kind, name)
+}
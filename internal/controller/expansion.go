@@ -0,0 +1,234 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const reasonInvalidStorage = "InvalidStorage"
+
+// StorageShrinkError reports a storage size below the size of a StatefulSet's
+// existing volume claims, which Kubernetes cannot shrink.
+type StorageShrinkError struct {
+	StatefulSet string
+	Claim       string
+	Current     resource.Quantity
+	Requested   resource.Quantity
+}
+
+func (e *StorageShrinkError) Error() string {
+	return fmt.Sprintf("volume claim %q of StatefulSet %s cannot shrink from %s to %s", e.Claim, e.StatefulSet, e.Current.String(), e.Requested.String())
+}
+
+// StorageExpansionError reports a volume claim that must grow but whose
+// StorageClass does not allow volume expansion.
+type StorageExpansionError struct {
+	Claim        string
+	StorageClass string
+}
+
+func (e *StorageExpansionError) Error() string {
+	if e.StorageClass == "" {
+		return fmt.Sprintf("volume claim %s has no StorageClass and cannot be expanded", e.Claim)
+	}
+	return fmt.Sprintf("StorageClass %s of volume claim %s does not allow volume expansion", e.StorageClass, e.Claim)
+}
+
+// claimSize returns the storage a volume claim requests
+func claimSize(spec corev1.PersistentVolumeClaimSpec) resource.Quantity {
+	return spec.Resources.Requests[corev1.ResourceStorage]
+}
+
+// claimTemplatesChanged reports whether the desired volume claim templates
+// differ from the existing ones in a field the operator sets. Fields the API
+// server defaults are not compared.
+func claimTemplatesChanged(existing, desired []corev1.PersistentVolumeClaim) bool {
+	if len(existing) != len(desired) {
+		return true
+	}
+
+	byName := map[string]corev1.PersistentVolumeClaimSpec{}
+	for _, claim := range existing {
+		byName[claim.Name] = claim.Spec
+	}
+
+	for _, claim := range desired {
+		current, found := byName[claim.Name]
+		if !found {
+			return true
+		}
+		currentSize, desiredSize := claimSize(current), claimSize(claim.Spec)
+		if currentSize.Cmp(desiredSize) != 0 {
+			return true
+		}
+		if len(claim.Spec.AccessModes) > 0 && !equality.Semantic.DeepEqual(current.AccessModes, claim.Spec.AccessModes) {
+			return true
+		}
+		if claim.Spec.StorageClassName != nil && (current.StorageClassName == nil || *current.StorageClassName != *claim.Spec.StorageClassName) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkClaimShrink returns a StorageShrinkError for a desired volume claim
+// template smaller than the existing template of the same name
+func checkClaimShrink(existing, desired *appsv1.StatefulSet) error {
+	sizes := map[string]resource.Quantity{}
+	for _, claim := range existing.Spec.VolumeClaimTemplates {
+		sizes[claim.Name] = claimSize(claim.Spec)
+	}
+
+	for _, claim := range desired.Spec.VolumeClaimTemplates {
+		current, found := sizes[claim.Name]
+		requested := claimSize(claim.Spec)
+		if found && requested.Cmp(current) < 0 {
+			return &StorageShrinkError{
+				StatefulSet: desired.Name,
+				Claim:       claim.Name,
+				Current:     current,
+				Requested:   requested,
+			}
+		}
+	}
+	return nil
+}
+
+// statefulSetClaims returns the volume claims a StatefulSet created from a
+// claim template, including those of pods it was scaled down from
+func statefulSetClaims(claims []corev1.PersistentVolumeClaim, template, statefulSet string) []corev1.PersistentVolumeClaim {
+	prefix := fmt.Sprintf("%s-%s-", template, statefulSet)
+
+	var owned []corev1.PersistentVolumeClaim
+	for _, claim := range claims {
+		ordinal, found := strings.CutPrefix(claim.Name, prefix)
+		if !found {
+			continue
+		}
+		if _, err := strconv.Atoi(ordinal); err == nil {
+			owned = append(owned, claim)
+		}
+	}
+	return owned
+}
+
+// reconcileClaimTemplates brings a StatefulSet's volume claim templates to the
+// desired ones, which the API server does not allow to update. Growing
+// templates first expand the claims already created from them. The
+// StatefulSet is then deleted, leaving its pods and claims in place, and the
+// next reconciliation creates it with the new templates. It reports whether
+// the StatefulSet was deleted. A StorageClass or access modes the existing
+// claims do not have are reported as a StorageClaimError instead, since the
+// pods would keep those claims.
+func (r *ObservabilityStackReconciler) reconcileClaimTemplates(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, existing, desired *appsv1.StatefulSet) (bool, error) {
+	if !claimTemplatesChanged(existing.Spec.VolumeClaimTemplates, desired.Spec.VolumeClaimTemplates) {
+		return false, nil
+	}
+	if err := checkClaimShrink(existing, desired); err != nil {
+		return false, err
+	}
+
+	// Only a StatefulSet already being deleted is left to the garbage collector
+	if existing.DeletionTimestamp != nil {
+		return true, nil
+	}
+
+	claims := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, claims, client.InNamespace(existing.Namespace)); err != nil {
+		return false, fmt.Errorf("failed to list volume claims: %w", err)
+	}
+	// Pods keep their claims, so a new class or access mode could not apply
+	field := specPaths[strings.TrimPrefix(existing.Name, stack.Name+"-")]
+	for _, template := range desired.Spec.VolumeClaimTemplates {
+		for _, claim := range statefulSetClaims(claims.Items, template.Name, existing.Name) {
+			if err := checkClaimSettings(field, &claim, &template); err != nil {
+				return false, err
+			}
+		}
+	}
+	for _, template := range desired.Spec.VolumeClaimTemplates {
+		for _, claim := range statefulSetClaims(claims.Items, template.Name, existing.Name) {
+			if err := r.expandClaim(ctx, stack, &claim, claimSize(template.Spec)); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if err := r.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationOrphan)); err != nil && !errors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to delete StatefulSet %s for new volume claim templates: %w", existing.Name, err)
+	}
+	r.recordObjectEvent(stack, reasonDeleted, existing)
+	return true, nil
+}
+
+// expandClaim raises the storage request of a volume claim smaller than size.
+// Claims already at least as large are left alone.
+func (r *ObservabilityStackReconciler) expandClaim(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, claim *corev1.PersistentVolumeClaim, size resource.Quantity) error {
+	current := claimSize(claim.Spec)
+	if current.Cmp(size) >= 0 {
+		return nil
+	}
+	if err := r.checkVolumeExpansion(ctx, claim); err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(claim.DeepCopy())
+	if claim.Spec.Resources.Requests == nil {
+		claim.Spec.Resources.Requests = corev1.ResourceList{}
+	}
+	claim.Spec.Resources.Requests[corev1.ResourceStorage] = size
+	if err := r.Patch(ctx, claim, patch); err != nil {
+		return fmt.Errorf("failed to expand volume claim %s: %w", claim.Name, err)
+	}
+	r.recordObjectEvent(stack, reasonExpanded, claim)
+	return nil
+}
+
+// checkVolumeExpansion returns a StorageExpansionError unless the StorageClass of a volume
+// claim allows expanding it. An operator that may not read StorageClasses
+// leaves the check to the API server.
+func (r *ObservabilityStackReconciler) checkVolumeExpansion(ctx context.Context, claim *corev1.PersistentVolumeClaim) error {
+	if claim.Spec.StorageClassName == nil || *claim.Spec.StorageClassName == "" {
+		return &StorageExpansionError{Claim: claim.Name}
+	}
+
+	class := &storagev1.StorageClass{}
+	if err := r.Get(ctx, client.ObjectKey{Name: *claim.Spec.StorageClassName}, class); err != nil {
+		if errors.IsForbidden(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get StorageClass %s: %w", *claim.Spec.StorageClassName, err)
+	}
+	if class.AllowVolumeExpansion == nil || !*class.AllowVolumeExpansion {
+		return &StorageExpansionError{Claim: claim.Name, StorageClass: class.Name}
+	}
+	return nil
+}
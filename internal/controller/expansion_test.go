@@ -0,0 +1,181 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+func claimTemplate(name, size string) corev1.PersistentVolumeClaim {
+	return corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(size)},
+			},
+		},
+	}
+}
+
+var _ = Describe("claimTemplatesChanged", func() {
+	var existing []corev1.PersistentVolumeClaim
+
+	BeforeEach(func() {
+		existing = []corev1.PersistentVolumeClaim{claimTemplate("storage", "10Gi")}
+		// Defaulted by the API server
+		existing[0].Spec.VolumeMode = new(corev1.PersistentVolumeMode)
+		*existing[0].Spec.VolumeMode = corev1.PersistentVolumeFilesystem
+		existing[0].Spec.StorageClassName = pointer.String("standard")
+	})
+
+	It("should ignore fields the API server defaults", func() {
+		Expect(claimTemplatesChanged(existing, []corev1.PersistentVolumeClaim{claimTemplate("storage", "10Gi")})).To(BeFalse())
+	})
+
+	It("should compare sizes as quantities", func() {
+		Expect(claimTemplatesChanged(existing, []corev1.PersistentVolumeClaim{claimTemplate("storage", "10240Mi")})).To(BeFalse())
+		Expect(claimTemplatesChanged(existing, []corev1.PersistentVolumeClaim{claimTemplate("storage", "20Gi")})).To(BeTrue())
+	})
+
+	It("should detect a new StorageClass or access modes", func() {
+		desired := claimTemplate("storage", "10Gi")
+		desired.Spec.StorageClassName = pointer.String("fast")
+		Expect(claimTemplatesChanged(existing, []corev1.PersistentVolumeClaim{desired})).To(BeTrue())
+
+		desired = claimTemplate("storage", "10Gi")
+		desired.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod}
+		Expect(claimTemplatesChanged(existing, []corev1.PersistentVolumeClaim{desired})).To(BeTrue())
+	})
+
+	It("should detect templates replaced by an emptyDir", func() {
+		Expect(claimTemplatesChanged(existing, nil)).To(BeTrue())
+	})
+})
+
+var _ = Describe("checkClaimShrink", func() {
+	statefulSet := func(size string) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-prometheus"},
+			Spec: appsv1.StatefulSetSpec{
+				VolumeClaimTemplates: []corev1.PersistentVolumeClaim{claimTemplate("storage", size)},
+			},
+		}
+	}
+
+	It("should allow growing claims", func() {
+		Expect(checkClaimShrink(statefulSet("10Gi"), statefulSet("20Gi"))).To(Succeed())
+	})
+
+	It("should report shrinking claims as an invalid spec", func() {
+		err := checkClaimShrink(statefulSet("10Gi"), statefulSet("5Gi"))
+		Expect(err).To(MatchError(`volume claim "storage" of StatefulSet test-prometheus cannot shrink from 10Gi to 5Gi`))
+
+		reason, specErr := invalidSpec(fmt.Errorf("failed to reconcile Prometheus StatefulSet: %w", err))
+		Expect(reason).To(Equal(reasonInvalidStorage))
+		Expect(specErr).To(Equal(err))
+	})
+})
+
+var _ = Describe("checkVolumeExpansion", func() {
+	claim := func(class string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "storage-test-prometheus-0"},
+			Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: pointer.String(class)},
+		}
+	}
+
+	It("should allow a StorageClass that allows volume expansion", func() {
+		c := newFakeClient(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "expandable"}, AllowVolumeExpansion: pointer.Bool(true)})
+		r := &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme()}
+		Expect(r.checkVolumeExpansion(context.Background(), claim("expandable"))).To(Succeed())
+	})
+
+	It("should report a StorageClass without volume expansion as an invalid spec", func() {
+		c := newFakeClient(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fixed"}})
+		r := &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme()}
+
+		err := r.checkVolumeExpansion(context.Background(), claim("fixed"))
+		Expect(err).To(MatchError("StorageClass fixed of volume claim storage-test-prometheus-0 does not allow volume expansion"))
+
+		reason, specErr := invalidSpec(fmt.Errorf("failed to reconcile Prometheus StatefulSet: %w", err))
+		Expect(reason).To(Equal(reasonInvalidStorage))
+		Expect(specErr).To(Equal(err))
+	})
+})
+
+var _ = Describe("statefulSetClaims", func() {
+	It("should select the claims created from a template", func() {
+		var claims []corev1.PersistentVolumeClaim
+		for _, name := range []string{
+			"storage-test-prometheus-0",
+			"storage-test-prometheus-2",
+			"storage-test-prometheus-old",
+			"storage-test-prometheus-thanos-0",
+			"data-test-prometheus-0",
+			"test-grafana",
+		} {
+			claims = append(claims, corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+
+		var names []string
+		for _, claim := range statefulSetClaims(claims, "storage", "test-prometheus") {
+			names = append(names, claim.Name)
+		}
+		Expect(names).To(ConsistOf("storage-test-prometheus-0", "storage-test-prometheus-2"))
+	})
+})
+
+var _ = Describe("reconcileClaimTemplates", func() {
+	It("should refuse a StorageClass the existing claims do not have", func() {
+		stack := &monitoringv1alpha1.ObservabilityStack{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "monitoring"}}
+		statefulSet := func(class string) *appsv1.StatefulSet {
+			template := claimTemplate("storage", "10Gi")
+			template.Spec.StorageClassName = pointer.String(class)
+			return &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-prometheus", Namespace: "monitoring"},
+				Spec:       appsv1.StatefulSetSpec{VolumeClaimTemplates: []corev1.PersistentVolumeClaim{template}},
+			}
+		}
+		existing := statefulSet("standard")
+		claim := claimTemplate("storage-test-prometheus-0", "10Gi")
+		claim.Namespace = "monitoring"
+		claim.Spec.StorageClassName = pointer.String("standard")
+
+		c := newFakeClient(existing, &claim)
+		r := &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(10)}
+
+		deleted, err := r.reconcileClaimTemplates(context.Background(), stack, existing, statefulSet("fast"))
+		Expect(deleted).To(BeFalse())
+		Expect(err).To(MatchError("spec.prometheus.storageClassName of volume claim storage-test-prometheus-0 cannot change from standard to fast; delete the claim to recreate it"))
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(existing), &appsv1.StatefulSet{})).To(Succeed())
+	})
+})
@@ -0,0 +1,735 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	grafanaAlertingVolume    = "alerting"
+	grafanaAlertingMountPath = "/etc/grafana/provisioning/alerting"
+	grafanaAlertingFile      = "alerting.yaml"
+
+	// alertingChecksumAnnotation on the pod template restarts Grafana when
+	// its alerting provisioning changes, as Grafana only reads it on start
+	alertingChecksumAnnotation = "monitoring.example.com/alerting-checksum"
+
+	// grafanaMainOrg is the organization alerting is provisioned in
+	grafanaMainOrg = 1
+
+	// grafanaExpressionDataSource runs the server-side expressions of a rule
+	grafanaExpressionDataSource = "__expr__"
+
+	reasonInvalidAlerting = "InvalidAlerting"
+)
+
+// InvalidAlertingError reports alerting resources of the spec Grafana would
+// refuse to provision
+type InvalidAlertingError struct {
+	Field   string
+	Message string
+}
+
+func (e *InvalidAlertingError) Error() string {
+	return fmt.Sprintf("spec.grafana.alerting.%s: %s", e.Field, e.Message)
+}
+
+// grafanaAlertingConfigured reports whether the spec provisions any alerting
+// resources
+func grafanaAlertingConfigured(alerting monitoringv1alpha1.GrafanaAlertingSpec) bool {
+	return len(alerting.ContactPoints) > 0 || alerting.Policy != nil ||
+		len(alerting.MuteTimings) > 0 || len(alerting.RuleGroups) > 0
+}
+
+// grafanaAlertingProvisioningName names the ConfigMap of the alerting
+// provisioning file
+func grafanaAlertingProvisioningName(stack *monitoringv1alpha1.ObservabilityStack) string {
+	return fmt.Sprintf("%s-grafana-alerting-provisioning", stack.Name)
+}
+
+// stackDataSourceKinds lists the data sources of the stack the alert rules
+// query without a dataSourceUID
+func stackDataSourceKinds(alerting monitoringv1alpha1.GrafanaAlertingSpec) []string {
+	seen := map[string]bool{}
+	var kinds []string
+	for _, group := range alerting.RuleGroups {
+		for _, rule := range group.Rules {
+			kind := defaultString(rule.DataSource, componentPrometheus)
+			if rule.DataSourceUID == "" && !seen[kind] {
+				seen[kind] = true
+				kinds = append(kinds, kind)
+			}
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// setAlertingDataSourceUIDs gives the stack's data sources the alert rules
+// query a UID to refer to them by, keeping any UID they already have. It
+// returns the UIDs by data source kind.
+func setAlertingDataSourceUIDs(configMap *corev1.ConfigMap, stack *monitoringv1alpha1.ObservabilityStack) (map[string]string, error) {
+	kinds := stackDataSourceKinds(stack.Spec.Grafana.Alerting)
+	if len(kinds) == 0 {
+		return nil, nil
+	}
+
+	uids := map[string]string{}
+	err := patchYAMLConfig(configMap, "datasources.yaml", func(config map[string]interface{}) {
+		datasources, _ := config["datasources"].([]interface{})
+		for _, kind := range kinds {
+			host := fmt.Sprintf("//%s-%s:", stack.Name, kind)
+			// Tenant data sources share the URL of the first one
+			for _, d := range datasources {
+				datasource, ok := d.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if url, _ := datasource["url"].(string); !strings.Contains(url, host) {
+					continue
+				}
+				uid, _ := datasource["uid"].(string)
+				if uid == "" {
+					uid = kind
+					datasource["uid"] = uid
+				}
+				uids[kind] = uid
+				break
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kind := range kinds {
+		if uids[kind] == "" {
+			return nil, &InvalidAlertingError{Field: "ruleGroups", Message: fmt.Sprintf("rules query the %s data source, which the stack does not deploy", kind)}
+		}
+	}
+	return uids, nil
+}
+
+// escapeInterpolation keeps Grafana from expanding "$" in values of the spec
+// as environment variables when it reads the provisioning files
+func escapeInterpolation(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return strings.ReplaceAll(v, "$", "$$")
+	case []interface{}:
+		for i := range v {
+			v[i] = escapeInterpolation(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = escapeInterpolation(v[key])
+		}
+	}
+	return value
+}
+
+// stringList converts a list for the provisioning file
+func stringList(values []string) []interface{} {
+	list := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		list = append(list, value)
+	}
+	return list
+}
+
+// stringMap converts a map for the provisioning file
+func stringMap(values map[string]string) map[string]interface{} {
+	converted := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		converted[key] = value
+	}
+	return converted
+}
+
+// alertingContactPoints renders the contact points. Secure settings refer to
+// environment variables, which Grafana expands when it reads the file, so the
+// file holds no secrets; the variables are returned.
+func alertingContactPoints(contactPoints []monitoringv1alpha1.GrafanaContactPoint) ([]interface{}, []corev1.EnvVar, error) {
+	var rendered []interface{}
+	var env []corev1.EnvVar
+	for i, contactPoint := range contactPoints {
+		var receivers []interface{}
+		for j, receiver := range contactPoint.Receivers {
+			settings := map[string]interface{}{}
+			if receiver.Settings != nil && len(receiver.Settings.Raw) > 0 {
+				if err := json.Unmarshal(receiver.Settings.Raw, &settings); err != nil {
+					return nil, nil, &InvalidAlertingError{
+						Field:   fmt.Sprintf("contactPoints[%d].receivers[%d].settings", i, j),
+						Message: "must be an object",
+					}
+				}
+			}
+			escapeInterpolation(settings)
+
+			names := make([]string, 0, len(receiver.SecureSettings))
+			for name := range receiver.SecureSettings {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				selector := receiver.SecureSettings[name]
+				variable := fmt.Sprintf("ALERTING_SECRET_%d", len(env))
+				env = append(env, secretEnvVar(variable, selector.Name, selector.Key))
+				settings[name] = "${" + variable + "}"
+			}
+
+			receivers = append(receivers, map[string]interface{}{
+				"uid":                   escapeInterpolation(receiver.UID),
+				"type":                  escapeInterpolation(receiver.Type),
+				"settings":              settings,
+				"disableResolveMessage": receiver.DisableResolveMessage,
+			})
+		}
+		rendered = append(rendered, map[string]interface{}{
+			"orgId":     grafanaMainOrg,
+			"name":      escapeInterpolation(contactPoint.Name),
+			"receivers": receivers,
+		})
+	}
+	return rendered, env, nil
+}
+
+// setNotificationTiming renders the grouping and pacing of a policy or route
+func setNotificationTiming(policy map[string]interface{}, timing monitoringv1alpha1.GrafanaNotificationTiming) {
+	if len(timing.GroupBy) > 0 {
+		policy["group_by"] = stringList(timing.GroupBy)
+	}
+	for key, value := range map[string]string{
+		"group_wait":      timing.GroupWait,
+		"group_interval":  timing.GroupInterval,
+		"repeat_interval": timing.RepeatInterval,
+	} {
+		if value != "" {
+			policy[key] = value
+		}
+	}
+}
+
+// alertingPolicy renders the notification policy tree
+func alertingPolicy(policy *monitoringv1alpha1.GrafanaNotificationPolicy) map[string]interface{} {
+	root := map[string]interface{}{
+		"orgId":    grafanaMainOrg,
+		"receiver": policy.Receiver,
+	}
+	setNotificationTiming(root, policy.GrafanaNotificationTiming)
+
+	var routes []interface{}
+	for _, route := range policy.Routes {
+		rendered := map[string]interface{}{}
+		if route.Receiver != "" {
+			rendered["receiver"] = route.Receiver
+		}
+		if len(route.Matchers) > 0 {
+			var matchers []interface{}
+			for _, matcher := range route.Matchers {
+				matchers = append(matchers, []interface{}{matcher.Label, defaultString(matcher.Operator, "="), matcher.Value})
+			}
+			rendered["object_matchers"] = matchers
+		}
+		setNotificationTiming(rendered, route.GrafanaNotificationTiming)
+		if len(route.MuteTimings) > 0 {
+			rendered["mute_time_intervals"] = stringList(route.MuteTimings)
+		}
+		if route.Continue {
+			rendered["continue"] = true
+		}
+		routes = append(routes, rendered)
+	}
+	if len(routes) > 0 {
+		root["routes"] = routes
+	}
+	return escapeInterpolation(root).(map[string]interface{})
+}
+
+// alertingMuteTimes renders the mute timings
+func alertingMuteTimes(muteTimings []monitoringv1alpha1.GrafanaMuteTiming) []interface{} {
+	var rendered []interface{}
+	for _, muteTiming := range muteTimings {
+		var intervals []interface{}
+		for _, interval := range muteTiming.TimeIntervals {
+			fields := map[string]interface{}{}
+			if len(interval.Times) > 0 {
+				var times []interface{}
+				for _, timeRange := range interval.Times {
+					times = append(times, map[string]interface{}{"start_time": timeRange.StartTime, "end_time": timeRange.EndTime})
+				}
+				fields["times"] = times
+			}
+			for key, values := range map[string][]string{
+				"weekdays":      interval.Weekdays,
+				"days_of_month": interval.DaysOfMonth,
+				"months":        interval.Months,
+				"years":         interval.Years,
+			} {
+				if len(values) > 0 {
+					fields[key] = stringList(values)
+				}
+			}
+			if interval.Location != "" {
+				fields["location"] = interval.Location
+			}
+			intervals = append(intervals, fields)
+		}
+		rendered = append(rendered, map[string]interface{}{
+			"orgId":          grafanaMainOrg,
+			"name":           muteTiming.Name,
+			"time_intervals": intervals,
+		})
+	}
+	return escapeInterpolation(rendered).([]interface{})
+}
+
+// alertRuleData renders the queries of a rule: A runs the rule's query, B
+// reduces each series to its last value and C compares it to the threshold
+func alertRuleData(rule monitoringv1alpha1.GrafanaAlertRule, dataSourceUID string) []interface{} {
+	query := map[string]interface{}{"refId": "A", "expr": rule.Expr}
+	if defaultString(rule.DataSource, componentPrometheus) == componentLoki {
+		query["queryType"] = "instant"
+	} else {
+		query["instant"] = true
+	}
+	// The threshold matches the pattern of the CRD
+	threshold, _ := strconv.ParseFloat(defaultString(rule.Threshold, "0"), 64)
+
+	return []interface{}{
+		map[string]interface{}{
+			"refId":             "A",
+			"datasourceUid":     dataSourceUID,
+			"relativeTimeRange": map[string]interface{}{"from": 600, "to": 0},
+			"model":             query,
+		},
+		map[string]interface{}{
+			"refId":         "B",
+			"datasourceUid": grafanaExpressionDataSource,
+			"model": map[string]interface{}{
+				"refId":      "B",
+				"type":       "reduce",
+				"expression": "A",
+				"reducer":    "last",
+			},
+		},
+		map[string]interface{}{
+			"refId":         "C",
+			"datasourceUid": grafanaExpressionDataSource,
+			"model": map[string]interface{}{
+				"refId":      "C",
+				"type":       "threshold",
+				"expression": "B",
+				"conditions": []interface{}{
+					map[string]interface{}{
+						"evaluator": map[string]interface{}{
+							"type":   defaultString(rule.Operator, "gt"),
+							"params": []interface{}{threshold},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// alertingRuleGroups renders the alert rule groups
+func alertingRuleGroups(groups []monitoringv1alpha1.GrafanaAlertRuleGroup, dataSourceUIDs map[string]string) []interface{} {
+	var rendered []interface{}
+	for _, group := range groups {
+		var rules []interface{}
+		for _, rule := range group.Rules {
+			dataSourceUID := rule.DataSourceUID
+			if dataSourceUID == "" {
+				dataSourceUID = dataSourceUIDs[defaultString(rule.DataSource, componentPrometheus)]
+			}
+			fields := map[string]interface{}{
+				"uid":          rule.UID,
+				"title":        rule.Title,
+				"condition":    "C",
+				"data":         alertRuleData(rule, dataSourceUID),
+				"noDataState":  defaultString(rule.NoDataState, "NoData"),
+				"execErrState": defaultString(rule.ExecErrState, "Error"),
+				"for":          defaultString(rule.For, "0s"),
+			}
+			if len(rule.Labels) > 0 {
+				fields["labels"] = stringMap(rule.Labels)
+			}
+			if len(rule.Annotations) > 0 {
+				fields["annotations"] = stringMap(rule.Annotations)
+			}
+			rules = append(rules, fields)
+		}
+		rendered = append(rendered, map[string]interface{}{
+			"orgId":    grafanaMainOrg,
+			"name":     group.Name,
+			"folder":   group.Folder,
+			"interval": defaultString(group.Interval, "1m"),
+			"rules":    rules,
+		})
+	}
+	return escapeInterpolation(rendered).([]interface{})
+}
+
+// validateGrafanaAlerting checks what Grafana would refuse: references to
+// contact points and mute timings the spec does not define, duplicate UIDs,
+// evaluation intervals and queries. PromQL queries are checked against the
+// given Prometheus version.
+func validateGrafanaAlerting(alerting monitoringv1alpha1.GrafanaAlertingSpec, promqlVersion [3]int) error {
+	contactPoints := map[string]bool{}
+	receiverUIDs := map[string]bool{}
+	for i, contactPoint := range alerting.ContactPoints {
+		contactPoints[contactPoint.Name] = true
+		for j, receiver := range contactPoint.Receivers {
+			if receiverUIDs[receiver.UID] {
+				return &InvalidAlertingError{
+					Field:   fmt.Sprintf("contactPoints[%d].receivers[%d].uid", i, j),
+					Message: fmt.Sprintf("receiver UID %q is used twice", receiver.UID),
+				}
+			}
+			receiverUIDs[receiver.UID] = true
+		}
+	}
+
+	muteTimings := map[string]bool{}
+	for _, muteTiming := range alerting.MuteTimings {
+		muteTimings[muteTiming.Name] = true
+	}
+
+	if policy := alerting.Policy; policy != nil {
+		if !contactPoints[policy.Receiver] {
+			return &InvalidAlertingError{Field: "policy.receiver", Message: fmt.Sprintf("contact point %q is not defined", policy.Receiver)}
+		}
+		for i, route := range policy.Routes {
+			if route.Receiver != "" && !contactPoints[route.Receiver] {
+				return &InvalidAlertingError{
+					Field:   fmt.Sprintf("policy.routes[%d].receiver", i),
+					Message: fmt.Sprintf("contact point %q is not defined", route.Receiver),
+				}
+			}
+			for _, name := range route.MuteTimings {
+				if !muteTimings[name] {
+					return &InvalidAlertingError{
+						Field:   fmt.Sprintf("policy.routes[%d].muteTimings", i),
+						Message: fmt.Sprintf("mute timing %q is not defined", name),
+					}
+				}
+			}
+		}
+	}
+
+	ruleUIDs := map[string]bool{}
+	for i, group := range alerting.RuleGroups {
+		interval, err := time.ParseDuration(defaultString(group.Interval, "1m"))
+		if err != nil || interval <= 0 || interval%(10*time.Second) != 0 {
+			return &InvalidAlertingError{
+				Field:   fmt.Sprintf("ruleGroups[%d].interval", i),
+				Message: fmt.Sprintf("interval %q is not a multiple of 10s", group.Interval),
+			}
+		}
+
+		for j, rule := range group.Rules {
+			field := fmt.Sprintf("ruleGroups[%d].rules[%d]", i, j)
+			if ruleUIDs[rule.UID] {
+				return &InvalidAlertingError{Field: field + ".uid", Message: fmt.Sprintf("rule UID %q is used twice", rule.UID)}
+			}
+			ruleUIDs[rule.UID] = true
+
+			// Queries of other data sources are left to Grafana
+			if rule.DataSourceUID != "" {
+				continue
+			}
+			validate := func(expr string) error { return validatePromQL(expr, promqlVersion) }
+			if rule.DataSource == componentLoki {
+				validate = validateLogQL
+			}
+			if err := validate(rule.Expr); err != nil {
+				return &InvalidAlertingError{Field: field + ".expr", Message: err.Error()}
+			}
+		}
+	}
+	return nil
+}
+
+// provisionedAlerting identifies the resources a provisioning file creates
+// and deletes, to delete the ones that leave the spec
+type provisionedAlerting struct {
+	ContactPoints []struct {
+		Receivers []struct {
+			UID string `json:"uid"`
+		} `json:"receivers"`
+	} `json:"contactPoints"`
+	DeleteContactPoints []alertingOrgUID `json:"deleteContactPoints"`
+
+	Policies      []json.RawMessage `json:"policies"`
+	ResetPolicies []int             `json:"resetPolicies"`
+
+	MuteTimes []struct {
+		Name string `json:"name"`
+	} `json:"muteTimes"`
+	DeleteMuteTimes []alertingOrgName `json:"deleteMuteTimes"`
+
+	Groups []struct {
+		Rules []struct {
+			UID string `json:"uid"`
+		} `json:"rules"`
+	} `json:"groups"`
+	DeleteRules []alertingOrgUID `json:"deleteRules"`
+}
+
+type alertingOrgUID struct {
+	OrgID int    `json:"orgId"`
+	UID   string `json:"uid"`
+}
+
+type alertingOrgName struct {
+	OrgID int    `json:"orgId"`
+	Name  string `json:"name"`
+}
+
+// unescapeInterpolation reverses escapeInterpolation
+func unescapeInterpolation(value string) string {
+	return strings.ReplaceAll(value, "$$", "$")
+}
+
+// alertingDeletions lists what a previous provisioning file created, or was
+// deleting, and the spec no longer has. Deletions stay in the file, since
+// Grafana only applies them when a pod starts with it.
+func alertingDeletions(previous string, alerting monitoringv1alpha1.GrafanaAlertingSpec) (map[string]interface{}, error) {
+	deletions := map[string]interface{}{}
+	if previous == "" {
+		return deletions, nil
+	}
+	var provisioned provisionedAlerting
+	if err := yaml.Unmarshal([]byte(previous), &provisioned); err != nil {
+		return nil, fmt.Errorf("failed to parse the provisioned alerting resources: %w", err)
+	}
+
+	receivers := map[string]bool{}
+	for _, contactPoint := range alerting.ContactPoints {
+		for _, receiver := range contactPoint.Receivers {
+			receivers[receiver.UID] = true
+		}
+	}
+	muteTimings := map[string]bool{}
+	for _, muteTiming := range alerting.MuteTimings {
+		muteTimings[muteTiming.Name] = true
+	}
+	rules := map[string]bool{}
+	for _, group := range alerting.RuleGroups {
+		for _, rule := range group.Rules {
+			rules[rule.UID] = true
+		}
+	}
+
+	var deleteReceivers, deleteMuteTimes, deleteRules []string
+	for _, deletion := range provisioned.DeleteContactPoints {
+		deleteReceivers = append(deleteReceivers, deletion.UID)
+	}
+	for _, contactPoint := range provisioned.ContactPoints {
+		for _, receiver := range contactPoint.Receivers {
+			deleteReceivers = append(deleteReceivers, receiver.UID)
+		}
+	}
+	for _, deletion := range provisioned.DeleteMuteTimes {
+		deleteMuteTimes = append(deleteMuteTimes, unescapeInterpolation(deletion.Name))
+	}
+	for _, muteTime := range provisioned.MuteTimes {
+		deleteMuteTimes = append(deleteMuteTimes, unescapeInterpolation(muteTime.Name))
+	}
+	for _, deletion := range provisioned.DeleteRules {
+		deleteRules = append(deleteRules, deletion.UID)
+	}
+	for _, group := range provisioned.Groups {
+		for _, rule := range group.Rules {
+			deleteRules = append(deleteRules, rule.UID)
+		}
+	}
+
+	if list := alertingDeletionList(deleteReceivers, receivers, "uid"); len(list) > 0 {
+		deletions["deleteContactPoints"] = list
+	}
+	if list := alertingDeletionList(deleteMuteTimes, muteTimings, "name"); len(list) > 0 {
+		deletions["deleteMuteTimes"] = list
+	}
+	if list := alertingDeletionList(deleteRules, rules, "uid"); len(list) > 0 {
+		deletions["deleteRules"] = list
+	}
+	if alerting.Policy == nil && (len(provisioned.Policies) > 0 || len(provisioned.ResetPolicies) > 0) {
+		deletions["resetPolicies"] = []interface{}{grafanaMainOrg}
+	}
+	return deletions, nil
+}
+
+// alertingDeletionList renders the sorted deletions of the values the spec
+// does not keep
+func alertingDeletionList(values []string, kept map[string]bool, field string) []interface{} {
+	unique := map[string]bool{}
+	for _, value := range values {
+		if !kept[value] {
+			unique[value] = true
+		}
+	}
+	names := make([]string, 0, len(unique))
+	for value := range unique {
+		names = append(names, value)
+	}
+	sort.Strings(names)
+
+	var list []interface{}
+	for _, name := range names {
+		list = append(list, map[string]interface{}{"orgId": grafanaMainOrg, field: escapeInterpolation(name)})
+	}
+	return list
+}
+
+// renderGrafanaAlerting renders the provisioning file of the spec, with the
+// deletions of what a previous file provisioned. It returns the environment
+// variables the secure settings are read from.
+func renderGrafanaAlerting(alerting monitoringv1alpha1.GrafanaAlertingSpec, promqlVersion [3]int, dataSourceUIDs map[string]string, previous string) (string, []corev1.EnvVar, error) {
+	if err := validateGrafanaAlerting(alerting, promqlVersion); err != nil {
+		return "", nil, err
+	}
+
+	file, err := alertingDeletions(previous, alerting)
+	if err != nil {
+		return "", nil, err
+	}
+	file["apiVersion"] = 1
+
+	contactPoints, env, err := alertingContactPoints(alerting.ContactPoints)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(contactPoints) > 0 {
+		file["contactPoints"] = contactPoints
+	}
+	if alerting.Policy != nil {
+		file["policies"] = []interface{}{alertingPolicy(alerting.Policy)}
+	}
+	if len(alerting.MuteTimings) > 0 {
+		file["muteTimes"] = alertingMuteTimes(alerting.MuteTimings)
+	}
+	if len(alerting.RuleGroups) > 0 {
+		file["groups"] = alertingRuleGroups(alerting.RuleGroups, dataSourceUIDs)
+	}
+
+	out, err := yaml.Marshal(file)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to write %s: %w", grafanaAlertingFile, err)
+	}
+	return string(out), env, nil
+}
+
+// reconcileGrafanaAlerting writes Grafana's alerting provisioning file to its
+// ConfigMap. Once written, the ConfigMap stays after the spec drops its
+// alerting resources, so that Grafana deletes them. It returns the checksum of
+// the file and the environment variables of its secure settings, or an empty
+// checksum when there is nothing to provision.
+func (r *ObservabilityStackReconciler) reconcileGrafanaAlerting(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, labels map[string]string, dataSourceUIDs map[string]string) (string, []corev1.EnvVar, error) {
+	alerting := stack.Spec.Grafana.Alerting
+
+	existing := &corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: stack.Namespace, Name: grafanaAlertingProvisioningName(stack)}
+	if err := r.Get(ctx, key, existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", nil, fmt.Errorf("failed to get Grafana alerting ConfigMap: %w", err)
+		}
+		if !grafanaAlertingConfigured(alerting) {
+			return "", nil, nil
+		}
+	}
+
+	for _, contactPoint := range alerting.ContactPoints {
+		for _, receiver := range contactPoint.Receivers {
+			for name, selector := range receiver.SecureSettings {
+				description := fmt.Sprintf("contact point %s setting %s", contactPoint.Name, name)
+				if err := r.checkSecretKeys(ctx, stack.Namespace, description, selector.Name, selector.Key); err != nil {
+					return "", nil, err
+				}
+			}
+		}
+	}
+
+	promqlVersion, err := r.prometheusRulesVersion(ctx, stack)
+	if err != nil {
+		return "", nil, err
+	}
+	data, env, err := renderGrafanaAlerting(alerting, promqlVersion, dataSourceUIDs, existing.Data[grafanaAlertingFile])
+	if err != nil {
+		return "", nil, err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      grafanaAlertingProvisioningName(stack),
+			Namespace: stack.Namespace,
+			Labels:    labels,
+		},
+		Data: map[string]string{grafanaAlertingFile: data},
+	}
+	if err := ctrl.SetControllerReference(stack, configMap, r.Scheme); err != nil {
+		return "", nil, fmt.Errorf("failed to set controller reference on configmap: %w", err)
+	}
+	if err := r.createOrUpdate(ctx, stack, configMap); err != nil {
+		return "", nil, fmt.Errorf("failed to reconcile Grafana alerting ConfigMap: %w", err)
+	}
+	return rulesChecksum(configMap.Data), env, nil
+}
+
+// mountGrafanaAlerting mounts the alerting provisioning into Grafana, with the
+// environment variables of its secure settings, and annotates the pod
+// template with the checksum of the file
+func mountGrafanaAlerting(template *corev1.PodTemplateSpec, configMapName, checksum string, env []corev1.EnvVar) {
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[alertingChecksumAnnotation] = checksum
+
+	podSpec := &template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: grafanaAlertingVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+			},
+		},
+	})
+
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      grafanaAlertingVolume,
+		MountPath: grafanaAlertingMountPath,
+		ReadOnly:  true,
+	})
+	container.Env = append(container.Env, env...)
+}
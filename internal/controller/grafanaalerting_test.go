@@ -0,0 +1,206 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Grafana alerting provisioning", func() {
+	var alerting monitoringv1alpha1.GrafanaAlertingSpec
+
+	render := func(previous string) (string, map[string]interface{}, []corev1.EnvVar) {
+		data, env, err := renderGrafanaAlerting(alerting, [3]int{2, 45, 0}, map[string]string{componentPrometheus: "prometheus"}, previous)
+		Expect(err).NotTo(HaveOccurred())
+		file := map[string]interface{}{}
+		Expect(yaml.Unmarshal([]byte(data), &file)).To(Succeed())
+		return data, file, env
+	}
+
+	BeforeEach(func() {
+		alerting = monitoringv1alpha1.GrafanaAlertingSpec{
+			ContactPoints: []monitoringv1alpha1.GrafanaContactPoint{{
+				Name: "oncall",
+				Receivers: []monitoringv1alpha1.GrafanaReceiver{{
+					UID:      "oncall-slack",
+					Type:     "slack",
+					Settings: &runtime.RawExtension{Raw: []byte(`{"recipient": "#oncall", "text": "{{ $labels.job }}"}`)},
+					SecureSettings: map[string]corev1.SecretKeySelector{
+						"url": {LocalObjectReference: corev1.LocalObjectReference{Name: "slack"}, Key: "webhook"},
+					},
+				}},
+			}},
+			Policy: &monitoringv1alpha1.GrafanaNotificationPolicy{
+				Receiver: "oncall",
+				Routes: []monitoringv1alpha1.GrafanaNotificationRoute{{
+					Matchers:    []monitoringv1alpha1.GrafanaMatcher{{Label: "severity", Value: "critical"}},
+					MuteTimings: []string{"weekends"},
+				}},
+			},
+			MuteTimings: []monitoringv1alpha1.GrafanaMuteTiming{{
+				Name:          "weekends",
+				TimeIntervals: []monitoringv1alpha1.GrafanaTimeInterval{{Weekdays: []string{"saturday", "sunday"}}},
+			}},
+			RuleGroups: []monitoringv1alpha1.GrafanaAlertRuleGroup{{
+				Name:   "availability",
+				Folder: "Platform",
+				Rules: []monitoringv1alpha1.GrafanaAlertRule{{
+					UID:         "targets-down",
+					Title:       "Targets down",
+					Expr:        "count(up == 0)",
+					For:         "5m",
+					Annotations: map[string]string{"summary": "{{ $values.B }} targets are down"},
+				}},
+			}},
+		}
+	})
+
+	It("should read secure settings from environment variables", func() {
+		data, file, env := render("")
+
+		Expect(data).NotTo(ContainSubstring("webhook"))
+		Expect(env).To(ConsistOf(secretEnvVar("ALERTING_SECRET_0", "slack", "webhook")))
+
+		contactPoint := file["contactPoints"].([]interface{})[0].(map[string]interface{})
+		receiver := contactPoint["receivers"].([]interface{})[0].(map[string]interface{})
+		Expect(receiver["settings"]).To(Equal(map[string]interface{}{
+			"recipient": "#oncall",
+			"text":      "{{ $$labels.job }}",
+			"url":       "${ALERTING_SECRET_0}",
+		}))
+	})
+
+	It("should render the policies, mute timings and rules", func() {
+		_, file, _ := render("")
+
+		policy := file["policies"].([]interface{})[0].(map[string]interface{})
+		Expect(policy["receiver"]).To(Equal("oncall"))
+		route := policy["routes"].([]interface{})[0].(map[string]interface{})
+		Expect(route["object_matchers"]).To(Equal([]interface{}{[]interface{}{"severity", "=", "critical"}}))
+		Expect(route["mute_time_intervals"]).To(Equal([]interface{}{"weekends"}))
+
+		Expect(file["muteTimes"]).To(HaveLen(1))
+
+		group := file["groups"].([]interface{})[0].(map[string]interface{})
+		Expect(group["folder"]).To(Equal("Platform"))
+		Expect(group["interval"]).To(Equal("1m"))
+		rule := group["rules"].([]interface{})[0].(map[string]interface{})
+		Expect(rule["condition"]).To(Equal("C"))
+		Expect(rule["annotations"]).To(HaveKeyWithValue("summary", "{{ $$values.B }} targets are down"))
+
+		data := rule["data"].([]interface{})
+		Expect(data).To(HaveLen(3))
+		query := data[0].(map[string]interface{})
+		Expect(query["datasourceUid"]).To(Equal("prometheus"))
+		Expect(query["model"]).To(HaveKeyWithValue("expr", "count(up == 0)"))
+		threshold := data[2].(map[string]interface{})["model"].(map[string]interface{})
+		Expect(threshold["conditions"]).To(Equal([]interface{}{map[string]interface{}{
+			"evaluator": map[string]interface{}{"type": "gt", "params": []interface{}{float64(0)}},
+		}}))
+	})
+
+	It("should delete what leaves the spec, until it comes back", func() {
+		previous, _, _ := render("")
+
+		alerting.Policy = nil
+		alerting.MuteTimings = nil
+		alerting.RuleGroups = nil
+		previous, file, _ := render(previous)
+		Expect(file["resetPolicies"]).To(Equal([]interface{}{float64(1)}))
+		Expect(file["deleteMuteTimes"]).To(Equal([]interface{}{map[string]interface{}{"orgId": float64(1), "name": "weekends"}}))
+		Expect(file["deleteRules"]).To(Equal([]interface{}{map[string]interface{}{"orgId": float64(1), "uid": "targets-down"}}))
+		Expect(file).NotTo(HaveKey("deleteContactPoints"))
+
+		// The deletions stay for the pods started later
+		_, file, _ = render(previous)
+		Expect(file).To(HaveKey("deleteRules"))
+
+		alerting.RuleGroups = []monitoringv1alpha1.GrafanaAlertRuleGroup{{
+			Name:   "availability",
+			Folder: "Platform",
+			Rules:  []monitoringv1alpha1.GrafanaAlertRule{{UID: "targets-down", Title: "Targets down", Expr: "count(up == 0)"}},
+		}}
+		_, file, _ = render(previous)
+		Expect(file).NotTo(HaveKey("deleteRules"))
+		Expect(file).To(HaveKey("deleteMuteTimes"))
+	})
+
+	It("should refuse what Grafana would not provision", func() {
+		for _, edit := range []func(){
+			func() { alerting.Policy.Receiver = "unknown" },
+			func() { alerting.Policy.Routes[0].MuteTimings = []string{"unknown"} },
+			func() { alerting.RuleGroups[0].Interval = "15s" },
+			func() { alerting.RuleGroups[0].Rules[0].Expr = "count(up ==" },
+		} {
+			saved := *alerting.DeepCopy()
+			edit()
+
+			_, _, err := renderGrafanaAlerting(alerting, [3]int{2, 45, 0}, nil, "")
+			var alertingErr *InvalidAlertingError
+			Expect(errors.As(err, &alertingErr)).To(BeTrue())
+			reason, _ := invalidSpec(err)
+			Expect(reason).To(Equal(reasonInvalidAlerting))
+
+			alerting = saved
+		}
+	})
+
+	It("should refer to the stack's data sources by UID", func() {
+		stack := &monitoringv1alpha1.ObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "monitoring"},
+		}
+		stack.Spec.Grafana.Alerting = alerting
+		stack.Spec.Grafana.Alerting.RuleGroups[0].Rules = append(stack.Spec.Grafana.Alerting.RuleGroups[0].Rules,
+			monitoringv1alpha1.GrafanaAlertRule{UID: "errors", Title: "Errors", DataSource: componentLoki, Expr: `sum(rate({app="api"} |= "error" [5m]))`})
+		configMap := &corev1.ConfigMap{Data: map[string]string{"datasources.yaml": `datasources:
+- name: Prometheus
+  type: prometheus
+  url: http://test-prometheus:9090
+- name: Loki
+  type: loki
+  uid: logs
+  url: http://test-loki:3100
+- name: Loki (team-a)
+  type: loki
+  url: http://test-loki:3100
+`}}
+
+		uids, err := setAlertingDataSourceUIDs(configMap, stack)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uids).To(Equal(map[string]string{componentPrometheus: "prometheus", componentLoki: "logs"}))
+
+		config := map[string]interface{}{}
+		Expect(yaml.Unmarshal([]byte(configMap.Data["datasources.yaml"]), &config)).To(Succeed())
+		datasources := config["datasources"].([]interface{})
+		Expect(datasources[0]).To(HaveKeyWithValue("uid", "prometheus"))
+		Expect(datasources[2]).NotTo(HaveKey("uid"))
+
+		configMap.Data["datasources.yaml"] = "datasources: []\n"
+		_, err = setAlertingDataSourceUIDs(configMap, stack)
+		var alertingErr *InvalidAlertingError
+		Expect(errors.As(err, &alertingErr)).To(BeTrue())
+	})
+})
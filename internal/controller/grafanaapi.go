@@ -0,0 +1,256 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// grafanaAPI calls Grafana's HTTP API as the admin user
+type grafanaAPI struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+}
+
+func newGrafanaAPI(baseURL, username, password string, client *http.Client) *grafanaAPI {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &grafanaAPI{baseURL: baseURL, username: username, password: password, client: client}
+}
+
+// GrafanaAPIError reports a request Grafana answered with an error status
+type GrafanaAPIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *GrafanaAPIError) Error() string {
+	return fmt.Sprintf("Grafana API %s %s returned %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// isGrafanaNotFound reports whether Grafana answered 404
+func isGrafanaNotFound(err error) bool {
+	var apiErr *GrafanaAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// do sends a request in the context of an organization, or of the admin's
+// current organization when orgID is 0, and decodes the JSON answer into out
+func (g *grafanaAPI) do(ctx context.Context, method, path string, orgID int64, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request to %s: %w", path, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, g.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build request to %s: %w", path, err)
+	}
+	req.SetBasicAuth(g.username, g.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if orgID != 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.FormatInt(orgID, 10))
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Grafana API %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read Grafana API answer to %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var answer struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &answer) != nil || answer.Message == "" {
+			answer.Message = string(data)
+		}
+		return &GrafanaAPIError{Method: method, Path: path, StatusCode: resp.StatusCode, Message: answer.Message}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode Grafana API answer to %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// ensureOrganization returns the ID of the organization, creating it if
+// needed. Grafana makes the admin an Admin of the organizations it creates.
+func (g *grafanaAPI) ensureOrganization(ctx context.Context, name string) (int64, error) {
+	var org struct {
+		ID int64 `json:"id"`
+	}
+	err := g.do(ctx, http.MethodGet, "/api/orgs/name/"+url.PathEscape(name), 0, nil, &org)
+	if err == nil {
+		return org.ID, nil
+	}
+	if !isGrafanaNotFound(err) {
+		return 0, err
+	}
+
+	var created struct {
+		OrgID int64 `json:"orgId"`
+	}
+	if err := g.do(ctx, http.MethodPost, "/api/orgs", 0, map[string]string{"name": name}, &created); err != nil {
+		return 0, err
+	}
+	return created.OrgID, nil
+}
+
+// ensureTeam returns the ID of a team of the organization, creating it or
+// updating its email
+func (g *grafanaAPI) ensureTeam(ctx context.Context, orgID int64, name, email string) (int64, error) {
+	var search struct {
+		Teams []struct {
+			ID    int64  `json:"id"`
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"teams"`
+	}
+	if err := g.do(ctx, http.MethodGet, "/api/teams/search?name="+url.QueryEscape(name), orgID, nil, &search); err != nil {
+		return 0, err
+	}
+	for _, team := range search.Teams {
+		if team.Name != name {
+			continue
+		}
+		if team.Email != email {
+			body := map[string]string{"name": name, "email": email}
+			if err := g.do(ctx, http.MethodPut, fmt.Sprintf("/api/teams/%d", team.ID), orgID, body, nil); err != nil {
+				return 0, err
+			}
+		}
+		return team.ID, nil
+	}
+
+	var created struct {
+		TeamID int64 `json:"teamId"`
+	}
+	body := map[string]string{"name": name, "email": email}
+	if err := g.do(ctx, http.MethodPost, "/api/teams", orgID, body, &created); err != nil {
+		return 0, err
+	}
+	return created.TeamID, nil
+}
+
+// ensureFolder creates a folder of the organization or updates its title
+func (g *grafanaAPI) ensureFolder(ctx context.Context, orgID int64, uid, title string) error {
+	var folder struct {
+		Title   string `json:"title"`
+		Version int    `json:"version"`
+	}
+	err := g.do(ctx, http.MethodGet, "/api/folders/"+url.PathEscape(uid), orgID, nil, &folder)
+	if isGrafanaNotFound(err) {
+		return g.do(ctx, http.MethodPost, "/api/folders", orgID, map[string]string{"uid": uid, "title": title}, nil)
+	}
+	if err != nil || folder.Title == title {
+		return err
+	}
+
+	body := map[string]interface{}{"title": title, "version": folder.Version}
+	return g.do(ctx, http.MethodPut, "/api/folders/"+url.PathEscape(uid), orgID, body, nil)
+}
+
+// grafanaPermissionItem is an entry of a folder's permissions
+type grafanaPermissionItem struct {
+	Role       string `json:"role,omitempty"`
+	TeamID     int64  `json:"teamId,omitempty"`
+	Permission int    `json:"permission"`
+}
+
+// setFolderPermissions replaces the permissions of a folder
+func (g *grafanaAPI) setFolderPermissions(ctx context.Context, orgID int64, uid string, items []grafanaPermissionItem) error {
+	body := map[string]interface{}{"items": items}
+	return g.do(ctx, http.MethodPost, "/api/folders/"+url.PathEscape(uid)+"/permissions", orgID, body, nil)
+}
+
+// ensureServiceAccount returns the ID of a service account of the
+// organization, creating it or updating its role
+func (g *grafanaAPI) ensureServiceAccount(ctx context.Context, orgID int64, name, role string) (int64, error) {
+	var search struct {
+		ServiceAccounts []struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+			Role string `json:"role"`
+		} `json:"serviceAccounts"`
+	}
+	if err := g.do(ctx, http.MethodGet, "/api/serviceaccounts/search?query="+url.QueryEscape(name), orgID, nil, &search); err != nil {
+		return 0, err
+	}
+	for _, account := range search.ServiceAccounts {
+		if account.Name != name {
+			continue
+		}
+		if account.Role != role {
+			path := fmt.Sprintf("/api/serviceaccounts/%d", account.ID)
+			if err := g.do(ctx, http.MethodPatch, path, orgID, map[string]string{"role": role}, nil); err != nil {
+				return 0, err
+			}
+		}
+		return account.ID, nil
+	}
+
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	body := map[string]string{"name": name, "role": role}
+	if err := g.do(ctx, http.MethodPost, "/api/serviceaccounts", orgID, body, &created); err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+// createServiceAccountToken creates a token of a service account and returns
+// its key, which Grafana never shows again
+func (g *grafanaAPI) createServiceAccountToken(ctx context.Context, orgID, accountID int64, name string) (string, error) {
+	var token struct {
+		Key string `json:"key"`
+	}
+	path := fmt.Sprintf("/api/serviceaccounts/%d/tokens", accountID)
+	if err := g.do(ctx, http.MethodPost, path, orgID, map[string]string{"name": name}, &token); err != nil {
+		return "", err
+	}
+	return token.Key, nil
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	grafanaLDAPVolume    = "ldap"
+	grafanaLDAPMountPath = "/etc/grafana/ldap"
+	grafanaLDAPFile      = "ldap.toml"
+
+	roleGrafanaAdmin = "GrafanaAdmin"
+)
+
+// checkGrafanaAuth fails while a Secret a provider reads is missing, rather
+// than leaving Grafana's pods unable to start
+func (r *ObservabilityStackReconciler) checkGrafanaAuth(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	auth := stack.Spec.Grafana.Auth
+	if oauth := auth.GenericOAuth; oauth != nil {
+		if err := r.checkSecretKeys(ctx, stack.Namespace, "OAuth client", oauth.ClientSecret.Name, oauth.ClientSecret.Key); err != nil {
+			return err
+		}
+	}
+	if ldap := auth.LDAP; ldap != nil {
+		if err := r.checkSecretKeys(ctx, stack.Namespace, "LDAP configuration", ldap.Config.Name, ldap.Config.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jmesPathString quotes a string as a JMESPath raw string literal
+func jmesPathString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// oauthRoleAttributePath turns the role mappings into the JMESPath expression
+// Grafana evaluates on the user info to find the user's role, e.g.
+// contains(groups[*], 'admins') && 'Admin' || 'Viewer'
+func oauthRoleAttributePath(oauth *monitoringv1alpha1.GrafanaOAuthSpec) string {
+	groups := defaultString(oauth.GroupsAttributePath, "groups")
+
+	var terms []string
+	for _, mapping := range oauth.RoleMappings {
+		terms = append(terms, fmt.Sprintf("contains(%s[*], %s) && %s", groups, jmesPathString(mapping.Group), jmesPathString(mapping.Role)))
+	}
+	terms = append(terms, jmesPathString(defaultString(oauth.DefaultRole, "Viewer")))
+	return strings.Join(terms, " || ")
+}
+
+// boolEnv formats an optional flag for Grafana, defaulting to true
+func boolEnv(name string, value *bool) corev1.EnvVar {
+	return corev1.EnvVar{Name: name, Value: strconv.FormatBool(value == nil || *value)}
+}
+
+// setGrafanaOAuth enables sign-in with a generic OAuth provider
+func setGrafanaOAuth(container *corev1.Container, oauth *monitoringv1alpha1.GrafanaOAuthSpec) {
+	scopes := oauth.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	grafanaAdmin := false
+	for _, mapping := range oauth.RoleMappings {
+		grafanaAdmin = grafanaAdmin || mapping.Role == roleGrafanaAdmin
+	}
+
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "GF_AUTH_GENERIC_OAUTH_ENABLED", Value: "true"},
+		corev1.EnvVar{Name: "GF_AUTH_GENERIC_OAUTH_NAME", Value: defaultString(oauth.Name, "OAuth")},
+		corev1.EnvVar{Name: "GF_AUTH_GENERIC_OAUTH_CLIENT_ID", Value: oauth.ClientID},
+		secretEnvVar("GF_AUTH_GENERIC_OAUTH_CLIENT_SECRET", oauth.ClientSecret.Name, oauth.ClientSecret.Key),
+		corev1.EnvVar{Name: "GF_AUTH_GENERIC_OAUTH_SCOPES", Value: strings.Join(scopes, " ")},
+		corev1.EnvVar{Name: "GF_AUTH_GENERIC_OAUTH_AUTH_URL", Value: oauth.AuthURL},
+		corev1.EnvVar{Name: "GF_AUTH_GENERIC_OAUTH_TOKEN_URL", Value: oauth.TokenURL},
+		corev1.EnvVar{Name: "GF_AUTH_GENERIC_OAUTH_API_URL", Value: oauth.APIURL},
+		corev1.EnvVar{Name: "GF_AUTH_GENERIC_OAUTH_ROLE_ATTRIBUTE_PATH", Value: oauthRoleAttributePath(oauth)},
+		corev1.EnvVar{Name: "GF_AUTH_GENERIC_OAUTH_ALLOW_ASSIGN_GRAFANA_ADMIN", Value: strconv.FormatBool(grafanaAdmin)},
+		boolEnv("GF_AUTH_GENERIC_OAUTH_ALLOW_SIGN_UP", oauth.AllowSignUp),
+	)
+}
+
+// setGrafanaLDAP enables sign-in against LDAP, mounting the configuration
+// from its Secret
+func setGrafanaLDAP(template *corev1.PodTemplateSpec, ldap *monitoringv1alpha1.GrafanaLDAPSpec) {
+	podSpec := &template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: grafanaLDAPVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: ldap.Config.Name,
+				Items:      []corev1.KeyToPath{{Key: ldap.Config.Key, Path: grafanaLDAPFile}},
+			},
+		},
+	})
+
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      grafanaLDAPVolume,
+		MountPath: grafanaLDAPMountPath,
+		ReadOnly:  true,
+	})
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "GF_AUTH_LDAP_ENABLED", Value: "true"},
+		corev1.EnvVar{Name: "GF_AUTH_LDAP_CONFIG_FILE", Value: grafanaLDAPMountPath + "/" + grafanaLDAPFile},
+		boolEnv("GF_AUTH_LDAP_ALLOW_SIGN_UP", ldap.AllowSignUp),
+	)
+}
+
+// setGrafanaAuthProxy trusts the user headers of an authenticating proxy
+func setGrafanaAuthProxy(container *corev1.Container, proxy *monitoringv1alpha1.GrafanaAuthProxySpec) {
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "GF_AUTH_PROXY_ENABLED", Value: "true"},
+		corev1.EnvVar{Name: "GF_AUTH_PROXY_HEADER_NAME", Value: defaultString(proxy.HeaderName, "X-WEBAUTH-USER")},
+		corev1.EnvVar{Name: "GF_AUTH_PROXY_HEADER_PROPERTY", Value: defaultString(proxy.HeaderProperty, "username")},
+		boolEnv("GF_AUTH_PROXY_AUTO_SIGN_UP", proxy.AutoSignUp),
+	)
+	if proxy.RoleHeader != "" {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "GF_AUTH_PROXY_HEADERS", Value: "Role:" + proxy.RoleHeader})
+	}
+	if len(proxy.Whitelist) > 0 {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "GF_AUTH_PROXY_WHITELIST", Value: strings.Join(proxy.Whitelist, ",")})
+	}
+}
+
+// setGrafanaAuth configures the identity providers of Grafana's pods. Secrets
+// reach Grafana through environment variables and volumes, so the generated
+// configuration holds none of them.
+func setGrafanaAuth(template *corev1.PodTemplateSpec, auth monitoringv1alpha1.GrafanaAuthSpec) {
+	container := &template.Spec.Containers[0]
+	if auth.RootURL != "" {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "GF_SERVER_ROOT_URL", Value: auth.RootURL})
+	}
+	if auth.DisableLoginForm {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "GF_AUTH_DISABLE_LOGIN_FORM", Value: "true"})
+	}
+	if auth.GenericOAuth != nil {
+		setGrafanaOAuth(container, auth.GenericOAuth)
+	}
+	if auth.Proxy != nil {
+		setGrafanaAuthProxy(container, auth.Proxy)
+	}
+	if auth.LDAP != nil {
+		setGrafanaLDAP(template, auth.LDAP)
+	}
+}
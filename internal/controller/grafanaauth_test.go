@@ -0,0 +1,129 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+// containerEnv indexes the environment of a container by name
+func containerEnv(container corev1.Container) map[string]corev1.EnvVar {
+	env := map[string]corev1.EnvVar{}
+	for _, variable := range container.Env {
+		env[variable.Name] = variable
+	}
+	return env
+}
+
+var _ = Describe("setGrafanaAuth", func() {
+	var template corev1.PodTemplateSpec
+
+	BeforeEach(func() {
+		template = corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "grafana"}},
+			},
+		}
+	})
+
+	It("should leave Grafana to local users without providers", func() {
+		setGrafanaAuth(&template, monitoringv1alpha1.GrafanaAuthSpec{})
+
+		Expect(template.Spec.Containers[0].Env).To(BeEmpty())
+		Expect(template.Spec.Volumes).To(BeEmpty())
+	})
+
+	It("should map OAuth groups to org roles in order", func() {
+		setGrafanaAuth(&template, monitoringv1alpha1.GrafanaAuthSpec{
+			RootURL: "https://grafana.example.com",
+			GenericOAuth: &monitoringv1alpha1.GrafanaOAuthSpec{
+				ClientID: "grafana",
+				ClientSecret: corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "oauth"},
+					Key:                  "client-secret",
+				},
+				AuthURL:             "https://idp.example.com/authorize",
+				TokenURL:            "https://idp.example.com/token",
+				APIURL:              "https://idp.example.com/userinfo",
+				GroupsAttributePath: "info.groups",
+				RoleMappings: []monitoringv1alpha1.GrafanaRoleMapping{
+					{Group: "platform", Role: "GrafanaAdmin"},
+					{Group: "o'brien-team", Role: "Editor"},
+				},
+				AllowSignUp: pointer.Bool(false),
+			},
+		})
+
+		env := containerEnv(template.Spec.Containers[0])
+		Expect(env["GF_SERVER_ROOT_URL"].Value).To(Equal("https://grafana.example.com"))
+		Expect(env["GF_AUTH_GENERIC_OAUTH_NAME"].Value).To(Equal("OAuth"))
+		Expect(env["GF_AUTH_GENERIC_OAUTH_SCOPES"].Value).To(Equal("openid email profile"))
+		Expect(env["GF_AUTH_GENERIC_OAUTH_ROLE_ATTRIBUTE_PATH"].Value).To(Equal(
+			`contains(info.groups[*], 'platform') && 'GrafanaAdmin' || contains(info.groups[*], 'o\'brien-team') && 'Editor' || 'Viewer'`))
+		Expect(env["GF_AUTH_GENERIC_OAUTH_ALLOW_ASSIGN_GRAFANA_ADMIN"].Value).To(Equal("true"))
+		Expect(env["GF_AUTH_GENERIC_OAUTH_ALLOW_SIGN_UP"].Value).To(Equal("false"))
+
+		secret := env["GF_AUTH_GENERIC_OAUTH_CLIENT_SECRET"]
+		Expect(secret.Value).To(BeEmpty())
+		Expect(secret.ValueFrom.SecretKeyRef.Name).To(Equal("oauth"))
+		Expect(secret.ValueFrom.SecretKeyRef.Key).To(Equal("client-secret"))
+	})
+
+	It("should mount the LDAP configuration from its Secret", func() {
+		setGrafanaAuth(&template, monitoringv1alpha1.GrafanaAuthSpec{
+			LDAP: &monitoringv1alpha1.GrafanaLDAPSpec{
+				Config: corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "ldap"},
+					Key:                  "config.toml",
+				},
+			},
+		})
+
+		env := containerEnv(template.Spec.Containers[0])
+		Expect(env["GF_AUTH_LDAP_ENABLED"].Value).To(Equal("true"))
+		Expect(env["GF_AUTH_LDAP_CONFIG_FILE"].Value).To(Equal("/etc/grafana/ldap/ldap.toml"))
+		Expect(env["GF_AUTH_LDAP_ALLOW_SIGN_UP"].Value).To(Equal("true"))
+
+		Expect(template.Spec.Volumes).To(HaveLen(1))
+		Expect(template.Spec.Volumes[0].Secret.SecretName).To(Equal("ldap"))
+		Expect(template.Spec.Volumes[0].Secret.Items).To(ConsistOf(corev1.KeyToPath{Key: "config.toml", Path: "ldap.toml"}))
+		Expect(template.Spec.Containers[0].VolumeMounts[0].MountPath).To(Equal("/etc/grafana/ldap"))
+	})
+
+	It("should trust the headers of the auth proxy", func() {
+		setGrafanaAuth(&template, monitoringv1alpha1.GrafanaAuthSpec{
+			DisableLoginForm: true,
+			Proxy: &monitoringv1alpha1.GrafanaAuthProxySpec{
+				HeaderProperty: "email",
+				RoleHeader:     "X-Grafana-Role",
+				Whitelist:      []string{"10.0.0.10", "10.1.0.0/16"},
+			},
+		})
+
+		env := containerEnv(template.Spec.Containers[0])
+		Expect(env["GF_AUTH_DISABLE_LOGIN_FORM"].Value).To(Equal("true"))
+		Expect(env["GF_AUTH_PROXY_HEADER_NAME"].Value).To(Equal("X-WEBAUTH-USER"))
+		Expect(env["GF_AUTH_PROXY_HEADER_PROPERTY"].Value).To(Equal("email"))
+		Expect(env["GF_AUTH_PROXY_HEADERS"].Value).To(Equal("Role:X-Grafana-Role"))
+		Expect(env["GF_AUTH_PROXY_WHITELIST"].Value).To(Equal("10.0.0.10,10.1.0.0/16"))
+	})
+})
@@ -0,0 +1,155 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// portGrafanaAlerting is where the Alertmanagers of Grafana's replicas gossip
+// silences and notification state
+const portGrafanaAlerting = 9094
+
+// grafanaReplicas returns the number of Grafana pods
+func grafanaReplicas(stack *monitoringv1alpha1.ObservabilityStack) int32 {
+	if replicas := stack.Spec.Grafana.Replicas; replicas != nil {
+		return *replicas
+	}
+	return 1
+}
+
+// grafanaHA reports whether Grafana runs more than one pod
+func grafanaHA(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	return grafanaReplicas(stack) > 1
+}
+
+func grafanaAlertingName(stack *monitoringv1alpha1.ObservabilityStack) string {
+	return fmt.Sprintf("%s-grafana-alerting", stack.Name)
+}
+
+// checkGrafanaDatabase returns an error unless the database credentials
+// Secret holds a username and a password, which Grafana's pods would
+// otherwise fail to start without
+func (r *ObservabilityStackReconciler) checkGrafanaDatabase(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	return r.checkSecretKeys(ctx, stack.Namespace, "database credentials", stack.Spec.Grafana.Database.CredentialsSecret,
+		corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey)
+}
+
+// secretEnvVar reads an environment variable from a key of a Secret
+func secretEnvVar(name, secret, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+// setGrafanaDatabase points Grafana at its external database, which then also
+// holds the sessions and cache shared by its replicas. The settings are GF_*
+// environment variables overriding the grafana.ini rendered by pkg/grafana.
+// The credentials are read from the Secret and never written to the
+// configuration.
+func setGrafanaDatabase(container *corev1.Container, database *monitoringv1alpha1.GrafanaDatabaseSpec) {
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "GF_DATABASE_TYPE", Value: database.Type},
+		corev1.EnvVar{Name: "GF_DATABASE_HOST", Value: database.Host},
+		corev1.EnvVar{Name: "GF_DATABASE_NAME", Value: defaultString(database.Name, "grafana")},
+		secretEnvVar("GF_DATABASE_USER", database.CredentialsSecret, corev1.BasicAuthUsernameKey),
+		secretEnvVar("GF_DATABASE_PASSWORD", database.CredentialsSecret, corev1.BasicAuthPasswordKey),
+		corev1.EnvVar{Name: "GF_REMOTE_CACHE_TYPE", Value: "database"},
+	)
+	if database.SSLMode != "" {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "GF_DATABASE_SSL_MODE", Value: database.SSLMode})
+	}
+}
+
+// setGrafanaAlertingHA makes the Alertmanagers of Grafana's replicas find each
+// other through the alerting Service, so that each alert is notified once
+func setGrafanaAlertingHA(container *corev1.Container, stack *monitoringv1alpha1.ObservabilityStack) {
+	address := fmt.Sprintf("$(POD_IP):%d", portGrafanaAlerting)
+	container.Env = append(container.Env,
+		corev1.EnvVar{
+			Name: "POD_IP",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"},
+			},
+		},
+		corev1.EnvVar{Name: "GF_UNIFIED_ALERTING_HA_LISTEN_ADDRESS", Value: address},
+		corev1.EnvVar{Name: "GF_UNIFIED_ALERTING_HA_ADVERTISE_ADDRESS", Value: address},
+		corev1.EnvVar{
+			Name:  "GF_UNIFIED_ALERTING_HA_PEERS",
+			Value: fmt.Sprintf("%s.%s.svc:%d", grafanaAlertingName(stack), stack.Namespace, portGrafanaAlerting),
+		},
+	)
+	container.Ports = append(container.Ports,
+		corev1.ContainerPort{Name: "alerting-tcp", ContainerPort: portGrafanaAlerting, Protocol: corev1.ProtocolTCP},
+		corev1.ContainerPort{Name: "alerting-udp", ContainerPort: portGrafanaAlerting, Protocol: corev1.ProtocolUDP},
+	)
+}
+
+// grafanaAlertingService is the headless Service resolving to every Grafana
+// pod, ready or not, for the Alertmanagers to gossip over
+func grafanaAlertingService(stack *monitoringv1alpha1.ObservabilityStack, labels map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      grafanaAlertingName(stack),
+			Namespace: stack.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			PublishNotReadyAddresses: true,
+			Selector:                 selectorLabels(labels),
+			Ports: []corev1.ServicePort{
+				{Name: "alerting-tcp", Port: portGrafanaAlerting, TargetPort: intstr.FromInt(portGrafanaAlerting), Protocol: corev1.ProtocolTCP},
+				{Name: "alerting-udp", Port: portGrafanaAlerting, TargetPort: intstr.FromInt(portGrafanaAlerting), Protocol: corev1.ProtocolUDP},
+			},
+		},
+	}
+}
+
+// reconcileGrafanaAlertingService creates the alerting Service while Grafana
+// runs more than one pod, and deletes it otherwise
+func (r *ObservabilityStackReconciler) reconcileGrafanaAlertingService(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, labels map[string]string) error {
+	svc := grafanaAlertingService(stack, labels)
+
+	if !grafanaHA(stack) {
+		if err := r.delete(ctx, stack, svc); err != nil {
+			return fmt.Errorf("failed to delete Grafana alerting Service: %w", err)
+		}
+		return nil
+	}
+
+	if err := ctrl.SetControllerReference(stack, svc, r.Scheme); err != nil {
+		return fmt.Errorf("failed to set controller reference on service: %w", err)
+	}
+	if err := r.createOrUpdate(ctx, stack, svc); err != nil {
+		return fmt.Errorf("failed to reconcile Grafana alerting Service: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Grafana high availability", func() {
+	var stack *monitoringv1alpha1.ObservabilityStack
+
+	BeforeEach(func() {
+		stack = &monitoringv1alpha1.ObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "monitoring"},
+			Spec: monitoringv1alpha1.ObservabilityStackSpec{
+				Grafana: monitoringv1alpha1.GrafanaSpec{
+					Enabled:  true,
+					Replicas: pointer.Int32(3),
+					Database: &monitoringv1alpha1.GrafanaDatabaseSpec{
+						Type:              "postgres",
+						Host:              "postgres:5432",
+						CredentialsSecret: "grafana-db",
+						SSLMode:           "require",
+					},
+				},
+			},
+		}
+	})
+
+	It("should read the database credentials from the Secret", func() {
+		container := &corev1.Container{}
+		setGrafanaDatabase(container, stack.Spec.Grafana.Database)
+
+		env := containerEnv(*container)
+		Expect(env["GF_DATABASE_TYPE"].Value).To(Equal("postgres"))
+		Expect(env["GF_DATABASE_HOST"].Value).To(Equal("postgres:5432"))
+		Expect(env["GF_DATABASE_NAME"].Value).To(Equal("grafana"))
+		Expect(env["GF_DATABASE_SSL_MODE"].Value).To(Equal("require"))
+		Expect(env["GF_REMOTE_CACHE_TYPE"].Value).To(Equal("database"))
+
+		for name, key := range map[string]string{"GF_DATABASE_USER": "username", "GF_DATABASE_PASSWORD": "password"} {
+			Expect(env[name].Value).To(BeEmpty())
+			Expect(env[name].ValueFrom.SecretKeyRef.Name).To(Equal("grafana-db"))
+			Expect(env[name].ValueFrom.SecretKeyRef.Key).To(Equal(key))
+		}
+	})
+
+	It("should make the Alertmanagers gossip through the alerting Service", func() {
+		container := &corev1.Container{}
+		setGrafanaAlertingHA(container, stack)
+
+		Expect(container.Env).To(ContainElement(corev1.EnvVar{
+			Name:  "GF_UNIFIED_ALERTING_HA_PEERS",
+			Value: "test-grafana-alerting.monitoring.svc:9094",
+		}))
+		Expect(container.Env).To(ContainElement(corev1.EnvVar{
+			Name:  "GF_UNIFIED_ALERTING_HA_ADVERTISE_ADDRESS",
+			Value: "$(POD_IP):9094",
+		}))
+		Expect(container.Ports).To(HaveLen(2))
+
+		svc := grafanaAlertingService(stack, map[string]string{
+			"app.kubernetes.io/name":       "grafana",
+			"app.kubernetes.io/instance":   "test",
+			"app.kubernetes.io/managed-by": "kube-insight-operator",
+		})
+		Expect(svc.Spec.ClusterIP).To(Equal(corev1.ClusterIPNone))
+		Expect(svc.Spec.PublishNotReadyAddresses).To(BeTrue())
+		Expect(svc.Spec.Selector).NotTo(HaveKey("app.kubernetes.io/managed-by"))
+	})
+
+	It("should admit gossip between the replicas", func() {
+		stack.Spec.NetworkPolicy.Enabled = true
+		policy := networkPolicyFor(stack, componentGrafana)
+
+		Expect(policy.Spec.Ingress).To(HaveLen(2))
+		gossip := policy.Spec.Ingress[1]
+		Expect(gossip.From[0].PodSelector.MatchLabels).To(HaveKeyWithValue("app.kubernetes.io/name", componentGrafana))
+		Expect(gossip.Ports).To(HaveLen(2))
+		Expect(*gossip.Ports[1].Protocol).To(Equal(corev1.ProtocolUDP))
+
+		stack.Spec.Grafana.Replicas = pointer.Int32(1)
+		Expect(networkPolicyFor(stack, componentGrafana).Spec.Ingress).To(HaveLen(1))
+	})
+	It("should warn when the replicas cannot share a volume claim", func() {
+		stack.Spec.Grafana.Storage = "10Gi"
+		c := newFakeClient()
+		recorder := record.NewFakeRecorder(10)
+		r := &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: recorder}
+
+		volume, err := r.reconcileGrafanaStorage(context.Background(), stack, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volume.EmptyDir).NotTo(BeNil())
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning StorageNotShared")))
+	})
+})
@@ -0,0 +1,227 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"time"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// grafanaPermissions maps folder permissions to Grafana's permission levels
+var grafanaPermissions = map[string]int{
+	"View":  1,
+	"Edit":  2,
+	"Admin": 4,
+}
+
+// tokenStore keeps the service account tokens the operator creates
+type tokenStore interface {
+	// HasToken reports whether the Secret already holds a token
+	HasToken(ctx context.Context, secret string) (bool, error)
+	// SaveToken writes a token to the Secret
+	SaveToken(ctx context.Context, secret, token string) error
+}
+
+// provisionGrafanaOrganizations creates the organizations of the spec with
+// their teams, folders and service accounts, and brings existing ones to the
+// spec. Nothing missing from the spec is deleted.
+func provisionGrafanaOrganizations(ctx context.Context, api *grafanaAPI, tokens tokenStore, organizations []monitoringv1alpha1.GrafanaOrganization) error {
+	for _, organization := range organizations {
+		orgID, err := api.ensureOrganization(ctx, organization.Name)
+		if err != nil {
+			return fmt.Errorf("failed to reconcile Grafana organization %q: %w", organization.Name, err)
+		}
+
+		teamIDs := map[string]int64{}
+		for _, team := range organization.Teams {
+			teamID, err := api.ensureTeam(ctx, orgID, team.Name, team.Email)
+			if err != nil {
+				return fmt.Errorf("failed to reconcile team %q of Grafana organization %q: %w", team.Name, organization.Name, err)
+			}
+			teamIDs[team.Name] = teamID
+		}
+
+		for _, folder := range organization.Folders {
+			if err := provisionGrafanaFolder(ctx, api, orgID, teamIDs, folder); err != nil {
+				return fmt.Errorf("failed to reconcile folder %q of Grafana organization %q: %w", folder.UID, organization.Name, err)
+			}
+		}
+
+		for _, account := range organization.ServiceAccounts {
+			if err := provisionGrafanaServiceAccount(ctx, api, tokens, orgID, account); err != nil {
+				return fmt.Errorf("failed to reconcile service account %q of Grafana organization %q: %w", account.Name, organization.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// provisionGrafanaFolder creates a folder and sets its permissions. Teams
+// must be among those the organization defines.
+func provisionGrafanaFolder(ctx context.Context, api *grafanaAPI, orgID int64, teamIDs map[string]int64, folder monitoringv1alpha1.GrafanaFolder) error {
+	if err := api.ensureFolder(ctx, orgID, folder.UID, folder.Title); err != nil {
+		return err
+	}
+	if len(folder.Permissions) == 0 {
+		return nil
+	}
+
+	var items []grafanaPermissionItem
+	for _, permission := range folder.Permissions {
+		item := grafanaPermissionItem{Role: permission.Role, Permission: grafanaPermissions[permission.Permission]}
+		if permission.Team != "" {
+			teamID, found := teamIDs[permission.Team]
+			if !found {
+				return fmt.Errorf("team %q is not defined in the organization", permission.Team)
+			}
+			item.TeamID = teamID
+		}
+		items = append(items, item)
+	}
+	return api.setFolderPermissions(ctx, orgID, folder.UID, items)
+}
+
+// provisionGrafanaServiceAccount creates a service account, and a token of it
+// whenever its token Secret is missing
+func provisionGrafanaServiceAccount(ctx context.Context, api *grafanaAPI, tokens tokenStore, orgID int64, account monitoringv1alpha1.GrafanaServiceAccount) error {
+	accountID, err := api.ensureServiceAccount(ctx, orgID, account.Name, defaultString(account.Role, "Viewer"))
+	if err != nil {
+		return err
+	}
+	if account.TokenSecret == "" {
+		return nil
+	}
+
+	found, err := tokens.HasToken(ctx, account.TokenSecret)
+	if err != nil || found {
+		return err
+	}
+	// Token names are unique per service account
+	key, err := api.createServiceAccountToken(ctx, orgID, accountID, fmt.Sprintf("%s-%d", account.TokenSecret, time.Now().Unix()))
+	if err != nil {
+		return err
+	}
+	return tokens.SaveToken(ctx, account.TokenSecret, key)
+}
+
+// secretTokenStore keeps service account tokens in Secrets of the stack. A
+// Secret that exists but is not controlled by the stack is refused rather
+// than overwritten.
+type secretTokenStore struct {
+	r     *ObservabilityStackReconciler
+	stack *monitoringv1alpha1.ObservabilityStack
+}
+
+func (s secretTokenStore) HasToken(ctx context.Context, name string) (bool, error) {
+	secret := &corev1.Secret{}
+	if err := s.r.Get(ctx, client.ObjectKey{Namespace: s.stack.Namespace, Name: name}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get token Secret %s: %w", name, err)
+	}
+	if !metav1.IsControlledBy(secret, s.stack) {
+		return false, fmt.Errorf("token Secret %s is not owned by the stack", name)
+	}
+	return len(secret.Data["token"]) > 0, nil
+}
+
+func (s secretTokenStore) SaveToken(ctx context.Context, name, token string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: s.stack.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       "grafana",
+				"app.kubernetes.io/instance":   s.stack.Name,
+				"app.kubernetes.io/managed-by": "kube-insight-operator",
+			},
+		},
+		Data: map[string][]byte{"token": []byte(token)},
+	}
+	if err := ctrl.SetControllerReference(s.stack, secret, s.r.Scheme); err != nil {
+		return fmt.Errorf("failed to set controller reference on secret: %w", err)
+	}
+	if err := s.r.createOrUpdate(ctx, s.stack, secret); err != nil {
+		return fmt.Errorf("failed to save token Secret %s: %w", name, err)
+	}
+	return nil
+}
+
+// grafanaHTTPClient returns the client the operator calls Grafana with,
+// trusting the CA of Grafana's serving certificate when TLS is enabled
+func (r *ObservabilityStackReconciler) grafanaHTTPClient(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) (*http.Client, error) {
+	if !tlsEnabled(stack) {
+		return nil, nil
+	}
+
+	secret := &corev1.Secret{}
+	name := tlsSecretName(stack, componentGrafana)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: name}, secret); err != nil {
+		return nil, fmt.Errorf("failed to get Grafana serving certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(secret.Data["ca.crt"]) {
+		return nil, fmt.Errorf("serving certificate Secret %s has no valid ca.crt", name)
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}, nil
+}
+
+// reconcileGrafanaOrganizations provisions the organizations of the spec once
+// Grafana is ready. Until then it does nothing; Grafana's Deployment becoming
+// ready triggers another reconciliation.
+func (r *ObservabilityStackReconciler) reconcileGrafanaOrganizations(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	if len(stack.Spec.Grafana.Organizations) == 0 {
+		return nil
+	}
+
+	deployment := &appsv1.Deployment{}
+	key := client.ObjectKey{Namespace: stack.Namespace, Name: fmt.Sprintf("%s-grafana", stack.Name)}
+	if err := r.Get(ctx, key, deployment); err != nil {
+		return fmt.Errorf("failed to get Grafana Deployment: %w", err)
+	}
+	if !workloadReady(deployment) {
+		return nil
+	}
+
+	httpClient, err := r.grafanaHTTPClient(ctx, stack)
+	if err != nil {
+		return err
+	}
+	baseURL := fmt.Sprintf("%s://%s-grafana.%s.svc:3000", serviceURLScheme(stack), stack.Name, stack.Namespace)
+	api := newGrafanaAPI(baseURL, "admin", defaultString(stack.Spec.Grafana.AdminPassword, "admin"), httpClient)
+
+	return provisionGrafanaOrganizations(ctx, api, secretTokenStore{r: r, stack: stack}, stack.Spec.Grafana.Organizations)
+}
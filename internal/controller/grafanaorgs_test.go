@@ -0,0 +1,301 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+// fakeGrafana is an in-memory stand-in for the parts of Grafana's HTTP API
+// the operator uses
+type fakeGrafana struct {
+	mu sync.Mutex
+
+	nextID          int64
+	orgs            map[string]int64
+	teams           map[string]int64 // by "<org>/<name>"
+	folders         map[string]string
+	permissions     map[string][]grafanaPermissionItem
+	serviceAccounts map[string]map[string]interface{}
+	tokens          int
+	requests        []string
+}
+
+func newFakeGrafana() *fakeGrafana {
+	return &fakeGrafana{
+		nextID:          1,
+		orgs:            map[string]int64{"Main Org.": 1},
+		teams:           map[string]int64{},
+		folders:         map[string]string{},
+		permissions:     map[string][]grafanaPermissionItem{},
+		serviceAccounts: map[string]map[string]interface{}{},
+	}
+}
+
+func (f *fakeGrafana) id() int64 {
+	f.nextID++
+	return f.nextID
+}
+
+func (f *fakeGrafana) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, password, ok := req.BasicAuth(); !ok || user != "admin" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	f.requests = append(f.requests, req.Method+" "+req.URL.Path)
+
+	org := req.Header.Get("X-Grafana-Org-Id")
+	body := map[string]interface{}{}
+	_ = json.NewDecoder(req.Body).Decode(&body)
+	reply := func(status int, answer interface{}) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(answer)
+	}
+	notFound := func() { reply(http.StatusNotFound, map[string]string{"message": "not found"}) }
+
+	path := req.URL.Path
+	switch {
+	case req.Method == http.MethodGet && strings.HasPrefix(path, "/api/orgs/name/"):
+		if id, ok := f.orgs[strings.TrimPrefix(path, "/api/orgs/name/")]; ok {
+			reply(http.StatusOK, map[string]interface{}{"id": id})
+			return
+		}
+		notFound()
+	case req.Method == http.MethodPost && path == "/api/orgs":
+		id := f.id()
+		f.orgs[body["name"].(string)] = id
+		reply(http.StatusOK, map[string]interface{}{"orgId": id})
+	case req.Method == http.MethodGet && path == "/api/teams/search":
+		var teams []map[string]interface{}
+		key := org + "/" + req.URL.Query().Get("name")
+		if id, ok := f.teams[key]; ok {
+			teams = append(teams, map[string]interface{}{"id": id, "name": req.URL.Query().Get("name")})
+		}
+		reply(http.StatusOK, map[string]interface{}{"teams": teams})
+	case req.Method == http.MethodPost && path == "/api/teams":
+		id := f.id()
+		f.teams[org+"/"+body["name"].(string)] = id
+		reply(http.StatusOK, map[string]interface{}{"teamId": id})
+	case req.Method == http.MethodGet && strings.HasPrefix(path, "/api/folders/"):
+		title, ok := f.folders[org+"/"+strings.TrimPrefix(path, "/api/folders/")]
+		if !ok {
+			notFound()
+			return
+		}
+		reply(http.StatusOK, map[string]interface{}{"title": title, "version": 1})
+	case req.Method == http.MethodPost && path == "/api/folders":
+		f.folders[org+"/"+body["uid"].(string)] = body["title"].(string)
+		reply(http.StatusOK, map[string]interface{}{})
+	case req.Method == http.MethodPut && strings.HasPrefix(path, "/api/folders/"):
+		f.folders[org+"/"+strings.TrimPrefix(path, "/api/folders/")] = body["title"].(string)
+		reply(http.StatusOK, map[string]interface{}{})
+	case req.Method == http.MethodPost && strings.HasSuffix(path, "/permissions"):
+		uid := strings.TrimSuffix(strings.TrimPrefix(path, "/api/folders/"), "/permissions")
+		data, _ := json.Marshal(body["items"])
+		var items []grafanaPermissionItem
+		_ = json.Unmarshal(data, &items)
+		f.permissions[org+"/"+uid] = items
+		reply(http.StatusOK, map[string]interface{}{})
+	case req.Method == http.MethodGet && path == "/api/serviceaccounts/search":
+		var accounts []map[string]interface{}
+		if account, ok := f.serviceAccounts[org+"/"+req.URL.Query().Get("query")]; ok {
+			accounts = append(accounts, account)
+		}
+		reply(http.StatusOK, map[string]interface{}{"serviceAccounts": accounts})
+	case req.Method == http.MethodPost && path == "/api/serviceaccounts":
+		account := map[string]interface{}{"id": f.id(), "name": body["name"], "role": body["role"]}
+		f.serviceAccounts[org+"/"+body["name"].(string)] = account
+		reply(http.StatusCreated, account)
+	case req.Method == http.MethodPatch && strings.HasPrefix(path, "/api/serviceaccounts/"):
+		id, _ := strconv.ParseInt(strings.TrimPrefix(path, "/api/serviceaccounts/"), 10, 64)
+		for _, account := range f.serviceAccounts {
+			if account["id"] == id {
+				account["role"] = body["role"]
+			}
+		}
+		reply(http.StatusOK, map[string]interface{}{})
+	case req.Method == http.MethodPost && strings.HasSuffix(path, "/tokens"):
+		f.tokens++
+		reply(http.StatusOK, map[string]interface{}{"key": fmt.Sprintf("glsa_%d", f.tokens)})
+	default:
+		reply(http.StatusInternalServerError, map[string]string{"message": "unexpected request " + req.Method + " " + path})
+	}
+}
+
+// memoryTokenStore keeps tokens in a map
+type memoryTokenStore map[string]string
+
+func (m memoryTokenStore) HasToken(_ context.Context, secret string) (bool, error) {
+	_, found := m[secret]
+	return found, nil
+}
+
+func (m memoryTokenStore) SaveToken(_ context.Context, secret, token string) error {
+	m[secret] = token
+	return nil
+}
+
+var _ = Describe("provisionGrafanaOrganizations", func() {
+	var (
+		grafana *fakeGrafana
+		server  *httptest.Server
+		api     *grafanaAPI
+		tokens  memoryTokenStore
+		orgs    []monitoringv1alpha1.GrafanaOrganization
+	)
+
+	BeforeEach(func() {
+		grafana = newFakeGrafana()
+		server = httptest.NewServer(grafana)
+		api = newGrafanaAPI(server.URL, "admin", "secret", server.Client())
+		tokens = memoryTokenStore{}
+		orgs = []monitoringv1alpha1.GrafanaOrganization{{
+			Name:  "payments",
+			Teams: []monitoringv1alpha1.GrafanaTeam{{Name: "payments-oncall"}},
+			Folders: []monitoringv1alpha1.GrafanaFolder{{
+				UID:   "payments",
+				Title: "Payments",
+				Permissions: []monitoringv1alpha1.GrafanaFolderPermission{
+					{Team: "payments-oncall", Permission: "Edit"},
+					{Role: "Viewer", Permission: "View"},
+				},
+			}},
+			ServiceAccounts: []monitoringv1alpha1.GrafanaServiceAccount{
+				{Name: "ci", Role: "Editor", TokenSecret: "payments-grafana-ci"},
+			},
+		}}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should create the organization with its teams, folders and service accounts", func() {
+		Expect(provisionGrafanaOrganizations(context.Background(), api, tokens, orgs)).To(Succeed())
+
+		orgID := grafana.orgs["payments"]
+		Expect(orgID).NotTo(BeZero())
+		org := strconv.FormatInt(orgID, 10)
+
+		teamID := grafana.teams[org+"/payments-oncall"]
+		Expect(teamID).NotTo(BeZero())
+		Expect(grafana.folders).To(HaveKeyWithValue(org+"/payments", "Payments"))
+		Expect(grafana.permissions[org+"/payments"]).To(ConsistOf(
+			grafanaPermissionItem{TeamID: teamID, Permission: 2},
+			grafanaPermissionItem{Role: "Viewer", Permission: 1},
+		))
+
+		Expect(grafana.serviceAccounts[org+"/ci"]).To(HaveKeyWithValue("role", "Editor"))
+		Expect(tokens).To(HaveKeyWithValue("payments-grafana-ci", "glsa_1"))
+	})
+
+	It("should converge without creating anything twice", func() {
+		Expect(provisionGrafanaOrganizations(context.Background(), api, tokens, orgs)).To(Succeed())
+		orgID := grafana.orgs["payments"]
+
+		orgs[0].Folders[0].Title = "Payments team"
+		orgs[0].ServiceAccounts[0].Role = "Viewer"
+		grafana.requests = nil
+		Expect(provisionGrafanaOrganizations(context.Background(), api, tokens, orgs)).To(Succeed())
+
+		org := strconv.FormatInt(orgID, 10)
+		Expect(grafana.orgs["payments"]).To(Equal(orgID))
+		Expect(grafana.folders).To(HaveKeyWithValue(org+"/payments", "Payments team"))
+		Expect(grafana.serviceAccounts[org+"/ci"]).To(HaveKeyWithValue("role", "Viewer"))
+		Expect(grafana.tokens).To(Equal(1))
+		for _, create := range []string{"POST /api/orgs", "POST /api/teams", "POST /api/folders", "POST /api/serviceaccounts"} {
+			Expect(grafana.requests).NotTo(ContainElement(create))
+		}
+	})
+
+	It("should create a new token once its Secret is gone", func() {
+		Expect(provisionGrafanaOrganizations(context.Background(), api, tokens, orgs)).To(Succeed())
+		delete(tokens, "payments-grafana-ci")
+
+		Expect(provisionGrafanaOrganizations(context.Background(), api, tokens, orgs)).To(Succeed())
+		Expect(tokens).To(HaveKeyWithValue("payments-grafana-ci", "glsa_2"))
+	})
+
+	It("should refuse permissions of teams the organization does not define", func() {
+		orgs[0].Folders[0].Permissions = []monitoringv1alpha1.GrafanaFolderPermission{{Team: "unknown", Permission: "View"}}
+
+		err := provisionGrafanaOrganizations(context.Background(), api, tokens, orgs)
+		Expect(err).To(MatchError(ContainSubstring(`team "unknown" is not defined in the organization`)))
+	})
+
+	It("should report errors returned by Grafana", func() {
+		api = newGrafanaAPI(server.URL, "admin", "wrong", server.Client())
+
+		err := provisionGrafanaOrganizations(context.Background(), api, tokens, orgs)
+		var apiErr *GrafanaAPIError
+		Expect(errors.As(err, &apiErr)).To(BeTrue())
+		Expect(apiErr.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+})
+
+var _ = Describe("secretTokenStore", func() {
+	var stack *monitoringv1alpha1.ObservabilityStack
+
+	BeforeEach(func() {
+		stack = &monitoringv1alpha1.ObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "monitoring", UID: "stack-uid"},
+		}
+	})
+
+	It("should save tokens in Secrets controlled by the stack", func() {
+		c := newFakeClient(stack)
+		tokens := secretTokenStore{r: &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(10)}, stack: stack}
+
+		Expect(tokens.SaveToken(context.Background(), "grafana-ci", "glsa_1")).To(Succeed())
+		Expect(tokens.HasToken(context.Background(), "grafana-ci")).To(BeTrue())
+	})
+
+	It("should refuse Secrets the stack does not own", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-credentials", Namespace: "monitoring"},
+			Data:       map[string][]byte{"password": []byte("secret")},
+		}
+		c := newFakeClient(stack, secret)
+		tokens := secretTokenStore{r: &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(10)}, stack: stack}
+
+		_, err := tokens.HasToken(context.Background(), "db-credentials")
+		Expect(err).To(MatchError("token Secret db-credentials is not owned by the stack"))
+
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(secret), secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("password", []byte("secret")))
+	})
+})
@@ -0,0 +1,137 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	grafanaPluginsVolume      = "plugins"
+	grafanaPluginsPath        = "/var/lib/grafana-plugins"
+	grafanaPluginBundleVolume = "plugin-bundle"
+	grafanaPluginBundlePath   = "/var/lib/grafana-plugin-bundle"
+)
+
+// checkGrafanaPluginBundle fails while the claim of the plugin bundle is
+// missing, rather than leaving Grafana's pods pending
+func (r *ObservabilityStackReconciler) checkGrafanaPluginBundle(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	name := stack.Spec.Grafana.PluginBundle.ClaimName
+	claim := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: name}, claim); err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("Grafana plugin bundle PersistentVolumeClaim %s not found", name)
+		}
+		return fmt.Errorf("failed to get Grafana plugin bundle PersistentVolumeClaim %s: %w", name, err)
+	}
+	return nil
+}
+
+// grafanaInstallPlugins renders the plugins in the format of the image's
+// GF_INSTALL_PLUGINS: "<id> <version>" for catalog plugins, and "<url>;<id>"
+// for archives
+func grafanaInstallPlugins(plugins []monitoringv1alpha1.GrafanaPlugin) string {
+	entries := make([]string, 0, len(plugins))
+	for _, plugin := range plugins {
+		switch {
+		case plugin.URL != "":
+			entries = append(entries, plugin.URL+";"+plugin.ID)
+		case plugin.Version != "":
+			entries = append(entries, plugin.ID+" "+plugin.Version)
+		default:
+			entries = append(entries, plugin.ID)
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// grafanaPluginArchive is the path of a plugin's archive in the bundle
+func grafanaPluginArchive(plugin monitoringv1alpha1.GrafanaPlugin) string {
+	name := plugin.ID
+	if plugin.Version != "" {
+		name += "-" + plugin.Version
+	}
+	return grafanaPluginBundlePath + "/" + name + ".zip"
+}
+
+// installGrafanaPluginBundle adds an init container per plugin installing it
+// from its archive in the bundle
+func installGrafanaPluginBundle(podSpec *corev1.PodSpec, plugins []monitoringv1alpha1.GrafanaPlugin, bundle *monitoringv1alpha1.GrafanaPluginBundle, image string) {
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: grafanaPluginBundleVolume,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: bundle.ClaimName,
+				ReadOnly:  true,
+			},
+		},
+	})
+
+	for i, plugin := range plugins {
+		podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{
+			// Plugin IDs are not always valid container names
+			Name:  fmt.Sprintf("install-plugin-%d", i),
+			Image: image,
+			Command: []string{
+				"grafana", "cli",
+				"--pluginsDir", grafanaPluginsPath,
+				"--pluginUrl", grafanaPluginArchive(plugin),
+				"plugins", "install", plugin.ID,
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: grafanaPluginsVolume, MountPath: grafanaPluginsPath},
+				{Name: grafanaPluginBundleVolume, MountPath: grafanaPluginBundlePath, ReadOnly: true},
+			},
+		})
+	}
+}
+
+// setGrafanaPlugins installs the plugins of the spec in a directory every pod
+// starts empty, so that a pod holds exactly the plugins listed when it
+// starts. The plugins are part of the pod template, so changing them rolls
+// the pods. Without plugins, Grafana keeps its plugins on its storage.
+func setGrafanaPlugins(template *corev1.PodTemplateSpec, grafana monitoringv1alpha1.GrafanaSpec, image string) {
+	if len(grafana.Plugins) == 0 {
+		return
+	}
+
+	podSpec := &template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         grafanaPluginsVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      grafanaPluginsVolume,
+		MountPath: grafanaPluginsPath,
+	})
+	container.Env = append(container.Env, corev1.EnvVar{Name: "GF_PATHS_PLUGINS", Value: grafanaPluginsPath})
+
+	if grafana.PluginBundle != nil {
+		installGrafanaPluginBundle(podSpec, grafana.Plugins, grafana.PluginBundle, image)
+		return
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: "GF_INSTALL_PLUGINS", Value: grafanaInstallPlugins(grafana.Plugins)})
+}
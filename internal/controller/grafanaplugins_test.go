@@ -0,0 +1,97 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("setGrafanaPlugins", func() {
+	var (
+		template corev1.PodTemplateSpec
+		grafana  monitoringv1alpha1.GrafanaSpec
+	)
+
+	BeforeEach(func() {
+		template = corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "grafana"}},
+			},
+		}
+		grafana = monitoringv1alpha1.GrafanaSpec{
+			Plugins: []monitoringv1alpha1.GrafanaPlugin{
+				{ID: "grafana-clock-panel", Version: "2.1.3"},
+				{ID: "grafana-piechart-panel"},
+				{ID: "example-panel", URL: "https://plugins.example.com/example-panel.zip"},
+			},
+		}
+	})
+
+	It("should leave the pods alone without plugins", func() {
+		setGrafanaPlugins(&template, monitoringv1alpha1.GrafanaSpec{}, "grafana/grafana:9.5.3")
+
+		Expect(template.Spec.Containers[0].Env).To(BeEmpty())
+		Expect(template.Spec.Volumes).To(BeEmpty())
+	})
+
+	It("should install the plugins from the internet when the pods start", func() {
+		setGrafanaPlugins(&template, grafana, "grafana/grafana:9.5.3")
+
+		env := containerEnv(template.Spec.Containers[0])
+		Expect(env["GF_INSTALL_PLUGINS"].Value).To(Equal(
+			"grafana-clock-panel 2.1.3,grafana-piechart-panel,https://plugins.example.com/example-panel.zip;example-panel"))
+		Expect(env["GF_PATHS_PLUGINS"].Value).To(Equal(grafanaPluginsPath))
+		Expect(template.Spec.Volumes[0].EmptyDir).NotTo(BeNil())
+		Expect(template.Spec.InitContainers).To(BeEmpty())
+	})
+
+	It("should install the plugins from the bundle in init containers", func() {
+		grafana.Plugins = grafana.Plugins[:2]
+		grafana.PluginBundle = &monitoringv1alpha1.GrafanaPluginBundle{ClaimName: "grafana-plugins"}
+		setGrafanaPlugins(&template, grafana, "grafana/grafana:9.5.3")
+
+		Expect(containerEnv(template.Spec.Containers[0])).NotTo(HaveKey("GF_INSTALL_PLUGINS"))
+		Expect(template.Spec.Volumes[1].PersistentVolumeClaim.ClaimName).To(Equal("grafana-plugins"))
+		Expect(template.Spec.Volumes[1].PersistentVolumeClaim.ReadOnly).To(BeTrue())
+
+		Expect(template.Spec.InitContainers).To(HaveLen(2))
+		install := template.Spec.InitContainers[0]
+		Expect(install.Image).To(Equal("grafana/grafana:9.5.3"))
+		Expect(install.Command).To(Equal([]string{
+			"grafana", "cli",
+			"--pluginsDir", grafanaPluginsPath,
+			"--pluginUrl", grafanaPluginBundlePath + "/grafana-clock-panel-2.1.3.zip",
+			"plugins", "install", "grafana-clock-panel",
+		}))
+		Expect(template.Spec.InitContainers[1].Command).To(ContainElement(grafanaPluginBundlePath + "/grafana-piechart-panel.zip"))
+	})
+
+	It("should change the pod template when the plugins change", func() {
+		setGrafanaPlugins(&template, grafana, "grafana/grafana:9.5.3")
+
+		changed := corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "grafana"}}}}
+		grafana.Plugins[0].Version = "2.1.4"
+		setGrafanaPlugins(&changed, grafana, "grafana/grafana:9.5.3")
+
+		Expect(equality.Semantic.DeepEqual(template, changed)).To(BeFalse())
+	})
+})
@@ -0,0 +1,521 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	reasonInvalidKubeStateMetrics = "InvalidKubeStateMetrics"
+
+	kubeStateMetricsCustomResourceVolume = "custom-resource-state"
+	kubeStateMetricsCustomResourcePath   = "/etc/kube-state-metrics"
+	kubeStateMetricsCustomResourceFile   = "custom-resource-state.yaml"
+
+	// customResourceStateChecksumAnnotation rolls kube-state-metrics when its
+	// custom resource state config changes
+	customResourceStateChecksumAnnotation = "monitoring.example.com/custom-resource-state-checksum"
+)
+
+// InvalidKubeStateMetricsError reports a kube-state-metrics configuration it
+// would refuse to run with
+type InvalidKubeStateMetricsError struct {
+	Field   string
+	Message string
+}
+
+func (e *InvalidKubeStateMetricsError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// kubeStateMetricsCustomResource is a kind the custom resource state config
+// reports on, with the resource kube-state-metrics lists it through
+type kubeStateMetricsCustomResource struct {
+	Group   string
+	Version string
+	Kind    string
+	Plural  string
+}
+
+// kubeStateMetricsShards is the number of shards of kube-state-metrics
+func kubeStateMetricsShards(spec monitoringv1alpha1.KubeStateMetricsSpec) int32 {
+	if spec.Shards == nil || *spec.Shards < 1 {
+		return 1
+	}
+	return *spec.Shards
+}
+
+// kubeStateMetricsGrantedResources are the resources kube-state-metrics may
+// report on: all of its rules, or their namespaced part
+func kubeStateMetricsGrantedResources(namespaced bool) []string {
+	if namespaced {
+		return kubeStateMetricsNamespacedResources
+	}
+
+	var resources []string
+	for _, rule := range kubeStateMetricsRules {
+		resources = append(resources, rule.Resources...)
+	}
+	sort.Strings(resources)
+	return resources
+}
+
+// kubeStateMetricsReportedResources narrows the granted resources to the
+// allowlist, or drops the denylist from them. It returns nil when
+// kube-state-metrics keeps its own defaults.
+func kubeStateMetricsReportedResources(spec monitoringv1alpha1.KubeStateMetricsSpec, namespaced bool) []string {
+	granted := kubeStateMetricsGrantedResources(namespaced)
+
+	var keep func(string) bool
+	switch {
+	case len(spec.ResourceAllowlist) > 0:
+		keep = func(resource string) bool {
+			for _, allowed := range spec.ResourceAllowlist {
+				if string(allowed) == resource {
+					return true
+				}
+			}
+			return false
+		}
+	case len(spec.ResourceDenylist) > 0:
+		keep = func(resource string) bool {
+			for _, denied := range spec.ResourceDenylist {
+				if string(denied) == resource {
+					return false
+				}
+			}
+			return true
+		}
+	case namespaced:
+		return granted
+	default:
+		return nil
+	}
+
+	reported := []string{}
+	for _, resource := range granted {
+		if keep(resource) {
+			reported = append(reported, resource)
+		}
+	}
+	return reported
+}
+
+// parseCustomResourceState reads the kinds a custom resource state config
+// reports on
+func parseCustomResourceState(config, field string) ([]kubeStateMetricsCustomResource, error) {
+	field += ".customResourceStateConfig"
+
+	var parsed struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			Resources []struct {
+				GroupVersionKind struct {
+					Group   string `json:"group"`
+					Version string `json:"version"`
+					Kind    string `json:"kind"`
+				} `json:"groupVersionKind"`
+				ResourcePlural string `json:"resourcePlural"`
+			} `json:"resources"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal([]byte(config), &parsed); err != nil {
+		return nil, &InvalidKubeStateMetricsError{Field: field, Message: err.Error()}
+	}
+	if parsed.Kind != "CustomResourceStateMetrics" {
+		return nil, &InvalidKubeStateMetricsError{Field: field, Message: "kind must be CustomResourceStateMetrics"}
+	}
+	if len(parsed.Spec.Resources) == 0 {
+		return nil, &InvalidKubeStateMetricsError{Field: field, Message: "spec.resources lists no kinds"}
+	}
+
+	resources := make([]kubeStateMetricsCustomResource, 0, len(parsed.Spec.Resources))
+	for i, resource := range parsed.Spec.Resources {
+		gvk := resource.GroupVersionKind
+		if gvk.Group == "" || gvk.Version == "" || gvk.Kind == "" {
+			return nil, &InvalidKubeStateMetricsError{Field: field,
+				Message: fmt.Sprintf("spec.resources[%d].groupVersionKind needs a group, a version and a kind", i)}
+		}
+		// Wildcards could not be granted without granting every resource
+		if strings.Contains(gvk.Group+gvk.Version+gvk.Kind, "*") {
+			return nil, &InvalidKubeStateMetricsError{Field: field,
+				Message: fmt.Sprintf("spec.resources[%d].groupVersionKind: wildcards are not supported, list each kind", i)}
+		}
+		resources = append(resources, kubeStateMetricsCustomResource{
+			Group:   gvk.Group,
+			Version: gvk.Version,
+			Kind:    gvk.Kind,
+			Plural:  resource.ResourcePlural,
+		})
+	}
+	return resources, nil
+}
+
+// validateKubeStateMetrics refuses allowlists and denylists leaving nothing
+// to report on, label allowlists of resources not reported on, and custom
+// resources when kube-state-metrics cannot be granted CustomResourceDefinitions
+func validateKubeStateMetrics(spec monitoringv1alpha1.KubeStateMetricsSpec, field string, namespaced bool) error {
+	reported := kubeStateMetricsReportedResources(spec, namespaced)
+	if reported != nil && len(reported) == 0 {
+		return &InvalidKubeStateMetricsError{Field: field, Message: "no resource is left to report on"}
+	}
+
+	resources := make([]string, 0, len(spec.MetricLabelsAllowlist))
+	for resource := range spec.MetricLabelsAllowlist {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	available := reported
+	if available == nil {
+		available = kubeStateMetricsGrantedResources(namespaced)
+	}
+	for _, resource := range resources {
+		labelsField := fmt.Sprintf("%s.metricLabelsAllowlist.%s", field, resource)
+		if !slices.Contains(available, resource) {
+			return &InvalidKubeStateMetricsError{Field: labelsField, Message: "kube-state-metrics does not report on this resource"}
+		}
+		for _, label := range spec.MetricLabelsAllowlist[resource] {
+			if label == "*" {
+				continue
+			}
+			if errs := validation.IsQualifiedName(label); len(errs) > 0 {
+				return &InvalidKubeStateMetricsError{Field: labelsField, Message: fmt.Sprintf("%q: %s", label, strings.Join(errs, "; "))}
+			}
+		}
+	}
+
+	if spec.CustomResourceStateConfig != "" && namespaced {
+		return &InvalidKubeStateMetricsError{Field: field + ".customResourceStateConfig",
+			Message: "custom resources need cluster-wide access to CustomResourceDefinitions, which is not granted when the operator watches a set of namespaces"}
+	}
+	return nil
+}
+
+// resolveKubeStateMetrics validates the spec of kube-state-metrics and
+// returns the kinds of its custom resource state config, with the resources
+// they are served as when the config does not name them. Kinds are only
+// returned once checkCustomResourceAccess allows them.
+func resolveKubeStateMetrics(ctx context.Context, c client.Client, spec monitoringv1alpha1.KubeStateMetricsSpec, field string, namespaced bool) ([]kubeStateMetricsCustomResource, error) {
+	if err := validateKubeStateMetrics(spec, field, namespaced); err != nil {
+		return nil, err
+	}
+	if spec.CustomResourceStateConfig == "" {
+		return nil, nil
+	}
+
+	resources, err := parseCustomResourceState(spec.CustomResourceStateConfig, field)
+	if err != nil {
+		return nil, err
+	}
+	for i := range resources {
+		resource := &resources[i]
+		if resource.Plural != "" {
+			continue
+		}
+		gvk := schema.GroupVersionKind{Group: resource.Group, Version: resource.Version, Kind: resource.Kind}
+		mapping, err := c.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			// The CustomResourceDefinition may be installed later
+			if meta.IsNoMatchError(err) {
+				return nil, fmt.Errorf("no CustomResourceDefinition serves %s", gvk)
+			}
+			return nil, fmt.Errorf("failed to resolve the resource of %s: %w", gvk, err)
+		}
+		resource.Plural = mapping.Resource.Resource
+	}
+	if err := checkCustomResourceAccess(ctx, c, resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+// checkCustomResourceAccess returns an error unless every kind is served by a
+// CustomResourceDefinition and the operator may list and watch it. Anyone
+// creating a stack chooses the kinds, so only those an admin granted the
+// operator are passed on to kube-state-metrics. Both may change later, so the
+// reconciliation is retried.
+func checkCustomResourceAccess(ctx context.Context, c client.Client, resources []kubeStateMetricsCustomResource) error {
+	for _, resource := range resources {
+		gvk := schema.GroupVersionKind{Group: resource.Group, Version: resource.Version, Kind: resource.Kind}
+
+		crd := &unstructured.Unstructured{}
+		crd.SetGroupVersionKind(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"})
+		if err := c.Get(ctx, client.ObjectKey{Name: resource.Plural + "." + resource.Group}, crd); err != nil {
+			if errors.IsNotFound(err) {
+				return fmt.Errorf("no CustomResourceDefinition serves %s", gvk)
+			}
+			return fmt.Errorf("failed to get the CustomResourceDefinition of %s: %w", gvk, err)
+		}
+		if !crdServes(crd, resource) {
+			return fmt.Errorf("CustomResourceDefinition %s does not serve %s", crd.GetName(), gvk)
+		}
+
+		for _, verb := range []string{"list", "watch"} {
+			review := &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: verb, Group: resource.Group, Resource: resource.Plural},
+				},
+			}
+			if err := c.Create(ctx, review); err != nil {
+				return fmt.Errorf("failed to review access to %s: %w", gvk, err)
+			}
+			if !review.Status.Allowed {
+				return fmt.Errorf("the operator may not %s %s.%s, so it cannot grant them to kube-state-metrics; give its ClusterRole list and watch on them", verb, resource.Plural, resource.Group)
+			}
+		}
+	}
+	return nil
+}
+
+// crdServes reports whether a CustomResourceDefinition serves the kind of a
+// custom resource at its version
+func crdServes(crd *unstructured.Unstructured, resource kubeStateMetricsCustomResource) bool {
+	kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	if kind != resource.Kind {
+		return false
+	}
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	for _, version := range versions {
+		version, ok := version.(map[string]interface{})
+		if ok && version["name"] == resource.Version && version["served"] == true {
+			return true
+		}
+	}
+	return false
+}
+
+// kubeStateMetricsLabelsAllowlist renders the label allowlist in the format of
+// --metric-labels-allowlist: pods=[app,team],deployments=[*]
+func kubeStateMetricsLabelsAllowlist(allowlist map[string][]string) string {
+	resources := make([]string, 0, len(allowlist))
+	for resource := range allowlist {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	entries := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		entries = append(entries, fmt.Sprintf("%s=[%s]", resource, strings.Join(allowlist[resource], ",")))
+	}
+	return strings.Join(entries, ",")
+}
+
+// kubeStateMetricsArgs are the arguments of kube-state-metrics, restricted to
+// the namespaces when the operator watches a set of them
+func kubeStateMetricsArgs(spec monitoringv1alpha1.KubeStateMetricsSpec, namespaces []string, customResources []kubeStateMetricsCustomResource) []string {
+	var args []string
+	if len(namespaces) > 0 {
+		args = append(args, "--namespaces="+strings.Join(namespaces, ","))
+	}
+
+	if resources := kubeStateMetricsReportedResources(spec, len(namespaces) > 0); resources != nil {
+		// Custom resources are only reported on when listed as well
+		for _, resource := range customResources {
+			resources = append(resources, resource.Plural)
+		}
+		args = append(args, "--resources="+strings.Join(resources, ","))
+	}
+
+	if len(spec.MetricLabelsAllowlist) > 0 {
+		args = append(args, "--metric-labels-allowlist="+kubeStateMetricsLabelsAllowlist(spec.MetricLabelsAllowlist))
+	}
+	if len(customResources) > 0 {
+		args = append(args, "--custom-resource-state-config-file="+kubeStateMetricsCustomResourcePath+"/"+kubeStateMetricsCustomResourceFile)
+	}
+	return args
+}
+
+// kubeStateMetricsRulesFor narrows the rules of kube-state-metrics to the
+// resources it reports on, and grants the custom resources and what shards
+// read to find their share
+func kubeStateMetricsRulesFor(spec monitoringv1alpha1.KubeStateMetricsSpec, namespaced bool, customResources []kubeStateMetricsCustomResource) []rbacv1.PolicyRule {
+	reported := kubeStateMetricsReportedResources(spec, namespaced)
+
+	var rules []rbacv1.PolicyRule
+	for _, rule := range kubeStateMetricsRules {
+		rule := *rule.DeepCopy()
+		if reported != nil {
+			var resources []string
+			for _, resource := range rule.Resources {
+				if slices.Contains(reported, resource) {
+					resources = append(resources, resource)
+				}
+			}
+			if len(resources) == 0 {
+				continue
+			}
+			rule.Resources = resources
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(customResources) > 0 {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{"apiextensions.k8s.io"},
+			Resources: []string{"customresourcedefinitions"},
+			Verbs:     []string{"list", "watch"},
+		})
+		for _, resource := range customResources {
+			rules = append(rules, rbacv1.PolicyRule{
+				APIGroups: []string{resource.Group},
+				Resources: []string{resource.Plural},
+				Verbs:     []string{"list", "watch"},
+			})
+		}
+	}
+
+	if kubeStateMetricsShards(spec) > 1 {
+		rules = append(rules,
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+			rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"statefulsets"}, Verbs: []string{"get"}},
+		)
+	}
+	return rules
+}
+
+// kubeStateMetricsCustomResourceConfigMap holds the custom resource state
+// config of kube-state-metrics
+func kubeStateMetricsCustomResourceConfigMap(name, namespace string, labels map[string]string, config string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-" + kubeStateMetricsCustomResourceVolume,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Data: map[string]string{kubeStateMetricsCustomResourceFile: config},
+	}
+}
+
+// mountCustomResourceState mounts the custom resource state config into
+// kube-state-metrics and annotates the pod template with its checksum
+func mountCustomResourceState(template *corev1.PodTemplateSpec, configMap *corev1.ConfigMap) {
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[customResourceStateChecksumAnnotation] = rulesChecksum(configMap.Data)
+
+	podSpec := &template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: kubeStateMetricsCustomResourceVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMap.Name},
+			},
+		},
+	})
+
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      kubeStateMetricsCustomResourceVolume,
+		MountPath: kubeStateMetricsCustomResourcePath,
+		ReadOnly:  true,
+	})
+}
+
+// shardKubeStateMetrics turns the kube-state-metrics Deployment into a
+// StatefulSet of shards. Each pod finds its shard from its ordinal and the
+// replicas of the StatefulSet, so the shards follow the replicas.
+func shardKubeStateMetrics(deployment *appsv1.Deployment, shards int32) *appsv1.StatefulSet {
+	template := *deployment.Spec.Template.DeepCopy()
+	container := &template.Spec.Containers[0]
+	container.Args = append(container.Args, "--pod=$(POD_NAME)", "--pod-namespace=$(POD_NAMESPACE)")
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.name"},
+		}},
+		corev1.EnvVar{Name: "POD_NAMESPACE", ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.namespace"},
+		}},
+	)
+
+	return &appsv1.StatefulSet{
+		ObjectMeta: *deployment.ObjectMeta.DeepCopy(),
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &shards,
+			ServiceName: deployment.Name,
+			Selector:    deployment.Spec.Selector.DeepCopy(),
+			Template:    template,
+			// Shards hold no state, so they need not start one by one
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+		},
+	}
+}
+
+// kubeStateMetricsShardsJob scrapes every shard of kube-state-metrics through
+// the endpoints of its Service, which alone would only reach one of them
+func kubeStateMetricsShardsJob(name, namespace string) map[string]interface{} {
+	return map[string]interface{}{
+		"job_name":     componentKubeStateMetrics,
+		"honor_labels": true,
+		"kubernetes_sd_configs": []interface{}{
+			map[string]interface{}{
+				"role":       "endpoints",
+				"namespaces": map[string]interface{}{"names": []interface{}{namespace}},
+			},
+		},
+		"relabel_configs": []interface{}{
+			map[string]interface{}{
+				"source_labels": []interface{}{"__meta_kubernetes_service_name", "__meta_kubernetes_endpoint_port_name"},
+				"regex":         name + ";http-metrics",
+				"action":        "keep",
+			},
+		},
+	}
+}
+
+// replaceScrapeJobs replaces the scrape jobs of a Prometheus configuration
+// named like the jobs, appending the jobs it does not have
+func replaceScrapeJobs(configMap *corev1.ConfigMap, key string, jobs []map[string]interface{}) error {
+	return patchYAMLConfig(configMap, key, func(config map[string]interface{}) {
+		replaced := map[string]bool{}
+		for _, job := range jobs {
+			replaced[job["job_name"].(string)] = true
+		}
+
+		scrapeConfigs, _ := config["scrape_configs"].([]interface{})
+		kept := []interface{}{}
+		for _, sc := range scrapeConfigs {
+			if job, ok := sc.(map[string]interface{}); ok && replaced[fmt.Sprint(job["job_name"])] {
+				continue
+			}
+			kept = append(kept, sc)
+		}
+		for _, job := range jobs {
+			kept = append(kept, job)
+		}
+		config["scrape_configs"] = kept
+	})
+}
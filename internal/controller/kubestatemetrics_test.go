@@ -0,0 +1,267 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("kube-state-metrics configuration", func() {
+	const customResourceState = `kind: CustomResourceStateMetrics
+spec:
+  resources:
+  - groupVersionKind:
+      group: monitoring.example.com
+      version: v1alpha1
+      kind: ObservabilityStack
+    resourcePlural: observabilitystacks
+    metrics:
+    - name: info
+      help: Exists
+      each:
+        type: Info
+        info:
+          labelsFromPath:
+            name: [metadata, name]
+`
+
+	var spec monitoringv1alpha1.KubeStateMetricsSpec
+
+	BeforeEach(func() {
+		spec = monitoringv1alpha1.KubeStateMetricsSpec{Enabled: true}
+	})
+
+	It("should keep the defaults of kube-state-metrics without a configuration", func() {
+		Expect(kubeStateMetricsArgs(spec, nil, nil)).To(BeEmpty())
+		Expect(kubeStateMetricsRulesFor(spec, false, nil)).To(Equal(kubeStateMetricsRules))
+	})
+
+	It("should report on and grant only the allowed resources", func() {
+		spec.ResourceAllowlist = []monitoringv1alpha1.KubeStateMetricsResource{"pods", "deployments", "nodes"}
+		spec.MetricLabelsAllowlist = map[string][]string{"pods": {"app", "team"}, "deployments": {"*"}}
+		Expect(validateKubeStateMetrics(spec, "spec.kubeStateMetrics", false)).To(Succeed())
+
+		Expect(kubeStateMetricsArgs(spec, nil, nil)).To(Equal([]string{
+			"--resources=deployments,nodes,pods",
+			"--metric-labels-allowlist=deployments=[*],pods=[app,team]",
+		}))
+		Expect(kubeStateMetricsRulesFor(spec, false, nil)).To(Equal([]rbacv1.PolicyRule{
+			{APIGroups: []string{""}, Resources: []string{"nodes", "pods"}, Verbs: []string{"list", "watch"}},
+			{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"list", "watch"}},
+		}))
+
+		// Cluster-scoped resources cannot be listed in the watched namespaces
+		Expect(kubeStateMetricsArgs(spec, []string{"team-a"}, nil)).To(ContainElement("--resources=deployments,pods"))
+	})
+
+	It("should drop the denied resources from those granted", func() {
+		spec.ResourceDenylist = []monitoringv1alpha1.KubeStateMetricsResource{"secrets", "configmaps"}
+
+		args := kubeStateMetricsArgs(spec, nil, nil)
+		Expect(args).To(HaveLen(1))
+		Expect(args[0]).To(HavePrefix("--resources=cronjobs,daemonsets,"))
+		Expect(args[0]).NotTo(ContainSubstring("secrets"))
+		Expect(kubeStateMetricsRulesFor(spec, false, nil)[0].Resources).NotTo(ContainElement("configmaps"))
+	})
+
+	It("should report on and grant the custom resources", func() {
+		spec.ResourceAllowlist = []monitoringv1alpha1.KubeStateMetricsResource{"pods"}
+		spec.CustomResourceStateConfig = customResourceState
+		customResources, err := parseCustomResourceState(spec.CustomResourceStateConfig, "spec.kubeStateMetrics")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(customResources).To(Equal([]kubeStateMetricsCustomResource{{
+			Group: "monitoring.example.com", Version: "v1alpha1", Kind: "ObservabilityStack", Plural: "observabilitystacks",
+		}}))
+
+		Expect(kubeStateMetricsArgs(spec, nil, customResources)).To(Equal([]string{
+			"--resources=pods,observabilitystacks",
+			"--custom-resource-state-config-file=" + kubeStateMetricsCustomResourcePath + "/" + kubeStateMetricsCustomResourceFile,
+		}))
+		Expect(kubeStateMetricsRulesFor(spec, false, customResources)).To(ContainElements(
+			rbacv1.PolicyRule{APIGroups: []string{"apiextensions.k8s.io"}, Resources: []string{"customresourcedefinitions"}, Verbs: []string{"list", "watch"}},
+			rbacv1.PolicyRule{APIGroups: []string{"monitoring.example.com"}, Resources: []string{"observabilitystacks"}, Verbs: []string{"list", "watch"}},
+		))
+
+		configMap := kubeStateMetricsCustomResourceConfigMap("test-kube-state-metrics", "monitoring", nil, spec.CustomResourceStateConfig)
+		template := corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "kube-state-metrics"}}}}
+		mountCustomResourceState(&template, configMap)
+		Expect(template.Annotations).To(HaveKey(customResourceStateChecksumAnnotation))
+		Expect(template.Spec.Volumes[0].ConfigMap.Name).To(Equal("test-kube-state-metrics-custom-resource-state"))
+		Expect(template.Spec.Containers[0].VolumeMounts[0].MountPath).To(Equal(kubeStateMetricsCustomResourcePath))
+	})
+
+	It("should refuse configurations kube-state-metrics would not run with", func() {
+		for _, edit := range []func(*monitoringv1alpha1.KubeStateMetricsSpec){
+			func(s *monitoringv1alpha1.KubeStateMetricsSpec) {
+				s.ResourceAllowlist = []monitoringv1alpha1.KubeStateMetricsResource{"nodes"}
+			},
+			func(s *monitoringv1alpha1.KubeStateMetricsSpec) {
+				s.ResourceDenylist = kubeStateMetricsResources(kubeStateMetricsNamespacedResources)
+			},
+			func(s *monitoringv1alpha1.KubeStateMetricsSpec) {
+				s.ResourceAllowlist = []monitoringv1alpha1.KubeStateMetricsResource{"pods"}
+				s.MetricLabelsAllowlist = map[string][]string{"deployments": {"app"}}
+			},
+			func(s *monitoringv1alpha1.KubeStateMetricsSpec) {
+				s.MetricLabelsAllowlist = map[string][]string{"pods": {"not a label"}}
+			},
+			func(s *monitoringv1alpha1.KubeStateMetricsSpec) { s.CustomResourceStateConfig = customResourceState },
+		} {
+			edited := monitoringv1alpha1.KubeStateMetricsSpec{Enabled: true}
+			edit(&edited)
+
+			// In the watched namespaces
+			err := validateKubeStateMetrics(edited, "spec.prometheus.kubeStateMetrics", true)
+			var kubeStateMetricsErr *InvalidKubeStateMetricsError
+			Expect(errors.As(err, &kubeStateMetricsErr)).To(BeTrue())
+			reason, _ := invalidSpec(err)
+			Expect(reason).To(Equal(reasonInvalidKubeStateMetrics))
+		}
+
+		for _, config := range []string{
+			"kind: Unknown",
+			"kind: CustomResourceStateMetrics\nspec:\n  resources: []",
+			"kind: CustomResourceStateMetrics\nspec:\n  resources:\n  - groupVersionKind: {group: example.com, version: v1, kind: \"*\"}",
+		} {
+			_, err := parseCustomResourceState(config, "spec.kubeStateMetrics")
+			var kubeStateMetricsErr *InvalidKubeStateMetricsError
+			Expect(errors.As(err, &kubeStateMetricsErr)).To(BeTrue())
+		}
+	})
+
+	It("should shard kube-state-metrics in a StatefulSet", func() {
+		spec.Shards = pointer.Int32(3)
+		cpu := resource.MustParse("100m")
+		deployment, _ := kubeStateMetricsWorkload("test-kube-state-metrics", "monitoring", map[string]string{"app.kubernetes.io/name": "kube-state-metrics"},
+			"registry.k8s.io/kube-state-metrics/kube-state-metrics:v2.10.0",
+			&corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: cpu}}, []string{"--resources=pods"})
+
+		statefulSet := shardKubeStateMetrics(deployment, kubeStateMetricsShards(spec))
+		Expect(*statefulSet.Spec.Replicas).To(Equal(int32(3)))
+		Expect(statefulSet.Spec.ServiceName).To(Equal("test-kube-state-metrics"))
+		Expect(statefulSet.Spec.PodManagementPolicy).To(Equal(appsv1.ParallelPodManagement))
+
+		container := statefulSet.Spec.Template.Spec.Containers[0]
+		Expect(container.Args).To(Equal([]string{"--resources=pods", "--pod=$(POD_NAME)", "--pod-namespace=$(POD_NAMESPACE)"}))
+		Expect(containerEnv(container)["POD_NAME"].ValueFrom.FieldRef.FieldPath).To(Equal("metadata.name"))
+		Expect(deployment.Spec.Template.Spec.Containers[0].Args).To(Equal([]string{"--resources=pods"}))
+
+		Expect(kubeStateMetricsRulesFor(spec, false, nil)).To(ContainElements(
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+			rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"statefulsets"}, Verbs: []string{"get"}},
+		))
+
+		stack := &monitoringv1alpha1.ObservabilityStack{}
+		stack.Spec.Prometheus.KubeStateMetrics = spec
+		Expect(componentWorkload(stack, componentKubeStateMetrics)).To(BeAssignableToTypeOf(&appsv1.StatefulSet{}))
+	})
+
+	It("should scrape every shard of the shared kube-state-metrics", func() {
+		clusterStack := &monitoringv1alpha1.ClusterObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+			Spec: monitoringv1alpha1.ClusterObservabilityStackSpec{
+				Namespace:        "monitoring-system",
+				KubeStateMetrics: monitoringv1alpha1.KubeStateMetricsSpec{Enabled: true, Shards: pointer.Int32(2)},
+			},
+		}
+		configMap := &corev1.ConfigMap{Data: map[string]string{"prometheus.yml": `scrape_configs:
+- job_name: kube-state-metrics
+  static_configs:
+  - targets: ['test-kube-state-metrics:8080']
+`}}
+
+		Expect(sharedCollectorJobs(configMap, "prometheus.yml", clusterStack)).To(Succeed())
+		Expect(configMap.Data["prometheus.yml"]).NotTo(ContainSubstring("static_configs"))
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("role: endpoints"))
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("regex: shared-kube-state-metrics;http-metrics"))
+	})
+})
+
+// kubeStateMetricsResources converts resource names to the spec's type
+func kubeStateMetricsResources(names []string) []monitoringv1alpha1.KubeStateMetricsResource {
+	resources := make([]monitoringv1alpha1.KubeStateMetricsResource, 0, len(names))
+	for _, name := range names {
+		resources = append(resources, monitoringv1alpha1.KubeStateMetricsResource(name))
+	}
+	return resources
+}
+
+var _ = Describe("checkCustomResourceAccess", func() {
+	resources := []kubeStateMetricsCustomResource{{Group: "example.com", Version: "v1", Kind: "Widget", Plural: "widgets"}}
+
+	// newClient serves the CustomResourceDefinitions of crds and allows the
+	// operator the verbs listed
+	newClient := func(crds map[string]string, allowed ...string) client.Client {
+		return interceptor.NewClient(newFakeClient().(client.WithWatch), interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				kind, found := crds[key.Name]
+				if !found {
+					return apierrors.NewNotFound(schema.GroupResource{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"}, key.Name)
+				}
+				crd := obj.(*unstructured.Unstructured)
+				crd.SetName(key.Name)
+				crd.Object["spec"] = map[string]interface{}{
+					"names":    map[string]interface{}{"kind": kind},
+					"versions": []interface{}{map[string]interface{}{"name": "v1", "served": true}},
+				}
+				return nil
+			},
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				review := obj.(*authorizationv1.SelfSubjectAccessReview)
+				review.Status.Allowed = slices.Contains(allowed, review.Spec.ResourceAttributes.Verb)
+				return nil
+			},
+		})
+	}
+
+	It("should allow custom resources the operator may list and watch", func() {
+		c := newClient(map[string]string{"widgets.example.com": "Widget"}, "list", "watch")
+		Expect(checkCustomResourceAccess(context.Background(), c, resources)).To(Succeed())
+	})
+
+	It("should refuse kinds no CustomResourceDefinition serves", func() {
+		c := newClient(nil, "list", "watch")
+		Expect(checkCustomResourceAccess(context.Background(), c, resources)).To(MatchError("no CustomResourceDefinition serves example.com/v1, Kind=Widget"))
+
+		builtIn := []kubeStateMetricsCustomResource{{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "Role", Plural: "roles"}}
+		Expect(checkCustomResourceAccess(context.Background(), c, builtIn)).To(MatchError(ContainSubstring("no CustomResourceDefinition serves")))
+	})
+
+	It("should refuse custom resources the operator may not watch", func() {
+		c := newClient(map[string]string{"widgets.example.com": "Widget"}, "list")
+		Expect(checkCustomResourceAccess(context.Background(), c, resources)).To(MatchError(ContainSubstring("the operator may not watch widgets.example.com")))
+	})
+})
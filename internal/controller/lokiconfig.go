@@ -0,0 +1,364 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	reasonInvalidSchema = "InvalidSchema"
+
+	// lokiDataPath is where Loki's volume is mounted
+	lokiDataPath = "/loki"
+
+	// schemaDateLayout is the layout of the dates schema periods start on
+	schemaDateLayout = "2006-01-02"
+)
+
+// InvalidSchemaError reports Loki schema periods that would leave stored
+// logs unreadable
+type InvalidSchemaError struct {
+	Message string
+}
+
+func (e *InvalidSchemaError) Error() string {
+	return fmt.Sprintf("spec.loki.schemas: %s", e.Message)
+}
+
+// setLokiSchema replaces the schema periods of loki.yaml with the stack's, or
+// with the deployed ones when the stack has none. Periods of the deployed
+// configuration that are in effect on now must be kept unchanged, since Loki
+// reads the logs they indexed with them, and periods added must start after
+// now. Without deployed configuration, Loki has not written anything yet and
+// any schema is accepted.
+func setLokiSchema(configMap, deployed *corev1.ConfigMap, schemas []monitoringv1alpha1.LokiSchemaPeriod, now time.Time) error {
+	if len(schemas) == 0 {
+		if deployed == nil {
+			return nil
+		}
+		return keepLokiSchema(configMap, deployed)
+	}
+
+	generated, err := lokiSchemaPeriods(configMap)
+	if err != nil {
+		return err
+	}
+	objectStore := "filesystem"
+	if len(generated) > 0 {
+		if store, ok := generated[len(generated)-1]["object_store"].(string); ok {
+			objectStore = store
+		}
+	}
+
+	var periods []map[string]interface{}
+	var previous time.Time
+	usesTSDB := false
+	for i, schema := range schemas {
+		from, err := time.Parse(schemaDateLayout, schema.From)
+		if err != nil {
+			return &InvalidSchemaError{Message: fmt.Sprintf("period %d has invalid date %q", i, schema.From)}
+		}
+		if i > 0 && !from.After(previous) {
+			return &InvalidSchemaError{Message: fmt.Sprintf("period %d must start after %s", i, previous.Format(schemaDateLayout))}
+		}
+		previous = from
+
+		schemaVersion, store := defaultString(schema.Schema, "v13"), defaultString(schema.Store, "tsdb")
+		if schemaVersion == "v13" && store != "tsdb" {
+			return &InvalidSchemaError{Message: fmt.Sprintf("period %d uses schema v13, which needs the tsdb store", i)}
+		}
+		usesTSDB = usesTSDB || store == "tsdb"
+
+		periods = append(periods, map[string]interface{}{
+			"from":         schema.From,
+			"schema":       schemaVersion,
+			"store":        store,
+			"object_store": objectStore,
+			"index": map[string]interface{}{
+				"prefix": defaultString(schema.IndexPrefix, "index_"),
+				"period": defaultString(schema.IndexPeriod, "24h"),
+			},
+		})
+	}
+
+	if deployed != nil {
+		inEffect, err := lokiSchemaPeriods(deployed)
+		if err != nil {
+			return err
+		}
+		if err := checkSchemaChange(inEffect, periods, now); err != nil {
+			return err
+		}
+	}
+
+	configs := make([]interface{}, 0, len(periods))
+	for _, period := range periods {
+		configs = append(configs, period)
+	}
+
+	return patchYAMLConfig(configMap, "loki.yaml", func(config map[string]interface{}) {
+		config["schema_config"] = map[string]interface{}{"configs": configs}
+		if !usesTSDB {
+			return
+		}
+
+		storage, _ := config["storage_config"].(map[string]interface{})
+		if storage == nil {
+			storage = map[string]interface{}{}
+		}
+		if _, ok := storage["tsdb_shipper"]; !ok {
+			storage["tsdb_shipper"] = map[string]interface{}{
+				"active_index_directory": lokiDataPath + "/tsdb-index",
+				"cache_location":         lokiDataPath + "/tsdb-cache",
+			}
+		}
+		config["storage_config"] = storage
+	})
+}
+
+// checkSchemaChange verifies that the periods of the deployed configuration
+// in effect on now are kept as they are, and that every other period
+// starts after now
+func checkSchemaChange(deployed, periods []map[string]interface{}, now time.Time) error {
+	today := now.UTC().Format(schemaDateLayout)
+
+	byDate := map[string]map[string]interface{}{}
+	for _, period := range periods {
+		byDate[period["from"].(string)] = period
+	}
+
+	kept := map[string]bool{}
+	for _, period := range deployed {
+		from := fmt.Sprint(period["from"])
+		if from > today {
+			continue
+		}
+		kept[from] = true
+
+		replacement, ok := byDate[from]
+		if !ok {
+			return &InvalidSchemaError{Message: fmt.Sprintf("the period from %s is in effect and cannot be removed", from)}
+		}
+		for _, key := range []string{"schema", "store", "object_store", "index"} {
+			if fmt.Sprint(replacement[key]) != fmt.Sprint(period[key]) {
+				return &InvalidSchemaError{Message: fmt.Sprintf("the period from %s is in effect and its %s cannot be changed", from, key)}
+			}
+		}
+	}
+
+	for _, period := range periods {
+		from := period["from"].(string)
+		if !kept[from] && from <= today {
+			return &InvalidSchemaError{Message: fmt.Sprintf("the new period from %s must start after today, %s (UTC)", from, today)}
+		}
+	}
+	return nil
+}
+
+// keepLokiSchema carries the schema periods of the deployed configuration
+// over, so a change to the generated schema does not orphan stored logs
+func keepLokiSchema(configMap, deployed *corev1.ConfigMap) error {
+	config, err := lokiConfig(deployed)
+	if err != nil {
+		return err
+	}
+	schema, ok := config["schema_config"]
+	if !ok {
+		return nil
+	}
+
+	return patchYAMLConfig(configMap, "loki.yaml", func(config map[string]interface{}) {
+		config["schema_config"] = schema
+	})
+}
+
+// lokiSchemaPeriods returns the schema periods of a Loki configuration
+func lokiSchemaPeriods(configMap *corev1.ConfigMap) ([]map[string]interface{}, error) {
+	config, err := lokiConfig(configMap)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, _ := config["schema_config"].(map[string]interface{})
+	configs, _ := schema["configs"].([]interface{})
+	var periods []map[string]interface{}
+	for _, c := range configs {
+		if period, ok := c.(map[string]interface{}); ok {
+			periods = append(periods, period)
+		}
+	}
+	return periods, nil
+}
+
+func lokiConfig(configMap *corev1.ConfigMap) (map[string]interface{}, error) {
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["loki.yaml"]), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse loki.yaml: %w", err)
+	}
+	return config, nil
+}
+
+// setLokiCompactor configures compaction and retention. Settings left out of
+// the spec keep the generated values, and stream rules turn retention on
+// unless it is explicitly off.
+func setLokiCompactor(configMap *corev1.ConfigMap, compactor monitoringv1alpha1.LokiCompactorSpec) error {
+	return patchYAMLConfig(configMap, "loki.yaml", func(config map[string]interface{}) {
+		settings, _ := config["compactor"].(map[string]interface{})
+		if settings == nil {
+			settings = map[string]interface{}{}
+		}
+		if _, ok := settings["working_directory"]; !ok {
+			settings["working_directory"] = lokiDataPath + "/compactor"
+		}
+
+		if compactor.CompactionInterval != "" {
+			settings["compaction_interval"] = compactor.CompactionInterval
+		}
+		if compactor.RetentionEnabled != nil {
+			settings["retention_enabled"] = *compactor.RetentionEnabled
+		} else if len(compactor.StreamRetention) > 0 {
+			settings["retention_enabled"] = true
+		}
+		if compactor.RetentionDeleteDelay != "" {
+			settings["retention_delete_delay"] = compactor.RetentionDeleteDelay
+		}
+		config["compactor"] = settings
+
+		if len(compactor.StreamRetention) == 0 {
+			return
+		}
+		rules := make([]interface{}, 0, len(compactor.StreamRetention))
+		for _, rule := range compactor.StreamRetention {
+			rules = append(rules, map[string]interface{}{
+				"selector": rule.Selector,
+				"priority": rule.Priority,
+				"period":   fmt.Sprintf("%dh", rule.Days*hoursPerDay),
+			})
+		}
+		limits := limitsConfig(config)
+		limits["retention_stream"] = rules
+	})
+}
+
+// setLokiDeleteRequestStore names the store of delete requests, which Loki 3
+// requires when retention is on. Earlier versions reject the setting.
+func setLokiDeleteRequestStore(configMap *corev1.ConfigMap, version string) error {
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return err
+	}
+	if compareVersions(parsed, [3]int{3, 0, 0}) < 0 {
+		return nil
+	}
+
+	periods, err := lokiSchemaPeriods(configMap)
+	if err != nil {
+		return err
+	}
+	store := "filesystem"
+	if len(periods) > 0 {
+		if objectStore, ok := periods[len(periods)-1]["object_store"].(string); ok {
+			store = objectStore
+		}
+	}
+
+	return patchYAMLConfig(configMap, "loki.yaml", func(config map[string]interface{}) {
+		settings, _ := config["compactor"].(map[string]interface{})
+		if settings == nil || settings["retention_enabled"] != true {
+			return
+		}
+		if _, ok := settings["delete_request_store"]; !ok {
+			settings["delete_request_store"] = store
+		}
+		delete(settings, "shared_store")
+	})
+}
+
+// setLokiLimits applies the stack's ingestion limits to every tenant
+func setLokiLimits(configMap *corev1.ConfigMap, spec monitoringv1alpha1.LokiLimits) error {
+	var maxLineSize int64
+	if spec.MaxLineSize != "" {
+		quantity, err := parseQuantity(componentLoki, "limits.maxLineSize", spec.MaxLineSize)
+		if err != nil {
+			return err
+		}
+		maxLineSize = quantity.Value()
+	}
+
+	return patchYAMLConfig(configMap, "loki.yaml", func(config map[string]interface{}) {
+		limits := limitsConfig(config)
+		if spec.IngestionRateMB != nil {
+			limits["ingestion_rate_mb"] = *spec.IngestionRateMB
+		}
+		if spec.IngestionBurstSizeMB != nil {
+			limits["ingestion_burst_size_mb"] = *spec.IngestionBurstSizeMB
+		}
+		if spec.PerStreamRateLimitMB != nil {
+			limits["per_stream_rate_limit"] = fmt.Sprintf("%dMB", *spec.PerStreamRateLimitMB)
+		}
+		if spec.MaxStreams != nil {
+			limits["max_global_streams_per_user"] = *spec.MaxStreams
+		}
+		if maxLineSize > 0 {
+			limits["max_line_size"] = maxLineSize
+		}
+		if spec.RejectOlderThanDays != nil {
+			limits["reject_old_samples"] = true
+			limits["reject_old_samples_max_age"] = fmt.Sprintf("%dh", *spec.RejectOlderThanDays*hoursPerDay)
+		}
+	})
+}
+
+// limitsConfig returns the limits_config section of a Loki configuration,
+// adding it if missing
+func limitsConfig(config map[string]interface{}) map[string]interface{} {
+	limits, _ := config["limits_config"].(map[string]interface{})
+	if limits == nil {
+		limits = map[string]interface{}{}
+		config["limits_config"] = limits
+	}
+	return limits
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// deployedConfigMap returns the stack's ConfigMap as currently stored, or nil
+// before it is created
+func (r *ObservabilityStackReconciler) deployedConfigMap(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, name string) (*corev1.ConfigMap, error) {
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: name}, configMap); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get ConfigMap %s: %w", name, err)
+	}
+	return configMap, nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Loki configuration", func() {
+	const deployedConfig = `schema_config:
+  configs:
+  - from: "2023-01-01"
+    index:
+      period: 24h
+      prefix: index_
+    object_store: filesystem
+    schema: v12
+    store: boltdb-shipper
+`
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	var configMap, deployed *corev1.ConfigMap
+
+	BeforeEach(func() {
+		configMap = &corev1.ConfigMap{Data: map[string]string{"loki.yaml": deployedConfig}}
+		deployed = configMap.DeepCopy()
+	})
+
+	It("should add a tsdb period after the ones in effect", func() {
+		schemas := []monitoringv1alpha1.LokiSchemaPeriod{
+			{From: "2023-01-01", Schema: "v12", Store: "boltdb-shipper"},
+			{From: "2024-06-01"},
+		}
+		Expect(setLokiSchema(configMap, deployed, schemas, now)).To(Succeed())
+
+		periods, err := lokiSchemaPeriods(configMap)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(periods).To(HaveLen(2))
+		Expect(periods[1]).To(HaveKeyWithValue("schema", "v13"))
+		Expect(periods[1]).To(HaveKeyWithValue("store", "tsdb"))
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("active_index_directory: /loki/tsdb-index"))
+	})
+
+	It("should refuse to change periods in effect or backdate new ones", func() {
+		var schemaErr *InvalidSchemaError
+
+		err := setLokiSchema(configMap, deployed, []monitoringv1alpha1.LokiSchemaPeriod{{From: "2024-06-01"}}, now)
+		Expect(err).To(BeAssignableToTypeOf(schemaErr))
+		Expect(err.Error()).To(ContainSubstring("cannot be removed"))
+
+		err = setLokiSchema(configMap, deployed, []monitoringv1alpha1.LokiSchemaPeriod{{From: "2023-01-01"}}, now)
+		Expect(err).To(MatchError(ContainSubstring("cannot be changed")))
+
+		err = setLokiSchema(configMap, deployed, []monitoringv1alpha1.LokiSchemaPeriod{
+			{From: "2023-01-01", Schema: "v12", Store: "boltdb-shipper"},
+			{From: "2024-05-10"},
+		}, now)
+		Expect(err).To(MatchError(ContainSubstring("must start after today")))
+	})
+
+	It("should keep the deployed schema when the stack has none", func() {
+		configMap.Data["loki.yaml"] = "schema_config:\n  configs:\n  - from: \"2024-01-01\"\n    schema: v13\n    store: tsdb\n"
+		Expect(setLokiSchema(configMap, deployed, nil, now)).To(Succeed())
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("boltdb-shipper"))
+	})
+
+	It("should write retention rules and limits", func() {
+		Expect(setLokiCompactor(configMap, monitoringv1alpha1.LokiCompactorSpec{
+			StreamRetention: []monitoringv1alpha1.LokiStreamRetention{{Selector: `{namespace="dev"}`, Days: 2, Priority: 1}},
+		})).To(Succeed())
+		Expect(setLokiLimits(configMap, monitoringv1alpha1.LokiLimits{
+			IngestionRateMB: pointer.Int32(8),
+			MaxLineSize:     "256Ki",
+		})).To(Succeed())
+
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("retention_enabled: true"))
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("period: 48h"))
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("ingestion_rate_mb: 8"))
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("max_line_size: 262144"))
+
+		Expect(setLokiDeleteRequestStore(configMap, "2.9.8")).To(Succeed())
+		Expect(configMap.Data["loki.yaml"]).NotTo(ContainSubstring("delete_request_store"))
+		Expect(setLokiDeleteRequestStore(configMap, "3.0.0")).To(Succeed())
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("delete_request_store: filesystem"))
+	})
+})
@@ -0,0 +1,371 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// ruleTenantLabel on a rule ConfigMap names the tenant its rules belong
+	// to when multi-tenancy is on. It defaults to the stack's defaultTenant.
+	ruleTenantLabel = "monitoring.example.com/tenant"
+
+	// lokiRulesMountPath holds a directory of rule files per tenant
+	lokiRulesMountPath = "/etc/loki-rules"
+	lokiRulesVolume    = "rules"
+
+	// lokiSingleTenant is the tenant Loki files everything under when
+	// authentication is off
+	lokiSingleTenant = "fake"
+
+	portAlertmanager = 9093
+
+	reasonInvalidRules = "InvalidRules"
+)
+
+// lokiRulerEnabled reports whether Loki runs a ruler
+func lokiRulerEnabled(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	return stack.Spec.Loki.Enabled && stack.Spec.Loki.Ruler.Enabled
+}
+
+// validateLokiRules checks that a rule file parses and that its groups and
+// rules are well-formed. LogQL expressions are only checked for balanced
+// brackets and valid stream selectors; Loki reports any other error in them
+// when loading the file.
+func validateLokiRules(data string) error {
+	file, err := parseRuleFile(data)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, group := range file.Groups {
+		if names[group.Name] {
+			return fmt.Errorf("rule group %q is defined twice", group.Name)
+		}
+		names[group.Name] = true
+
+		if err := validateRuleGroup(group, validateLogQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logqlBrackets maps each closing bracket to its opening one
+var logqlBrackets = map[byte]byte{')': '(', ']': '[', '}': '{'}
+
+// validateLogQL checks that the brackets of a LogQL expression are balanced
+// outside its strings and that it selects streams. Every pair of braces is a
+// stream selector, which needs at least one label matcher.
+func validateLogQL(expr string) error {
+	var open []int
+	selectors := 0
+	for pos := 0; pos < len(expr); pos++ {
+		c := expr[pos]
+		switch c {
+		case '"', '`':
+			end := pos + 1
+			for ; end < len(expr) && expr[end] != c; end++ {
+				if expr[end] == '\\' && c != '`' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return fmt.Errorf("expr %q has an unterminated string at position %d", expr, pos)
+			}
+			pos = end
+
+		case '(', '[', '{':
+			open = append(open, pos)
+
+		case ')', ']', '}':
+			if len(open) == 0 || expr[open[len(open)-1]] != logqlBrackets[c] {
+				return fmt.Errorf("expr %q has an unmatched %q at position %d", expr, c, pos)
+			}
+			start := open[len(open)-1]
+			open = open[:len(open)-1]
+			if c != '}' {
+				continue
+			}
+
+			if err := validateStreamSelector(expr[start : pos+1]); err != nil {
+				return fmt.Errorf("expr %q has an invalid stream selector at position %d: %w", expr, start, err)
+			}
+			selectors++
+		}
+	}
+
+	if len(open) > 0 {
+		return fmt.Errorf("expr %q has an unclosed %q at position %d", expr, expr[open[len(open)-1]], open[len(open)-1])
+	}
+	if selectors == 0 {
+		return fmt.Errorf("expr %q has no stream selector", expr)
+	}
+	return nil
+}
+
+// validateStreamSelector checks the label matchers of a stream selector with
+// the PromQL parser, whose matchers LogQL shares
+func validateStreamSelector(selector string) error {
+	tokens, err := lexPromQL(selector)
+	if err != nil {
+		return err
+	}
+
+	p := &promqlParser{tokens: tokens}
+	count, nonEmpty, err := p.parseMatchers()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("no label matchers")
+	}
+	if nonEmpty == 0 {
+		return fmt.Errorf("no label matcher that excludes the empty value")
+	}
+	return nil
+}
+
+// lokiRuleFiles collects the rule files of the ConfigMaps the ruler selects,
+// keyed by the name they are stored under in the stack's rules ConfigMap,
+// along with the path of each below lokiRulesMountPath. Invalid files are
+// left out and reported as Warning events, so one broken file does not stop
+// the other rules from being evaluated.
+func (r *ObservabilityStackReconciler) lokiRuleFiles(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) (map[string]string, []corev1.KeyToPath, error) {
+	files := map[string]string{}
+	var items []corev1.KeyToPath
+
+	selector := stack.Spec.Loki.Ruler.RuleSelector
+	if selector == nil {
+		return files, items, nil
+	}
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid spec.loki.ruler.ruleSelector: %w", err)
+	}
+
+	list := &corev1.ConfigMapList{}
+	if err := r.List(ctx, list, client.InNamespace(stack.Namespace), client.MatchingLabelsSelector{Selector: sel}); err != nil {
+		return nil, nil, fmt.Errorf("failed to list rule ConfigMaps: %w", err)
+	}
+
+	for _, configMap := range list.Items {
+		if managedByOperator(&configMap) {
+			continue
+		}
+
+		tenant := lokiSingleTenant
+		if multiTenant(stack) {
+			tenant = configMap.Labels[ruleTenantLabel]
+			if tenant == "" {
+				tenant = defaultTenant(stack)
+			}
+		}
+
+		for _, key := range yamlConfigKeys(&configMap) {
+			if err := validateLokiRules(configMap.Data[key]); err != nil {
+				r.Recorder.Eventf(stack, corev1.EventTypeWarning, reasonInvalidRules, "Skipped %s in ConfigMap %s: %v", key, configMap.Name, err)
+				continue
+			}
+
+			// ConfigMap names cannot contain "_", so the names are unique
+			name := fmt.Sprintf("%s_%s", configMap.Name, key)
+			files[name] = configMap.Data[key]
+			items = append(items, corev1.KeyToPath{Key: name, Path: path.Join(tenant, name)})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return files, items, nil
+}
+
+// lokiAlertmanagerURL returns where the ruler sends alerts: the configured
+// URL, or the stack's Alertmanager Service if there is one
+func (r *ObservabilityStackReconciler) lokiAlertmanagerURL(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) (string, error) {
+	if stack.Spec.Loki.Ruler.AlertmanagerURL != "" {
+		return stack.Spec.Loki.Ruler.AlertmanagerURL, nil
+	}
+
+	name := fmt.Sprintf("%s-alertmanager", stack.Name)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: name}, &corev1.Service{}); err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get Service %s: %w", name, err)
+	}
+	return fmt.Sprintf("http://%s:%d", name, portAlertmanager), nil
+}
+
+// setLokiRuler configures the ruler to load the rule files mounted at
+// lokiRulesMountPath, send alerts to alertmanagerURL, and write the results
+// of recording rules to the stack's Prometheus.
+func setLokiRuler(configMap *corev1.ConfigMap, stack *monitoringv1alpha1.ObservabilityStack, alertmanagerURL, version string) error {
+	parsed, err := parseVersion(version)
+	if err != nil {
+		return err
+	}
+
+	return patchYAMLConfig(configMap, "loki.yaml", func(config map[string]interface{}) {
+		ruler := map[string]interface{}{
+			"storage": map[string]interface{}{
+				"type":  "local",
+				"local": map[string]interface{}{"directory": lokiRulesMountPath},
+			},
+			// Scratch space for the rules of the tenants being evaluated
+			"rule_path":           "/tmp/loki-rules",
+			"enable_api":          true,
+			"evaluation_interval": defaultString(stack.Spec.Loki.Ruler.EvaluationInterval, "1m"),
+			"wal": map[string]interface{}{
+				"dir": lokiDataPath + "/ruler-wal",
+			},
+		}
+		if alertmanagerURL != "" {
+			ruler["alertmanager_url"] = alertmanagerURL
+			// Loki 3 always speaks the v2 API and dropped the setting
+			if compareVersions(parsed, [3]int{3, 0, 0}) < 0 {
+				ruler["enable_alertmanager_v2"] = true
+			}
+		}
+		if stack.Spec.Prometheus.Enabled {
+			ruler["remote_write"] = map[string]interface{}{
+				"enabled": true,
+				"clients": map[string]interface{}{
+					componentPrometheus: map[string]interface{}{
+						"url": serviceURL(stack, componentPrometheus, portPrometheus) + "/api/v1/write",
+					},
+				},
+			}
+		}
+		config["ruler"] = ruler
+	})
+}
+
+func lokiRulesName(stack *monitoringv1alpha1.ObservabilityStack) string {
+	return fmt.Sprintf("%s-loki-rules", stack.Name)
+}
+
+// reconcileLokiRules gathers the selected rule files into the stack's rules
+// ConfigMap and configures the ruler in Loki's configuration. It returns the
+// files to mount.
+func (r *ObservabilityStackReconciler) reconcileLokiRules(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, configMap *corev1.ConfigMap, lokiLabels map[string]string, version string) ([]corev1.KeyToPath, error) {
+	files, items, err := r.lokiRuleFiles(ctx, stack)
+	if err != nil {
+		return nil, err
+	}
+
+	alertmanagerURL, err := r.lokiAlertmanagerURL(ctx, stack)
+	if err != nil {
+		return nil, err
+	}
+	if err := setLokiRuler(configMap, stack, alertmanagerURL, version); err != nil {
+		return nil, fmt.Errorf("failed to configure Loki ruler: %w", err)
+	}
+
+	rules := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      lokiRulesName(stack),
+			Namespace: stack.Namespace,
+			Labels:    lokiLabels,
+		},
+		Data: files,
+	}
+	if err := ctrl.SetControllerReference(stack, rules, r.Scheme); err != nil {
+		return nil, fmt.Errorf("failed to set controller reference on configmap: %w", err)
+	}
+	if err := r.createOrUpdate(ctx, stack, rules); err != nil {
+		return nil, fmt.Errorf("failed to reconcile Loki rules ConfigMap: %w", err)
+	}
+	return items, nil
+}
+
+// deleteLokiRules removes the rules ConfigMap once the ruler is disabled
+func (r *ObservabilityStackReconciler) deleteLokiRules(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	rules := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: lokiRulesName(stack), Namespace: stack.Namespace},
+	}
+	if err := r.delete(ctx, stack, rules); err != nil {
+		return fmt.Errorf("failed to delete Loki rules ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// mountLokiRules mounts the rules ConfigMap with each file in its tenant's
+// directory
+func mountLokiRules(template *corev1.PodTemplateSpec, configMapName string, items []corev1.KeyToPath) {
+	podSpec := &template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: lokiRulesVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+				Items:                items,
+			},
+		},
+	})
+
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      lokiRulesVolume,
+			MountPath: lokiRulesMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// managedByOperator reports whether an object was created by the operator,
+// so a broad rule selector does not pick up the operator's own ConfigMaps
+func managedByOperator(obj client.Object) bool {
+	return obj.GetLabels()["app.kubernetes.io/managed-by"] == "kube-insight-operator"
+}
+
+// stacksSelectingRules maps a ConfigMap to the stacks of its namespace whose
+// Loki ruler or Prometheus selects it
+func (r *ObservabilityStackReconciler) stacksSelectingRules(ctx context.Context, obj client.Object) []reconcile.Request {
+	if managedByOperator(obj) {
+		return nil
+	}
+
+	list := &monitoringv1alpha1.ObservabilityStackList{}
+	if err := r.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list ObservabilityStacks")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, stack := range list.Items {
+		lokiRules := lokiRulerEnabled(&stack) && selectsRules(stack.Spec.Loki.Ruler.RuleSelector, obj)
+		prometheusRules := stack.Spec.Prometheus.Enabled && selectsRules(stack.Spec.Prometheus.Rules.RuleSelector, obj)
+		if lokiRules || prometheusRules {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&stack)})
+		}
+	}
+	return requests
+}
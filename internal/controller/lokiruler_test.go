@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Loki ruler", func() {
+	It("should accept well-formed alerting and recording rules", func() {
+		Expect(validateLokiRules(`groups:
+- name: errors
+  interval: 1m
+  rules:
+  - alert: HighErrorRate
+    expr: sum(rate({app="api"} |= "error" [5m])) > 10
+    for: 10m
+    labels:
+      severity: warning
+  - record: app:errors:rate5m
+    expr: sum by (app) (rate({app="api"} |= "error" [5m]))
+`)).To(Succeed())
+	})
+
+	It("should reject malformed rules", func() {
+		Expect(validateLokiRules("groups: []")).To(MatchError(ContainSubstring("no rule groups")))
+		Expect(validateLokiRules(`groups:
+- name: errors
+  rules:
+  - alert: A
+    record: b
+    expr: '{app="api"}'
+`)).To(MatchError(ContainSubstring("only one of alert or record")))
+		Expect(validateLokiRules(`groups:
+- name: errors
+  rules:
+  - record: errors-rate
+    expr: 'rate({app="api"}[5m])'
+`)).To(MatchError(ContainSubstring("invalid metric name")))
+		Expect(validateLokiRules(`groups:
+- name: errors
+  rules:
+  - alert: NoSelector
+    expr: vector(1)
+`)).To(MatchError(ContainSubstring("no stream selector")))
+	})
+
+	It("should check the brackets and stream selectors of LogQL expressions", func() {
+		Expect(validateLogQL(`sum by (level) (count_over_time({app="api", env=~"prod|stage"} | json | line_format "{{.msg}}" [5m]))`)).To(Succeed())
+		Expect(validateLogQL("{app=`a}b`} |= `(`")).To(Succeed())
+
+		Expect(validateLogQL(`rate({app="x"}[5m]`)).To(MatchError(ContainSubstring(`unclosed '('`)))
+		Expect(validateLogQL(`rate({app="x"}[5m]))`)).To(MatchError(ContainSubstring(`unmatched ')'`)))
+		Expect(validateLogQL(`rate({app="x"}[5m})`)).To(MatchError(ContainSubstring(`unmatched '}'`)))
+		Expect(validateLogQL(`{app="x"} |= "error`)).To(MatchError(ContainSubstring("unterminated string")))
+		Expect(validateLogQL(`count_over_time({}[5m])`)).To(MatchError(ContainSubstring("no label matchers")))
+		Expect(validateLogQL(`{app=~".*"} |= "error"`)).To(MatchError(ContainSubstring("no label matcher that excludes the empty value")))
+		Expect(validateLogQL(`{app=x}`)).To(MatchError(ContainSubstring("invalid stream selector")))
+		Expect(validateLogQL(`{app=~"("}`)).To(MatchError(ContainSubstring("invalid regular expression")))
+	})
+
+	It("should send alerts to the Alertmanager and recording rules to Prometheus", func() {
+		stack := &monitoringv1alpha1.ObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+			Spec: monitoringv1alpha1.ObservabilityStackSpec{
+				Prometheus: monitoringv1alpha1.PrometheusSpec{Enabled: true},
+				Loki:       monitoringv1alpha1.LokiSpec{Enabled: true, Ruler: monitoringv1alpha1.LokiRulerSpec{Enabled: true}},
+			},
+		}
+		configMap := &corev1.ConfigMap{Data: map[string]string{"loki.yaml": "auth_enabled: false\n"}}
+
+		Expect(setLokiRuler(configMap, stack, "http://test-alertmanager:9093", "2.9.8")).To(Succeed())
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("alertmanager_url: http://test-alertmanager:9093"))
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("enable_alertmanager_v2: true"))
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("url: http://test-prometheus:9090/api/v1/write"))
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("directory: /etc/loki-rules"))
+
+		Expect(setLokiRuler(configMap, stack, "", "3.0.0")).To(Succeed())
+		Expect(configMap.Data["loki.yaml"]).NotTo(ContainSubstring("alertmanager"))
+	})
+})
@@ -0,0 +1,190 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "kube_insight"
+
+var (
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "component_reconcile_duration_seconds",
+		Help:      "Time taken to reconcile a component of an ObservabilityStack.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace", "stack", "component"})
+
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "component_reconcile_errors_total",
+		Help:      "Failed reconciliations of a component of an ObservabilityStack, by reason.",
+	}, []string{"namespace", "stack", "component", "reason"})
+
+	componentReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "component_ready",
+		Help:      "Whether all replicas of a component's workload are ready (1) or not (0).",
+	}, []string{"namespace", "stack", "component"})
+
+	configGenerations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_generations_total",
+		Help:      "Configurations written to a component's ConfigMap, counting creation and every change.",
+	}, []string{"namespace", "stack", "component"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileDuration, reconcileErrors, componentReady, configGenerations)
+}
+
+// componentWorkloads maps each component to the kind of its workload, which is
+// named "<stack>-<component>"
+var componentWorkloads = map[string]func() client.Object{
+	componentPrometheus:       func() client.Object { return &appsv1.StatefulSet{} },
+	componentKubeStateMetrics: func() client.Object { return &appsv1.Deployment{} },
+	componentGrafana:          func() client.Object { return &appsv1.Deployment{} },
+	componentLoki:             func() client.Object { return &appsv1.StatefulSet{} },
+	componentPromtail:         func() client.Object { return &appsv1.DaemonSet{} },
+	componentTempo:            func() client.Object { return &appsv1.StatefulSet{} },
+	componentThanosQuery:      func() client.Object { return &appsv1.Deployment{} },
+	componentThanosStore:      func() client.Object { return &appsv1.StatefulSet{} },
+}
+
+// componentWorkload returns an empty object of the kind of a component's
+// workload. Sharded kube-state-metrics runs as a StatefulSet.
+func componentWorkload(stack *monitoringv1alpha1.ObservabilityStack, component string) client.Object {
+	if component == componentKubeStateMetrics && kubeStateMetricsShards(stack.Spec.Prometheus.KubeStateMetrics) > 1 {
+		return &appsv1.StatefulSet{}
+	}
+	return componentWorkloads[component]()
+}
+
+// reconcileComponent runs the reconciliation of one component, recording its
+// duration and, on success, the readiness of its workload. Components of a
+// paused stack and unmanaged components are not reconciled, but their
+// readiness is still recorded.
+func (r *ObservabilityStackReconciler) reconcileComponent(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string, reconcile func(context.Context, *monitoringv1alpha1.ObservabilityStack) error) error {
+	if paused(stack) || unmanaged(stack, component) {
+		return r.recordReadiness(ctx, stack, component)
+	}
+
+	start := time.Now()
+	err := reconcile(ctx, stack)
+	reconcileDuration.WithLabelValues(stack.Namespace, stack.Name, component).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+
+	return r.recordReadiness(ctx, stack, component)
+}
+
+// recordReadiness sets the readiness gauge of a component from the status of
+// its workload. Components without a workload have their series removed.
+func (r *ObservabilityStackReconciler) recordReadiness(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string) error {
+	workload := componentWorkload(stack, component)
+	key := client.ObjectKey{Namespace: stack.Namespace, Name: fmt.Sprintf("%s-%s", stack.Name, component)}
+	if err := r.Get(ctx, key, workload); err != nil {
+		if errors.IsNotFound(err) {
+			componentReady.DeleteLabelValues(stack.Namespace, stack.Name, component)
+			return nil
+		}
+		return fmt.Errorf("failed to get %s workload: %w", component, err)
+	}
+
+	ready := 0.0
+	if workloadReady(workload) {
+		ready = 1
+	}
+	componentReady.WithLabelValues(stack.Namespace, stack.Name, component).Set(ready)
+	return nil
+}
+
+// workloadReady reports whether every desired replica of a workload is ready
+func workloadReady(workload client.Object) bool {
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		desired := int32(1)
+		if w.Spec.Replicas != nil {
+			desired = *w.Spec.Replicas
+		}
+		return w.Status.ReadyReplicas >= desired
+	case *appsv1.Deployment:
+		desired := int32(1)
+		if w.Spec.Replicas != nil {
+			desired = *w.Spec.Replicas
+		}
+		return w.Status.ReadyReplicas >= desired
+	case *appsv1.DaemonSet:
+		return w.Status.DesiredNumberScheduled > 0 && w.Status.NumberReady >= w.Status.DesiredNumberScheduled
+	}
+	return false
+}
+
+// recordConfigGeneration counts a configuration written to a ConfigMap,
+// attributed to the component named by its app.kubernetes.io/name label.
+func recordConfigGeneration(stack *monitoringv1alpha1.ObservabilityStack, configMap client.Object) {
+	component := configMap.GetLabels()["app.kubernetes.io/name"]
+	if component == "" {
+		return
+	}
+	configGenerations.WithLabelValues(stack.Namespace, stack.Name, component).Inc()
+}
+
+// enabledComponents reports which components the stack runs itself
+func enabledComponents(stack *monitoringv1alpha1.ObservabilityStack) map[string]bool {
+	return map[string]bool{
+		componentPrometheus:       stack.Spec.Prometheus.Enabled,
+		componentKubeStateMetrics: stack.Spec.Prometheus.Enabled && stack.Spec.Prometheus.KubeStateMetrics.Enabled && !sharesCollectors(stack),
+		componentGrafana:          stack.Spec.Grafana.Enabled,
+		componentLoki:             stack.Spec.Loki.Enabled,
+		componentPromtail:         stack.Spec.Promtail.Enabled && !sharesCollectors(stack),
+		componentTempo:            stack.Spec.Tempo.Enabled,
+		componentThanosQuery:      thanosQueryEnabled(stack),
+		componentThanosStore:      thanosStoreEnabled(stack),
+	}
+}
+
+// forgetDisabledComponents drops the readiness and duration series of
+// components that are no longer enabled on the stack.
+func forgetDisabledComponents(stack *monitoringv1alpha1.ObservabilityStack) {
+	for component, on := range enabledComponents(stack) {
+		if !on {
+			componentReady.DeleteLabelValues(stack.Namespace, stack.Name, component)
+			reconcileDuration.DeleteLabelValues(stack.Namespace, stack.Name, component)
+		}
+	}
+}
+
+// forgetStack removes every series of a deleted stack
+func forgetStack(stack *monitoringv1alpha1.ObservabilityStack) {
+	labels := prometheus.Labels{"namespace": stack.Namespace, "stack": stack.Name}
+	reconcileDuration.DeletePartialMatch(labels)
+	reconcileErrors.DeletePartialMatch(labels)
+	componentReady.DeletePartialMatch(labels)
+	configGenerations.DeletePartialMatch(labels)
+}
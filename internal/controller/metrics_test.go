@@ -0,0 +1,95 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Operator metrics", func() {
+	It("should report a workload ready only once all replicas are ready", func() {
+		sts := &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: pointer.Int32(2)}}
+		sts.Status.ReadyReplicas = 1
+		Expect(workloadReady(sts)).To(BeFalse())
+
+		sts.Status.ReadyReplicas = 2
+		Expect(workloadReady(sts)).To(BeTrue())
+
+		ds := &appsv1.DaemonSet{}
+		Expect(workloadReady(ds)).To(BeFalse())
+
+		ds.Status.DesiredNumberScheduled = 3
+		ds.Status.NumberReady = 3
+		Expect(workloadReady(ds)).To(BeTrue())
+	})
+
+	It("should count config generations per component and forget deleted stacks", func() {
+		stack := &monitoringv1alpha1.ObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "metrics-test", Namespace: "default"},
+		}
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app.kubernetes.io/name": componentLoki}},
+		}
+
+		recordConfigGeneration(stack, configMap)
+		recordConfigGeneration(stack, configMap)
+		Expect(testutil.ToFloat64(configGenerations.WithLabelValues("default", "metrics-test", componentLoki))).To(Equal(2.0))
+
+		forgetStack(stack)
+		Expect(testutil.ToFloat64(configGenerations.WithLabelValues("default", "metrics-test", componentLoki))).To(Equal(0.0))
+	})
+
+	It("should leave an unmanaged component alone and still record its readiness", func() {
+		stack := &monitoringv1alpha1.ObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "unmanaged-test", Namespace: "default"},
+		}
+		stack.Spec.Grafana.Enabled = true
+		stack.Spec.Grafana.Unmanaged = true
+
+		// Edited by hand to a replica count the spec does not ask for
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "unmanaged-test-grafana", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Replicas: pointer.Int32(3)},
+		}
+		deployment.Status.ReadyReplicas = 3
+		c := newFakeClient(stack, deployment)
+		r := &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(10)}
+
+		Expect(r.reconcileComponent(context.Background(), stack, componentGrafana, func(context.Context, *monitoringv1alpha1.ObservabilityStack) error {
+			Fail("reconciled an unmanaged component")
+			return nil
+		})).To(Succeed())
+
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(deployment), deployment)).To(Succeed())
+		Expect(*deployment.Spec.Replicas).To(Equal(int32(3)))
+		Expect(testutil.ToFloat64(componentReady.WithLabelValues("default", "unmanaged-test", componentGrafana))).To(Equal(1.0))
+
+		forgetStack(stack)
+	})
+})
@@ -0,0 +1,249 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// stackNamespaceLabel marks Roles and RoleBindings created outside the
+// stack's namespace, which cannot carry an owner reference to it
+const stackNamespaceLabel = "monitoring.example.com/stack-namespace"
+
+// clusterScopedResources cannot be granted by a Role
+var clusterScopedResources = map[string]bool{
+	"nodes":             true,
+	"nodes/proxy":       true,
+	"nodes/metrics":     true,
+	"namespaces":        true,
+	"persistentvolumes": true,
+	"storageclasses":    true,
+}
+
+// kubeStateMetricsNamespacedResources are the resources kube-state-metrics
+// reports on when it may only list namespaced objects
+var kubeStateMetricsNamespacedResources = []string{
+	"configmaps",
+	"cronjobs",
+	"daemonsets",
+	"deployments",
+	"endpoints",
+	"ingresses",
+	"jobs",
+	"limitranges",
+	"persistentvolumeclaims",
+	"pods",
+	"replicasets",
+	"replicationcontrollers",
+	"resourcequotas",
+	"secrets",
+	"services",
+	"statefulsets",
+}
+
+// clusterScopedComponents are the components that get a ClusterRole and
+// ClusterRoleBinding, or Roles and RoleBindings in namespaced mode, named
+// after the stack
+var clusterScopedComponents = []string{
+	componentPrometheus,
+	componentKubeStateMetrics,
+	componentPromtail,
+}
+
+// namespaced reports whether the operator runs restricted to a set of
+// namespaces, in which case components get Roles in each of them instead of
+// ClusterRoles and only discover targets there.
+func (r *ObservabilityStackReconciler) namespaced() bool {
+	return len(r.WatchNamespaces) > 0
+}
+
+// reconcileNamespacedRBAC grants a component's service account the namespaced
+// part of its rules through a Role and RoleBinding in every watched namespace.
+// Those of a stack with the same name in another namespace are left alone
+// and fail the reconciliation.
+func (r *ObservabilityStackReconciler) reconcileNamespacedRBAC(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component, serviceAccount string, rules []rbacv1.PolicyRule) error {
+	name := fmt.Sprintf("%s-%s", stack.Name, component)
+	labels := map[string]string{
+		"app.kubernetes.io/name":     component,
+		"app.kubernetes.io/instance": stack.Name,
+		stackNamespaceLabel:          stack.Namespace,
+	}
+
+	for _, namespace := range r.WatchNamespaces {
+		role := &rbacv1.Role{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Rules:      namespacedRules(rules),
+		}
+		binding := &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: rbacv1.GroupName,
+				Kind:     "Role",
+				Name:     name,
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      serviceAccount,
+				Namespace: stack.Namespace,
+			}},
+		}
+
+		for _, obj := range []client.Object{role, binding} {
+			if namespace == stack.Namespace {
+				if err := ctrl.SetControllerReference(stack, obj, r.Scheme); err != nil {
+					return fmt.Errorf("failed to set controller reference on %s: %w", name, err)
+				}
+			}
+			_, err := createOrUpdate(ctx, r.Client, r.Recorder, stack, obj, func(existing client.Object) (bool, error) {
+				// A stack with the same name in another namespace shares these names
+				if owner := existing.GetLabels()[stackNamespaceLabel]; owner != stack.Namespace {
+					return false, fmt.Errorf("%s belongs to the stack %s in namespace %s", name, stack.Name, owner)
+				}
+				return false, nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to reconcile %s RBAC in namespace %s: %w", component, namespace, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// namespacedRules drops the non-resource URLs and cluster-scoped resources
+// from a ClusterRole's rules
+func namespacedRules(rules []rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	var namespaced []rbacv1.PolicyRule
+	for _, rule := range rules {
+		if len(rule.NonResourceURLs) > 0 {
+			continue
+		}
+
+		var resources []string
+		for _, resource := range rule.Resources {
+			if !clusterScopedResources[resource] {
+				resources = append(resources, resource)
+			}
+		}
+		if len(resources) == 0 {
+			continue
+		}
+
+		rule = *rule.DeepCopy()
+		rule.Resources = resources
+		namespaced = append(namespaced, rule)
+	}
+	return namespaced
+}
+
+// stackDeleted removes what garbage collection leaves behind a deleted stack:
+// its metric series and, in namespaced mode, its Roles and RoleBindings in
+// other namespaces, which cannot carry an owner reference to it.
+func (r *ObservabilityStackReconciler) stackDeleted(ctx context.Context, key client.ObjectKey) error {
+	stack := &monitoringv1alpha1.ObservabilityStack{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace},
+	}
+	forgetStack(stack)
+
+	if !r.namespaced() {
+		return nil
+	}
+	return r.deleteNamespacedRBAC(ctx, stack)
+}
+
+// deleteNamespacedRBAC removes the Roles and RoleBindings a stack created in
+// the watched namespaces.
+func (r *ObservabilityStackReconciler) deleteNamespacedRBAC(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	for _, namespace := range r.WatchNamespaces {
+		for _, component := range clusterScopedComponents {
+			name := fmt.Sprintf("%s-%s", stack.Name, component)
+			key := client.ObjectKey{Namespace: namespace, Name: name}
+
+			for _, obj := range []client.Object{&rbacv1.RoleBinding{}, &rbacv1.Role{}} {
+				if err := r.Get(ctx, key, obj); err != nil {
+					if errors.IsNotFound(err) {
+						continue
+					}
+					return fmt.Errorf("failed to get %s in namespace %s: %w", name, namespace, err)
+				}
+
+				// A stack with the same name in another namespace shares these names
+				if obj.GetLabels()[stackNamespaceLabel] != stack.Namespace {
+					continue
+				}
+
+				if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+					r.Recorder.Eventf(stack, corev1.EventTypeWarning, reasonReconcileFailed, "Failed to delete %s in namespace %s: %v", name, namespace, err)
+					return fmt.Errorf("failed to delete %s in namespace %s: %w", name, namespace, err)
+				}
+				r.recordObjectEvent(stack, reasonDeleted, obj)
+			}
+		}
+	}
+	return nil
+}
+
+// restrictDiscovery limits the Kubernetes service discovery of a Prometheus
+// or Promtail configuration to the namespaces. Jobs discovering nodes are
+// dropped, since nodes cannot be listed without a ClusterRole.
+func restrictDiscovery(configMap *corev1.ConfigMap, key string, namespaces []string) error {
+	names := make([]interface{}, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		names = append(names, namespace)
+	}
+
+	return patchYAMLConfig(configMap, key, func(config map[string]interface{}) {
+		scrapeConfigs, _ := config["scrape_configs"].([]interface{})
+		kept := []interface{}{}
+		for _, sc := range scrapeConfigs {
+			job, ok := sc.(map[string]interface{})
+			if !ok {
+				kept = append(kept, sc)
+				continue
+			}
+
+			discoversNodes := false
+			sdConfigs, _ := job["kubernetes_sd_configs"].([]interface{})
+			for _, sd := range sdConfigs {
+				sdConfig, ok := sd.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if sdConfig["role"] == "node" {
+					discoversNodes = true
+				}
+				sdConfig["namespaces"] = map[string]interface{}{"names": names}
+			}
+
+			if !discoversNodes {
+				kept = append(kept, job)
+			}
+		}
+		if len(scrapeConfigs) > 0 {
+			config["scrape_configs"] = kept
+		}
+	})
+}
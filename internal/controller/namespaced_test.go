@@ -0,0 +1,102 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Namespaced mode", func() {
+	It("should keep only the namespaced part of a ClusterRole", func() {
+		rules := namespacedRules([]rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"nodes", "nodes/proxy", "services", "pods"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+			{
+				APIGroups: []string{"storage.k8s.io"},
+				Resources: []string{"storageclasses"},
+				Verbs:     []string{"list", "watch"},
+			},
+			{
+				NonResourceURLs: []string{"/metrics"},
+				Verbs:           []string{"get"},
+			},
+		})
+
+		Expect(rules).To(HaveLen(1))
+		Expect(rules[0].Resources).To(Equal([]string{"services", "pods"}))
+	})
+
+	It("should restrict discovery to the watched namespaces", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"prometheus.yml": `scrape_configs:
+- job_name: kubernetes-nodes
+  kubernetes_sd_configs:
+  - role: node
+- job_name: kubernetes-pods
+  kubernetes_sd_configs:
+  - role: pod
+- job_name: prometheus
+  static_configs:
+  - targets: ['localhost:9090']
+`}}
+
+		Expect(restrictDiscovery(configMap, "prometheus.yml", []string{"team-a", "team-b"})).To(Succeed())
+		Expect(configMap.Data["prometheus.yml"]).NotTo(ContainSubstring("kubernetes-nodes"))
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("job_name: kubernetes-pods"))
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("names:\n      - team-a\n      - team-b"))
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("job_name: prometheus"))
+	})
+
+	It("should limit kube-state-metrics to the watched namespaces", func() {
+		args := kubeStateMetricsArgs(monitoringv1alpha1.KubeStateMetricsSpec{}, []string{"team-a", "team-b"}, nil)
+
+		Expect(args).To(ContainElement("--namespaces=team-a,team-b"))
+		Expect(args[1]).NotTo(ContainSubstring("nodes"))
+	})
+	It("should leave the RBAC of a stack with the same name in another namespace alone", func() {
+		binding := &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-prometheus",
+				Namespace: "apps",
+				Labels:    map[string]string{stackNamespaceLabel: "team-a"},
+			},
+			RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "test-prometheus"},
+			Subjects: []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "test-prometheus", Namespace: "team-a"}},
+		}
+		c := newFakeClient(binding)
+		r := &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(10), WatchNamespaces: []string{"apps"}}
+		stack := &monitoringv1alpha1.ObservabilityStack{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "team-b"}}
+
+		err := r.reconcileNamespacedRBAC(context.Background(), stack, componentPrometheus, "test-prometheus", nil)
+		Expect(err).To(MatchError(ContainSubstring("test-prometheus belongs to the stack test in namespace team-a")))
+
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(binding), binding)).To(Succeed())
+		Expect(binding.Subjects[0].Namespace).To(Equal("team-a"))
+	})
+})
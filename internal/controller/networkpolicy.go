@@ -0,0 +1,237 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Ports the components listen on
+const (
+	portPrometheus         = 9090
+	portGrafana            = 3000
+	portLokiHTTP           = 3100
+	portLokiGRPC           = 9096
+	portTempoHTTP          = 3200
+	portPromtailHTTP       = 9080
+	portKubeStateHTTP      = 8080
+	portKubeStateTelemetry = 8081
+	portOTLPGRPC           = 4317
+	portOTLPHTTP           = 4318
+	portJaegerThrift       = 14268
+	portZipkin             = 9411
+	portNodeExporter       = 9100
+	portThanosGRPC         = 10901
+	portThanosHTTP         = 10902
+)
+
+// reconcileNetworkPolicies creates a NetworkPolicy for every enabled component
+// admitting only the traffic the stack needs, and removes the policies of
+// disabled components. Prometheus may scrape every component.
+func (r *ObservabilityStackReconciler) reconcileNetworkPolicies(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	enabled := stack.Spec.NetworkPolicy.Enabled
+	policies := map[string]bool{
+		componentPrometheus:       enabled && stack.Spec.Prometheus.Enabled,
+		componentKubeStateMetrics: enabled && stack.Spec.Prometheus.Enabled && stack.Spec.Prometheus.KubeStateMetrics.Enabled && !sharesCollectors(stack),
+		componentGrafana:          enabled && stack.Spec.Grafana.Enabled,
+		componentLoki:             enabled && stack.Spec.Loki.Enabled,
+		componentPromtail:         enabled && stack.Spec.Promtail.Enabled && !sharesCollectors(stack),
+		componentTempo:            enabled && stack.Spec.Tempo.Enabled,
+		componentThanosQuery:      enabled && thanosQueryEnabled(stack),
+		componentThanosStore:      enabled && thanosStoreEnabled(stack),
+	}
+
+	for component, wanted := range policies {
+		if !wanted {
+			if err := r.deleteNetworkPolicy(ctx, stack, component); err != nil {
+				return err
+			}
+			continue
+		}
+
+		policy := networkPolicyFor(stack, component)
+		if component == componentLoki {
+			if err := r.admitSharedPromtail(ctx, stack, policy); err != nil {
+				return err
+			}
+		}
+		if err := ctrl.SetControllerReference(stack, policy, r.Scheme); err != nil {
+			return fmt.Errorf("failed to set controller reference on networkpolicy: %w", err)
+		}
+
+		if err := r.createOrUpdate(ctx, stack, policy); err != nil {
+			return fmt.Errorf("failed to reconcile %s NetworkPolicy: %w", component, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *ObservabilityStackReconciler) deleteNetworkPolicy(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string) error {
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", stack.Name, component),
+			Namespace: stack.Namespace,
+		},
+	}
+
+	if err := r.delete(ctx, stack, policy); err != nil {
+		return fmt.Errorf("failed to delete %s NetworkPolicy: %w", component, err)
+	}
+	return nil
+}
+
+// admitSharedPromtail lets the Promtail of a referenced
+// ClusterObservabilityStack push to Loki
+func (r *ObservabilityStackReconciler) admitSharedPromtail(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, policy *networkingv1.NetworkPolicy) error {
+	clusterStack, err := r.clusterStack(ctx, stack)
+	if err != nil || clusterStack == nil {
+		return err
+	}
+
+	policy.Spec.Ingress[0].From = append(policy.Spec.Ingress[0].From, networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{corev1.LabelMetadataName: clusterStack.Spec.Namespace},
+		},
+		PodSelector: &metav1.LabelSelector{
+			MatchLabels: selectorLabels(sharedCollectorLabels(clusterStack, componentPromtail)),
+		},
+	})
+	return nil
+}
+
+// networkPolicyFor builds the ingress policy of a component
+func networkPolicyFor(stack *monitoringv1alpha1.ObservabilityStack, component string) *networkingv1.NetworkPolicy {
+	prometheus := componentPeer(stack, componentPrometheus)
+	grafana := componentPeer(stack, componentGrafana)
+
+	var rules []networkingv1.NetworkPolicyIngressRule
+	switch component {
+	case componentPrometheus:
+		peers := []networkingv1.NetworkPolicyPeer{grafana, prometheus}
+		if lokiRulerEnabled(stack) {
+			// The ruler writes the results of recording rules
+			peers = append(peers, componentPeer(stack, componentLoki))
+		}
+		rules = []networkingv1.NetworkPolicyIngressRule{
+			ingressRule(peers, portPrometheus),
+		}
+		if thanosQueryEnabled(stack) {
+			// Thanos Query reads the sidecar's Store API
+			rules = append(rules, ingressRule([]networkingv1.NetworkPolicyPeer{componentPeer(stack, componentThanosQuery)}, portThanosGRPC))
+		}
+	case componentKubeStateMetrics:
+		rules = []networkingv1.NetworkPolicyIngressRule{
+			ingressRule([]networkingv1.NetworkPolicyPeer{prometheus}, portKubeStateHTTP, portKubeStateTelemetry),
+		}
+	case componentGrafana:
+		namespaces := append([]string{stack.Namespace}, stack.Spec.NetworkPolicy.GrafanaAllowedNamespaces...)
+		rules = []networkingv1.NetworkPolicyIngressRule{
+			ingressRule([]networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{
+						Key:      corev1.LabelMetadataName,
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   namespaces,
+					}},
+				},
+			}}, portGrafana),
+		}
+		if grafanaHA(stack) {
+			// The replicas' Alertmanagers gossip over both protocols
+			gossip := ingressRule([]networkingv1.NetworkPolicyPeer{grafana}, portGrafanaAlerting)
+			udp := corev1.ProtocolUDP
+			gossip.Ports = append(gossip.Ports, networkingv1.NetworkPolicyPort{Protocol: &udp, Port: gossip.Ports[0].Port})
+			rules = append(rules, gossip)
+		}
+	case componentLoki:
+		rules = []networkingv1.NetworkPolicyIngressRule{
+			ingressRule([]networkingv1.NetworkPolicyPeer{componentPeer(stack, componentPromtail), grafana, prometheus}, portLokiHTTP),
+			ingressRule([]networkingv1.NetworkPolicyPeer{componentPeer(stack, componentLoki)}, portLokiGRPC),
+		}
+	case componentPromtail:
+		rules = []networkingv1.NetworkPolicyIngressRule{
+			ingressRule([]networkingv1.NetworkPolicyPeer{prometheus}, portPromtailHTTP),
+		}
+	case componentTempo:
+		rules = []networkingv1.NetworkPolicyIngressRule{
+			ingressRule([]networkingv1.NetworkPolicyPeer{grafana, prometheus}, portTempoHTTP),
+			// Applications anywhere in the cluster send traces
+			ingressRule(nil, portOTLPGRPC, portOTLPHTTP, portJaegerThrift, portZipkin),
+		}
+	case componentThanosQuery:
+		rules = []networkingv1.NetworkPolicyIngressRule{
+			ingressRule([]networkingv1.NetworkPolicyPeer{grafana, prometheus}, portThanosHTTP),
+		}
+	case componentThanosStore:
+		rules = []networkingv1.NetworkPolicyIngressRule{
+			ingressRule([]networkingv1.NetworkPolicyPeer{componentPeer(stack, componentThanosQuery)}, portThanosGRPC),
+			ingressRule([]networkingv1.NetworkPolicyPeer{prometheus}, portThanosHTTP),
+		}
+	}
+
+	labels := map[string]string{
+		"app.kubernetes.io/name":       component,
+		"app.kubernetes.io/instance":   stack.Name,
+		"app.kubernetes.io/managed-by": "kube-insight-operator",
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s", stack.Name, component),
+			Namespace: stack.Namespace,
+			Labels:    labels,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: selectorLabels(labels)},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     rules,
+		},
+	}
+}
+
+// componentPeer selects the pods of a component of the stack
+func componentPeer(stack *monitoringv1alpha1.ObservabilityStack, component string) networkingv1.NetworkPolicyPeer {
+	return networkingv1.NetworkPolicyPeer{
+		PodSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				"app.kubernetes.io/name":     component,
+				"app.kubernetes.io/instance": stack.Name,
+			},
+		},
+	}
+}
+
+// ingressRule admits TCP traffic from the peers to the ports. No peers
+// admits traffic from anywhere.
+func ingressRule(from []networkingv1.NetworkPolicyPeer, ports ...int) networkingv1.NetworkPolicyIngressRule {
+	protocol := corev1.ProtocolTCP
+	rule := networkingv1.NetworkPolicyIngressRule{From: from}
+	for _, port := range ports {
+		port := intstr.FromInt(port)
+		rule.Ports = append(rule.Ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port})
+	}
+	return rule
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("networkPolicyFor", func() {
+	stack := &monitoringv1alpha1.ObservabilityStack{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "monitoring"},
+		Spec: monitoringv1alpha1.ObservabilityStackSpec{
+			NetworkPolicy: monitoringv1alpha1.NetworkPolicySpec{
+				Enabled:                  true,
+				GrafanaAllowedNamespaces: []string{"ops"},
+			},
+		},
+	}
+
+	It("should admit Promtail, Grafana and Prometheus to Loki", func() {
+		policy := networkPolicyFor(stack, componentLoki)
+
+		Expect(policy.Name).To(Equal("test-loki"))
+		Expect(policy.Spec.PodSelector.MatchLabels).To(HaveKeyWithValue("app.kubernetes.io/name", componentLoki))
+		Expect(policy.Spec.Ingress).To(HaveLen(2))
+
+		var sources []string
+		for _, peer := range policy.Spec.Ingress[0].From {
+			sources = append(sources, peer.PodSelector.MatchLabels["app.kubernetes.io/name"])
+		}
+		Expect(sources).To(ConsistOf(componentPromtail, componentGrafana, componentPrometheus))
+		Expect(policy.Spec.Ingress[0].Ports[0].Port.IntValue()).To(Equal(portLokiHTTP))
+	})
+
+	It("should admit the allowed namespaces to Grafana", func() {
+		policy := networkPolicyFor(stack, componentGrafana)
+
+		selector := policy.Spec.Ingress[0].From[0].NamespaceSelector
+		Expect(selector.MatchExpressions[0].Values).To(ConsistOf("monitoring", "ops"))
+	})
+})
@@ -0,0 +1,89 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// createOrUpdate creates obj, or updates the existing object to it, and emits
+// an event on owner when the object changed. It returns the reason of that
+// event, or "" when nothing was written. When keep is set, it is given the
+// existing object first and returns true to leave it as it is.
+func createOrUpdate(ctx context.Context, c client.Client, recorder record.EventRecorder, owner, obj client.Object, keep func(existing client.Object) (bool, error)) (string, error) {
+	existing := obj.DeepCopyObject().(client.Object)
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to get resource: %w", err)
+		}
+		if err := c.Create(ctx, obj); err != nil {
+			return "", fmt.Errorf("failed to create resource: %w", err)
+		}
+		recordObjectEvent(recorder, c.Scheme(), owner, reasonCreated, obj)
+		return reasonCreated, nil
+	}
+
+	if keep != nil {
+		if kept, err := keep(existing); err != nil || kept {
+			return "", err
+		}
+	}
+
+	// Custom resources reject updates without a resourceVersion
+	if obj.GetResourceVersion() == "" {
+		obj.SetResourceVersion(existing.GetResourceVersion())
+	}
+	if err := c.Update(ctx, obj); err != nil {
+		return "", fmt.Errorf("failed to update resource: %w", err)
+	}
+
+	// The API server keeps the resourceVersion of no-op updates
+	if obj.GetResourceVersion() == existing.GetResourceVersion() {
+		return "", nil
+	}
+	reason := reasonUpdated
+	if _, isConfigMap := obj.(*corev1.ConfigMap); isConfigMap {
+		reason = reasonConfigChanged
+	}
+	recordObjectEvent(recorder, c.Scheme(), owner, reason, obj)
+	return reason, nil
+}
+
+// deleteObject removes obj and emits an event on owner. An object that does
+// not exist is left alone, so disabled components cost no writes.
+func deleteObject(ctx context.Context, c client.Client, recorder record.EventRecorder, owner, obj client.Object) error {
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get %s: %w", obj.GetName(), err)
+	}
+	if err := c.Delete(ctx, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete %s: %w", obj.GetName(), err)
+	}
+	recordObjectEvent(recorder, c.Scheme(), owner, reasonDeleted, obj)
+	return nil
+}
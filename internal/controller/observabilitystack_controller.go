@@ -31,11 +31,21 @@ limitations under the License.
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=selfsubjectaccessreviews,verbs=create
 package controller
 
 import (
 	"context"
 	"fmt"
+	"time"
 
 	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
 	"github.com/johnwroge/kube-insight-operator/pkg/grafana"
@@ -45,22 +55,34 @@ import (
 	"github.com/johnwroge/kube-insight-operator/pkg/tempo"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 // ObservabilityStackReconciler reconciles a ObservabilityStack object
 type ObservabilityStackReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// WatchNamespaces restricts the operator and the stacks it manages to
+	// these namespaces. Empty means cluster-wide.
+	WatchNamespaces []string
+
+	// Clock times component upgrades. Nil uses the system clock.
+	Clock clock.PassiveClock
 }
 
 // Reconcile handles the main reconciliation loop for ObservabilityStack
@@ -73,50 +95,113 @@ func (r *ObservabilityStackReconciler) Reconcile(ctx context.Context, req ctrl.R
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Object not found, return.  Created objects are automatically garbage collected.
-			return ctrl.Result{}, nil
+			return ctrl.Result{}, r.stackDeleted(ctx, req.NamespacedName)
 		}
 		// Error reading the object - requeue the request.
 		return ctrl.Result{}, err
 	}
 
+	if err := r.setPaused(ctx, stack); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// A ClusterObservabilityStack that does not allow the stack's namespace
+	// neither ships its logs nor lets it run collectors of its own
+	if _, err := r.clusterStack(ctx, stack); err != nil {
+		return r.reconcileFailed(ctx, stack, componentPromtail, err)
+	}
+
 	// Check if Prometheus is enabled and reconcile it
 	if stack.Spec.Prometheus.Enabled {
-		if err := r.reconcilePrometheus(ctx, stack); err != nil {
+		if err := r.reconcileComponent(ctx, stack, componentPrometheus, r.reconcilePrometheus); err != nil {
 			log.Error(err, "Failed to reconcile Prometheus")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, stack, componentPrometheus, err)
+		}
+
+		if stack.Spec.Prometheus.KubeStateMetrics.Enabled && !sharesCollectors(stack) {
+			if err := r.reconcileComponent(ctx, stack, componentKubeStateMetrics, r.reconcileKubeStateMetrics); err != nil {
+				log.Error(err, "Failed to reconcile kube-state-metrics")
+				return r.reconcileFailed(ctx, stack, componentKubeStateMetrics, err)
+			}
+		}
+	}
+
+	// Thanos Query and the Store Gateway are removed once disabled
+	if thanosQueryEnabled(stack) {
+		if err := r.reconcileComponent(ctx, stack, componentThanosQuery, r.reconcileThanosQuery); err != nil {
+			log.Error(err, "Failed to reconcile Thanos Query")
+			return r.reconcileFailed(ctx, stack, componentThanosQuery, err)
+		}
+	} else if !paused(stack) {
+		if err := r.deleteThanosComponent(ctx, stack, componentThanosQuery, &appsv1.Deployment{}); err != nil {
+			return r.reconcileFailed(ctx, stack, componentThanosQuery, err)
+		}
+	}
+	if thanosStoreEnabled(stack) {
+		if err := r.reconcileComponent(ctx, stack, componentThanosStore, r.reconcileThanosStore); err != nil {
+			log.Error(err, "Failed to reconcile Thanos Store Gateway")
+			return r.reconcileFailed(ctx, stack, componentThanosStore, err)
+		}
+	} else if !paused(stack) {
+		if err := r.deleteThanosComponent(ctx, stack, componentThanosStore, &appsv1.StatefulSet{}); err != nil {
+			return r.reconcileFailed(ctx, stack, componentThanosStore, err)
 		}
 	}
 
 	// Check if Grafana is enabled and reconcile it
 	if stack.Spec.Grafana.Enabled {
-		if err := r.reconcileGrafana(ctx, stack); err != nil {
+		if err := r.reconcileComponent(ctx, stack, componentGrafana, r.reconcileGrafana); err != nil {
 			log.Error(err, "Failed to reconcile Grafana")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, stack, componentGrafana, err)
 		}
 	}
 
 	// Check if Loki is enabled and reconcile it
 	if stack.Spec.Loki.Enabled {
-		if err := r.reconcileLoki(ctx, stack); err != nil {
+		if err := r.reconcileComponent(ctx, stack, componentLoki, r.reconcileLoki); err != nil {
 			log.Error(err, "Failed to reconcile Loki")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, stack, componentLoki, err)
 		}
 	}
 
-	if stack.Spec.Promtail.Enabled {
-		if err := r.reconcilePromtail(ctx, stack); err != nil {
+	// A referenced ClusterObservabilityStack ships the logs instead
+	if stack.Spec.Promtail.Enabled && !sharesCollectors(stack) {
+		if err := r.reconcileComponent(ctx, stack, componentPromtail, r.reconcilePromtail); err != nil {
 			log.Error(err, "Failed to reconcile Promtail")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, stack, componentPromtail, err)
 		}
 	}
 
 	if stack.Spec.Tempo.Enabled {
-		if err := r.reconcileTempo(ctx, stack); err != nil {
+		if err := r.reconcileComponent(ctx, stack, componentTempo, r.reconcileTempo); err != nil {
 			log.Error(err, "Failed to reconcile Tempo")
-			return ctrl.Result{}, err
+			return r.reconcileFailed(ctx, stack, componentTempo, err)
+		}
+	}
+
+	if !paused(stack) {
+		if err := r.reconcileNetworkPolicies(ctx, stack); err != nil {
+			log.Error(err, "Failed to reconcile NetworkPolicies")
+			return r.reconcileFailed(ctx, stack, "network-policies", err)
 		}
 	}
 
+	forgetDisabledComponents(stack)
+
+	// Degraded describes the last reconciliation, which a paused stack did not get
+	if paused(stack) {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.setDegraded(ctx, stack, metav1.ConditionFalse, reasonReconciled, "All enabled components reconciled"); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Check back on rolling upgrades, which roll back when they time out
+	if upgrading(stack) {
+		return ctrl.Result{RequeueAfter: upgradeRequeue}, nil
+	}
+
 	return ctrl.Result{}, nil
 }
 
@@ -126,10 +211,6 @@ func (r *ObservabilityStackReconciler) reconcilePrometheus(ctx context.Context,
 		return fmt.Errorf("failed to reconcile Prometheus RBAC: %w", err)
 	}
 
-	if err := r.reconcileKubeStateMetrics(ctx, stack); err != nil {
-		return fmt.Errorf("failed to reconcile kube-state-metrics: %w", err)
-	}
-
 	// Define common labels
 	labels := map[string]string{
 		"app.kubernetes.io/name":       "prometheus",
@@ -145,14 +226,83 @@ func (r *ObservabilityStackReconciler) reconcilePrometheus(ctx context.Context,
 	)
 
 	configMap := configGen.GenerateConfigMap()
+
+	if r.namespaced() {
+		if err := restrictDiscovery(configMap, "prometheus.yml", r.WatchNamespaces); err != nil {
+			return fmt.Errorf("failed to restrict Prometheus discovery: %w", err)
+		}
+	}
+
+	// A stack sharing the collectors scrapes the shared kube-state-metrics instead
+	if ksm := stack.Spec.Prometheus.KubeStateMetrics; ksm.Enabled && !sharesCollectors(stack) && kubeStateMetricsShards(ksm) > 1 {
+		job := kubeStateMetricsShardsJob(fmt.Sprintf("%s-kube-state-metrics", stack.Name), stack.Namespace)
+		if err := replaceScrapeJobs(configMap, "prometheus.yml", []map[string]interface{}{job}); err != nil {
+			return fmt.Errorf("failed to scrape the kube-state-metrics shards: %w", err)
+		}
+	}
+
+	clusterStack, err := r.clusterStack(ctx, stack)
+	if err != nil {
+		return err
+	}
+	if clusterStack != nil {
+		if err := sharedCollectorJobs(configMap, "prometheus.yml", clusterStack); err != nil {
+			return fmt.Errorf("failed to scrape the shared collectors: %w", err)
+		}
+	}
+
+	if tlsEnabled(stack) {
+		if err := r.reconcileServingCertificate(ctx, stack, componentPrometheus); err != nil {
+			return err
+		}
+		if err := setPrometheusTLS(configMap, stack); err != nil {
+			return fmt.Errorf("failed to configure Prometheus TLS: %w", err)
+		}
+	}
+
+	if thanosEnabled(stack) {
+		if err := setThanosExternalLabels(configMap, stack); err != nil {
+			return fmt.Errorf("failed to set Prometheus external labels: %w", err)
+		}
+	}
+	if thanosUploads(stack) {
+		if err := r.checkObjectStorageConfig(ctx, stack); err != nil {
+			return err
+		}
+	}
+
+	checksum := ""
+	if prometheusRulesConfigured(stack) {
+		if checksum, err = r.reconcilePrometheusRules(ctx, stack, configMap, labels); err != nil {
+			return err
+		}
+	} else if err := r.deletePrometheusRules(ctx, stack); err != nil {
+		return err
+	}
+
 	if err := ctrl.SetControllerReference(stack, configMap, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference on configmap: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, configMap); err != nil {
+	if err := r.createOrUpdate(ctx, stack, configMap); err != nil {
 		return fmt.Errorf("failed to reconcile Prometheus ConfigMap: %w", err)
 	}
 
+	resources, err := componentResources(stack, componentPrometheus, stack.Spec.Prometheus.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Prometheus resources: %w", err)
+	}
+
+	storage, err := parseStorage(componentPrometheus, stack.Spec.Prometheus.Storage, stack.Spec.Prometheus.StorageSettings)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Prometheus storage: %w", err)
+	}
+
+	version, err := r.componentVersion(ctx, stack, componentPrometheus, configMap)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Prometheus version: %w", err)
+	}
+
 	// Create StatefulSet for Prometheus
 	sts := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -182,7 +332,7 @@ func (r *ObservabilityStackReconciler) reconcilePrometheus(ctx context.Context,
 					Containers: []corev1.Container{
 						{
 							Name:  "prometheus",
-							Image: "prom/prometheus:v2.45.0",
+							Image: componentImage(componentPrometheus, version),
 							Args: []string{
 								"--config.file=/etc/prometheus/prometheus.yml",
 								"--storage.tsdb.path=/prometheus",
@@ -194,6 +344,7 @@ func (r *ObservabilityStackReconciler) reconcilePrometheus(ctx context.Context,
 									Name:          "web",
 								},
 							},
+							Resources: *resources,
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      "config",
@@ -231,7 +382,7 @@ func (r *ObservabilityStackReconciler) reconcilePrometheus(ctx context.Context,
 						},
 						Resources: corev1.VolumeResourceRequirements{
 							Requests: corev1.ResourceList{
-								corev1.ResourceStorage: resource.MustParse(stack.Spec.Prometheus.Storage),
+								corev1.ResourceStorage: storage,
 							},
 						},
 					},
@@ -240,13 +391,46 @@ func (r *ObservabilityStackReconciler) reconcilePrometheus(ctx context.Context,
 		},
 	}
 
+	applyStorageSettings(sts, stack.Spec.Prometheus.StorageSettings)
+
+	if tlsEnabled(stack) {
+		container := &sts.Spec.Template.Spec.Containers[0]
+		container.Args = append(container.Args, "--web.config.file=/etc/prometheus/web.yml")
+		mountServingCertificate(&sts.Spec.Template, stack, componentPrometheus)
+	}
+
+	if prometheusRulesConfigured(stack) {
+		mountPrometheusRules(&sts.Spec.Template, prometheusRulesName(stack), checksum)
+	}
+
+	if thanosEnabled(stack) {
+		image, err := thanosImage(stack)
+		if err != nil {
+			return err
+		}
+		sidecarResources, err := componentResources(stack, componentThanosSidecar, stack.Spec.Prometheus.Thanos.Resources)
+		if err != nil {
+			return fmt.Errorf("failed to resolve Thanos sidecar resources: %w", err)
+		}
+		addThanosSidecar(sts, stack, image, sidecarResources)
+	}
+
+	// Loki's ruler writes the results of recording rules
+	if lokiRulerEnabled(stack) {
+		container := &sts.Spec.Template.Spec.Containers[0]
+		container.Args = append(container.Args, "--web.enable-remote-write-receiver")
+	}
+
+	applyPodPlacement(&sts.Spec.Template, stack.Spec.Prometheus.PodPlacement)
+	applyPodSecurity(&sts.Spec.Template, componentPrometheus, stack.Spec.Prometheus.PodSecurity)
+
 	// Set controller reference for garbage collection
 	if err := ctrl.SetControllerReference(stack, sts, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference: %w", err)
 	}
 
 	// Create or update the StatefulSet using our helper
-	if err := r.createOrUpdate(ctx, sts); err != nil {
+	if err := r.createOrUpdate(ctx, stack, sts); err != nil {
 		return fmt.Errorf("failed to reconcile Prometheus StatefulSet: %w", err)
 	}
 
@@ -272,13 +456,23 @@ func (r *ObservabilityStackReconciler) reconcilePrometheus(ctx context.Context,
 		},
 	}
 
+	// Thanos Query reaches the sidecar's Store API through the Service
+	if thanosEnabled(stack) {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+			Name:       "grpc",
+			Port:       portThanosGRPC,
+			Protocol:   corev1.ProtocolTCP,
+			TargetPort: intstr.FromString("grpc"),
+		})
+	}
+
 	// Set controller reference for the service
 	if err := ctrl.SetControllerReference(stack, svc, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference for service: %w", err)
 	}
 
 	// Create or update the Service using our helper
-	if err := r.createOrUpdate(ctx, svc); err != nil {
+	if err := r.createOrUpdate(ctx, stack, svc); err != nil {
 		return fmt.Errorf("failed to reconcile Prometheus Service: %w", err)
 	}
 
@@ -287,17 +481,43 @@ func (r *ObservabilityStackReconciler) reconcilePrometheus(ctx context.Context,
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ObservabilityStackReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&monitoringv1alpha1.ObservabilityStack{}).
 		Owns(&appsv1.StatefulSet{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
 		Owns(&appsv1.DaemonSet{}).
-		Complete(r)
+		Owns(&networkingv1.NetworkPolicy{}).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.stacksSelectingRules))
+
+	// Cluster-scoped resources cannot be watched in namespaced mode
+	if !r.namespaced() {
+		builder = builder.Watches(&monitoringv1alpha1.ClusterObservabilityStack{}, handler.EnqueueRequestsFromMapFunc(r.stacksReferencing))
+	}
+
+	return builder.Complete(r)
+}
+
+// stacksReferencing maps a ClusterObservabilityStack to the stacks that
+// scrape its collectors
+func (r *ObservabilityStackReconciler) stacksReferencing(ctx context.Context, obj client.Object) []reconcile.Request {
+	list := &monitoringv1alpha1.ObservabilityStackList{}
+	if err := r.List(ctx, list); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list ObservabilityStacks")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, stack := range list.Items {
+		if stack.Spec.ClusterStackRef == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&stack)})
+		}
+	}
+	return requests
 }
 
 func (r *ObservabilityStackReconciler) reconcilePromtail(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
-	if !stack.Spec.Promtail.Enabled {
+	if !stack.Spec.Promtail.Enabled || sharesCollectors(stack) {
 		return nil
 	}
 
@@ -311,32 +531,31 @@ func (r *ObservabilityStackReconciler) reconcilePromtail(ctx context.Context, st
 		"app.kubernetes.io/managed-by": "kube-insight-operator",
 	}
 
+	// Default toleration for node-critical pods unless the CRD overrides it
+	tolerations := stack.Spec.Promtail.Tolerations
+	if len(tolerations) == 0 {
+		tolerations = []corev1.Toleration{
+			{
+				Effect:   corev1.TaintEffectNoSchedule,
+				Operator: corev1.TolerationOperator("Exists"),
+			},
+		}
+	}
+
 	// Convert resource requirements from CRD format to k8s format
-	resources := &corev1.ResourceRequirements{
-		Limits: corev1.ResourceList{
-			corev1.ResourceCPU:    resource.MustParse(stack.Spec.Promtail.Resources.CPULimit),
-			corev1.ResourceMemory: resource.MustParse(stack.Spec.Promtail.Resources.MemoryLimit),
-		},
-		Requests: corev1.ResourceList{
-			corev1.ResourceCPU:    resource.MustParse(stack.Spec.Promtail.Resources.CPURequest),
-			corev1.ResourceMemory: resource.MustParse(stack.Spec.Promtail.Resources.MemoryRequest),
-		},
+	resources, err := componentResources(stack, componentPromtail, stack.Spec.Promtail.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Promtail resources: %w", err)
 	}
 
 	// Create Promtail instance with values from CRD
 	promtailOpts := promtail.Options{
-		Name:      fmt.Sprintf("%s-promtail", stack.Name),
-		Namespace: stack.Namespace,
-		Labels:    labels,
-		LokiURL:   fmt.Sprintf("http://%s-loki:3100", stack.Name),
-		Resources: resources,
-		// Add default toleration for node-critical pods
-		Tolerations: []corev1.Toleration{
-			{
-				Effect:   corev1.TaintEffectNoSchedule,
-				Operator: corev1.TolerationOperator("Exists"),
-			},
-		},
+		Name:                 fmt.Sprintf("%s-promtail", stack.Name),
+		Namespace:            stack.Namespace,
+		Labels:               labels,
+		LokiURL:              serviceURL(stack, componentLoki, portLokiHTTP),
+		Resources:            resources,
+		Tolerations:          tolerations,
 		ExtraArgs:            stack.Spec.Promtail.ExtraArgs,
 		ScrapeKubernetesLogs: stack.Spec.Promtail.ScrapeKubernetesLogs,
 	}
@@ -345,17 +564,40 @@ func (r *ObservabilityStackReconciler) reconcilePromtail(ctx context.Context, st
 
 	// Generate and create ConfigMap
 	configMap := generator.GenerateConfigMap()
+
+	if r.namespaced() {
+		for _, key := range yamlConfigKeys(configMap) {
+			if err := restrictDiscovery(configMap, key, r.WatchNamespaces); err != nil {
+				return fmt.Errorf("failed to restrict Promtail discovery: %w", err)
+			}
+		}
+	}
+
+	if multiTenant(stack) {
+		for _, key := range yamlConfigKeys(configMap) {
+			if err := setPromtailTenancy(configMap, key, stack); err != nil {
+				return fmt.Errorf("failed to configure Promtail tenants: %w", err)
+			}
+		}
+	}
+
 	if err := ctrl.SetControllerReference(stack, configMap, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference on configmap: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, configMap); err != nil {
+	if err := r.createOrUpdate(ctx, stack, configMap); err != nil {
 		return fmt.Errorf("failed to reconcile Promtail ConfigMap: %w", err)
 	}
 
+	version, err := r.componentVersion(ctx, stack, componentPromtail, configMap)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Promtail version: %w", err)
+	}
+
 	// Generate and create DaemonSet
 	ds := generator.GenerateDaemonSet()
 	ds.Spec.Template.Spec.ServiceAccountName = fmt.Sprintf("%s-promtail", stack.Name)
+	setContainerImage(&ds.Spec.Template, componentImage(componentPromtail, version))
 
 	// Add extra args from CRD to container args
 	if len(stack.Spec.Promtail.ExtraArgs) > 0 {
@@ -365,49 +607,52 @@ func (r *ObservabilityStackReconciler) reconcilePromtail(ctx context.Context, st
 		)
 	}
 
+	if tlsEnabled(stack) {
+		trustServerCAs(&ds.Spec.Template, stack, componentLoki)
+	}
+
+	applyPodPlacement(&ds.Spec.Template, stack.Spec.Promtail.PodPlacement)
+	applyPodSecurity(&ds.Spec.Template, componentPromtail, stack.Spec.Promtail.PodSecurity)
+
 	if err := ctrl.SetControllerReference(stack, ds, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference on daemonset: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, ds); err != nil {
+	if err := r.createOrUpdate(ctx, stack, ds); err != nil {
 		return fmt.Errorf("failed to reconcile Promtail DaemonSet: %w", err)
 	}
 
 	return nil
 }
 
-func (r *ObservabilityStackReconciler) createOrUpdate(ctx context.Context, obj client.Object) error {
-	if _, isPVC := obj.(*corev1.PersistentVolumeClaim); isPVC {
-		err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj.DeepCopyObject().(client.Object))
-		if err != nil {
-			if errors.IsNotFound(err) {
-				if err = r.Create(ctx, obj); err != nil {
-					return fmt.Errorf("failed to create resource: %w", err)
-				}
-				return nil
-			}
-			return fmt.Errorf("failed to get resource: %w", err)
+func (r *ObservabilityStackReconciler) createOrUpdate(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, obj client.Object) error {
+	reason, err := createOrUpdate(ctx, r.Client, r.Recorder, stack, obj, func(existing client.Object) (bool, error) {
+		switch desired := obj.(type) {
+		case *corev1.PersistentVolumeClaim:
+			// Claims are only created, since most of their spec is immutable
+			return true, nil
+		case *appsv1.StatefulSet:
+			// Volume claim templates cannot be updated, so a StatefulSet whose
+			// templates change is recreated instead
+			return r.reconcileClaimTemplates(ctx, stack, existing.(*appsv1.StatefulSet), desired)
 		}
-		return nil
-	}
-
-	err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj.DeepCopyObject().(client.Object))
+		return false, nil
+	})
 	if err != nil {
-		if errors.IsNotFound(err) {
-			if err = r.Create(ctx, obj); err != nil {
-				return fmt.Errorf("failed to create resource: %w", err)
-			}
-			return nil
-		}
-		return fmt.Errorf("failed to get resource: %w", err)
+		return err
 	}
 
-	if err = r.Update(ctx, obj); err != nil {
-		return fmt.Errorf("failed to update resource: %w", err)
+	if _, isConfigMap := obj.(*corev1.ConfigMap); isConfigMap && reason != "" {
+		recordConfigGeneration(stack, obj)
 	}
 	return nil
 }
 
+// delete removes an object of the stack, if it exists
+func (r *ObservabilityStackReconciler) delete(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, obj client.Object) error {
+	return deleteObject(ctx, r.Client, r.Recorder, stack, obj)
+}
+
 func (r *ObservabilityStackReconciler) reconcilePrometheusRBAC(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
 	// Create ServiceAccount (your existing code)
 	sa := &corev1.ServiceAccount{
@@ -425,7 +670,7 @@ func (r *ObservabilityStackReconciler) reconcilePrometheusRBAC(ctx context.Conte
 		return fmt.Errorf("failed to set controller reference on serviceaccount: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, sa); err != nil {
+	if err := r.createOrUpdate(ctx, stack, sa); err != nil {
 		return fmt.Errorf("failed to reconcile ServiceAccount: %w", err)
 	}
 
@@ -468,7 +713,11 @@ func (r *ObservabilityStackReconciler) reconcilePrometheusRBAC(ctx context.Conte
 		},
 	}
 
-	if err := r.createOrUpdate(ctx, cr); err != nil {
+	if r.namespaced() {
+		return r.reconcileNamespacedRBAC(ctx, stack, componentPrometheus, sa.Name, cr.Rules)
+	}
+
+	if err := r.createOrUpdate(ctx, stack, cr); err != nil {
 		return fmt.Errorf("failed to reconcile ClusterRole: %w", err)
 	}
 
@@ -494,14 +743,14 @@ func (r *ObservabilityStackReconciler) reconcilePrometheusRBAC(ctx context.Conte
 		},
 	}
 
-	if err := r.createOrUpdate(ctx, crb); err != nil {
+	if err := r.createOrUpdate(ctx, stack, crb); err != nil {
 		return fmt.Errorf("failed to reconcile ClusterRoleBinding: %w", err)
 	}
 
 	return nil
 }
 
-func (r *ObservabilityStackReconciler) reconcileKubeStateMetricsRBAC(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+func (r *ObservabilityStackReconciler) reconcileKubeStateMetricsRBAC(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, rules []rbacv1.PolicyRule) error {
 	// Skip if kube-state-metrics is not enabled
 	if !stack.Spec.Prometheus.KubeStateMetrics.Enabled {
 		return nil
@@ -526,7 +775,7 @@ func (r *ObservabilityStackReconciler) reconcileKubeStateMetricsRBAC(ctx context
 		return fmt.Errorf("failed to set controller reference on serviceaccount: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, sa); err != nil {
+	if err := r.createOrUpdate(ctx, stack, sa); err != nil {
 		return fmt.Errorf("failed to reconcile ServiceAccount: %w", err)
 	}
 
@@ -536,61 +785,14 @@ func (r *ObservabilityStackReconciler) reconcileKubeStateMetricsRBAC(ctx context
 			Name:   fmt.Sprintf("%s-kube-state-metrics", stack.Name),
 			Labels: labels,
 		},
-		Rules: []rbacv1.PolicyRule{
-			{
-				APIGroups: []string{""},
-				Resources: []string{
-					"configmaps",
-					"secrets",
-					"nodes",
-					"pods",
-					"services",
-					"resourcequotas",
-					"replicationcontrollers",
-					"limitranges",
-					"persistentvolumeclaims",
-					"persistentvolumes",
-					"namespaces",
-					"endpoints",
-				},
-				Verbs: []string{"list", "watch"},
-			},
-			{
-				APIGroups: []string{"apps"},
-				Resources: []string{
-					"statefulsets",
-					"daemonsets",
-					"deployments",
-					"replicasets",
-				},
-				Verbs: []string{"list", "watch"},
-			},
-			{
-				APIGroups: []string{"batch"},
-				Resources: []string{
-					"cronjobs",
-					"jobs",
-				},
-				Verbs: []string{"list", "watch"},
-			},
-			{
-				APIGroups: []string{"networking.k8s.io"},
-				Resources: []string{
-					"ingresses",
-				},
-				Verbs: []string{"list", "watch"},
-			},
-			{
-				APIGroups: []string{"storage.k8s.io"},
-				Resources: []string{
-					"storageclasses",
-				},
-				Verbs: []string{"list", "watch"},
-			},
-		},
+		Rules: rules,
+	}
+
+	if r.namespaced() {
+		return r.reconcileNamespacedRBAC(ctx, stack, componentKubeStateMetrics, sa.Name, cr.Rules)
 	}
 
-	if err := r.createOrUpdate(ctx, cr); err != nil {
+	if err := r.createOrUpdate(ctx, stack, cr); err != nil {
 		return fmt.Errorf("failed to reconcile ClusterRole: %w", err)
 	}
 
@@ -614,7 +816,7 @@ func (r *ObservabilityStackReconciler) reconcileKubeStateMetricsRBAC(ctx context
 		},
 	}
 
-	if err := r.createOrUpdate(ctx, crb); err != nil {
+	if err := r.createOrUpdate(ctx, stack, crb); err != nil {
 		return fmt.Errorf("failed to reconcile ClusterRoleBinding: %w", err)
 	}
 
@@ -638,7 +840,7 @@ func (r *ObservabilityStackReconciler) reconcilePromtailRBAC(ctx context.Context
 		return fmt.Errorf("failed to set controller reference on serviceaccount: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, sa); err != nil {
+	if err := r.createOrUpdate(ctx, stack, sa); err != nil {
 		return fmt.Errorf("failed to reconcile ServiceAccount: %w", err)
 	}
 
@@ -651,22 +853,14 @@ func (r *ObservabilityStackReconciler) reconcilePromtailRBAC(ctx context.Context
 				"app.kubernetes.io/instance": stack.Name,
 			},
 		},
-		Rules: []rbacv1.PolicyRule{
-			{
-				APIGroups: []string{""},
-				Resources: []string{
-					"nodes",
-					"nodes/proxy",
-					"services",
-					"endpoints",
-					"pods",
-				},
-				Verbs: []string{"get", "list", "watch"},
-			},
-		},
+		Rules: promtailRules,
+	}
+
+	if r.namespaced() {
+		return r.reconcileNamespacedRBAC(ctx, stack, componentPromtail, sa.Name, cr.Rules)
 	}
 
-	if err := r.createOrUpdate(ctx, cr); err != nil {
+	if err := r.createOrUpdate(ctx, stack, cr); err != nil {
 		return fmt.Errorf("failed to reconcile ClusterRole: %w", err)
 	}
 
@@ -693,7 +887,7 @@ func (r *ObservabilityStackReconciler) reconcilePromtailRBAC(ctx context.Context
 		},
 	}
 
-	if err := r.createOrUpdate(ctx, crb); err != nil {
+	if err := r.createOrUpdate(ctx, stack, crb); err != nil {
 		return fmt.Errorf("failed to reconcile ClusterRoleBinding: %w", err)
 	}
 
@@ -701,11 +895,18 @@ func (r *ObservabilityStackReconciler) reconcilePromtailRBAC(ctx context.Context
 }
 
 func (r *ObservabilityStackReconciler) reconcileKubeStateMetrics(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
-	if !stack.Spec.Prometheus.KubeStateMetrics.Enabled {
+	if !stack.Spec.Prometheus.KubeStateMetrics.Enabled || sharesCollectors(stack) {
 		return nil
 	}
 
-	if err := r.reconcileKubeStateMetricsRBAC(ctx, stack); err != nil {
+	spec := stack.Spec.Prometheus.KubeStateMetrics
+	name := fmt.Sprintf("%s-kube-state-metrics", stack.Name)
+	customResources, err := resolveKubeStateMetrics(ctx, r.Client, spec, specPaths[componentKubeStateMetrics], r.namespaced())
+	if err != nil {
+		return err
+	}
+
+	if err := r.reconcileKubeStateMetricsRBAC(ctx, stack, kubeStateMetricsRulesFor(spec, r.namespaced(), customResources)); err != nil {
 		return fmt.Errorf("failed to reconcile kube-state-metrics RBAC: %w", err)
 	}
 
@@ -714,86 +915,67 @@ func (r *ObservabilityStackReconciler) reconcileKubeStateMetrics(ctx context.Con
 		"app.kubernetes.io/instance": stack.Name,
 	}
 
-	// Create deployment
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-kube-state-metrics", stack.Name),
-			Namespace: stack.Namespace,
-			Labels:    labels,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
-				},
-				Spec: corev1.PodSpec{
-					ServiceAccountName: fmt.Sprintf("%s-kube-state-metrics", stack.Name),
-					Containers: []corev1.Container{
-						{
-							Name:  "kube-state-metrics",
-							Image: "registry.k8s.io/kube-state-metrics/kube-state-metrics:v2.10.0",
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "http-metrics",
-									ContainerPort: 8080,
-								},
-								{
-									Name:          "telemetry",
-									ContainerPort: 8081,
-								},
-							},
-						},
-					},
-				},
-			},
-		},
+	resources, err := componentResources(stack, componentKubeStateMetrics, spec.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve kube-state-metrics resources: %w", err)
 	}
 
-	// Set controller reference
-	if err := ctrl.SetControllerReference(stack, deployment, r.Scheme); err != nil {
+	args := kubeStateMetricsArgs(spec, r.WatchNamespaces, customResources)
+
+	version, err := r.componentVersion(ctx, stack, componentKubeStateMetrics, nil)
+	if err != nil {
+		return fmt.Errorf("failed to resolve kube-state-metrics version: %w", err)
+	}
+
+	deployment, service := kubeStateMetricsWorkload(name, stack.Namespace, labels,
+		componentImage(componentKubeStateMetrics, version), resources, args)
+
+	applyPodPlacement(&deployment.Spec.Template, spec.PodPlacement)
+	applyPodSecurity(&deployment.Spec.Template, componentKubeStateMetrics, spec.PodSecurity)
+
+	configMap := kubeStateMetricsCustomResourceConfigMap(name, stack.Namespace, labels, spec.CustomResourceStateConfig)
+	if len(customResources) > 0 {
+		if err := ctrl.SetControllerReference(stack, configMap, r.Scheme); err != nil {
+			return fmt.Errorf("failed to set controller reference on configmap: %w", err)
+		}
+		if err := r.createOrUpdate(ctx, stack, configMap); err != nil {
+			return fmt.Errorf("failed to reconcile kube-state-metrics custom resource state ConfigMap: %w", err)
+		}
+		mountCustomResourceState(&deployment.Spec.Template, configMap)
+	} else if err := r.delete(ctx, stack, configMap); err != nil {
 		return err
 	}
 
-	// Create or update deployment
-	if err := r.createOrUpdate(ctx, deployment); err != nil {
+	// Shards run as a StatefulSet, and a single instance as a Deployment;
+	// the workload of the other kind is removed when the shards change
+	var workload, stale client.Object = deployment, &appsv1.StatefulSet{}
+	if shards := kubeStateMetricsShards(spec); shards > 1 {
+		workload, stale = shardKubeStateMetrics(deployment, shards), &appsv1.Deployment{}
+	}
+	stale.SetName(name)
+	stale.SetNamespace(stack.Namespace)
+	if err := r.delete(ctx, stack, stale); err != nil {
 		return err
 	}
 
-	// Create service
-	service := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-kube-state-metrics", stack.Name),
-			Namespace: stack.Namespace,
-			Labels:    labels,
-		},
-		Spec: corev1.ServiceSpec{
-			Ports: []corev1.ServicePort{
-				{
-					Name:       "http-metrics",
-					Port:       8080,
-					TargetPort: intstr.FromString("http-metrics"),
-				},
-				{
-					Name:       "telemetry",
-					Port:       8081,
-					TargetPort: intstr.FromString("telemetry"),
-				},
-			},
-			Selector: labels,
-		},
+	// Set controller reference
+	if err := ctrl.SetControllerReference(stack, workload, r.Scheme); err != nil {
+		return fmt.Errorf("failed to set controller reference on workload: %w", err)
+	}
+
+	// Create or update the workload
+	if err := r.createOrUpdate(ctx, stack, workload); err != nil {
+		return fmt.Errorf("failed to reconcile kube-state-metrics workload: %w", err)
 	}
 
 	// Set controller reference
 	if err := ctrl.SetControllerReference(stack, service, r.Scheme); err != nil {
-		return err
+		return fmt.Errorf("failed to set controller reference on service: %w", err)
 	}
 
 	// Create or update service
-	if err := r.createOrUpdate(ctx, service); err != nil {
-		return err
+	if err := r.createOrUpdate(ctx, stack, service); err != nil {
+		return fmt.Errorf("failed to reconcile kube-state-metrics Service: %w", err)
 	}
 
 	return nil
@@ -836,14 +1018,78 @@ func (r *ObservabilityStackReconciler) reconcileGrafana(ctx context.Context, sta
 
 	// Create ConfigMap
 	configMap := g.GenerateConfigMap()
+
+	if multiTenant(stack) {
+		if err := setGrafanaTenancy(configMap, stack); err != nil {
+			return fmt.Errorf("failed to configure Grafana tenant data sources: %w", err)
+		}
+	}
+
+	if tlsEnabled(stack) {
+		if err := r.reconcileServingCertificate(ctx, stack, componentGrafana); err != nil {
+			return err
+		}
+		useHTTPSDataSources(configMap, stack)
+	}
+
+	// Thanos Query serves plain HTTP, so its data source is added after the
+	// stack's data sources switch to HTTPS
+	if thanosQueryEnabled(stack) {
+		if err := addThanosDataSource(configMap, stack); err != nil {
+			return fmt.Errorf("failed to add the Thanos data source: %w", err)
+		}
+	}
+
+	dataSourceUIDs, err := setAlertingDataSourceUIDs(configMap, stack)
+	if err != nil {
+		return fmt.Errorf("failed to configure Grafana alerting data sources: %w", err)
+	}
+
 	if err := ctrl.SetControllerReference(stack, configMap, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference on configmap: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, configMap); err != nil {
+	if err := r.createOrUpdate(ctx, stack, configMap); err != nil {
 		return fmt.Errorf("failed to reconcile Grafana ConfigMap: %w", err)
 	}
 
+	resources, err := componentResources(stack, componentGrafana, stack.Spec.Grafana.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Grafana resources: %w", err)
+	}
+
+	version, err := r.componentVersion(ctx, stack, componentGrafana, configMap)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Grafana version: %w", err)
+	}
+
+	if stack.Spec.Grafana.Database != nil {
+		if err := r.checkGrafanaDatabase(ctx, stack); err != nil {
+			return err
+		}
+	}
+
+	if err := r.checkGrafanaAuth(ctx, stack); err != nil {
+		return err
+	}
+
+	if len(stack.Spec.Grafana.Plugins) > 0 && stack.Spec.Grafana.PluginBundle != nil {
+		if err := r.checkGrafanaPluginBundle(ctx, stack); err != nil {
+			return err
+		}
+	}
+
+	alertingChecksum, alertingEnv, err := r.reconcileGrafanaAlerting(ctx, stack, labels, dataSourceUIDs)
+	if err != nil {
+		return err
+	}
+
+	// The claim exists before the Deployment refers to it
+	storageVolume, err := r.reconcileGrafanaStorage(ctx, stack, labels)
+	if err != nil {
+		return err
+	}
+
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf("%s-grafana", stack.Name),
@@ -851,6 +1097,7 @@ func (r *ObservabilityStackReconciler) reconcileGrafana(ctx context.Context, sta
 			Labels:    labels,
 		},
 		Spec: appsv1.DeploymentSpec{
+			Replicas: stack.Spec.Grafana.Replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
@@ -860,44 +1107,10 @@ func (r *ObservabilityStackReconciler) reconcileGrafana(ctx context.Context, sta
 					Labels: labels,
 				},
 				Spec: corev1.PodSpec{
-					SecurityContext: &corev1.PodSecurityContext{
-						FSGroup: pointer.Int64(472),
-					},
-					InitContainers: []corev1.Container{
-						{
-							Name:  "init-chown-data",
-							Image: "busybox:1.35",
-							Command: []string{
-								"sh",
-								"-c",
-								"mkdir -p /etc/grafana/provisioning/datasources /etc/grafana/provisioning/dashboards && chown -R 472:472 /etc/grafana /var/lib/grafana || true",
-							},
-							SecurityContext: &corev1.SecurityContext{
-								RunAsUser:  pointer.Int64(0),
-								RunAsGroup: pointer.Int64(0),
-							},
-							Resources: corev1.ResourceRequirements{
-								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("10m"),
-									corev1.ResourceMemory: resource.MustParse("50Mi"),
-								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "config",
-									MountPath: "/etc/grafana",
-								},
-								{
-									Name:      "storage",
-									MountPath: "/var/lib/grafana",
-								},
-							},
-						},
-					},
 					Containers: []corev1.Container{
 						{
 							Name:  "grafana",
-							Image: "grafana/grafana:9.5.3",
+							Image: componentImage(componentGrafana, version),
 							Ports: []corev1.ContainerPort{
 								{
 									Name:          "http",
@@ -905,16 +1118,7 @@ func (r *ObservabilityStackReconciler) reconcileGrafana(ctx context.Context, sta
 									Protocol:      corev1.ProtocolTCP,
 								},
 							},
-							Resources: corev1.ResourceRequirements{
-								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("100m"),
-									corev1.ResourceMemory: resource.MustParse("256Mi"),
-								},
-								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("500m"),
-									corev1.ResourceMemory: resource.MustParse("512Mi"),
-								},
-							},
+							Resources: *resources,
 							LivenessProbe: &corev1.Probe{
 								ProbeHandler: corev1.ProbeHandler{
 									HTTPGet: &corev1.HTTPGetAction{
@@ -954,6 +1158,10 @@ func (r *ObservabilityStackReconciler) reconcileGrafana(ctx context.Context, sta
 									Name:      "storage",
 									MountPath: "/var/lib/grafana",
 								},
+								{
+									Name:      "logs",
+									MountPath: "/var/log/grafana",
+								},
 							},
 						},
 					},
@@ -968,12 +1176,11 @@ func (r *ObservabilityStackReconciler) reconcileGrafana(ctx context.Context, sta
 								},
 							},
 						},
+						storageVolume,
 						{
-							Name: "storage",
+							Name: "logs",
 							VolumeSource: corev1.VolumeSource{
-								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
-									ClaimName: fmt.Sprintf("%s-grafana", stack.Name),
-								},
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
 							},
 						},
 					},
@@ -982,11 +1189,32 @@ func (r *ObservabilityStackReconciler) reconcileGrafana(ctx context.Context, sta
 		},
 	}
 
+	if database := stack.Spec.Grafana.Database; database != nil {
+		setGrafanaDatabase(&deployment.Spec.Template.Spec.Containers[0], database)
+	}
+	if grafanaHA(stack) {
+		setGrafanaAlertingHA(&deployment.Spec.Template.Spec.Containers[0], stack)
+	}
+	setGrafanaAuth(&deployment.Spec.Template, stack.Spec.Grafana.Auth)
+	setGrafanaPlugins(&deployment.Spec.Template, stack.Spec.Grafana, componentImage(componentGrafana, version))
+	if alertingChecksum != "" {
+		mountGrafanaAlerting(&deployment.Spec.Template, grafanaAlertingProvisioningName(stack), alertingChecksum, alertingEnv)
+	}
+
+	if tlsEnabled(stack) {
+		setGrafanaTLS(&deployment.Spec.Template.Spec.Containers[0])
+		mountServingCertificate(&deployment.Spec.Template, stack, componentGrafana)
+		trustServerCAs(&deployment.Spec.Template, stack, componentPrometheus, componentLoki, componentTempo)
+	}
+
+	applyPodPlacement(&deployment.Spec.Template, stack.Spec.Grafana.PodPlacement)
+	applyPodSecurity(&deployment.Spec.Template, componentGrafana, stack.Spec.Grafana.PodSecurity)
+
 	if err := ctrl.SetControllerReference(stack, deployment, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference on deployment: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, deployment); err != nil {
+	if err := r.createOrUpdate(ctx, stack, deployment); err != nil {
 		return fmt.Errorf("failed to reconcile Grafana Deployment: %w", err)
 	}
 
@@ -1013,41 +1241,15 @@ func (r *ObservabilityStackReconciler) reconcileGrafana(ctx context.Context, sta
 		return fmt.Errorf("failed to set controller reference on service: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, svc); err != nil {
+	if err := r.createOrUpdate(ctx, stack, svc); err != nil {
 		return fmt.Errorf("failed to reconcile Grafana Service: %w", err)
 	}
 
-	// Create PVC for Grafana storage
-	if stack.Spec.Grafana.Storage != "" {
-		pvc := &corev1.PersistentVolumeClaim{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      fmt.Sprintf("%s-grafana", stack.Name),
-				Namespace: stack.Namespace,
-				Labels:    labels,
-			},
-			Spec: corev1.PersistentVolumeClaimSpec{
-				AccessModes: []corev1.PersistentVolumeAccessMode{
-					corev1.ReadWriteOnce,
-				},
-				StorageClassName: pointer.String("standard"),
-				Resources: corev1.VolumeResourceRequirements{
-					Requests: corev1.ResourceList{
-						corev1.ResourceStorage: resource.MustParse(stack.Spec.Grafana.Storage),
-					},
-				},
-			},
-		}
-
-		if err := ctrl.SetControllerReference(stack, pvc, r.Scheme); err != nil {
-			return fmt.Errorf("failed to set controller reference on pvc: %w", err)
-		}
-
-		if err := r.createOrUpdate(ctx, pvc); err != nil {
-			return fmt.Errorf("failed to reconcile Grafana PVC: %w", err)
-		}
+	if err := r.reconcileGrafanaAlertingService(ctx, stack, labels); err != nil {
+		return err
 	}
 
-	return nil
+	return r.reconcileGrafanaOrganizations(ctx, stack)
 }
 
 func (r *ObservabilityStackReconciler) reconcileLoki(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
@@ -1062,6 +1264,11 @@ func (r *ObservabilityStackReconciler) reconcileLoki(ctx context.Context, stack
 		"app.kubernetes.io/managed-by": "kube-insight-operator",
 	}
 
+	storage, err := parseStorage(componentLoki, stack.Spec.Loki.Storage, stack.Spec.Loki.StorageSettings)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Loki storage: %w", err)
+	}
+
 	// Create ConfigMap
 	configGen := loki.NewConfigGenerator(loki.Options{
 		Name:          fmt.Sprintf("%s-loki", stack.Name),
@@ -1072,14 +1279,67 @@ func (r *ObservabilityStackReconciler) reconcileLoki(ctx context.Context, stack
 	})
 
 	configMap := configGen.GenerateConfigMap()
+
+	deployed, err := r.deployedConfigMap(ctx, stack, configMap.Name)
+	if err != nil {
+		return err
+	}
+	if err := setLokiSchema(configMap, deployed, stack.Spec.Loki.Schemas, time.Now()); err != nil {
+		return fmt.Errorf("failed to configure Loki schema: %w", err)
+	}
+	if err := setLokiCompactor(configMap, stack.Spec.Loki.Compactor); err != nil {
+		return fmt.Errorf("failed to configure Loki compactor: %w", err)
+	}
+	if err := setLokiLimits(configMap, stack.Spec.Loki.Limits); err != nil {
+		return fmt.Errorf("failed to configure Loki limits: %w", err)
+	}
+
+	if tlsEnabled(stack) {
+		if err := r.reconcileServingCertificate(ctx, stack, componentLoki); err != nil {
+			return err
+		}
+		if err := setServerTLS(configMap, "loki.yaml"); err != nil {
+			return fmt.Errorf("failed to configure Loki TLS: %w", err)
+		}
+	}
+
+	if multiTenant(stack) {
+		if err := setLokiTenancy(configMap, stack); err != nil {
+			return fmt.Errorf("failed to configure Loki tenants: %w", err)
+		}
+	}
+
+	version, err := r.componentVersion(ctx, stack, componentLoki, configMap)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Loki version: %w", err)
+	}
+
+	if err := setLokiDeleteRequestStore(configMap, version); err != nil {
+		return fmt.Errorf("failed to configure Loki compactor: %w", err)
+	}
+
+	var ruleItems []corev1.KeyToPath
+	if lokiRulerEnabled(stack) {
+		if ruleItems, err = r.reconcileLokiRules(ctx, stack, configMap, labels, version); err != nil {
+			return err
+		}
+	} else if err := r.deleteLokiRules(ctx, stack); err != nil {
+		return err
+	}
+
 	if err := ctrl.SetControllerReference(stack, configMap, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference on configmap: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, configMap); err != nil {
+	if err := r.createOrUpdate(ctx, stack, configMap); err != nil {
 		return fmt.Errorf("failed to reconcile Loki ConfigMap: %w", err)
 	}
 
+	resources, err := componentResources(stack, componentLoki, stack.Spec.Loki.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Loki resources: %w", err)
+	}
+
 	// Create StatefulSet
 	sts := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -1098,13 +1358,10 @@ func (r *ObservabilityStackReconciler) reconcileLoki(ctx context.Context, stack
 					Labels: labels,
 				},
 				Spec: corev1.PodSpec{
-					SecurityContext: &corev1.PodSecurityContext{
-						FSGroup: pointer.Int64(10001),
-					},
 					Containers: []corev1.Container{
 						{
 							Name:  "loki",
-							Image: "grafana/loki:2.8.4",
+							Image: componentImage(componentLoki, version),
 							Args: []string{
 								"-config.file=/etc/loki/loki.yaml",
 								"-config.expand-env=true",
@@ -1137,16 +1394,7 @@ func (r *ObservabilityStackReconciler) reconcileLoki(ctx context.Context, stack
 									Value: fmt.Sprintf("%d", stack.Spec.Loki.RetentionDays),
 								},
 							},
-							Resources: corev1.ResourceRequirements{
-								Requests: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("100m"),
-									corev1.ResourceMemory: resource.MustParse("128Mi"),
-								},
-								Limits: corev1.ResourceList{
-									corev1.ResourceCPU:    resource.MustParse("1"),
-									corev1.ResourceMemory: resource.MustParse("1Gi"),
-								},
-							},
+							Resources: *resources,
 							LivenessProbe: &corev1.Probe{
 								ProbeHandler: corev1.ProbeHandler{
 									HTTPGet: &corev1.HTTPGetAction{
@@ -1192,7 +1440,7 @@ func (r *ObservabilityStackReconciler) reconcileLoki(ctx context.Context, stack
 						},
 						Resources: corev1.VolumeResourceRequirements{
 							Requests: corev1.ResourceList{
-								corev1.ResourceStorage: resource.MustParse(stack.Spec.Loki.Storage),
+								corev1.ResourceStorage: storage,
 							},
 						},
 					},
@@ -1201,11 +1449,27 @@ func (r *ObservabilityStackReconciler) reconcileLoki(ctx context.Context, stack
 		},
 	}
 
+	applyStorageSettings(sts, stack.Spec.Loki.StorageSettings)
+
+	if tlsEnabled(stack) {
+		mountServingCertificate(&sts.Spec.Template, stack, componentLoki)
+		if lokiRulerEnabled(stack) && stack.Spec.Prometheus.Enabled {
+			trustServerCAs(&sts.Spec.Template, stack, componentPrometheus)
+		}
+	}
+
+	if lokiRulerEnabled(stack) {
+		mountLokiRules(&sts.Spec.Template, lokiRulesName(stack), ruleItems)
+	}
+
+	applyPodPlacement(&sts.Spec.Template, stack.Spec.Loki.PodPlacement)
+	applyPodSecurity(&sts.Spec.Template, componentLoki, stack.Spec.Loki.PodSecurity)
+
 	if err := ctrl.SetControllerReference(stack, sts, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference on statefulset: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, sts); err != nil {
+	if err := r.createOrUpdate(ctx, stack, sts); err != nil {
 		return fmt.Errorf("failed to reconcile Loki StatefulSet: %w", err)
 	}
 
@@ -1239,7 +1503,7 @@ func (r *ObservabilityStackReconciler) reconcileLoki(ctx context.Context, stack
 		return fmt.Errorf("failed to set controller reference on service: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, svc); err != nil {
+	if err := r.createOrUpdate(ctx, stack, svc); err != nil {
 		return fmt.Errorf("failed to reconcile Loki Service: %w", err)
 	}
 
@@ -1258,23 +1522,16 @@ func (r *ObservabilityStackReconciler) reconcileTempo(ctx context.Context, stack
 		"app.kubernetes.io/managed-by": "kube-insight-operator",
 	}
 
-	// Convert resource requirements or use defaults
-	resources := &corev1.ResourceRequirements{
-		Limits: corev1.ResourceList{
-			corev1.ResourceCPU:    resource.MustParse("1"),
-			corev1.ResourceMemory: resource.MustParse("2Gi"),
-		},
-		Requests: corev1.ResourceList{
-			corev1.ResourceCPU:    resource.MustParse("200m"),
-			corev1.ResourceMemory: resource.MustParse("512Mi"),
-		},
+	// Validate storage before the generator turns it into a volume claim,
+	// which also sizes Tempo's emptyDir
+	if _, err := parseStorage(componentTempo, stack.Spec.Tempo.Storage, stack.Spec.Tempo.StorageSettings); err != nil {
+		return fmt.Errorf("failed to resolve Tempo storage: %w", err)
 	}
 
-	if stack.Spec.Tempo.Resources.CPULimit != "" {
-		resources.Limits[corev1.ResourceCPU] = resource.MustParse(stack.Spec.Tempo.Resources.CPULimit)
-		resources.Limits[corev1.ResourceMemory] = resource.MustParse(stack.Spec.Tempo.Resources.MemoryLimit)
-		resources.Requests[corev1.ResourceCPU] = resource.MustParse(stack.Spec.Tempo.Resources.CPURequest)
-		resources.Requests[corev1.ResourceMemory] = resource.MustParse(stack.Spec.Tempo.Resources.MemoryRequest)
+	// Convert resource requirements, falling back to the profile defaults
+	resources, err := componentResources(stack, componentTempo, stack.Spec.Tempo.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Tempo resources: %w", err)
 	}
 
 	// Create Tempo instance
@@ -1291,21 +1548,59 @@ func (r *ObservabilityStackReconciler) reconcileTempo(ctx context.Context, stack
 
 	// Generate and create ConfigMap
 	configMap := generator.GenerateConfigMap()
+
+	if tlsEnabled(stack) {
+		if err := r.reconcileServingCertificate(ctx, stack, componentTempo); err != nil {
+			return err
+		}
+		for _, key := range yamlConfigKeys(configMap) {
+			if err := setServerTLS(configMap, key); err != nil {
+				return fmt.Errorf("failed to configure Tempo TLS: %w", err)
+			}
+		}
+	}
+
+	if multiTenant(stack) {
+		mountPath, err := configMountPath(&generator.GenerateStatefulSet().Spec.Template, configMap.Name)
+		if err != nil {
+			return fmt.Errorf("failed to configure Tempo tenants: %w", err)
+		}
+		for _, key := range yamlConfigKeys(configMap) {
+			if err := setTempoTenancy(configMap, key, mountPath, stack); err != nil {
+				return fmt.Errorf("failed to configure Tempo tenants: %w", err)
+			}
+		}
+	}
+
 	if err := ctrl.SetControllerReference(stack, configMap, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference on configmap: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, configMap); err != nil {
+	if err := r.createOrUpdate(ctx, stack, configMap); err != nil {
 		return fmt.Errorf("failed to reconcile Tempo ConfigMap: %w", err)
 	}
 
+	version, err := r.componentVersion(ctx, stack, componentTempo, configMap)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Tempo version: %w", err)
+	}
+
 	// Generate and create StatefulSet
 	sts := generator.GenerateStatefulSet()
+	setContainerImage(&sts.Spec.Template, componentImage(componentTempo, version))
+	applyStorageSettings(sts, stack.Spec.Tempo.StorageSettings)
+	if tlsEnabled(stack) {
+		mountServingCertificate(&sts.Spec.Template, stack, componentTempo)
+	}
+
+	applyPodPlacement(&sts.Spec.Template, stack.Spec.Tempo.PodPlacement)
+	applyPodSecurity(&sts.Spec.Template, componentTempo, stack.Spec.Tempo.PodSecurity)
+
 	if err := ctrl.SetControllerReference(stack, sts, r.Scheme); err != nil {
 		return fmt.Errorf("failed to set controller reference on statefulset: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, sts); err != nil {
+	if err := r.createOrUpdate(ctx, stack, sts); err != nil {
 		return fmt.Errorf("failed to reconcile Tempo StatefulSet: %w", err)
 	}
 
@@ -1315,7 +1610,7 @@ func (r *ObservabilityStackReconciler) reconcileTempo(ctx context.Context, stack
 		return fmt.Errorf("failed to set controller reference on service: %w", err)
 	}
 
-	if err := r.createOrUpdate(ctx, svc); err != nil {
+	if err := r.createOrUpdate(ctx, stack, svc); err != nil {
 		return fmt.Errorf("failed to reconcile Tempo Service: %w", err)
 	}
 
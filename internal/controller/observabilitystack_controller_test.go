@@ -21,8 +21,11 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -69,8 +72,9 @@ var _ = Describe("ObservabilityStack Controller", func() {
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &ObservabilityStackReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(100),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -80,5 +84,108 @@ var _ = Describe("ObservabilityStack Controller", func() {
 			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
 			// Example: If you expect a certain status condition after reconciliation, verify it here.
 		})
+
+		It("should mark the stack Degraded for an invalid quantity", func() {
+			By("Setting a malformed Promtail CPU limit")
+			resource := &monitoringv1alpha1.ObservabilityStack{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Promtail.Enabled = true
+			resource.Spec.Promtail.Resources.CPULimit = "lots"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			recorder := record.NewFakeRecorder(100)
+			controllerReconciler := &ObservabilityStackReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			degraded := meta.FindStatusCondition(resource.Status.Conditions, conditionDegraded)
+			Expect(degraded).NotTo(BeNil())
+			Expect(degraded.Status).To(Equal(metav1.ConditionTrue))
+			Expect(degraded.Reason).To(Equal(reasonInvalidQuantity))
+			Expect(degraded.Message).To(ContainSubstring("spec.promtail.resources.cpuLimit"))
+			Expect(drainEvents(recorder)).To(ContainElement(ContainSubstring(reasonInvalidQuantity)))
+		})
+
+		It("should record events for created resources", func() {
+			resource := &monitoringv1alpha1.ObservabilityStack{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Promtail.Enabled = true
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			recorder := record.NewFakeRecorder(100)
+			controllerReconciler := &ObservabilityStackReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(drainEvents(recorder)).To(ContainElement(
+				"Normal Created Created ServiceAccount default/test-resource-promtail"))
+		})
+
+		It("should leave the resources of a paused stack alone", func() {
+			By("Creating a stack that is paused before its first reconciliation")
+			pausedName := types.NamespacedName{Name: "paused-resource", Namespace: "default"}
+			resource := &monitoringv1alpha1.ObservabilityStack{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        pausedName.Name,
+					Namespace:   pausedName.Namespace,
+					Annotations: map[string]string{pausedAnnotation: "true"},
+				},
+				Spec: monitoringv1alpha1.ObservabilityStackSpec{
+					Promtail: monitoringv1alpha1.PromtailSpec{Enabled: true},
+				},
+			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+			DeferCleanup(func() {
+				Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			})
+
+			recorder := record.NewFakeRecorder(100)
+			controllerReconciler := &ObservabilityStackReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: pausedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, pausedName, resource)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, conditionPaused)).To(BeTrue())
+			Expect(drainEvents(recorder)).NotTo(ContainElement(ContainSubstring("Created")))
+
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: "paused-resource-promtail", Namespace: "default"}, &corev1.ServiceAccount{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
 	})
 })
+
+// drainEvents returns the events buffered in a fake recorder.
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
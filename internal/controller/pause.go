@@ -0,0 +1,102 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// pausedAnnotation set to "true" pauses a stack like spec.paused
+	pausedAnnotation = "monitoring.example.com/paused"
+
+	// conditionPaused is True while the operator leaves the stack's resources alone
+	conditionPaused = "Paused"
+
+	reasonSpecPaused       = "SpecPaused"
+	reasonAnnotationPaused = "AnnotationPaused"
+	reasonResumed          = "Resumed"
+)
+
+// paused reports whether the operator must not change any of the stack's
+// resources
+func paused(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	return stack.Spec.Paused || stack.Annotations[pausedAnnotation] == "true"
+}
+
+// unmanaged reports whether a component's resources are left to manual edits
+func unmanaged(stack *monitoringv1alpha1.ObservabilityStack, component string) bool {
+	switch component {
+	case componentPrometheus:
+		return stack.Spec.Prometheus.Unmanaged
+	case componentKubeStateMetrics:
+		return stack.Spec.Prometheus.KubeStateMetrics.Unmanaged
+	case componentGrafana:
+		return stack.Spec.Grafana.Unmanaged
+	case componentLoki:
+		return stack.Spec.Loki.Unmanaged
+	case componentPromtail:
+		return stack.Spec.Promtail.Unmanaged
+	case componentTempo:
+		return stack.Spec.Tempo.Unmanaged
+	}
+	return false
+}
+
+// setPaused records on the Paused condition whether the stack is paused, and
+// emits an event when that changes.
+func (r *ObservabilityStackReconciler) setPaused(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	condition := metav1.Condition{
+		Type:               conditionPaused,
+		Status:             metav1.ConditionFalse,
+		Reason:             reasonResumed,
+		Message:            "The operator manages the stack",
+		ObservedGeneration: stack.Generation,
+	}
+	switch {
+	case stack.Spec.Paused:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reasonSpecPaused
+		condition.Message = "spec.paused is set; resources are not changed"
+	case paused(stack):
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reasonAnnotationPaused
+		condition.Message = fmt.Sprintf("The %s annotation is set; resources are not changed", pausedAnnotation)
+	}
+
+	wasPaused := meta.IsStatusConditionTrue(stack.Status.Conditions, conditionPaused)
+	if !meta.SetStatusCondition(&stack.Status.Conditions, condition) {
+		return nil
+	}
+
+	if err := r.Status().Update(ctx, stack); err != nil {
+		return fmt.Errorf("failed to update ObservabilityStack status: %w", err)
+	}
+
+	if condition.Status == metav1.ConditionTrue {
+		r.Recorder.Event(stack, corev1.EventTypeNormal, condition.Reason, "Paused: "+condition.Message)
+	} else if wasPaused {
+		r.Recorder.Event(stack, corev1.EventTypeNormal, reasonResumed, "Resumed managing the stack")
+	}
+	return nil
+}
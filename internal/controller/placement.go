@@ -0,0 +1,71 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// applyPodPlacement copies the scheduling constraints of a component spec onto
+// the pod template of its workload. Unset fields leave the template untouched
+// so defaults chosen by the generators are kept.
+func applyPodPlacement(template *corev1.PodTemplateSpec, placement monitoringv1alpha1.PodPlacement) {
+	placement = *placement.DeepCopy()
+	podSpec := &template.Spec
+
+	if len(placement.NodeSelector) > 0 {
+		podSpec.NodeSelector = placement.NodeSelector
+	}
+
+	if placement.Affinity != nil {
+		podSpec.Affinity = placement.Affinity
+	}
+
+	if len(placement.Tolerations) > 0 {
+		podSpec.Tolerations = placement.Tolerations
+	}
+
+	if len(placement.TopologySpreadConstraints) > 0 {
+		// Default the selector to the component's own pods
+		for i := range placement.TopologySpreadConstraints {
+			if placement.TopologySpreadConstraints[i].LabelSelector == nil {
+				placement.TopologySpreadConstraints[i].LabelSelector = &metav1.LabelSelector{
+					MatchLabels: selectorLabels(template.Labels),
+				}
+			}
+		}
+		podSpec.TopologySpreadConstraints = placement.TopologySpreadConstraints
+	}
+
+	if placement.PriorityClassName != "" {
+		podSpec.PriorityClassName = placement.PriorityClassName
+	}
+}
+
+// selectorLabels returns the name and instance labels that identify a
+// component's pods.
+func selectorLabels(labels map[string]string) map[string]string {
+	selector := map[string]string{}
+	for _, key := range []string{"app.kubernetes.io/name", "app.kubernetes.io/instance"} {
+		if value, ok := labels[key]; ok {
+			selector[key] = value
+		}
+	}
+	return selector
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("applyPodPlacement", func() {
+	var template corev1.PodTemplateSpec
+
+	BeforeEach(func() {
+		template = corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{
+					"app.kubernetes.io/name":       "loki",
+					"app.kubernetes.io/instance":   "test",
+					"app.kubernetes.io/managed-by": "kube-insight-operator",
+				},
+			},
+			Spec: corev1.PodSpec{
+				Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			},
+		}
+	})
+
+	It("should keep the generated pod spec when nothing is set", func() {
+		applyPodPlacement(&template, monitoringv1alpha1.PodPlacement{})
+
+		Expect(template.Spec.Tolerations).To(HaveLen(1))
+		Expect(template.Spec.NodeSelector).To(BeNil())
+		Expect(template.Spec.PriorityClassName).To(BeEmpty())
+	})
+
+	It("should apply every placement field", func() {
+		applyPodPlacement(&template, monitoringv1alpha1.PodPlacement{
+			NodeSelector:      map[string]string{"pool": "observability"},
+			Affinity:          &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}},
+			Tolerations:       []corev1.Toleration{{Key: "dedicated", Value: "observability"}},
+			PriorityClassName: "high",
+		})
+
+		Expect(template.Spec.NodeSelector).To(HaveKeyWithValue("pool", "observability"))
+		Expect(template.Spec.Affinity.NodeAffinity).NotTo(BeNil())
+		Expect(template.Spec.Tolerations).To(ConsistOf(corev1.Toleration{Key: "dedicated", Value: "observability"}))
+		Expect(template.Spec.PriorityClassName).To(Equal("high"))
+	})
+
+	It("should default topology spread selectors to the component pods", func() {
+		placement := monitoringv1alpha1.PodPlacement{
+			TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+				{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone"},
+			},
+		}
+
+		applyPodPlacement(&template, placement)
+
+		Expect(template.Spec.TopologySpreadConstraints).To(HaveLen(1))
+		Expect(template.Spec.TopologySpreadConstraints[0].LabelSelector.MatchLabels).To(Equal(map[string]string{
+			"app.kubernetes.io/name":     "loki",
+			"app.kubernetes.io/instance": "test",
+		}))
+		Expect(placement.TopologySpreadConstraints[0].LabelSelector).To(BeNil())
+	})
+})
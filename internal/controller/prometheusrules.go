@@ -0,0 +1,320 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	prometheusRulesMountPath = "/etc/prometheus/rules"
+	prometheusRulesVolume    = "rules"
+
+	// rulesChecksumAnnotation on the pod template restarts Prometheus when
+	// its rules change, as it does not reload them by itself
+	rulesChecksumAnnotation = "monitoring.example.com/rules-checksum"
+
+	// specRulesKey holds the rule groups of the spec in the rules ConfigMap.
+	// Keys of selected files contain "_", so they never collide with it.
+	specRulesKey = "spec.yaml"
+	specSource   = "spec"
+)
+
+// prometheusRulesConfigured reports whether Prometheus loads rules, from the
+// spec or from selected ConfigMaps
+func prometheusRulesConfigured(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	rules := stack.Spec.Prometheus.Rules
+	return len(rules.Groups) > 0 || rules.RuleSelector != nil
+}
+
+// specRuleGroups returns the rule groups of the spec as a rule file
+func specRuleGroups(stack *monitoringv1alpha1.ObservabilityStack) ruleFile {
+	var file ruleFile
+	for _, group := range stack.Spec.Prometheus.Rules.Groups {
+		converted := ruleGroup{Name: group.Name, Interval: group.Interval}
+		for _, recording := range group.Rules {
+			converted.Rules = append(converted.Rules, rule{Record: recording.Record, Expr: recording.Expr, Labels: recording.Labels})
+		}
+		file.Groups = append(file.Groups, converted)
+	}
+	return file
+}
+
+// validRuleGroups keeps the groups of a file that pass validation against
+// Prometheus at version, reporting the others as invalid. Prometheus refuses
+// to load a file with two groups of the same name, so only the first of them
+// is kept.
+func validRuleGroups(source string, file ruleFile, version [3]int) (ruleFile, []monitoringv1alpha1.InvalidRuleGroup) {
+	validate := func(expr string) error { return validatePromQL(expr, version) }
+	var valid ruleFile
+	var invalid []monitoringv1alpha1.InvalidRuleGroup
+	names := map[string]bool{}
+	for _, group := range file.Groups {
+		err := validateRuleGroup(group, validate)
+		if err == nil && names[group.Name] {
+			err = fmt.Errorf("rule group %q is defined twice", group.Name)
+		}
+		if err != nil {
+			invalid = append(invalid, monitoringv1alpha1.InvalidRuleGroup{Source: source, Group: group.Name, Message: err.Error()})
+			continue
+		}
+		names[group.Name] = true
+		valid.Groups = append(valid.Groups, group)
+	}
+	return valid, invalid
+}
+
+// prometheusRuleFiles validates the rule groups of the spec and of the
+// selected ConfigMaps, returning the files of valid groups keyed by their
+// name in the stack's rules ConfigMap, and the groups left out
+func (r *ObservabilityStackReconciler) prometheusRuleFiles(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, version [3]int) (map[string]string, []monitoringv1alpha1.InvalidRuleGroup, error) {
+	files := map[string]string{}
+	var invalid []monitoringv1alpha1.InvalidRuleGroup
+
+	add := func(name, source string, file ruleFile) error {
+		valid, rejected := validRuleGroups(source, file, version)
+		invalid = append(invalid, rejected...)
+		if len(valid.Groups) == 0 {
+			return nil
+		}
+		out, err := marshalRuleFile(valid)
+		if err != nil {
+			return fmt.Errorf("failed to write rule file %s: %w", name, err)
+		}
+		files[name] = string(out)
+		return nil
+	}
+
+	if err := add(specRulesKey, specSource, specRuleGroups(stack)); err != nil {
+		return nil, nil, err
+	}
+
+	selector := stack.Spec.Prometheus.Rules.RuleSelector
+	if selector == nil {
+		return files, invalid, nil
+	}
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid spec.prometheus.rules.ruleSelector: %w", err)
+	}
+
+	list := &corev1.ConfigMapList{}
+	if err := r.List(ctx, list, client.InNamespace(stack.Namespace), client.MatchingLabelsSelector{Selector: sel}); err != nil {
+		return nil, nil, fmt.Errorf("failed to list rule ConfigMaps: %w", err)
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+
+	for _, configMap := range list.Items {
+		if managedByOperator(&configMap) {
+			continue
+		}
+		for _, key := range yamlConfigKeys(&configMap) {
+			source := fmt.Sprintf("%s/%s", configMap.Name, key)
+			file, err := parseRuleFile(configMap.Data[key])
+			if err != nil {
+				invalid = append(invalid, monitoringv1alpha1.InvalidRuleGroup{Source: source, Message: err.Error()})
+				continue
+			}
+			// ConfigMap names cannot contain "_", so the names are unique
+			if err := add(fmt.Sprintf("%s_%s", configMap.Name, key), source, *file); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return files, invalid, nil
+}
+
+// setPrometheusRuleFiles points Prometheus at the mounted rule files
+func setPrometheusRuleFiles(configMap *corev1.ConfigMap) error {
+	return patchYAMLConfig(configMap, "prometheus.yml", func(config map[string]interface{}) {
+		config["rule_files"] = []interface{}{
+			prometheusRulesMountPath + "/*.yaml",
+			prometheusRulesMountPath + "/*.yml",
+		}
+	})
+}
+
+func prometheusRulesName(stack *monitoringv1alpha1.ObservabilityStack) string {
+	return fmt.Sprintf("%s-prometheus-rules", stack.Name)
+}
+
+// reconcilePrometheusRules gathers the valid rule groups into the stack's
+// rules ConfigMap, reports the invalid ones in the stack's status and adds
+// the rule files to Prometheus' configuration. It returns a checksum of the
+// rules, which changes whenever Prometheus must load them again.
+func (r *ObservabilityStackReconciler) reconcilePrometheusRules(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, configMap *corev1.ConfigMap, prometheusLabels map[string]string) (string, error) {
+	version, err := r.prometheusRulesVersion(ctx, stack)
+	if err != nil {
+		return "", err
+	}
+	files, invalid, err := r.prometheusRuleFiles(ctx, stack, version)
+	if err != nil {
+		return "", err
+	}
+	if err := r.setInvalidRuleGroups(ctx, stack, invalid); err != nil {
+		return "", err
+	}
+	if err := setPrometheusRuleFiles(configMap); err != nil {
+		return "", fmt.Errorf("failed to configure Prometheus rule files: %w", err)
+	}
+
+	rules := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      prometheusRulesName(stack),
+			Namespace: stack.Namespace,
+			Labels:    prometheusLabels,
+		},
+		Data: files,
+	}
+	if err := ctrl.SetControllerReference(stack, rules, r.Scheme); err != nil {
+		return "", fmt.Errorf("failed to set controller reference on configmap: %w", err)
+	}
+	if err := r.createOrUpdate(ctx, stack, rules); err != nil {
+		return "", fmt.Errorf("failed to reconcile Prometheus rules ConfigMap: %w", err)
+	}
+	return rulesChecksum(files), nil
+}
+
+// prometheusRulesVersion returns the Prometheus version rules are validated
+// against: the older of the running and the desired version, since either
+// may load them while an upgrade rolls out or is rolled back
+func (r *ObservabilityStackReconciler) prometheusRulesVersion(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) ([3]int, error) {
+	desired, err := desiredVersion(stack, componentPrometheus)
+	if err != nil {
+		return [3]int{}, err
+	}
+	version, err := parseVersion(desired)
+	if err != nil {
+		return [3]int{}, err
+	}
+
+	_, current, err := r.runningVersion(ctx, stack, componentPrometheus)
+	if err != nil || current == "" {
+		return version, err
+	}
+	if running, err := parseVersion(current); err == nil && compareVersions(running, version) < 0 {
+		return running, nil
+	}
+	return version, nil
+}
+
+// deletePrometheusRules removes the rules ConfigMap and clears the reported
+// invalid groups once no rules are configured
+func (r *ObservabilityStackReconciler) deletePrometheusRules(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	if err := r.setInvalidRuleGroups(ctx, stack, nil); err != nil {
+		return err
+	}
+
+	rules := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: prometheusRulesName(stack), Namespace: stack.Namespace},
+	}
+	if err := r.delete(ctx, stack, rules); err != nil {
+		return fmt.Errorf("failed to delete Prometheus rules ConfigMap: %w", err)
+	}
+	return nil
+}
+
+// setInvalidRuleGroups records the invalid rule groups in the stack's status,
+// with a Warning event for each group that was not reported before
+func (r *ObservabilityStackReconciler) setInvalidRuleGroups(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, invalid []monitoringv1alpha1.InvalidRuleGroup) error {
+	if equality.Semantic.DeepEqual(stack.Status.InvalidRuleGroups, invalid) {
+		return nil
+	}
+
+	reported := map[monitoringv1alpha1.InvalidRuleGroup]bool{}
+	for _, group := range stack.Status.InvalidRuleGroups {
+		reported[group] = true
+	}
+
+	stack.Status.InvalidRuleGroups = invalid
+	if err := r.Status().Update(ctx, stack); err != nil {
+		return fmt.Errorf("failed to update ObservabilityStack status: %w", err)
+	}
+
+	for _, group := range invalid {
+		switch {
+		case reported[group]:
+		case group.Group == "":
+			r.Recorder.Eventf(stack, corev1.EventTypeWarning, reasonInvalidRules, "Skipped %s: %s", group.Source, group.Message)
+		default:
+			r.Recorder.Eventf(stack, corev1.EventTypeWarning, reasonInvalidRules, "Skipped rule group %q of %s: %s", group.Group, group.Source, group.Message)
+		}
+	}
+	return nil
+}
+
+// rulesChecksum hashes the rule files in a stable order
+func rulesChecksum(files map[string]string) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s\x00%s\x00", name, files[name])
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// mountPrometheusRules mounts the rules ConfigMap into Prometheus and
+// annotates the pod template with the checksum of the rules
+func mountPrometheusRules(template *corev1.PodTemplateSpec, configMapName, checksum string) {
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[rulesChecksumAnnotation] = checksum
+
+	podSpec := &template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: prometheusRulesVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+			},
+		},
+	})
+
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      prometheusRulesVolume,
+			MountPath: prometheusRulesMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// selectsRules reports whether a rule selector selects a ConfigMap
+func selectsRules(selector *metav1.LabelSelector, obj client.Object) bool {
+	if selector == nil {
+		return false
+	}
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	return err == nil && sel.Matches(labels.Set(obj.GetLabels()))
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Prometheus rules", func() {
+	DescribeTable("should accept valid PromQL",
+		func(expr string) {
+			Expect(validatePromQL(expr, [3]int{2, 45, 0})).To(Succeed())
+		},
+		Entry("selector", `up{job="api",code=~"5.."}`),
+		Entry("aggregation with trailing grouping", `sum(rate(http_requests_total[5m])) by (job)`),
+		Entry("vector matching", `a / ignoring(code) group_left sum by (job) (b)`),
+		Entry("function with scalar argument", `histogram_quantile(0.99, sum by (le) (rate(h_bucket[5m])))`),
+		Entry("subquery", `max_over_time(rate(x[5m])[1h:1m])`),
+		Entry("offset and @", `x offset 5m + x @ 1609746000`),
+		Entry("scalar comparison with bool", `1 > bool 2`),
+		Entry("string arguments", `label_replace(up, "host", "$1", "instance", "(.*):.*")`),
+		Entry("selector with a non-empty negative matcher", `{job!="", code=""}`),
+	)
+
+	DescribeTable("should reject invalid PromQL",
+		func(expr, message string) {
+			Expect(validatePromQL(expr, [3]int{2, 45, 0})).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("instant vector for a range", `rate(x)`, "expected type range vector"),
+		Entry("range on an expression", `(x)[5m]`, "ranges only allowed for vector selectors"),
+		Entry("range vector result", `x[5m]`, "must evaluate to a scalar or instant vector"),
+		Entry("unknown function", `rates(x[5m])`, `unknown function "rates"`),
+		Entry("scalar comparison without bool", `1 > 2`, "must use BOOL modifier"),
+		Entry("set operator on a scalar", `1 and x`, "not allowed in binary scalar expression"),
+		Entry("invalid regular expression", `up{job=~"("}`, "invalid regular expression"),
+		Entry("missing parameter", `topk(up)`, "expected type scalar"),
+		Entry("unbalanced parentheses", `sum(up`, `expected ")"`),
+		Entry("trailing tokens", `x y`, `unexpected "y"`),
+		Entry("selector matching the empty value", `{job=""}`, "at least one non-empty matcher"),
+		Entry("selector with a regexp matching the empty value", `{job=~".*"}`, "at least one non-empty matcher"),
+	)
+
+	It("should only accept the functions of the deployed Prometheus", func() {
+		Expect(validatePromQL(`histogram_avg(rate(h[5m]))`, [3]int{2, 45, 0})).To(MatchError(ContainSubstring(`function "histogram_avg" needs Prometheus 2.50.0 or later`)))
+		Expect(validatePromQL(`histogram_avg(rate(h[5m]))`, [3]int{2, 51, 2})).To(Succeed())
+
+		Expect(validatePromQL(`sort_by_label(up, "job")`, [3]int{2, 51, 2})).To(MatchError(ContainSubstring("experimental")))
+		Expect(validatePromQL(`limitk(5, up)`, [3]int{2, 51, 2})).To(MatchError(ContainSubstring(`aggregation "limitk" needs Prometheus 2.54.0`)))
+		Expect(validatePromQL(`limit_ratio(0.5, up)`, [3]int{2, 45, 0})).NotTo(Succeed())
+	})
+
+	It("should keep valid groups and report the others", func() {
+		file := ruleFile{Groups: []ruleGroup{
+			{Name: "ok", Rules: []rule{{Record: "job:up:sum", Expr: "sum by (job) (up)"}}},
+			{Name: "broken", Rules: []rule{{Record: "job:rate", Expr: "rate(x)"}}},
+			{Name: "ok", Rules: []rule{{Record: "job:up:count", Expr: "count by (job) (up)"}}},
+		}}
+
+		valid, invalid := validRuleGroups("rules/a.yaml", file, [3]int{2, 45, 0})
+		Expect(valid.Groups).To(HaveLen(1))
+		Expect(valid.Groups[0].Rules[0].Record).To(Equal("job:up:sum"))
+		Expect(invalid).To(HaveLen(2))
+		Expect(invalid[0].Source).To(Equal("rules/a.yaml"))
+		Expect(invalid[0].Group).To(Equal("broken"))
+		Expect(invalid[1].Message).To(ContainSubstring("defined twice"))
+	})
+
+	It("should write valid groups with the fields validation does not know", func() {
+		file, err := parseRuleFile(`groups:
+- name: ok
+  limit: 10
+  query_offset: 1m
+  labels: {team: payments}
+  rules:
+  - alert: Down
+    expr: up == 0
+    keep_firing_for: 5m
+- name: broken
+  rules:
+  - record: job:rate
+    expr: rate(x)
+`)
+		Expect(err).NotTo(HaveOccurred())
+
+		valid, _ := validRuleGroups("rules/a.yaml", *file, [3]int{2, 45, 0})
+		out, err := marshalRuleFile(valid)
+		Expect(err).NotTo(HaveOccurred())
+
+		var written map[string][]map[string]interface{}
+		Expect(yaml.Unmarshal(out, &written)).To(Succeed())
+		Expect(written["groups"]).To(HaveLen(1))
+		Expect(written["groups"][0]).To(HaveKeyWithValue("limit", BeNumerically("==", 10)))
+		Expect(written["groups"][0]).To(HaveKeyWithValue("query_offset", "1m"))
+		Expect(written["groups"][0]).To(HaveKey("labels"))
+		Expect(written["groups"][0]["rules"]).To(ContainElement(HaveKeyWithValue("keep_firing_for", "5m")))
+	})
+
+	It("should load the mounted rule files and restart on changes", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"prometheus.yml": "global: {}\n"}}
+		Expect(setPrometheusRuleFiles(configMap)).To(Succeed())
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("- /etc/prometheus/rules/*.yaml"))
+
+		template := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "prometheus"}}}}
+		mountPrometheusRules(template, "test-prometheus-rules", rulesChecksum(map[string]string{"spec.yaml": "groups: []"}))
+		Expect(template.Annotations[rulesChecksumAnnotation]).NotTo(BeEmpty())
+		Expect(template.Annotations[rulesChecksumAnnotation]).NotTo(Equal(rulesChecksum(map[string]string{"spec.yaml": "groups: [x]"})))
+		Expect(template.Spec.Containers[0].VolumeMounts).To(ContainElement(HaveField("MountPath", prometheusRulesMountPath)))
+
+		stack := &monitoringv1alpha1.ObservabilityStack{}
+		Expect(prometheusRulesConfigured(stack)).To(BeFalse())
+		stack.Spec.Prometheus.Rules.Groups = []monitoringv1alpha1.PrometheusRuleGroup{{Name: "ok"}}
+		Expect(prometheusRulesConfigured(stack)).To(BeTrue())
+	})
+})
@@ -0,0 +1,770 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// promqlType is the type of value a PromQL expression evaluates to
+type promqlType int
+
+const (
+	promqlScalar promqlType = iota
+	promqlVector
+	promqlMatrix
+	promqlString
+)
+
+func (t promqlType) String() string {
+	return [...]string{"scalar", "instant vector", "range vector", "string"}[t]
+}
+
+// promqlFunction is the signature of a PromQL function. The last optional
+// arguments may be left out, and a variadic function repeats its last
+// argument. since is the first Prometheus release with the function;
+// experimental functions also need a feature flag the operator does not set.
+type promqlFunction struct {
+	args         []promqlType
+	optional     int
+	variadic     bool
+	returns      promqlType
+	since        [3]int
+	experimental bool
+}
+
+var promqlFunctions = map[string]promqlFunction{}
+
+func init() {
+	vectorFunctions := []string{
+		"abs", "absent", "acos", "acosh", "asin", "asinh", "atan", "atanh", "ceil", "cos", "cosh", "deg",
+		"exp", "floor", "histogram_count", "histogram_stddev", "histogram_stdvar", "histogram_sum", "ln",
+		"log10", "log2", "rad", "sgn", "sin", "sinh", "sort", "sort_desc", "sqrt", "tan", "tanh", "timestamp",
+	}
+	for _, name := range vectorFunctions {
+		promqlFunctions[name] = promqlFunction{args: []promqlType{promqlVector}, returns: promqlVector}
+	}
+
+	rangeFunctions := []string{
+		"absent_over_time", "avg_over_time", "changes", "count_over_time", "delta", "deriv", "idelta",
+		"increase", "irate", "last_over_time", "max_over_time", "min_over_time", "present_over_time",
+		"rate", "resets", "stddev_over_time", "stdvar_over_time", "sum_over_time",
+	}
+	for _, name := range rangeFunctions {
+		promqlFunctions[name] = promqlFunction{args: []promqlType{promqlMatrix}, returns: promqlVector}
+	}
+
+	// Date functions default to the evaluation time
+	for _, name := range []string{"day_of_month", "day_of_week", "day_of_year", "days_in_month", "hour", "minute", "month", "year"} {
+		promqlFunctions[name] = promqlFunction{args: []promqlType{promqlVector}, optional: 1, returns: promqlVector}
+	}
+
+	for name, fn := range map[string]promqlFunction{
+		"clamp":                        {args: []promqlType{promqlVector, promqlScalar, promqlScalar}, returns: promqlVector},
+		"clamp_max":                    {args: []promqlType{promqlVector, promqlScalar}, returns: promqlVector},
+		"clamp_min":                    {args: []promqlType{promqlVector, promqlScalar}, returns: promqlVector},
+		"double_exponential_smoothing": {args: []promqlType{promqlMatrix, promqlScalar, promqlScalar}, returns: promqlVector, since: [3]int{3, 0, 0}, experimental: true},
+		"histogram_avg":                {args: []promqlType{promqlVector}, returns: promqlVector, since: [3]int{2, 50, 0}},
+		"histogram_fraction":           {args: []promqlType{promqlScalar, promqlScalar, promqlVector}, returns: promqlVector},
+		"histogram_quantile":           {args: []promqlType{promqlScalar, promqlVector}, returns: promqlVector},
+		"holt_winters":                 {args: []promqlType{promqlMatrix, promqlScalar, promqlScalar}, returns: promqlVector},
+		"label_join":                   {args: []promqlType{promqlVector, promqlString, promqlString, promqlString}, variadic: true, returns: promqlVector},
+		"label_replace":                {args: []promqlType{promqlVector, promqlString, promqlString, promqlString, promqlString}, returns: promqlVector},
+		"pi":                           {returns: promqlScalar},
+		"predict_linear":               {args: []promqlType{promqlMatrix, promqlScalar}, returns: promqlVector},
+		"quantile_over_time":           {args: []promqlType{promqlScalar, promqlMatrix}, returns: promqlVector},
+		"round":                        {args: []promqlType{promqlVector, promqlScalar}, optional: 1, returns: promqlVector},
+		"scalar":                       {args: []promqlType{promqlVector}, returns: promqlScalar},
+		"sort_by_label":                {args: []promqlType{promqlVector, promqlString}, variadic: true, returns: promqlVector, since: [3]int{2, 51, 0}, experimental: true},
+		"sort_by_label_desc":           {args: []promqlType{promqlVector, promqlString}, variadic: true, returns: promqlVector, since: [3]int{2, 51, 0}, experimental: true},
+		"time":                         {returns: promqlScalar},
+		"vector":                       {args: []promqlType{promqlScalar}, returns: promqlVector},
+	} {
+		promqlFunctions[name] = fn
+	}
+}
+
+// promqlAggregation describes an aggregation operator: the type of its
+// parameter, for those that take one, and when it became available, as for
+// functions
+type promqlAggregation struct {
+	param        *promqlType
+	since        [3]int
+	experimental bool
+}
+
+var promqlAggregations = map[string]promqlAggregation{
+	"avg": {}, "bottomk": {param: typeOf(promqlScalar)}, "count": {}, "count_values": {param: typeOf(promqlString)},
+	"group": {}, "max": {}, "min": {}, "quantile": {param: typeOf(promqlScalar)}, "stddev": {}, "stdvar": {},
+	"sum": {}, "topk": {param: typeOf(promqlScalar)},
+	"limitk":      {param: typeOf(promqlScalar), since: [3]int{2, 54, 0}, experimental: true},
+	"limit_ratio": {param: typeOf(promqlScalar), since: [3]int{2, 54, 0}, experimental: true},
+}
+
+func typeOf(t promqlType) *promqlType {
+	return &t
+}
+
+// promqlAvailable reports why Prometheus at version does not know a function
+// or aggregation, or nil if it does
+func promqlAvailable(kind, name string, version, since [3]int, experimental bool) error {
+	if compareVersions(version, since) < 0 {
+		return fmt.Errorf("%s %q needs Prometheus %d.%d.%d or later", kind, name, since[0], since[1], since[2])
+	}
+	if experimental {
+		return fmt.Errorf("%s %q is experimental and not enabled", kind, name)
+	}
+	return nil
+}
+
+// promqlPrecedence ranks the binary operators, loosest first
+var promqlPrecedence = map[string]int{
+	"or":  1,
+	"and": 2, "unless": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5, "atan2": 5,
+	"^": 6,
+}
+
+func promqlComparison(op string) bool {
+	return promqlPrecedence[op] == 3
+}
+
+func promqlSetOperator(op string) bool {
+	return op == "and" || op == "or" || op == "unless"
+}
+
+type promqlTokenKind int
+
+const (
+	tokenEOF promqlTokenKind = iota
+	tokenIdentifier
+	tokenNumber
+	tokenDuration
+	tokenString
+	tokenOperator
+)
+
+type promqlToken struct {
+	kind  promqlTokenKind
+	value string
+	pos   int
+}
+
+// promqlOperators are the symbols of the language, longest first so that
+// "=~" is not read as "="
+var promqlOperators = []string{
+	"==", "!=", "<=", ">=", "=~", "!~",
+	"+", "-", "*", "/", "%", "^", "<", ">", "=", ",", "(", ")", "{", "}", "[", "]", ":", "@",
+}
+
+// lexPromQL splits an expression into tokens
+func lexPromQL(expr string) ([]promqlToken, error) {
+	var tokens []promqlToken
+	for pos := 0; pos < len(expr); {
+		c := expr[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+
+		case c == '#':
+			for pos < len(expr) && expr[pos] != '\n' {
+				pos++
+			}
+
+		case c == '"' || c == '\'' || c == '`':
+			end := pos + 1
+			for ; end < len(expr) && expr[end] != c; end++ {
+				if expr[end] == '\\' && c != '`' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at position %d", pos)
+			}
+			tokens = append(tokens, promqlToken{kind: tokenString, value: expr[pos : end+1], pos: pos})
+			pos = end + 1
+
+		case isDigit(c) || (c == '.' && pos+1 < len(expr) && isDigit(expr[pos+1])):
+			token, end := lexPromQLNumber(expr, pos)
+			tokens = append(tokens, token)
+			pos = end
+
+		// A leading colon is a subquery's, so identifiers start with a letter
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := pos
+			for end < len(expr) && (expr[end] == '_' || expr[end] == ':' || isDigit(expr[end]) || unicode.IsLetter(rune(expr[end]))) {
+				end++
+			}
+			word := expr[pos:end]
+			kind := tokenIdentifier
+			if strings.EqualFold(word, "inf") || strings.EqualFold(word, "nan") {
+				kind = tokenNumber
+			}
+			tokens = append(tokens, promqlToken{kind: kind, value: word, pos: pos})
+			pos = end
+
+		default:
+			operator := ""
+			for _, op := range promqlOperators {
+				if strings.HasPrefix(expr[pos:], op) {
+					operator = op
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, pos)
+			}
+			tokens = append(tokens, promqlToken{kind: tokenOperator, value: operator, pos: pos})
+			pos += len(operator)
+		}
+	}
+	return append(tokens, promqlToken{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+var (
+	promqlDurationPrefix = regexp.MustCompile(`^([0-9]+(ms|s|m|h|d|w|y))+`)
+	promqlNumberPrefix   = regexp.MustCompile(`^(0[xX][0-9a-fA-F]+|([0-9]*\.)?[0-9]+([eE][-+]?[0-9]+)?)`)
+)
+
+// lexPromQLNumber reads the duration or number at pos
+func lexPromQLNumber(expr string, pos int) (promqlToken, int) {
+	if match := promqlDurationPrefix.FindString(expr[pos:]); match != "" {
+		// A duration unit is never followed by more of an identifier
+		end := pos + len(match)
+		if end == len(expr) || !(unicode.IsLetter(rune(expr[end])) || expr[end] == '_') {
+			return promqlToken{kind: tokenDuration, value: match, pos: pos}, end
+		}
+	}
+	match := promqlNumberPrefix.FindString(expr[pos:])
+	if strings.HasPrefix(expr[pos+len(match):], ".") && !strings.Contains(match, ".") {
+		// A trailing dot, as in "1."
+		match += "."
+		for end := pos + len(match); end < len(expr) && isDigit(expr[end]); end++ {
+			match += string(expr[end])
+		}
+	}
+	return promqlToken{kind: tokenNumber, value: match, pos: pos}, pos + len(match)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// promqlParser checks the syntax and types of a PromQL expression without
+// building a tree of it. Functions and aggregations are checked against the
+// Prometheus version that evaluates the expression.
+type promqlParser struct {
+	tokens  []promqlToken
+	next    int
+	version [3]int
+}
+
+// promqlResult describes a parsed expression: its type, and whether it is a
+// bare series selector, the only expression a range may be applied to
+type promqlResult struct {
+	typ      promqlType
+	selector bool
+}
+
+// validatePromQL parses a PromQL expression and checks the types of its
+// operands, so that an expression Prometheus at version would reject is
+// caught before it is loaded
+func validatePromQL(expr string, version [3]int) error {
+	if strings.TrimSpace(expr) == "" {
+		return fmt.Errorf("empty expr")
+	}
+	tokens, err := lexPromQL(expr)
+	if err != nil {
+		return fmt.Errorf("invalid expr %q: %w", expr, err)
+	}
+
+	p := &promqlParser{tokens: tokens, version: version}
+	result, err := p.parseExpr(1)
+	if err == nil && p.peek().kind != tokenEOF {
+		err = p.unexpected()
+	}
+	if err == nil && result.typ != promqlScalar && result.typ != promqlVector {
+		err = fmt.Errorf("expression must evaluate to a scalar or instant vector, not a %s", result.typ)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid expr %q: %w", expr, err)
+	}
+	return nil
+}
+
+func (p *promqlParser) peek() promqlToken {
+	return p.tokens[p.next]
+}
+
+func (p *promqlParser) advance() promqlToken {
+	token := p.tokens[p.next]
+	if token.kind != tokenEOF {
+		p.next++
+	}
+	return token
+}
+
+// accept consumes the next token if it is the given operator or keyword
+func (p *promqlParser) accept(value string) bool {
+	token := p.peek()
+	if (token.kind == tokenOperator || token.kind == tokenIdentifier) && token.value == value {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *promqlParser) expect(value string) error {
+	if !p.accept(value) {
+		return fmt.Errorf("expected %q, %s", value, p.describe(p.peek()))
+	}
+	return nil
+}
+
+func (p *promqlParser) unexpected() error {
+	return fmt.Errorf("unexpected %s", p.describe(p.peek()))
+}
+
+func (p *promqlParser) describe(token promqlToken) string {
+	if token.kind == tokenEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q at position %d", token.value, token.pos)
+}
+
+// binaryOperator returns the binary operator the next token is, if any
+func (p *promqlParser) binaryOperator() (string, int) {
+	token := p.peek()
+	if token.kind != tokenOperator && token.kind != tokenIdentifier {
+		return "", 0
+	}
+	return token.value, promqlPrecedence[token.value]
+}
+
+// parseExpr parses a chain of binary operations whose operators bind at
+// least as tightly as minPrecedence
+func (p *promqlParser) parseExpr(minPrecedence int) (promqlResult, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return lhs, err
+	}
+
+	for {
+		op, precedence := p.binaryOperator()
+		if precedence == 0 || precedence < minPrecedence {
+			return lhs, nil
+		}
+		p.advance()
+
+		returnBool := false
+		if promqlComparison(op) && p.accept("bool") {
+			returnBool = true
+		}
+		matching, err := p.parseVectorMatching(op)
+		if err != nil {
+			return lhs, err
+		}
+
+		// "^" is right-associative, every other operator left-associative
+		next := precedence + 1
+		if op == "^" {
+			next = precedence
+		}
+		rhs, err := p.parseExpr(next)
+		if err != nil {
+			return rhs, err
+		}
+
+		if lhs, err = binaryResult(op, lhs, rhs, returnBool, matching); err != nil {
+			return lhs, err
+		}
+	}
+}
+
+// parseVectorMatching parses the on/ignoring and group_left/group_right
+// modifiers of a binary operator, reporting whether there were any
+func (p *promqlParser) parseVectorMatching(op string) (bool, error) {
+	matching := false
+	if p.accept("on") || p.accept("ignoring") {
+		matching = true
+		if err := p.parseLabelList(); err != nil {
+			return false, err
+		}
+	}
+	if p.accept("group_left") || p.accept("group_right") {
+		if !matching {
+			return false, fmt.Errorf("group modifiers need on or ignoring")
+		}
+		if promqlSetOperator(op) {
+			return false, fmt.Errorf("no grouping allowed for %q operation", op)
+		}
+		if p.peek().value == "(" {
+			if err := p.parseLabelList(); err != nil {
+				return false, err
+			}
+		}
+	}
+	return matching, nil
+}
+
+// binaryResult checks the operands of a binary operation and returns its
+// result
+func binaryResult(op string, lhs, rhs promqlResult, returnBool, matching bool) (promqlResult, error) {
+	for _, operand := range []promqlResult{lhs, rhs} {
+		if operand.typ != promqlScalar && operand.typ != promqlVector {
+			return operand, fmt.Errorf("binary expression must contain only scalar and instant vector types, not a %s", operand.typ)
+		}
+	}
+
+	bothScalars := lhs.typ == promqlScalar && rhs.typ == promqlScalar
+	switch {
+	case promqlSetOperator(op) && (lhs.typ != promqlVector || rhs.typ != promqlVector):
+		return lhs, fmt.Errorf("set operator %q not allowed in binary scalar expression", op)
+	case promqlComparison(op) && bothScalars && !returnBool:
+		return lhs, fmt.Errorf("comparisons between scalars must use BOOL modifier")
+	case returnBool && !promqlComparison(op):
+		return lhs, fmt.Errorf("bool modifier can only be used on comparison operators")
+	case matching && (lhs.typ != promqlVector || rhs.typ != promqlVector):
+		return lhs, fmt.Errorf("vector matching only allowed between instant vectors")
+	}
+
+	if bothScalars {
+		return promqlResult{typ: promqlScalar}, nil
+	}
+	return promqlResult{typ: promqlVector}, nil
+}
+
+func (p *promqlParser) parseUnary() (promqlResult, error) {
+	if p.accept("-") || p.accept("+") {
+		// Unary operators bind looser than "^": -2^2 is -(2^2)
+		operand, err := p.parseExpr(promqlPrecedence["^"])
+		if err != nil {
+			return operand, err
+		}
+		if operand.typ != promqlScalar && operand.typ != promqlVector {
+			return operand, fmt.Errorf("unary expression only allowed on expressions of type scalar or instant vector, not a %s", operand.typ)
+		}
+		return promqlResult{typ: operand.typ}, nil
+	}
+
+	result, err := p.parsePrimary()
+	if err != nil {
+		return result, err
+	}
+	return p.parsePostfix(result)
+}
+
+// parsePostfix parses the ranges, subqueries and offset and @ modifiers that
+// follow an expression
+func (p *promqlParser) parsePostfix(result promqlResult) (promqlResult, error) {
+	for {
+		switch {
+		case p.accept("["):
+			if p.peek().kind != tokenDuration {
+				return result, fmt.Errorf("expected a duration in range, %s", p.describe(p.peek()))
+			}
+			p.advance()
+
+			if p.accept(":") {
+				if result.typ != promqlVector {
+					return result, fmt.Errorf("subquery is only allowed on instant vector, not a %s", result.typ)
+				}
+				// The resolution defaults to the global evaluation interval
+				if p.peek().kind == tokenDuration {
+					p.advance()
+				}
+			} else if !result.selector {
+				return result, fmt.Errorf("ranges only allowed for vector selectors")
+			}
+			if err := p.expect("]"); err != nil {
+				return result, err
+			}
+			result = promqlResult{typ: promqlMatrix}
+
+		case p.accept("offset"):
+			p.accept("-")
+			if p.peek().kind != tokenDuration {
+				return result, fmt.Errorf("expected a duration after offset, %s", p.describe(p.peek()))
+			}
+			p.advance()
+
+		case p.accept("@"):
+			if p.accept("start") || p.accept("end") {
+				if err := p.expect("("); err != nil {
+					return result, err
+				}
+				if err := p.expect(")"); err != nil {
+					return result, err
+				}
+				continue
+			}
+			p.accept("-")
+			p.accept("+")
+			if p.peek().kind != tokenNumber {
+				return result, fmt.Errorf("expected a timestamp after @, %s", p.describe(p.peek()))
+			}
+			p.advance()
+
+		default:
+			return result, nil
+		}
+	}
+}
+
+func (p *promqlParser) parsePrimary() (promqlResult, error) {
+	token := p.peek()
+	switch token.kind {
+	case tokenNumber:
+		p.advance()
+		if _, err := strconv.ParseFloat(token.value, 64); err != nil && !strings.HasPrefix(strings.ToLower(token.value), "0x") {
+			return promqlResult{}, fmt.Errorf("invalid number %q at position %d", token.value, token.pos)
+		}
+		return promqlResult{typ: promqlScalar}, nil
+
+	case tokenString:
+		p.advance()
+		if _, err := unquotePromQL(token.value); err != nil {
+			return promqlResult{}, fmt.Errorf("invalid string %s at position %d", token.value, token.pos)
+		}
+		return promqlResult{typ: promqlString}, nil
+
+	case tokenIdentifier:
+		p.advance()
+		if _, ok := promqlAggregations[token.value]; ok {
+			return p.parseAggregation(token)
+		}
+		if p.peek().value == "(" {
+			return p.parseCall(token)
+		}
+		if !metricNamePattern.MatchString(token.value) {
+			return promqlResult{}, fmt.Errorf("invalid metric name %q", token.value)
+		}
+		if p.peek().value == "{" {
+			if _, _, err := p.parseMatchers(); err != nil {
+				return promqlResult{}, err
+			}
+		}
+		return promqlResult{typ: promqlVector, selector: true}, nil
+	}
+
+	switch {
+	case p.accept("("):
+		result, err := p.parseExpr(1)
+		if err != nil {
+			return result, err
+		}
+		if err := p.expect(")"); err != nil {
+			return result, err
+		}
+		// Parentheses make a selector an expression, which takes no range
+		return promqlResult{typ: result.typ}, nil
+
+	case token.value == "{":
+		_, nonEmpty, err := p.parseMatchers()
+		if err != nil {
+			return promqlResult{}, err
+		}
+		if nonEmpty == 0 {
+			return promqlResult{}, fmt.Errorf("vector selector must contain at least one non-empty matcher")
+		}
+		return promqlResult{typ: promqlVector, selector: true}, nil
+	}
+	return promqlResult{}, p.unexpected()
+}
+
+// parseMatchers parses the label matchers of a selector, returning how many
+// there are and how many of them cannot match the empty string, of which
+// Prometheus needs one
+func (p *promqlParser) parseMatchers() (int, int, error) {
+	if err := p.expect("{"); err != nil {
+		return 0, 0, err
+	}
+
+	count, nonEmpty := 0, 0
+	for !p.accept("}") {
+		name := p.advance()
+		if name.kind != tokenIdentifier || !labelNamePattern.MatchString(name.value) {
+			return 0, 0, fmt.Errorf("expected a label name, %s", p.describe(name))
+		}
+
+		op := p.advance()
+		if op.kind != tokenOperator || (op.value != "=" && op.value != "!=" && op.value != "=~" && op.value != "!~") {
+			return 0, 0, fmt.Errorf("expected a label matching operator, %s", p.describe(op))
+		}
+
+		value := p.advance()
+		if value.kind != tokenString {
+			return 0, 0, fmt.Errorf("expected a string, %s", p.describe(value))
+		}
+		unquoted, err := unquotePromQL(value.value)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid string %s at position %d", value.value, value.pos)
+		}
+
+		// matchesEmpty is whether the value or pattern matches the empty
+		// string, which a negated matcher then excludes
+		matchesEmpty := unquoted == ""
+		if op.value == "=~" || op.value == "!~" {
+			re, err := regexp.Compile("^(?:" + unquoted + ")$")
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid regular expression for label %s: %w", name.value, err)
+			}
+			matchesEmpty = re.MatchString("")
+		}
+		if (op.value == "!=" || op.value == "!~") == matchesEmpty {
+			nonEmpty++
+		}
+		count++
+
+		if !p.accept(",") {
+			if err := p.expect("}"); err != nil {
+				return 0, 0, err
+			}
+			break
+		}
+	}
+	return count, nonEmpty, nil
+}
+
+// parseLabelList parses a parenthesised list of label names
+func (p *promqlParser) parseLabelList() error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for !p.accept(")") {
+		label := p.advance()
+		if label.kind != tokenIdentifier || !labelNamePattern.MatchString(label.value) {
+			return fmt.Errorf("expected a label name, %s", p.describe(label))
+		}
+		if !p.accept(",") {
+			return p.expect(")")
+		}
+	}
+	return nil
+}
+
+// parseAggregation parses an aggregation, whose by or without clause may
+// come before or after its arguments
+func (p *promqlParser) parseAggregation(op promqlToken) (promqlResult, error) {
+	grouped := false
+	if p.accept("by") || p.accept("without") {
+		grouped = true
+		if err := p.parseLabelList(); err != nil {
+			return promqlResult{}, err
+		}
+	}
+
+	if err := p.expect("("); err != nil {
+		return promqlResult{}, err
+	}
+	aggregation := promqlAggregations[op.value]
+	if err := promqlAvailable("aggregation", op.value, p.version, aggregation.since, aggregation.experimental); err != nil {
+		return promqlResult{}, err
+	}
+	if param := aggregation.param; param != nil {
+		result, err := p.parseExpr(1)
+		if err != nil {
+			return result, err
+		}
+		if result.typ != *param {
+			return result, fmt.Errorf("expected type %s in aggregation parameter of %s, got %s", *param, op.value, result.typ)
+		}
+		if err := p.expect(","); err != nil {
+			return promqlResult{}, err
+		}
+	}
+	result, err := p.parseExpr(1)
+	if err != nil {
+		return result, err
+	}
+	if result.typ != promqlVector {
+		return result, fmt.Errorf("expected type instant vector in aggregation expression of %s, got %s", op.value, result.typ)
+	}
+	if err := p.expect(")"); err != nil {
+		return promqlResult{}, err
+	}
+
+	if !grouped && (p.accept("by") || p.accept("without")) {
+		if err := p.parseLabelList(); err != nil {
+			return promqlResult{}, err
+		}
+	}
+	return promqlResult{typ: promqlVector}, nil
+}
+
+// parseCall parses a function call and checks its arguments against the
+// function's signature
+func (p *promqlParser) parseCall(name promqlToken) (promqlResult, error) {
+	fn, ok := promqlFunctions[name.value]
+	if !ok {
+		return promqlResult{}, fmt.Errorf("unknown function %q", name.value)
+	}
+	if err := promqlAvailable("function", name.value, p.version, fn.since, fn.experimental); err != nil {
+		return promqlResult{}, err
+	}
+	if err := p.expect("("); err != nil {
+		return promqlResult{}, err
+	}
+
+	var args []promqlType
+	for !p.accept(")") {
+		arg, err := p.parseExpr(1)
+		if err != nil {
+			return arg, err
+		}
+		args = append(args, arg.typ)
+		if !p.accept(",") {
+			if err := p.expect(")"); err != nil {
+				return promqlResult{}, err
+			}
+			break
+		}
+	}
+
+	required := len(fn.args) - fn.optional
+	if len(args) < required || (!fn.variadic && len(args) > len(fn.args)) {
+		return promqlResult{}, fmt.Errorf("wrong number of arguments for function %s: got %d", name.value, len(args))
+	}
+	for i, arg := range args {
+		want := fn.args[len(fn.args)-1]
+		if i < len(fn.args) {
+			want = fn.args[i]
+		}
+		if arg != want {
+			return promqlResult{}, fmt.Errorf("expected type %s in call to function %s, got %s", want, name.value, arg)
+		}
+	}
+	return promqlResult{typ: fn.returns}, nil
+}
+
+// unquotePromQL returns the value of a string literal, which follows Go's
+// escaping rules in any of its three quote styles
+func unquotePromQL(literal string) (string, error) {
+	if strings.HasPrefix(literal, "'") {
+		body := literal[1 : len(literal)-1]
+		body = strings.ReplaceAll(body, `\'`, `'`)
+		body = strings.ReplaceAll(body, `"`, `\"`)
+		literal = `"` + body + `"`
+	}
+	return strconv.Unquote(literal)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// specPaths maps each component to the path of its spec in the CRD
+var specPaths = map[string]string{
+	componentPrometheus:       "spec.prometheus",
+	componentGrafana:          "spec.grafana",
+	componentLoki:             "spec.loki",
+	componentPromtail:         "spec.promtail",
+	componentTempo:            "spec.tempo",
+	componentKubeStateMetrics: "spec.prometheus.kubeStateMetrics",
+	componentThanosSidecar:    "spec.prometheus.thanos",
+	componentThanosQuery:      "spec.prometheus.thanos.query",
+	componentThanosStore:      "spec.prometheus.thanos.storeGateway",
+}
+
+// InvalidQuantityError reports a spec field that does not hold a valid
+// Kubernetes quantity such as "500m" or "10Gi".
+type InvalidQuantityError struct {
+	Component string
+	Field     string
+	Value     string
+	Err       error
+}
+
+func (e *InvalidQuantityError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("%s must be set", e.Field)
+	}
+	return fmt.Sprintf("%s has invalid quantity %q: %v", e.Field, e.Value, e.Err)
+}
+
+func (e *InvalidQuantityError) Unwrap() error {
+	return e.Err
+}
+
+// parseQuantity converts a quantity from a component spec, returning an
+// InvalidQuantityError instead of panicking on empty or malformed input.
+// field is relative to the component's spec, e.g. "storage".
+func parseQuantity(component, field, value string) (resource.Quantity, error) {
+	path := fmt.Sprintf("%s.%s", specPaths[component], field)
+
+	if value == "" {
+		return resource.Quantity{}, &InvalidQuantityError{Component: component, Field: path}
+	}
+
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return resource.Quantity{}, &InvalidQuantityError{Component: component, Field: path, Value: value, Err: err}
+	}
+
+	return quantity, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Component names, matching the app.kubernetes.io/name label of each workload
+const (
+	componentPrometheus       = "prometheus"
+	componentGrafana          = "grafana"
+	componentLoki             = "loki"
+	componentPromtail         = "promtail"
+	componentTempo            = "tempo"
+	componentKubeStateMetrics = "kube-state-metrics"
+	componentNodeExporter     = "node-exporter"
+	componentThanosSidecar    = "thanos-sidecar"
+	componentThanosQuery      = "thanos-query"
+	componentThanosStore      = "thanos-store"
+
+	// componentThanos names the image shared by the Thanos components
+	componentThanos = "thanos"
+)
+
+// profileResources holds the default requests and limits of each component
+// for every resource profile. The small profile keeps the values the operator
+// used before profiles existed.
+var profileResources = map[monitoringv1alpha1.ResourceProfile]map[string]monitoringv1alpha1.ResourceRequirements{
+	monitoringv1alpha1.ResourceProfileSmall: {
+		componentPrometheus:       {CPURequest: "200m", MemoryRequest: "512Mi", CPULimit: "1", MemoryLimit: "2Gi"},
+		componentGrafana:          {CPURequest: "100m", MemoryRequest: "256Mi", CPULimit: "500m", MemoryLimit: "512Mi"},
+		componentLoki:             {CPURequest: "100m", MemoryRequest: "128Mi", CPULimit: "1", MemoryLimit: "1Gi"},
+		componentPromtail:         {CPURequest: "100m", MemoryRequest: "128Mi", CPULimit: "200m", MemoryLimit: "256Mi"},
+		componentTempo:            {CPURequest: "200m", MemoryRequest: "512Mi", CPULimit: "1", MemoryLimit: "2Gi"},
+		componentKubeStateMetrics: {CPURequest: "10m", MemoryRequest: "64Mi", CPULimit: "100m", MemoryLimit: "128Mi"},
+		componentNodeExporter:     {CPURequest: "10m", MemoryRequest: "32Mi", CPULimit: "100m", MemoryLimit: "64Mi"},
+		componentThanosSidecar:    {CPURequest: "50m", MemoryRequest: "64Mi", CPULimit: "200m", MemoryLimit: "256Mi"},
+		componentThanosQuery:      {CPURequest: "100m", MemoryRequest: "256Mi", CPULimit: "500m", MemoryLimit: "1Gi"},
+		componentThanosStore:      {CPURequest: "100m", MemoryRequest: "256Mi", CPULimit: "500m", MemoryLimit: "1Gi"},
+	},
+	monitoringv1alpha1.ResourceProfileMedium: {
+		componentPrometheus:       {CPURequest: "500m", MemoryRequest: "2Gi", CPULimit: "2", MemoryLimit: "4Gi"},
+		componentGrafana:          {CPURequest: "250m", MemoryRequest: "512Mi", CPULimit: "1", MemoryLimit: "1Gi"},
+		componentLoki:             {CPURequest: "500m", MemoryRequest: "1Gi", CPULimit: "2", MemoryLimit: "2Gi"},
+		componentPromtail:         {CPURequest: "200m", MemoryRequest: "256Mi", CPULimit: "500m", MemoryLimit: "512Mi"},
+		componentTempo:            {CPURequest: "500m", MemoryRequest: "1Gi", CPULimit: "2", MemoryLimit: "4Gi"},
+		componentKubeStateMetrics: {CPURequest: "50m", MemoryRequest: "128Mi", CPULimit: "200m", MemoryLimit: "256Mi"},
+		componentNodeExporter:     {CPURequest: "50m", MemoryRequest: "64Mi", CPULimit: "200m", MemoryLimit: "128Mi"},
+		componentThanosSidecar:    {CPURequest: "100m", MemoryRequest: "128Mi", CPULimit: "500m", MemoryLimit: "512Mi"},
+		componentThanosQuery:      {CPURequest: "250m", MemoryRequest: "512Mi", CPULimit: "1", MemoryLimit: "2Gi"},
+		componentThanosStore:      {CPURequest: "250m", MemoryRequest: "1Gi", CPULimit: "1", MemoryLimit: "2Gi"},
+	},
+	monitoringv1alpha1.ResourceProfileLarge: {
+		componentPrometheus:       {CPURequest: "1", MemoryRequest: "8Gi", CPULimit: "4", MemoryLimit: "16Gi"},
+		componentGrafana:          {CPURequest: "500m", MemoryRequest: "1Gi", CPULimit: "2", MemoryLimit: "2Gi"},
+		componentLoki:             {CPURequest: "1", MemoryRequest: "4Gi", CPULimit: "4", MemoryLimit: "8Gi"},
+		componentPromtail:         {CPURequest: "250m", MemoryRequest: "512Mi", CPULimit: "1", MemoryLimit: "1Gi"},
+		componentTempo:            {CPURequest: "1", MemoryRequest: "4Gi", CPULimit: "4", MemoryLimit: "8Gi"},
+		componentKubeStateMetrics: {CPURequest: "100m", MemoryRequest: "256Mi", CPULimit: "500m", MemoryLimit: "512Mi"},
+		componentNodeExporter:     {CPURequest: "100m", MemoryRequest: "128Mi", CPULimit: "250m", MemoryLimit: "256Mi"},
+		componentThanosSidecar:    {CPURequest: "250m", MemoryRequest: "256Mi", CPULimit: "1", MemoryLimit: "1Gi"},
+		componentThanosQuery:      {CPURequest: "500m", MemoryRequest: "1Gi", CPULimit: "2", MemoryLimit: "4Gi"},
+		componentThanosStore:      {CPURequest: "500m", MemoryRequest: "2Gi", CPULimit: "2", MemoryLimit: "8Gi"},
+	},
+}
+
+// componentResources merges the resources set on a component spec over the
+// defaults of the stack's profile, so any subset of the four fields can be
+// overridden.
+func componentResources(stack *monitoringv1alpha1.ObservabilityStack, component string, overrides monitoringv1alpha1.ResourceRequirements) (*corev1.ResourceRequirements, error) {
+	return profileResourcesFor(stack.Spec.Profile, component, overrides)
+}
+
+// profileResourcesFor merges the overrides over the defaults of a profile
+func profileResourcesFor(profile monitoringv1alpha1.ResourceProfile, component string, overrides monitoringv1alpha1.ResourceRequirements) (*corev1.ResourceRequirements, error) {
+	if profile == "" {
+		profile = monitoringv1alpha1.ResourceProfileSmall
+	}
+
+	defaults, ok := profileResources[profile][component]
+	if !ok {
+		return nil, fmt.Errorf("no default resources for %s in profile %q", component, profile)
+	}
+
+	merged := defaults
+	if overrides.CPURequest != "" {
+		merged.CPURequest = overrides.CPURequest
+	}
+	if overrides.MemoryRequest != "" {
+		merged.MemoryRequest = overrides.MemoryRequest
+	}
+	if overrides.CPULimit != "" {
+		merged.CPULimit = overrides.CPULimit
+	}
+	if overrides.MemoryLimit != "" {
+		merged.MemoryLimit = overrides.MemoryLimit
+	}
+
+	resources := &corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{},
+		Limits:   corev1.ResourceList{},
+	}
+
+	fields := []struct {
+		name  string
+		value string
+		list  corev1.ResourceList
+		key   corev1.ResourceName
+	}{
+		{"cpuRequest", merged.CPURequest, resources.Requests, corev1.ResourceCPU},
+		{"memoryRequest", merged.MemoryRequest, resources.Requests, corev1.ResourceMemory},
+		{"cpuLimit", merged.CPULimit, resources.Limits, corev1.ResourceCPU},
+		{"memoryLimit", merged.MemoryLimit, resources.Limits, corev1.ResourceMemory},
+	}
+
+	for _, f := range fields {
+		quantity, err := parseQuantity(component, "resources."+f.name, f.value)
+		if err != nil {
+			return nil, err
+		}
+		f.list[f.key] = quantity
+	}
+
+	// The API server rejects pods requesting more than their limit
+	for _, f := range []struct {
+		name, limitName string
+		key             corev1.ResourceName
+		value           string
+	}{
+		{"cpuRequest", "cpuLimit", corev1.ResourceCPU, merged.CPURequest},
+		{"memoryRequest", "memoryLimit", corev1.ResourceMemory, merged.MemoryRequest},
+	} {
+		request, limit := resources.Requests[f.key], resources.Limits[f.key]
+		if request.Cmp(limit) > 0 {
+			return nil, &InvalidQuantityError{
+				Component: component,
+				Field:     fmt.Sprintf("%s.resources.%s", specPaths[component], f.name),
+				Value:     f.value,
+				Err:       fmt.Errorf("exceeds resources.%s %s", f.limitName, limit.String()),
+			}
+		}
+	}
+
+	return resources, nil
+}
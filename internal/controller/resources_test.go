@@ -0,0 +1,72 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("componentResources", func() {
+	It("should use the small profile when none is set", func() {
+		stack := &monitoringv1alpha1.ObservabilityStack{}
+
+		resources, err := componentResources(stack, componentGrafana, monitoringv1alpha1.ResourceRequirements{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resources.Requests[corev1.ResourceCPU]).To(Equal(resource.MustParse("100m")))
+		Expect(resources.Limits[corev1.ResourceMemory]).To(Equal(resource.MustParse("512Mi")))
+	})
+
+	It("should merge partial overrides over the profile defaults", func() {
+		stack := &monitoringv1alpha1.ObservabilityStack{
+			Spec: monitoringv1alpha1.ObservabilityStackSpec{Profile: monitoringv1alpha1.ResourceProfileLarge},
+		}
+
+		resources, err := componentResources(stack, componentLoki, monitoringv1alpha1.ResourceRequirements{MemoryLimit: "12Gi"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resources.Limits[corev1.ResourceMemory]).To(Equal(resource.MustParse("12Gi")))
+		Expect(resources.Limits[corev1.ResourceCPU]).To(Equal(resource.MustParse("4")))
+		Expect(resources.Requests[corev1.ResourceMemory]).To(Equal(resource.MustParse("4Gi")))
+	})
+
+	It("should refuse requests above the merged limits", func() {
+		stack := &monitoringv1alpha1.ObservabilityStack{}
+
+		_, err := componentResources(stack, componentPrometheus, monitoringv1alpha1.ResourceRequirements{MemoryRequest: "4Gi"})
+
+		var quantityErr *InvalidQuantityError
+		Expect(errors.As(err, &quantityErr)).To(BeTrue())
+		Expect(quantityErr.Field).To(Equal("spec.prometheus.resources.memoryRequest"))
+		Expect(err.Error()).To(ContainSubstring("exceeds resources.memoryLimit 2Gi"))
+
+		_, err = componentResources(stack, componentPrometheus, monitoringv1alpha1.ResourceRequirements{MemoryRequest: "4Gi", MemoryLimit: "4Gi"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should return an error for malformed values", func() {
+		stack := &monitoringv1alpha1.ObservabilityStack{}
+
+		_, err := componentResources(stack, componentTempo, monitoringv1alpha1.ResourceRequirements{CPULimit: "lots"})
+		Expect(err).To(HaveOccurred())
+	})
+})
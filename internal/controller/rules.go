@@ -0,0 +1,135 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"regexp"
+
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	durationPattern   = regexp.MustCompile(`^([0-9]+(ms|s|m|h|d|w|y))+$`)
+)
+
+// ruleFile is a file of rule groups in the format shared by Prometheus and
+// Loki's ruler
+type ruleFile struct {
+	Groups []ruleGroup `json:"groups"`
+}
+
+type ruleGroup struct {
+	Name     string `json:"name"`
+	Interval string `json:"interval,omitempty"`
+	Rules    []rule `json:"rules"`
+
+	// source is the group as parsed, including the fields validation does
+	// not look at, such as limit or keep_firing_for
+	source map[string]interface{}
+}
+
+type rule struct {
+	Alert       string            `json:"alert,omitempty"`
+	Record      string            `json:"record,omitempty"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// parseRuleFile parses a rule file that has at least one group, keeping each
+// group as parsed for marshalRuleFile
+func parseRuleFile(data string) (*ruleFile, error) {
+	var file ruleFile
+	if err := yaml.Unmarshal([]byte(data), &file); err != nil {
+		return nil, fmt.Errorf("failed to parse rule groups: %w", err)
+	}
+	if len(file.Groups) == 0 {
+		return nil, fmt.Errorf("no rule groups")
+	}
+
+	var source struct {
+		Groups []map[string]interface{} `json:"groups"`
+	}
+	if err := yaml.Unmarshal([]byte(data), &source); err != nil {
+		return nil, fmt.Errorf("failed to parse rule groups: %w", err)
+	}
+	for i := range file.Groups {
+		file.Groups[i].source = source.Groups[i]
+	}
+	return &file, nil
+}
+
+// marshalRuleFile writes a rule file, with the groups that were parsed as
+// they were written so that no field is lost
+func marshalRuleFile(file ruleFile) ([]byte, error) {
+	groups := make([]interface{}, 0, len(file.Groups))
+	for _, group := range file.Groups {
+		if group.source != nil {
+			groups = append(groups, group.source)
+		} else {
+			groups = append(groups, group)
+		}
+	}
+	return yaml.Marshal(map[string]interface{}{"groups": groups})
+}
+
+// validateRuleGroup checks that a group and its rules are well-formed, using
+// validateExpr for the expressions of the rules
+func validateRuleGroup(group ruleGroup, validateExpr func(string) error) error {
+	if group.Name == "" {
+		return fmt.Errorf("rule group without a name")
+	}
+	if group.Interval != "" && !durationPattern.MatchString(group.Interval) {
+		return fmt.Errorf("rule group %q has invalid interval %q", group.Name, group.Interval)
+	}
+	if len(group.Rules) == 0 {
+		return fmt.Errorf("rule group %q has no rules", group.Name)
+	}
+
+	for i, rule := range group.Rules {
+		if err := validateRule(rule, validateExpr); err != nil {
+			return fmt.Errorf("rule %d of group %q: %w", i, group.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateRule(rule rule, validateExpr func(string) error) error {
+	switch {
+	case rule.Alert == "" && rule.Record == "":
+		return fmt.Errorf("one of alert or record must be set")
+	case rule.Alert != "" && rule.Record != "":
+		return fmt.Errorf("only one of alert or record may be set")
+	case rule.Record != "" && !metricNamePattern.MatchString(rule.Record):
+		return fmt.Errorf("invalid metric name %q", rule.Record)
+	case rule.Record != "" && (rule.For != "" || len(rule.Annotations) > 0):
+		return fmt.Errorf("recording rules take no for or annotations")
+	case rule.For != "" && !durationPattern.MatchString(rule.For):
+		return fmt.Errorf("invalid for duration %q", rule.For)
+	}
+
+	for name := range rule.Labels {
+		if !labelNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return validateExpr(rule.Expr)
+}
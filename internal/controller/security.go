@@ -0,0 +1,134 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+)
+
+// componentUsers holds the user each component's image is built to run as.
+// Promtail runs as root to read the container logs on the node.
+var componentUsers = map[string]int64{
+	componentPrometheus:       65534,
+	componentGrafana:          472,
+	componentLoki:             10001,
+	componentPromtail:         0,
+	componentTempo:            10001,
+	componentKubeStateMetrics: 65534,
+	componentNodeExporter:     65534,
+	componentThanosQuery:      65534,
+	componentThanosStore:      65534,
+}
+
+// tmpVolume is mounted at /tmp in every container, since the root
+// filesystem is read-only
+const tmpVolume = "tmp"
+
+// applyPodSecurity sets the security contexts of a component's pod template.
+// By default pods satisfy the restricted Pod Security Standard: they run as
+// the image's non-root user with a RuntimeDefault seccomp profile, and
+// containers drop all capabilities on a read-only root filesystem. Volumes
+// are made writable through fsGroup rather than a chown init container.
+// Either context can be replaced wholesale from the component spec.
+func applyPodSecurity(template *corev1.PodTemplateSpec, component string, security monitoringv1alpha1.PodSecurity) {
+	podSpec := &template.Spec
+	user := componentUsers[component]
+
+	if security.PodSecurityContext != nil {
+		podSpec.SecurityContext = security.PodSecurityContext.DeepCopy()
+	} else {
+		podSpec.SecurityContext = restrictedPodSecurityContext(user)
+	}
+
+	podSpec.InitContainers = secureContainers(podSpec.InitContainers, user, security.ContainerSecurityContext)
+	podSpec.Containers = secureContainers(podSpec.Containers, user, security.ContainerSecurityContext)
+
+	if !hasVolume(podSpec.Volumes, tmpVolume) {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name:         tmpVolume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+}
+
+func restrictedPodSecurityContext(user int64) *corev1.PodSecurityContext {
+	context := &corev1.PodSecurityContext{
+		RunAsNonRoot: pointer.Bool(user != 0),
+		RunAsUser:    pointer.Int64(user),
+		RunAsGroup:   pointer.Int64(user),
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+	if user != 0 {
+		policy := corev1.FSGroupChangeOnRootMismatch
+		context.FSGroup = pointer.Int64(user)
+		context.FSGroupChangePolicy = &policy
+	}
+	return context
+}
+
+func restrictedContainerSecurityContext(user int64) *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             pointer.Bool(user != 0),
+		AllowPrivilegeEscalation: pointer.Bool(false),
+		ReadOnlyRootFilesystem:   pointer.Bool(true),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+	}
+}
+
+// secureContainers sets the security context of each container and gives it
+// a writable /tmp.
+func secureContainers(containers []corev1.Container, user int64, override *corev1.SecurityContext) []corev1.Container {
+	for i := range containers {
+		if override != nil {
+			containers[i].SecurityContext = override.DeepCopy()
+		} else {
+			containers[i].SecurityContext = restrictedContainerSecurityContext(user)
+		}
+
+		if !hasMountPath(containers[i].VolumeMounts, "/tmp") {
+			containers[i].VolumeMounts = append(containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      tmpVolume,
+				MountPath: "/tmp",
+			})
+		}
+	}
+	return containers
+}
+
+func hasVolume(volumes []corev1.Volume, name string) bool {
+	for _, volume := range volumes {
+		if volume.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMountPath(mounts []corev1.VolumeMount, path string) bool {
+	for _, mount := range mounts {
+		if mount.MountPath == path {
+			return true
+		}
+	}
+	return false
+}
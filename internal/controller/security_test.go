@@ -0,0 +1,73 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/pointer"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("applyPodSecurity", func() {
+	var template corev1.PodTemplateSpec
+
+	BeforeEach(func() {
+		template = corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "loki"}},
+			},
+		}
+	})
+
+	It("should apply restricted defaults", func() {
+		applyPodSecurity(&template, componentLoki, monitoringv1alpha1.PodSecurity{})
+
+		podContext := template.Spec.SecurityContext
+		Expect(*podContext.RunAsNonRoot).To(BeTrue())
+		Expect(*podContext.RunAsUser).To(Equal(int64(10001)))
+		Expect(*podContext.FSGroup).To(Equal(int64(10001)))
+		Expect(podContext.SeccompProfile.Type).To(Equal(corev1.SeccompProfileTypeRuntimeDefault))
+
+		container := template.Spec.Containers[0]
+		Expect(*container.SecurityContext.AllowPrivilegeEscalation).To(BeFalse())
+		Expect(*container.SecurityContext.ReadOnlyRootFilesystem).To(BeTrue())
+		Expect(container.SecurityContext.Capabilities.Drop).To(ConsistOf(corev1.Capability("ALL")))
+		Expect(container.VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: tmpVolume, MountPath: "/tmp"}))
+		Expect(template.Spec.Volumes).To(HaveLen(1))
+	})
+
+	It("should replace the contexts set on the component", func() {
+		applyPodSecurity(&template, componentLoki, monitoringv1alpha1.PodSecurity{
+			PodSecurityContext:       &corev1.PodSecurityContext{RunAsUser: pointer.Int64(1000)},
+			ContainerSecurityContext: &corev1.SecurityContext{ReadOnlyRootFilesystem: pointer.Bool(false)},
+		})
+
+		Expect(template.Spec.SecurityContext).To(Equal(&corev1.PodSecurityContext{RunAsUser: pointer.Int64(1000)}))
+		Expect(template.Spec.Containers[0].SecurityContext).To(Equal(&corev1.SecurityContext{ReadOnlyRootFilesystem: pointer.Bool(false)}))
+	})
+
+	It("should not mark Promtail non-root", func() {
+		applyPodSecurity(&template, componentPromtail, monitoringv1alpha1.PodSecurity{})
+
+		Expect(*template.Spec.SecurityContext.RunAsNonRoot).To(BeFalse())
+		Expect(template.Spec.SecurityContext.FSGroup).To(BeNil())
+		Expect(*template.Spec.Containers[0].SecurityContext.AllowPrivilegeEscalation).To(BeFalse())
+	})
+})
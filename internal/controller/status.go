@@ -0,0 +1,134 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// conditionDegraded is True while the stack cannot be reconciled as specified
+	conditionDegraded = "Degraded"
+
+	reasonReconciled      = "Reconciled"
+	reasonInvalidQuantity = "InvalidQuantity"
+)
+
+// reconcileFailed records a failed reconciliation of a component on the stack.
+// Errors caused by invalid spec values mark the stack Degraded and are not
+// retried, since only a spec change can fix them; any other error is emitted
+// as a Warning event and returned for requeue.
+func (r *ObservabilityStackReconciler) reconcileFailed(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string, err error) (ctrl.Result, error) {
+	reason, _ := invalidSpec(err)
+	if reason == "" {
+		reason = reasonReconcileFailed
+	}
+	reconcileErrors.WithLabelValues(stack.Namespace, stack.Name, component, reason).Inc()
+
+	return reconcileFailed(r.Recorder, stack, component, err, func(reason, message string) error {
+		return r.setDegraded(ctx, stack, metav1.ConditionTrue, reason, message)
+	})
+}
+
+// reconcileFailed records a failed reconciliation of a component on owner.
+// An invalid spec value is passed to degrade, which marks owner Degraded, and
+// is not retried; any other error is emitted as a Warning event and returned
+// for requeue.
+func reconcileFailed(recorder record.EventRecorder, owner client.Object, component string, err error, degrade func(reason, message string) error) (ctrl.Result, error) {
+	if reason, specErr := invalidSpec(err); specErr != nil {
+		recorder.Event(owner, corev1.EventTypeWarning, reason, specErr.Error())
+		if statusErr := degrade(reason, specErr.Error()); statusErr != nil {
+			return ctrl.Result{}, statusErr
+		}
+		return ctrl.Result{}, nil
+	}
+
+	recorder.Eventf(owner, corev1.EventTypeWarning, reasonReconcileFailed, "Failed to reconcile %s: %v", component, err)
+	return ctrl.Result{}, err
+}
+
+// invalidSpec returns the error in err's chain caused by an invalid spec
+// value and the reason to record it under, or nil for any other error.
+func invalidSpec(err error) (string, error) {
+	var quantityErr *InvalidQuantityError
+	if errors.As(err, &quantityErr) {
+		return reasonInvalidQuantity, quantityErr
+	}
+	var versionErr *UnsupportedVersionError
+	if errors.As(err, &versionErr) {
+		return reasonUnsupportedVersion, versionErr
+	}
+	var schemaErr *InvalidSchemaError
+	if errors.As(err, &schemaErr) {
+		return reasonInvalidSchema, schemaErr
+	}
+	var shrinkErr *StorageShrinkError
+	if errors.As(err, &shrinkErr) {
+		return reasonInvalidStorage, shrinkErr
+	}
+	var expansionErr *StorageExpansionError
+	if errors.As(err, &expansionErr) {
+		return reasonInvalidStorage, expansionErr
+	}
+	var claimErr *StorageClaimError
+	if errors.As(err, &claimErr) {
+		return reasonInvalidStorage, claimErr
+	}
+	var alertingErr *InvalidAlertingError
+	if errors.As(err, &alertingErr) {
+		return reasonInvalidAlerting, alertingErr
+	}
+	var kubeStateMetricsErr *InvalidKubeStateMetricsError
+	if errors.As(err, &kubeStateMetricsErr) {
+		return reasonInvalidKubeStateMetrics, kubeStateMetricsErr
+	}
+	var refusedErr *ClusterStackRefusedError
+	if errors.As(err, &refusedErr) {
+		return reasonClusterStackRefused, refusedErr
+	}
+	return "", nil
+}
+
+// setDegraded updates the Degraded condition, writing the status only when the
+// condition actually changed.
+func (r *ObservabilityStackReconciler) setDegraded(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, status metav1.ConditionStatus, reason, message string) error {
+	changed := meta.SetStatusCondition(&stack.Status.Conditions, metav1.Condition{
+		Type:               conditionDegraded,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: stack.Generation,
+	})
+	if !changed {
+		return nil
+	}
+
+	if err := r.Status().Update(ctx, stack); err != nil {
+		return fmt.Errorf("failed to update ObservabilityStack status: %w", err)
+	}
+	return nil
+}
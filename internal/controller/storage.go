@@ -0,0 +1,197 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// parseStorage resolves the storage size of a component. A component kept in
+// an emptyDir needs no size, which then leaves the emptyDir unlimited.
+func parseStorage(component, value string, settings monitoringv1alpha1.StorageSettings) (resource.Quantity, error) {
+	if settings.EmptyDir && value == "" {
+		return resource.Quantity{}, nil
+	}
+	return parseQuantity(component, "storage", value)
+}
+
+// StorageClaimError reports a change to the StorageClass or access modes of
+// an existing volume claim, which Kubernetes does not allow
+type StorageClaimError struct {
+	Claim     string
+	Field     string
+	Current   string
+	Requested string
+}
+
+func (e *StorageClaimError) Error() string {
+	return fmt.Sprintf("%s of volume claim %s cannot change from %s to %s; delete the claim to recreate it", e.Field, e.Claim, e.Current, e.Requested)
+}
+
+// checkClaimSettings reports a StorageClass or access modes of desired that
+// differ from those of the existing claim. A claim without a StorageClass or
+// access modes takes the cluster's defaults, so it matches any existing ones.
+func checkClaimSettings(field string, existing, desired *corev1.PersistentVolumeClaim) error {
+	if desired.Spec.StorageClassName != nil && !equality.Semantic.DeepEqual(existing.Spec.StorageClassName, desired.Spec.StorageClassName) {
+		return &StorageClaimError{
+			Claim:     existing.Name,
+			Field:     field + ".storageClassName",
+			Current:   pointer.StringDeref(existing.Spec.StorageClassName, "<default>"),
+			Requested: *desired.Spec.StorageClassName,
+		}
+	}
+	if len(desired.Spec.AccessModes) > 0 && !equality.Semantic.DeepEqual(existing.Spec.AccessModes, desired.Spec.AccessModes) {
+		return &StorageClaimError{
+			Claim:     existing.Name,
+			Field:     field + ".accessModes",
+			Current:   fmt.Sprint(existing.Spec.AccessModes),
+			Requested: fmt.Sprint(desired.Spec.AccessModes),
+		}
+	}
+	return nil
+}
+
+// emptyDirVolume returns an emptyDir limited to the given size, if any
+func emptyDirVolume(name string, size resource.Quantity) corev1.Volume {
+	emptyDir := &corev1.EmptyDirVolumeSource{}
+	if !size.IsZero() {
+		emptyDir.SizeLimit = &size
+	}
+	return corev1.Volume{
+		Name:         name,
+		VolumeSource: corev1.VolumeSource{EmptyDir: emptyDir},
+	}
+}
+
+// applyStorageClaim sets the StorageClass and access modes of a volume claim.
+// The claim keeps its access modes when none are configured.
+func applyStorageClaim(spec *corev1.PersistentVolumeClaimSpec, settings monitoringv1alpha1.StorageSettings) {
+	if settings.StorageClassName != nil {
+		spec.StorageClassName = settings.StorageClassName
+	}
+	if len(settings.AccessModes) > 0 {
+		spec.AccessModes = append([]corev1.PersistentVolumeAccessMode(nil), settings.AccessModes...)
+	}
+}
+
+// applyStorageSettings applies a component's storage settings to the volume
+// claim templates of its StatefulSet. With an emptyDir, each template becomes
+// an emptyDir volume of the same name, limited to the size it requested.
+func applyStorageSettings(sts *appsv1.StatefulSet, settings monitoringv1alpha1.StorageSettings) {
+	if !settings.EmptyDir {
+		for i := range sts.Spec.VolumeClaimTemplates {
+			applyStorageClaim(&sts.Spec.VolumeClaimTemplates[i].Spec, settings)
+		}
+		return
+	}
+
+	for _, claim := range sts.Spec.VolumeClaimTemplates {
+		size := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		sts.Spec.Template.Spec.Volumes = append(sts.Spec.Template.Spec.Volumes, emptyDirVolume(claim.Name, size))
+	}
+	sts.Spec.VolumeClaimTemplates = nil
+}
+
+// reasonStorageNotShared is the Warning event reason for Grafana replicas
+// that fall back to an emptyDir because their claim cannot be shared
+const reasonStorageNotShared = "StorageNotShared"
+
+// reconcileGrafanaStorage returns the volume holding Grafana's data. Grafana
+// keeps its data in a volume claim when it has storage and no emptyDir, and
+// the claim is created before the volume refers to it. Otherwise the data is
+// kept in an emptyDir, and a claim created before is left in place. Replicas
+// keep their state in the database, so they only share a claim that can be
+// mounted on several nodes, and otherwise fall back to an emptyDir with a
+// Warning event.
+func (r *ObservabilityStackReconciler) reconcileGrafanaStorage(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, labels map[string]string) (corev1.Volume, error) {
+	spec := stack.Spec.Grafana
+	if spec.Storage == "" {
+		return emptyDirVolume("storage", resource.Quantity{}), nil
+	}
+
+	storage, err := parseQuantity(componentGrafana, "storage", spec.Storage)
+	if err != nil {
+		return corev1.Volume{}, fmt.Errorf("failed to resolve Grafana storage: %w", err)
+	}
+	if spec.EmptyDir {
+		return emptyDirVolume("storage", storage), nil
+	}
+	if grafanaHA(stack) && !slices.Contains(spec.AccessModes, corev1.ReadWriteMany) {
+		r.Recorder.Eventf(stack, corev1.EventTypeWarning, reasonStorageNotShared,
+			"Grafana keeps its data in an emptyDir: %d replicas need the ReadWriteMany access mode to share a volume claim", grafanaReplicas(stack))
+		return emptyDirVolume("storage", storage), nil
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-grafana", stack.Name),
+			Namespace: stack.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{
+				corev1.ReadWriteOnce,
+			},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: storage,
+				},
+			},
+		},
+	}
+	applyStorageClaim(&pvc.Spec, spec.StorageSettings)
+
+	// Claims are only created, so a changed class or access mode would be ignored
+	existing := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(pvc), existing); err == nil {
+		if err := checkClaimSettings(specPaths[componentGrafana], existing, pvc); err != nil {
+			return corev1.Volume{}, err
+		}
+	} else if !errors.IsNotFound(err) {
+		return corev1.Volume{}, fmt.Errorf("failed to get Grafana PVC: %w", err)
+	}
+
+	if err := ctrl.SetControllerReference(stack, pvc, r.Scheme); err != nil {
+		return corev1.Volume{}, fmt.Errorf("failed to set controller reference on pvc: %w", err)
+	}
+
+	if err := r.createOrUpdate(ctx, stack, pvc); err != nil {
+		return corev1.Volume{}, fmt.Errorf("failed to reconcile Grafana PVC: %w", err)
+	}
+
+	return corev1.Volume{
+		Name: "storage",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: pvc.Name,
+			},
+		},
+	}, nil
+}
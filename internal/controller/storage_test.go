@@ -0,0 +1,133 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("applyStorageSettings", func() {
+	var sts *appsv1.StatefulSet
+
+	BeforeEach(func() {
+		sts = &appsv1.StatefulSet{
+			Spec: appsv1.StatefulSetSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Volumes: []corev1.Volume{{Name: "config"}},
+					},
+				},
+				VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{
+					ObjectMeta: metav1.ObjectMeta{Name: "storage"},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+						Resources: corev1.VolumeResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("5Gi")},
+						},
+					},
+				}},
+			},
+		}
+	})
+
+	It("should leave the claims to the cluster's defaults", func() {
+		applyStorageSettings(sts, monitoringv1alpha1.StorageSettings{})
+
+		claim := sts.Spec.VolumeClaimTemplates[0]
+		Expect(claim.Spec.StorageClassName).To(BeNil())
+		Expect(claim.Spec.AccessModes).To(ConsistOf(corev1.ReadWriteOnce))
+	})
+
+	It("should set the StorageClass and access modes of the claims", func() {
+		applyStorageSettings(sts, monitoringv1alpha1.StorageSettings{
+			StorageClassName: pointer.String("fast"),
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOncePod},
+		})
+
+		claim := sts.Spec.VolumeClaimTemplates[0]
+		Expect(*claim.Spec.StorageClassName).To(Equal("fast"))
+		Expect(claim.Spec.AccessModes).To(ConsistOf(corev1.ReadWriteOncePod))
+	})
+
+	It("should replace the claims with size-limited emptyDirs", func() {
+		applyStorageSettings(sts, monitoringv1alpha1.StorageSettings{EmptyDir: true})
+
+		Expect(sts.Spec.VolumeClaimTemplates).To(BeEmpty())
+		Expect(sts.Spec.Template.Spec.Volumes).To(HaveLen(2))
+		volume := sts.Spec.Template.Spec.Volumes[1]
+		Expect(volume.Name).To(Equal("storage"))
+		Expect(volume.EmptyDir.SizeLimit.String()).To(Equal("5Gi"))
+	})
+})
+
+var _ = Describe("parseStorage", func() {
+	It("should require storage for a volume claim", func() {
+		_, err := parseStorage(componentPrometheus, "", monitoringv1alpha1.StorageSettings{})
+		Expect(err).To(MatchError("spec.prometheus.storage must be set"))
+	})
+
+	It("should leave an emptyDir without storage unlimited", func() {
+		storage, err := parseStorage(componentPrometheus, "", monitoringv1alpha1.StorageSettings{EmptyDir: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(storage.IsZero()).To(BeTrue())
+
+		Expect(emptyDirVolume("storage", storage).EmptyDir.SizeLimit).To(BeNil())
+	})
+})
+
+var _ = Describe("checkClaimSettings", func() {
+	claim := func(class *string, modes ...corev1.PersistentVolumeAccessMode) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "stack-grafana"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: class,
+				AccessModes:      modes,
+			},
+		}
+	}
+
+	It("should accept a claim that takes the default StorageClass", func() {
+		existing := claim(pointer.String("standard"), corev1.ReadWriteOnce)
+		Expect(checkClaimSettings("spec.grafana", existing, claim(nil, corev1.ReadWriteOnce))).To(Succeed())
+	})
+
+	It("should refuse a changed StorageClass", func() {
+		existing := claim(pointer.String("standard"), corev1.ReadWriteOnce)
+		err := checkClaimSettings("spec.grafana", existing, claim(pointer.String("fast"), corev1.ReadWriteOnce))
+
+		var claimErr *StorageClaimError
+		Expect(err).To(BeAssignableToTypeOf(claimErr))
+		Expect(err.Error()).To(ContainSubstring("spec.grafana.storageClassName of volume claim stack-grafana cannot change from standard to fast"))
+
+		reason, _ := invalidSpec(err)
+		Expect(reason).To(Equal(reasonInvalidStorage))
+	})
+
+	It("should refuse changed access modes", func() {
+		existing := claim(nil, corev1.ReadWriteOnce)
+		err := checkClaimSettings("spec.grafana", existing, claim(nil, corev1.ReadWriteMany))
+		Expect(err).To(MatchError(ContainSubstring("spec.grafana.accessModes")))
+	})
+})
@@ -0,0 +1,258 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// tenantHeader carries the tenant of every request to Loki and Tempo
+	tenantHeader = "X-Scope-OrgID"
+
+	lokiRuntimeConfigKey = "runtime.yaml"
+	tempoOverridesKey    = "overrides.yaml"
+	lokiConfigMountPath  = "/etc/loki"
+	bytesPerMB           = 1024 * 1024
+	hoursPerDay          = 24
+)
+
+// tenantDataSourceNames names the per-tenant data sources of each backend
+var tenantDataSourceNames = map[string]string{
+	"loki":  "Loki",
+	"tempo": "Tempo",
+}
+
+// multiTenant reports whether Loki and Tempo require a tenant on every request
+func multiTenant(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	return stack.Spec.MultiTenancy.Enabled
+}
+
+func defaultTenant(stack *monitoringv1alpha1.ObservabilityStack) string {
+	if stack.Spec.MultiTenancy.DefaultTenant == "" {
+		return "fake"
+	}
+	return stack.Spec.MultiTenancy.DefaultTenant
+}
+
+// setLokiTenancy enables authentication in Loki and writes the per-tenant
+// limits to a runtime configuration file next to loki.yaml, which Loki
+// reloads without a restart.
+func setLokiTenancy(configMap *corev1.ConfigMap, stack *monitoringv1alpha1.ObservabilityStack) error {
+	overrides := map[string]interface{}{}
+	for _, tenant := range stack.Spec.MultiTenancy.Tenants {
+		limits := map[string]interface{}{}
+		if tenant.IngestionRateMB != nil {
+			limits["ingestion_rate_mb"] = *tenant.IngestionRateMB
+		}
+		if tenant.IngestionBurstSizeMB != nil {
+			limits["ingestion_burst_size_mb"] = *tenant.IngestionBurstSizeMB
+		}
+		if tenant.RetentionDays != nil {
+			limits["retention_period"] = fmt.Sprintf("%dh", *tenant.RetentionDays*hoursPerDay)
+		}
+		if tenant.MaxStreams != nil {
+			limits["max_global_streams_per_user"] = *tenant.MaxStreams
+		}
+		overrides[tenant.Name] = limits
+	}
+
+	if err := setOverridesFile(configMap, lokiRuntimeConfigKey, overrides); err != nil {
+		return err
+	}
+
+	return patchYAMLConfig(configMap, "loki.yaml", func(config map[string]interface{}) {
+		config["auth_enabled"] = true
+		config["runtime_config"] = map[string]interface{}{
+			"file": path.Join(lokiConfigMountPath, lokiRuntimeConfigKey),
+		}
+	})
+}
+
+// setTempoTenancy enables multi-tenancy in Tempo and writes the per-tenant
+// overrides next to its configuration, which is mounted at mountPath.
+func setTempoTenancy(configMap *corev1.ConfigMap, key, mountPath string, stack *monitoringv1alpha1.ObservabilityStack) error {
+	overrides := map[string]interface{}{}
+	for _, tenant := range stack.Spec.MultiTenancy.Tenants {
+		limits := map[string]interface{}{}
+		if tenant.IngestionRateMB != nil {
+			limits["ingestion_rate_limit_bytes"] = int64(*tenant.IngestionRateMB) * bytesPerMB
+		}
+		if tenant.IngestionBurstSizeMB != nil {
+			limits["ingestion_burst_size_bytes"] = int64(*tenant.IngestionBurstSizeMB) * bytesPerMB
+		}
+		if tenant.RetentionDays != nil {
+			limits["block_retention"] = fmt.Sprintf("%dh", *tenant.RetentionDays*hoursPerDay)
+		}
+		if tenant.MaxTraces != nil {
+			limits["max_traces_per_user"] = *tenant.MaxTraces
+		}
+		overrides[tenant.Name] = limits
+	}
+
+	if err := setOverridesFile(configMap, tempoOverridesKey, overrides); err != nil {
+		return err
+	}
+
+	return patchYAMLConfig(configMap, key, func(config map[string]interface{}) {
+		config["multitenancy_enabled"] = true
+		defaults, _ := config["overrides"].(map[string]interface{})
+		if defaults == nil {
+			defaults = map[string]interface{}{}
+		}
+		defaults["per_tenant_override_config"] = path.Join(mountPath, tempoOverridesKey)
+		config["overrides"] = defaults
+	})
+}
+
+func setOverridesFile(configMap *corev1.ConfigMap, key string, overrides map[string]interface{}) error {
+	out, err := yaml.Marshal(map[string]interface{}{"overrides": overrides})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[key] = string(out)
+	return nil
+}
+
+// configMountPath returns where a pod template mounts the ConfigMap
+func configMountPath(template *corev1.PodTemplateSpec, configMapName string) (string, error) {
+	for _, volume := range template.Spec.Volumes {
+		if volume.ConfigMap == nil || volume.ConfigMap.Name != configMapName {
+			continue
+		}
+		for _, container := range template.Spec.Containers {
+			for _, mount := range container.VolumeMounts {
+				if mount.Name == volume.Name && mount.SubPath == "" {
+					return mount.MountPath, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("ConfigMap %s is not mounted as a directory", configMapName)
+}
+
+// setPromtailTenancy sends logs under the default tenant, except for the
+// namespaces claimed by a tenant, which a tenant stage assigns to it.
+func setPromtailTenancy(configMap *corev1.ConfigMap, key string, stack *monitoringv1alpha1.ObservabilityStack) error {
+	var stages []interface{}
+	for _, tenant := range stack.Spec.MultiTenancy.Tenants {
+		if len(tenant.Namespaces) == 0 {
+			continue
+		}
+		stages = append(stages, map[string]interface{}{
+			"match": map[string]interface{}{
+				"selector": fmt.Sprintf(`{namespace=~"%s"}`, strings.Join(tenant.Namespaces, "|")),
+				"stages": []interface{}{
+					map[string]interface{}{"tenant": map[string]interface{}{"value": tenant.Name}},
+				},
+			},
+		})
+	}
+
+	return patchYAMLConfig(configMap, key, func(config map[string]interface{}) {
+		clients, _ := config["clients"].([]interface{})
+		for _, c := range clients {
+			if client, ok := c.(map[string]interface{}); ok {
+				client["tenant_id"] = defaultTenant(stack)
+			}
+		}
+
+		scrapeConfigs, _ := config["scrape_configs"].([]interface{})
+		for _, sc := range scrapeConfigs {
+			job, ok := sc.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			pipeline, _ := job["pipeline_stages"].([]interface{})
+			job["pipeline_stages"] = append(pipeline, stages...)
+		}
+	})
+}
+
+// setGrafanaTenancy sends the default tenant from the existing Loki and Tempo
+// data sources of the stack and adds a pair of data sources per tenant.
+func setGrafanaTenancy(configMap *corev1.ConfigMap, stack *monitoringv1alpha1.ObservabilityStack) error {
+	if _, ok := configMap.Data["datasources.yaml"]; !ok {
+		return nil
+	}
+
+	backends := map[string]string{}
+	if stack.Spec.Loki.Enabled {
+		backends["loki"] = serviceURL(stack, componentLoki, portLokiHTTP)
+	}
+	if stack.Spec.Tempo.Enabled {
+		backends["tempo"] = serviceURL(stack, componentTempo, portTempoHTTP)
+	}
+
+	return patchYAMLConfig(configMap, "datasources.yaml", func(config map[string]interface{}) {
+		datasources, _ := config["datasources"].([]interface{})
+		for _, d := range datasources {
+			datasource, ok := d.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			url, _ := datasource["url"].(string)
+			if strings.Contains(url, fmt.Sprintf("//%s-%s:", stack.Name, componentLoki)) ||
+				strings.Contains(url, fmt.Sprintf("//%s-%s:", stack.Name, componentTempo)) {
+				setTenantHeader(datasource, defaultTenant(stack))
+			}
+		}
+
+		for _, tenant := range stack.Spec.MultiTenancy.Tenants {
+			for _, kind := range []string{"loki", "tempo"} {
+				url, ok := backends[kind]
+				if !ok {
+					continue
+				}
+				datasource := map[string]interface{}{
+					"name":   fmt.Sprintf("%s (%s)", tenantDataSourceNames[kind], tenant.Name),
+					"type":   kind,
+					"access": "proxy",
+					"url":    url,
+				}
+				setTenantHeader(datasource, tenant.Name)
+				datasources = append(datasources, datasource)
+			}
+		}
+		config["datasources"] = datasources
+	})
+}
+
+func setTenantHeader(datasource map[string]interface{}, tenant string) {
+	jsonData, _ := datasource["jsonData"].(map[string]interface{})
+	if jsonData == nil {
+		jsonData = map[string]interface{}{}
+	}
+	jsonData["httpHeaderName1"] = tenantHeader
+	datasource["jsonData"] = jsonData
+
+	secureJSONData, _ := datasource["secureJsonData"].(map[string]interface{})
+	if secureJSONData == nil {
+		secureJSONData = map[string]interface{}{}
+	}
+	secureJSONData["httpHeaderValue1"] = tenant
+	datasource["secureJsonData"] = secureJSONData
+}
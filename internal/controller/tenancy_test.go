@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Multi-tenancy", func() {
+	var stack *monitoringv1alpha1.ObservabilityStack
+
+	BeforeEach(func() {
+		stack = &monitoringv1alpha1.ObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "monitoring"},
+			Spec: monitoringv1alpha1.ObservabilityStackSpec{
+				Loki: monitoringv1alpha1.LokiSpec{Enabled: true},
+				MultiTenancy: monitoringv1alpha1.MultiTenancySpec{
+					Enabled:       true,
+					DefaultTenant: "platform",
+					Tenants: []monitoringv1alpha1.TenantSpec{{
+						Name:            "team-a",
+						Namespaces:      []string{"shop", "checkout"},
+						IngestionRateMB: pointer.Int32(8),
+						RetentionDays:   pointer.Int32(30),
+					}},
+				},
+			},
+		}
+	})
+
+	It("should enable authentication in Loki with per-tenant limits", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"loki.yaml": "auth_enabled: false\n"}}
+
+		Expect(setLokiTenancy(configMap, stack)).To(Succeed())
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("auth_enabled: true"))
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("file: /etc/loki/runtime.yaml"))
+		Expect(configMap.Data[lokiRuntimeConfigKey]).To(Equal(
+			"overrides:\n  team-a:\n    ingestion_rate_mb: 8\n    retention_period: 720h\n"))
+	})
+
+	It("should route namespaces to their tenant in Promtail", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"promtail.yaml": `clients:
+- url: http://test-loki:3100/loki/api/v1/push
+scrape_configs:
+- job_name: kubernetes-pods
+`}}
+
+		Expect(setPromtailTenancy(configMap, "promtail.yaml", stack)).To(Succeed())
+		Expect(configMap.Data["promtail.yaml"]).To(ContainSubstring("tenant_id: platform"))
+		Expect(configMap.Data["promtail.yaml"]).To(ContainSubstring(`selector: '{namespace=~"shop|checkout"}'`))
+		Expect(configMap.Data["promtail.yaml"]).To(ContainSubstring("value: team-a"))
+	})
+
+	It("should add a Grafana data source per tenant", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"datasources.yaml": `apiVersion: 1
+datasources:
+- name: loki
+  type: loki
+  url: http://test-loki:3100
+`}}
+
+		Expect(setGrafanaTenancy(configMap, stack)).To(Succeed())
+		Expect(configMap.Data["datasources.yaml"]).To(ContainSubstring("httpHeaderValue1: platform"))
+		Expect(configMap.Data["datasources.yaml"]).To(ContainSubstring("name: Loki (team-a)"))
+		Expect(configMap.Data["datasources.yaml"]).To(ContainSubstring("httpHeaderValue1: team-a"))
+		Expect(configMap.Data["datasources.yaml"]).NotTo(ContainSubstring("Tempo"))
+	})
+})
@@ -0,0 +1,413 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// thanosObjstoreMountPath holds the object storage configuration as
+	// objstore.yml
+	thanosObjstoreMountPath = "/etc/thanos"
+	thanosObjstoreVolume    = "objstore"
+
+	// thanosBlockDuration is the fixed length of the TSDB blocks the sidecar
+	// uploads. Prometheus must not compact them, or blocks get uploaded twice.
+	thanosBlockDuration = "2h"
+
+	// thanosExternalLabel identifies the series of the stack's Prometheus
+	// among every store Thanos Query reads
+	thanosExternalLabel = "prometheus"
+)
+
+// thanosEnabled reports whether Prometheus runs with a Thanos sidecar
+func thanosEnabled(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	return stack.Spec.Prometheus.Enabled && stack.Spec.Prometheus.Thanos.Enabled
+}
+
+// thanosUploads reports whether the sidecar uploads blocks to object storage
+func thanosUploads(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	return thanosEnabled(stack) && stack.Spec.Prometheus.Thanos.ObjectStorageConfig != nil
+}
+
+func thanosQueryEnabled(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	return thanosEnabled(stack) && stack.Spec.Prometheus.Thanos.Query.Enabled
+}
+
+func thanosStoreEnabled(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	return thanosUploads(stack) && stack.Spec.Prometheus.Thanos.StoreGateway.Enabled
+}
+
+// thanosImage resolves the Thanos image shared by the sidecar, Query and the
+// Store Gateway
+func thanosImage(stack *monitoringv1alpha1.ObservabilityStack) (string, error) {
+	version, err := resolveVersion(componentThanos, "spec.prometheus.thanos.version", stack.Spec.Prometheus.Thanos.Version)
+	if err != nil {
+		return "", err
+	}
+	return componentImage(componentThanos, version), nil
+}
+
+// checkObjectStorageConfig fails while the Secret holding the object storage
+// configuration is missing, rather than leaving pods unable to start
+func (r *ObservabilityStackReconciler) checkObjectStorageConfig(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	selector := stack.Spec.Prometheus.Thanos.ObjectStorageConfig
+	return r.checkSecretKeys(ctx, stack.Namespace, "object storage", selector.Name, selector.Key)
+}
+
+// checkSecretKeys returns an error unless a Secret exists and holds every
+// key. description names the Secret's purpose in the error.
+func (r *ObservabilityStackReconciler) checkSecretKeys(ctx context.Context, namespace, description, name string, keys ...string) error {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("%s Secret %s not found", description, name)
+		}
+		return fmt.Errorf("failed to get %s Secret %s: %w", description, name, err)
+	}
+	for _, key := range keys {
+		if _, ok := secret.Data[key]; !ok {
+			return fmt.Errorf("%s Secret %s has no key %s", description, name, key)
+		}
+	}
+	return nil
+}
+
+// setThanosExternalLabels labels the series of the stack's Prometheus, which
+// the sidecar requires to tell its data apart from other stores'
+func setThanosExternalLabels(configMap *corev1.ConfigMap, stack *monitoringv1alpha1.ObservabilityStack) error {
+	return patchYAMLConfig(configMap, "prometheus.yml", func(config map[string]interface{}) {
+		global, _ := config["global"].(map[string]interface{})
+		if global == nil {
+			global = map[string]interface{}{}
+		}
+		externalLabels, _ := global["external_labels"].(map[string]interface{})
+		if externalLabels == nil {
+			externalLabels = map[string]interface{}{}
+		}
+		externalLabels[thanosExternalLabel] = fmt.Sprintf("%s/%s", stack.Namespace, stack.Name)
+		global["external_labels"] = externalLabels
+		config["global"] = global
+	})
+}
+
+// objectStorageVolume mounts the object storage configuration as objstore.yml
+func objectStorageVolume(selector *corev1.SecretKeySelector) corev1.Volume {
+	return corev1.Volume{
+		Name: thanosObjstoreVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: selector.Name,
+				Items:      []corev1.KeyToPath{{Key: selector.Key, Path: "objstore.yml"}},
+			},
+		},
+	}
+}
+
+// thanosPorts are the gRPC Store API and HTTP ports of every Thanos component
+func thanosPorts() []corev1.ContainerPort {
+	return []corev1.ContainerPort{
+		{Name: "grpc", ContainerPort: portThanosGRPC},
+		{Name: "http", ContainerPort: portThanosHTTP},
+	}
+}
+
+func thanosListenArgs() []string {
+	return []string{
+		fmt.Sprintf("--grpc-address=0.0.0.0:%d", portThanosGRPC),
+		fmt.Sprintf("--http-address=0.0.0.0:%d", portThanosHTTP),
+	}
+}
+
+func thanosReadinessProbe() *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/-/ready", Port: intstr.FromString("http")},
+		},
+	}
+}
+
+// addThanosSidecar adds the sidecar to the Prometheus pod. It serves the
+// Store API over the data Prometheus holds and, with object storage, uploads
+// every block Prometheus completes, for which Prometheus must cut blocks of
+// a fixed length and leave their compaction to Thanos.
+func addThanosSidecar(sts *appsv1.StatefulSet, stack *monitoringv1alpha1.ObservabilityStack, image string, resources *corev1.ResourceRequirements) {
+	podSpec := &sts.Spec.Template.Spec
+
+	args := append([]string{
+		"sidecar",
+		"--tsdb.path=/prometheus",
+		fmt.Sprintf("--prometheus.url=%s://localhost:%d", serviceURLScheme(stack), portPrometheus),
+	}, thanosListenArgs()...)
+	mounts := []corev1.VolumeMount{{Name: "storage", MountPath: "/prometheus"}}
+
+	if tlsEnabled(stack) {
+		// Prometheus' certificate is issued for its Service, not localhost
+		args = append(args, fmt.Sprintf(`--prometheus.http-client={"tls_config": {"ca_file": "%s/ca.crt", "server_name": "%s-%s"}}`,
+			tlsMountPath, stack.Name, componentPrometheus))
+		mounts = append(mounts, corev1.VolumeMount{Name: tlsVolume, MountPath: tlsMountPath, ReadOnly: true})
+	}
+
+	if thanosUploads(stack) {
+		args = append(args, fmt.Sprintf("--objstore.config-file=%s/objstore.yml", thanosObjstoreMountPath))
+		mounts = append(mounts, corev1.VolumeMount{Name: thanosObjstoreVolume, MountPath: thanosObjstoreMountPath, ReadOnly: true})
+		podSpec.Volumes = append(podSpec.Volumes, objectStorageVolume(stack.Spec.Prometheus.Thanos.ObjectStorageConfig))
+
+		prometheus := &podSpec.Containers[0]
+		prometheus.Args = append(prometheus.Args,
+			"--storage.tsdb.min-block-duration="+thanosBlockDuration,
+			"--storage.tsdb.max-block-duration="+thanosBlockDuration)
+	}
+
+	podSpec.Containers = append(podSpec.Containers, corev1.Container{
+		Name:           "thanos-sidecar",
+		Image:          image,
+		Args:           args,
+		Ports:          thanosPorts(),
+		Resources:      *resources,
+		VolumeMounts:   mounts,
+		ReadinessProbe: thanosReadinessProbe(),
+	})
+}
+
+// thanosService builds the Service of a Thanos component
+func thanosService(name, namespace string, labels map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "grpc", Port: portThanosGRPC, TargetPort: intstr.FromString("grpc")},
+				{Name: "http", Port: portThanosHTTP, TargetPort: intstr.FromString("http")},
+			},
+			Selector: selectorLabels(labels),
+		},
+	}
+}
+
+func thanosLabels(stack *monitoringv1alpha1.ObservabilityStack, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       component,
+		"app.kubernetes.io/instance":   stack.Name,
+		"app.kubernetes.io/managed-by": "kube-insight-operator",
+	}
+}
+
+// thanosQueryEndpoints lists the Store API endpoints Thanos Query reads: the
+// sidecar through the Prometheus Service, and the Store Gateway
+func thanosQueryEndpoints(stack *monitoringv1alpha1.ObservabilityStack) []string {
+	endpoints := []string{fmt.Sprintf("%s-%s:%d", stack.Name, componentPrometheus, portThanosGRPC)}
+	if thanosStoreEnabled(stack) {
+		endpoints = append(endpoints, fmt.Sprintf("%s-%s:%d", stack.Name, componentThanosStore, portThanosGRPC))
+	}
+	return endpoints
+}
+
+// reconcileThanosQuery runs Thanos Query
+func (r *ObservabilityStackReconciler) reconcileThanosQuery(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	name := fmt.Sprintf("%s-%s", stack.Name, componentThanosQuery)
+
+	spec := stack.Spec.Prometheus.Thanos.Query
+	image, err := thanosImage(stack)
+	if err != nil {
+		return err
+	}
+	resources, err := componentResources(stack, componentThanosQuery, spec.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Thanos Query resources: %w", err)
+	}
+
+	args := append([]string{"query"}, thanosListenArgs()...)
+	for _, endpoint := range thanosQueryEndpoints(stack) {
+		args = append(args, "--endpoint="+endpoint)
+	}
+
+	labels := thanosLabels(stack, componentThanosQuery)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: stack.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: spec.Replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: selectorLabels(labels)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: selectorLabels(labels)},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:           "thanos-query",
+						Image:          image,
+						Args:           args,
+						Ports:          thanosPorts(),
+						Resources:      *resources,
+						ReadinessProbe: thanosReadinessProbe(),
+					}},
+				},
+			},
+		},
+	}
+
+	applyPodPlacement(&deployment.Spec.Template, spec.PodPlacement)
+	applyPodSecurity(&deployment.Spec.Template, componentThanosQuery, spec.PodSecurity)
+
+	return r.applyThanosComponent(ctx, stack, deployment, thanosService(name, stack.Namespace, labels))
+}
+
+// reconcileThanosStore runs the Store Gateway. Its volume only caches index
+// headers, so it is kept small.
+func (r *ObservabilityStackReconciler) reconcileThanosStore(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) error {
+	name := fmt.Sprintf("%s-%s", stack.Name, componentThanosStore)
+
+	spec := stack.Spec.Prometheus.Thanos.StoreGateway
+	image, err := thanosImage(stack)
+	if err != nil {
+		return err
+	}
+	if err := r.checkObjectStorageConfig(ctx, stack); err != nil {
+		return err
+	}
+	resources, err := componentResources(stack, componentThanosStore, spec.Resources)
+	if err != nil {
+		return fmt.Errorf("failed to resolve Thanos Store Gateway resources: %w", err)
+	}
+	storage, err := parseQuantity(componentThanosStore, "storage", defaultString(spec.Storage, "10Gi"))
+	if err != nil {
+		return fmt.Errorf("failed to resolve Thanos Store Gateway storage: %w", err)
+	}
+
+	args := append([]string{
+		"store",
+		"--data-dir=/data",
+		fmt.Sprintf("--objstore.config-file=%s/objstore.yml", thanosObjstoreMountPath),
+	}, thanosListenArgs()...)
+
+	labels := thanosLabels(stack, componentThanosStore)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: stack.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			ServiceName: name,
+			Replicas:    pointer.Int32(1),
+			Selector:    &metav1.LabelSelector{MatchLabels: selectorLabels(labels)},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: selectorLabels(labels)},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:      "thanos-store",
+						Image:     image,
+						Args:      args,
+						Ports:     thanosPorts(),
+						Resources: *resources,
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "data", MountPath: "/data"},
+							{Name: thanosObjstoreVolume, MountPath: thanosObjstoreMountPath, ReadOnly: true},
+						},
+						ReadinessProbe: thanosReadinessProbe(),
+					}},
+					Volumes: []corev1.Volume{objectStorageVolume(stack.Spec.Prometheus.Thanos.ObjectStorageConfig)},
+				},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{{
+				ObjectMeta: metav1.ObjectMeta{Name: "data"},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					Resources: corev1.VolumeResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceStorage: storage},
+					},
+				},
+			}},
+		},
+	}
+
+	applyStorageSettings(sts, spec.StorageSettings)
+	applyPodPlacement(&sts.Spec.Template, spec.PodPlacement)
+	applyPodSecurity(&sts.Spec.Template, componentThanosStore, spec.PodSecurity)
+
+	return r.applyThanosComponent(ctx, stack, sts, thanosService(name, stack.Namespace, labels))
+}
+
+// applyThanosComponent creates or updates the workload and Service of a
+// Thanos component
+func (r *ObservabilityStackReconciler) applyThanosComponent(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, workload client.Object, service *corev1.Service) error {
+	for _, obj := range []client.Object{workload, service} {
+		if err := ctrl.SetControllerReference(stack, obj, r.Scheme); err != nil {
+			return fmt.Errorf("failed to set controller reference on %s: %w", obj.GetName(), err)
+		}
+		if err := r.createOrUpdate(ctx, stack, obj); err != nil {
+			return fmt.Errorf("failed to reconcile %s: %w", obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// deleteThanosComponent removes the workload and Service of a disabled Thanos
+// component once, when it is turned off; objects already gone are not
+// deleted again. The Store Gateway's cache volume is left behind like the
+// volumes of other StatefulSets.
+func (r *ObservabilityStackReconciler) deleteThanosComponent(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string, workload client.Object) error {
+	name := fmt.Sprintf("%s-%s", stack.Name, component)
+	for _, obj := range []client.Object{workload, &corev1.Service{}} {
+		obj.SetName(name)
+		obj.SetNamespace(stack.Namespace)
+		if err := r.delete(ctx, stack, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addThanosDataSource adds Thanos Query as a Grafana data source, for
+// queries reaching past the retention of Prometheus
+func addThanosDataSource(configMap *corev1.ConfigMap, stack *monitoringv1alpha1.ObservabilityStack) error {
+	if _, ok := configMap.Data["datasources.yaml"]; !ok {
+		return nil
+	}
+
+	return patchYAMLConfig(configMap, "datasources.yaml", func(config map[string]interface{}) {
+		datasources, _ := config["datasources"].([]interface{})
+		for _, d := range datasources {
+			if datasource, ok := d.(map[string]interface{}); ok && datasource["name"] == "Thanos" {
+				return
+			}
+		}
+		config["datasources"] = append(datasources, map[string]interface{}{
+			"name":   "Thanos",
+			"type":   "prometheus",
+			"access": "proxy",
+			"url":    fmt.Sprintf("http://%s-%s:%d", stack.Name, componentThanosQuery, portThanosHTTP),
+		})
+	})
+}
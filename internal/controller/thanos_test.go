@@ -0,0 +1,148 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/yaml"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Thanos", func() {
+	var stack *monitoringv1alpha1.ObservabilityStack
+
+	BeforeEach(func() {
+		stack = &monitoringv1alpha1.ObservabilityStack{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "monitoring"},
+			Spec: monitoringv1alpha1.ObservabilityStackSpec{
+				Prometheus: monitoringv1alpha1.PrometheusSpec{
+					Enabled: true,
+					Thanos: monitoringv1alpha1.ThanosSpec{
+						Enabled: true,
+						ObjectStorageConfig: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "objstore"},
+							Key:                  "objstore.yml",
+						},
+					},
+				},
+			},
+		}
+	})
+
+	prometheusStatefulSet := func() *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "prometheus", Args: []string{"--storage.tsdb.path=/prometheus"}}},
+		}}}}
+	}
+
+	It("should upload fixed-length blocks from the sidecar", func() {
+		sts := prometheusStatefulSet()
+		addThanosSidecar(sts, stack, "quay.io/thanos/thanos:v0.34.1", &corev1.ResourceRequirements{})
+
+		Expect(sts.Spec.Template.Spec.Containers).To(HaveLen(2))
+		Expect(sts.Spec.Template.Spec.Containers[0].Args).To(ContainElements(
+			"--storage.tsdb.min-block-duration=2h", "--storage.tsdb.max-block-duration=2h"))
+
+		sidecar := sts.Spec.Template.Spec.Containers[1]
+		Expect(sidecar.Args).To(ContainElements("sidecar", "--prometheus.url=http://localhost:9090", "--objstore.config-file=/etc/thanos/objstore.yml"))
+		Expect(sidecar.VolumeMounts).To(ContainElement(HaveField("MountPath", "/prometheus")))
+		Expect(sts.Spec.Template.Spec.Volumes[0].Secret.Items).To(ConsistOf(corev1.KeyToPath{Key: "objstore.yml", Path: "objstore.yml"}))
+	})
+
+	It("should leave compaction to Prometheus without object storage", func() {
+		stack.Spec.Prometheus.Thanos.ObjectStorageConfig = nil
+		sts := prometheusStatefulSet()
+		addThanosSidecar(sts, stack, "quay.io/thanos/thanos:v0.34.1", &corev1.ResourceRequirements{})
+
+		Expect(sts.Spec.Template.Spec.Containers[0].Args).To(HaveLen(1))
+		Expect(sts.Spec.Template.Spec.Containers[1].Args).NotTo(ContainElement(HavePrefix("--objstore")))
+		Expect(sts.Spec.Template.Spec.Volumes).To(BeEmpty())
+	})
+
+	It("should only run the Store Gateway with object storage", func() {
+		stack.Spec.Prometheus.Thanos.StoreGateway.Enabled = true
+		Expect(thanosStoreEnabled(stack)).To(BeTrue())
+		Expect(thanosQueryEndpoints(stack)).To(ConsistOf("test-prometheus:10901", "test-thanos-store:10901"))
+
+		stack.Spec.Prometheus.Thanos.ObjectStorageConfig = nil
+		Expect(thanosStoreEnabled(stack)).To(BeFalse())
+		Expect(thanosQueryEndpoints(stack)).To(ConsistOf("test-prometheus:10901"))
+	})
+
+	It("should label the series of the stack's Prometheus", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"prometheus.yml": "global:\n  scrape_interval: 15s\n"}}
+		Expect(setThanosExternalLabels(configMap, stack)).To(Succeed())
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("prometheus: monitoring/test"))
+		Expect(configMap.Data["prometheus.yml"]).To(ContainSubstring("scrape_interval: 15s"))
+	})
+
+	It("should add Thanos Query as a Grafana data source once", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"datasources.yaml": "apiVersion: 1\ndatasources: []\n"}}
+		Expect(addThanosDataSource(configMap, stack)).To(Succeed())
+		Expect(addThanosDataSource(configMap, stack)).To(Succeed())
+
+		var config struct {
+			DataSources []map[string]interface{} `json:"datasources"`
+		}
+		Expect(yaml.Unmarshal([]byte(configMap.Data["datasources.yaml"]), &config)).To(Succeed())
+		Expect(config.DataSources).To(Equal([]map[string]interface{}{{
+			"name":   "Thanos",
+			"type":   "prometheus",
+			"access": "proxy",
+			"url":    "http://test-thanos-query:10902",
+		}}))
+	})
+
+	It("should only delete Thanos Query when it is turned off", func() {
+		deletes := 0
+		c := interceptor.NewClient(newFakeClient(stack, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-thanos-query", Namespace: "monitoring"},
+		}).(client.WithWatch), interceptor.Funcs{
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				deletes++
+				return c.Delete(ctx, obj, opts...)
+			},
+		})
+		r := &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: record.NewFakeRecorder(10)}
+
+		Expect(r.deleteThanosComponent(context.Background(), stack, componentThanosQuery, &appsv1.Deployment{})).To(Succeed())
+		Expect(deletes).To(Equal(1))
+		Expect(r.deleteThanosComponent(context.Background(), stack, componentThanosQuery, &appsv1.Deployment{})).To(Succeed())
+		Expect(deletes).To(Equal(1))
+	})
+
+	It("should admit Thanos Query to the sidecar and the Store Gateway", func() {
+		stack.Spec.Prometheus.Thanos.Query.Enabled = true
+		policy := networkPolicyFor(stack, componentPrometheus)
+		Expect(policy.Spec.Ingress).To(HaveLen(2))
+		Expect(policy.Spec.Ingress[1].Ports[0].Port.IntValue()).To(Equal(portThanosGRPC))
+		Expect(policy.Spec.Ingress[1].From[0].PodSelector.MatchLabels).To(HaveKeyWithValue("app.kubernetes.io/name", componentThanosQuery))
+
+		policy = networkPolicyFor(stack, componentThanosStore)
+		Expect(policy.Spec.Ingress[0].From[0].PodSelector.MatchLabels).To(HaveKeyWithValue("app.kubernetes.io/name", componentThanosQuery))
+	})
+})
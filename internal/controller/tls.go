@@ -0,0 +1,412 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// tlsMountPath holds the serving certificate of a component
+	tlsMountPath = "/etc/tls"
+	// trustedCAMountPath holds the CAs of the servers a component talks to
+	trustedCAMountPath = "/etc/tls-ca"
+
+	tlsVolume       = "tls"
+	trustedCAVolume = "tls-ca"
+)
+
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// tlsEnabled reports whether the stack serves its components over HTTPS
+func tlsEnabled(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	return stack.Spec.TLS.Enabled
+}
+
+// serviceURL returns the in-cluster URL of a component's Service
+func serviceURL(stack *monitoringv1alpha1.ObservabilityStack, component string, port int) string {
+	return fmt.Sprintf("%s://%s-%s:%d", serviceURLScheme(stack), stack.Name, component, port)
+}
+
+// serviceURLScheme is the scheme the stack's components are served over
+func serviceURLScheme(stack *monitoringv1alpha1.ObservabilityStack) string {
+	if tlsEnabled(stack) {
+		return "https"
+	}
+	return "http"
+}
+
+func tlsSecretName(stack *monitoringv1alpha1.ObservabilityStack, component string) string {
+	return fmt.Sprintf("%s-%s-tls", stack.Name, component)
+}
+
+// certificateDNSNames lists the names a component is reached by, including
+// the pods behind a headless Service.
+func certificateDNSNames(stack *monitoringv1alpha1.ObservabilityStack, component string) []string {
+	service := fmt.Sprintf("%s-%s", stack.Name, component)
+	return []string{
+		service,
+		fmt.Sprintf("%s.%s", service, stack.Namespace),
+		fmt.Sprintf("%s.%s.svc", service, stack.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", service, stack.Namespace),
+		fmt.Sprintf("*.%s.%s.svc", service, stack.Namespace),
+		fmt.Sprintf("*.%s.%s.svc.cluster.local", service, stack.Namespace),
+		"localhost",
+	}
+}
+
+// reconcileServingCertificate makes sure the component's TLS Secret holds a
+// current certificate, either issued by cert-manager or by the stack's
+// self-signed CA.
+func (r *ObservabilityStackReconciler) reconcileServingCertificate(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string) error {
+	if stack.Spec.TLS.Provider == monitoringv1alpha1.TLSProviderCertManager {
+		return r.reconcileCertManagerCertificate(ctx, stack, component)
+	}
+	return r.reconcileSelfSignedCertificate(ctx, stack, component)
+}
+
+func (r *ObservabilityStackReconciler) reconcileCertManagerCertificate(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string) error {
+	issuer := stack.Spec.TLS.IssuerRef
+	if issuer == nil {
+		return fmt.Errorf("spec.tls.issuerRef is required with the CertManager provider")
+	}
+
+	kind := issuer.Kind
+	if kind == "" {
+		kind = "Issuer"
+	}
+
+	dnsNames := []interface{}{}
+	for _, name := range certificateDNSNames(stack, component) {
+		dnsNames = append(dnsNames, name)
+	}
+
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetName(fmt.Sprintf("%s-%s", stack.Name, component))
+	certificate.SetNamespace(stack.Namespace)
+	certificate.SetLabels(map[string]string{
+		"app.kubernetes.io/name":       component,
+		"app.kubernetes.io/instance":   stack.Name,
+		"app.kubernetes.io/managed-by": "kube-insight-operator",
+	})
+	certificate.Object["spec"] = map[string]interface{}{
+		"secretName": tlsSecretName(stack, component),
+		"dnsNames":   dnsNames,
+		"issuerRef": map[string]interface{}{
+			"name":  issuer.Name,
+			"kind":  kind,
+			"group": certificateGVK.Group,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(stack, certificate, r.Scheme); err != nil {
+		return fmt.Errorf("failed to set controller reference on certificate: %w", err)
+	}
+
+	if err := r.createOrUpdate(ctx, stack, certificate); err != nil {
+		return fmt.Errorf("failed to reconcile %s Certificate: %w", component, err)
+	}
+	return nil
+}
+
+func (r *ObservabilityStackReconciler) reconcileSelfSignedCertificate(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string) error {
+	caCert, caKey, err := r.reconcileCA(ctx, stack)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	dnsNames := certificateDNSNames(stack, component)
+	name := tlsSecretName(stack, component)
+
+	existing := &corev1.Secret{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: name}, existing)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to get %s TLS Secret: %w", component, err)
+	}
+	if err == nil && certificateCurrent(existing.Data[corev1.TLSCertKey], caCert, dnsNames, now) {
+		return nil
+	}
+
+	cert, key, err := generateServingCertificate(caCert, caKey, dnsNames, now)
+	if err != nil {
+		return fmt.Errorf("failed to issue %s certificate: %w", component, err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: stack.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       component,
+				"app.kubernetes.io/instance":   stack.Name,
+				"app.kubernetes.io/managed-by": "kube-insight-operator",
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       cert,
+			corev1.TLSPrivateKeyKey: key,
+			"ca.crt":                caCert,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(stack, secret, r.Scheme); err != nil {
+		return fmt.Errorf("failed to set controller reference on secret: %w", err)
+	}
+
+	if err := r.createOrUpdate(ctx, stack, secret); err != nil {
+		return fmt.Errorf("failed to reconcile %s TLS Secret: %w", component, err)
+	}
+	return nil
+}
+
+// reconcileCA returns the stack's self-signed CA, creating it on first use and
+// replacing it once it is due for renewal. Certificates it signed are reissued
+// on their next reconciliation.
+func (r *ObservabilityStackReconciler) reconcileCA(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack) (cert, key []byte, err error) {
+	name := fmt.Sprintf("%s-ca", stack.Name)
+	now := time.Now()
+
+	existing := &corev1.Secret{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: stack.Namespace, Name: name}, existing)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, nil, fmt.Errorf("failed to get CA Secret: %w", err)
+	}
+	if err == nil && certificateCurrent(existing.Data[corev1.TLSCertKey], nil, nil, now) {
+		return existing.Data[corev1.TLSCertKey], existing.Data[corev1.TLSPrivateKeyKey], nil
+	}
+
+	cert, key, err = generateCA(fmt.Sprintf("%s.%s observability CA", stack.Name, stack.Namespace), now)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA: %w", err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: stack.Namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/instance":   stack.Name,
+				"app.kubernetes.io/managed-by": "kube-insight-operator",
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       cert,
+			corev1.TLSPrivateKeyKey: key,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(stack, secret, r.Scheme); err != nil {
+		return nil, nil, fmt.Errorf("failed to set controller reference on secret: %w", err)
+	}
+
+	if err := r.createOrUpdate(ctx, stack, secret); err != nil {
+		return nil, nil, fmt.Errorf("failed to reconcile CA Secret: %w", err)
+	}
+	return cert, key, nil
+}
+
+// mountServingCertificate mounts the component's TLS Secret at /etc/tls in
+// every container and switches HTTP probes to HTTPS.
+func mountServingCertificate(template *corev1.PodTemplateSpec, stack *monitoringv1alpha1.ObservabilityStack, component string) {
+	podSpec := &template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: tlsVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: tlsSecretName(stack, component)},
+		},
+	})
+
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      tlsVolume,
+			MountPath: tlsMountPath,
+			ReadOnly:  true,
+		})
+
+		for _, probe := range []*corev1.Probe{container.LivenessProbe, container.ReadinessProbe, container.StartupProbe} {
+			if probe != nil && probe.HTTPGet != nil {
+				probe.HTTPGet.Scheme = corev1.URISchemeHTTPS
+			}
+		}
+	}
+}
+
+// trustServerCAs mounts the CA of each server at /etc/tls-ca and points Go's
+// certificate loading at it through SSL_CERT_DIR, so clients trust the
+// stack's certificates next to the system roots.
+func trustServerCAs(template *corev1.PodTemplateSpec, stack *monitoringv1alpha1.ObservabilityStack, servers ...string) {
+	sources := []corev1.VolumeProjection{}
+	for _, server := range servers {
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: tlsSecretName(stack, server)},
+				Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: server + "-ca.crt"}},
+				// Servers that are disabled have no Secret
+				Optional: pointer.Bool(true),
+			},
+		})
+	}
+	if len(sources) == 0 {
+		return
+	}
+
+	podSpec := &template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: trustedCAVolume,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{Sources: sources},
+		},
+	})
+
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      trustedCAVolume,
+			MountPath: trustedCAMountPath,
+			ReadOnly:  true,
+		})
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  "SSL_CERT_DIR",
+			Value: "/etc/ssl/certs:" + trustedCAMountPath,
+		})
+	}
+}
+
+// setServerTLS enables HTTPS in a Loki or Tempo configuration file
+func setServerTLS(configMap *corev1.ConfigMap, key string) error {
+	return patchYAMLConfig(configMap, key, func(config map[string]interface{}) {
+		server, _ := config["server"].(map[string]interface{})
+		if server == nil {
+			server = map[string]interface{}{}
+		}
+		server["http_tls_config"] = map[string]interface{}{
+			"cert_file": tlsMountPath + "/" + corev1.TLSCertKey,
+			"key_file":  tlsMountPath + "/" + corev1.TLSPrivateKeyKey,
+		}
+		config["server"] = server
+	})
+}
+
+// setPrometheusTLS serves the Prometheus web UI and API over HTTPS through a
+// web.yml next to prometheus.yml, and switches Prometheus' scrape of itself
+// to HTTPS.
+func setPrometheusTLS(configMap *corev1.ConfigMap, stack *monitoringv1alpha1.ObservabilityStack) error {
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data["web.yml"] = fmt.Sprintf("tls_server_config:\n  cert_file: %s/%s\n  key_file: %s/%s\n",
+		tlsMountPath, corev1.TLSCertKey, tlsMountPath, corev1.TLSPrivateKeyKey)
+
+	return patchYAMLConfig(configMap, "prometheus.yml", func(config map[string]interface{}) {
+		scrapeConfigs, _ := config["scrape_configs"].([]interface{})
+		for _, sc := range scrapeConfigs {
+			job, ok := sc.(map[string]interface{})
+			if !ok || !scrapesTarget(job, fmt.Sprintf("localhost:%d", portPrometheus)) {
+				continue
+			}
+			job["scheme"] = "https"
+			job["tls_config"] = map[string]interface{}{
+				"ca_file":     tlsMountPath + "/ca.crt",
+				"server_name": fmt.Sprintf("%s-%s", stack.Name, componentPrometheus),
+			}
+		}
+	})
+}
+
+// scrapesTarget reports whether a scrape job has the target in a static config
+func scrapesTarget(job map[string]interface{}, target string) bool {
+	staticConfigs, _ := job["static_configs"].([]interface{})
+	for _, sc := range staticConfigs {
+		static, _ := sc.(map[string]interface{})
+		targets, _ := static["targets"].([]interface{})
+		for _, t := range targets {
+			if t == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setGrafanaTLS serves Grafana over HTTPS
+func setGrafanaTLS(container *corev1.Container) {
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "GF_SERVER_PROTOCOL", Value: "https"},
+		corev1.EnvVar{Name: "GF_SERVER_CERT_FILE", Value: tlsMountPath + "/" + corev1.TLSCertKey},
+		corev1.EnvVar{Name: "GF_SERVER_CERT_KEY", Value: tlsMountPath + "/" + corev1.TLSPrivateKeyKey},
+	)
+}
+
+// useHTTPSDataSources points the Grafana data sources of the stack's own
+// components at their HTTPS endpoints
+func useHTTPSDataSources(configMap *corev1.ConfigMap, stack *monitoringv1alpha1.ObservabilityStack) {
+	if datasources, ok := configMap.Data["datasources.yaml"]; ok {
+		configMap.Data["datasources.yaml"] = strings.ReplaceAll(datasources,
+			fmt.Sprintf("http://%s-", stack.Name), fmt.Sprintf("https://%s-", stack.Name))
+	}
+}
+
+// patchYAMLConfig edits a YAML file held in a ConfigMap
+func patchYAMLConfig(configMap *corev1.ConfigMap, key string, patch func(map[string]interface{})) error {
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(configMap.Data[key]), &config); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", key, err)
+	}
+
+	patch(config)
+
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	configMap.Data[key] = string(out)
+	return nil
+}
+
+// yamlConfigKeys returns the keys of a ConfigMap that hold YAML files
+func yamlConfigKeys(configMap *corev1.ConfigMap) []string {
+	var keys []string
+	for key := range configMap.Data {
+		if strings.HasSuffix(key, ".yaml") || strings.HasSuffix(key, ".yml") {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
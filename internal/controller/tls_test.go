@@ -0,0 +1,76 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("TLS", func() {
+	stack := &monitoringv1alpha1.ObservabilityStack{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "monitoring"},
+		Spec: monitoringv1alpha1.ObservabilityStackSpec{
+			TLS: monitoringv1alpha1.TLSSpec{Enabled: true},
+		},
+	}
+
+	It("should issue serving certificates that stay current until renewal", func() {
+		now := time.Now()
+		caCert, caKey, err := generateCA("test CA", now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certificateCurrent(caCert, nil, nil, now)).To(BeTrue())
+
+		dnsNames := certificateDNSNames(stack, componentLoki)
+		cert, _, err := generateServingCertificate(caCert, caKey, dnsNames, now)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(certificateCurrent(cert, caCert, dnsNames, now)).To(BeTrue())
+		Expect(certificateCurrent(cert, caCert, append(dnsNames, "other"), now)).To(BeFalse())
+		Expect(certificateCurrent(cert, caCert, dnsNames, now.Add(certificateValidity-renewBefore/2))).To(BeFalse())
+
+		otherCA, _, err := generateCA("other CA", now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(certificateCurrent(cert, otherCA, dnsNames, now)).To(BeFalse())
+	})
+
+	It("should enable HTTPS in a Loki configuration", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{
+			"loki.yaml": "auth_enabled: false\nserver:\n  http_listen_port: 3100\n",
+		}}
+
+		Expect(setServerTLS(configMap, "loki.yaml")).To(Succeed())
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("http_listen_port: 3100"))
+		Expect(configMap.Data["loki.yaml"]).To(ContainSubstring("cert_file: /etc/tls/tls.crt"))
+	})
+
+	It("should use HTTPS for the stack's own URLs", func() {
+		Expect(serviceURL(stack, componentLoki, portLokiHTTP)).To(Equal("https://test-loki:3100"))
+
+		configMap := &corev1.ConfigMap{Data: map[string]string{
+			"datasources.yaml": "url: http://test-prometheus:9090\n---\nurl: http://example.com\n",
+		}}
+		useHTTPSDataSources(configMap, stack)
+		Expect(configMap.Data["datasources.yaml"]).To(Equal("url: https://test-prometheus:9090\n---\nurl: http://example.com\n"))
+	})
+})
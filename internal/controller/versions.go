@@ -0,0 +1,597 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// upgradeTimeout is how long an upgraded workload has to roll out and
+	// become ready before the previous version is restored
+	upgradeTimeout = 10 * time.Minute
+
+	// upgradeRequeue is how often a stack is reconciled while an upgrade
+	// rolls out, so a stuck upgrade is noticed without a workload event
+	upgradeRequeue = 30 * time.Second
+
+	reasonUnsupportedVersion = "UnsupportedVersion"
+	reasonUpgrading          = "Upgrading"
+	reasonUpgraded           = "Upgraded"
+	reasonUpgradeBlocked     = "UpgradeBlocked"
+	reasonRolledBack         = "RolledBack"
+)
+
+// componentRelease describes the images the operator deploys for a component
+type componentRelease struct {
+	// image is the repository; its tags are tagPrefix followed by the version
+	image     string
+	tagPrefix string
+
+	// versions the operator is tested with, oldest first
+	versions []string
+
+	// defaultVersion is deployed when the spec does not ask for a version
+	defaultVersion string
+}
+
+// supportedVersions is the version matrix of the operator. Versions are kept
+// without the "v" some projects put in their tags.
+var supportedVersions = map[string]componentRelease{
+	componentPrometheus: {
+		image:          "prom/prometheus",
+		tagPrefix:      "v",
+		versions:       []string{"2.45.0", "2.47.2", "2.51.2"},
+		defaultVersion: "2.45.0",
+	},
+	componentKubeStateMetrics: {
+		image:          "registry.k8s.io/kube-state-metrics/kube-state-metrics",
+		tagPrefix:      "v",
+		versions:       []string{"2.10.0", "2.10.1", "2.12.0"},
+		defaultVersion: "2.10.0",
+	},
+	componentNodeExporter: {
+		image:          "quay.io/prometheus/node-exporter",
+		tagPrefix:      "v",
+		versions:       []string{"1.7.0", "1.8.0"},
+		defaultVersion: "1.7.0",
+	},
+	componentGrafana: {
+		image:          "grafana/grafana",
+		versions:       []string{"9.5.3", "10.2.3", "10.4.2"},
+		defaultVersion: "9.5.3",
+	},
+	componentLoki: {
+		image:          "grafana/loki",
+		versions:       []string{"2.8.4", "2.9.8", "3.0.0"},
+		defaultVersion: "2.8.4",
+	},
+	componentPromtail: {
+		image:          "grafana/promtail",
+		versions:       []string{"2.8.4", "2.9.8", "3.0.0"},
+		defaultVersion: "2.8.4",
+	},
+	componentTempo: {
+		image:          "grafana/tempo",
+		versions:       []string{"2.2.0", "2.3.1", "2.4.1"},
+		defaultVersion: "2.2.0",
+	},
+	componentThanos: {
+		image:          "quay.io/thanos/thanos",
+		tagPrefix:      "v",
+		versions:       []string{"0.32.5", "0.34.1", "0.35.1"},
+		defaultVersion: "0.34.1",
+	},
+}
+
+// upgradeOrder lists the components in the order they are upgraded.
+// Collectors go first, so they keep shipping to backends that are not
+// upgraded yet; Grafana goes last, once every data source it queries is.
+var upgradeOrder = []string{
+	componentPromtail,
+	componentKubeStateMetrics,
+	componentPrometheus,
+	componentLoki,
+	componentTempo,
+	componentGrafana,
+}
+
+// upgradeTiers groups the components of upgradeOrder that may be upgraded
+// together
+var upgradeTiers = map[string]int{
+	componentPromtail:         0,
+	componentKubeStateMetrics: 0,
+	componentPrometheus:       1,
+	componentLoki:             1,
+	componentTempo:            1,
+	componentGrafana:          2,
+}
+
+// UnsupportedVersionError reports a version that is not in the operator's
+// version matrix
+type UnsupportedVersionError struct {
+	Component string
+	Field     string
+	Value     string
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("%s has unsupported version %q; supported versions are %s",
+		e.Field, e.Value, strings.Join(supportedVersions[e.Component].versions, ", "))
+}
+
+// resolveVersion returns the version to deploy for a component given the one
+// its spec asks for at field, falling back to the default.
+func resolveVersion(component, field, requested string) (string, error) {
+	release := supportedVersions[component]
+	if requested == "" {
+		return release.defaultVersion, nil
+	}
+
+	version := strings.TrimPrefix(requested, "v")
+	for _, supported := range release.versions {
+		if supported == version {
+			return version, nil
+		}
+	}
+	return "", &UnsupportedVersionError{Component: component, Field: field, Value: requested}
+}
+
+// componentImage returns the image of a component at a version
+func componentImage(component, version string) string {
+	release := supportedVersions[component]
+	return fmt.Sprintf("%s:%s%s", release.image, release.tagPrefix, version)
+}
+
+// imageVersion returns the version in an image's tag, or "" for an untagged image
+func imageVersion(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	return strings.TrimPrefix(image[i+1:], "v")
+}
+
+// specVersion returns the version a stack's spec asks for a component
+func specVersion(stack *monitoringv1alpha1.ObservabilityStack, component string) string {
+	switch component {
+	case componentPrometheus:
+		return stack.Spec.Prometheus.Version
+	case componentKubeStateMetrics:
+		return stack.Spec.Prometheus.KubeStateMetrics.Version
+	case componentGrafana:
+		return stack.Spec.Grafana.Version
+	case componentLoki:
+		return stack.Spec.Loki.Version
+	case componentPromtail:
+		return stack.Spec.Promtail.Version
+	case componentTempo:
+		return stack.Spec.Tempo.Version
+	}
+	return ""
+}
+
+// desiredVersion returns the version a stack's component should run
+func desiredVersion(stack *monitoringv1alpha1.ObservabilityStack, component string) (string, error) {
+	return resolveVersion(component, specPaths[component]+".version", specVersion(stack, component))
+}
+
+// componentVersion decides which version of a component to deploy and records
+// it in the stack's status. A version change is held back while components
+// earlier in upgradeOrder are still upgrading, and blocked when the
+// component's configuration is not compatible with the new version. Once
+// started, an upgrade must roll out and become ready within upgradeTimeout,
+// or the previous version is restored until the spec asks for another one.
+// config is the component's configuration, for the compatibility checks.
+// The shared collectors of a ClusterObservabilityStack are not upgraded this
+// way; they deploy whatever resolveVersion returns.
+func (r *ObservabilityStackReconciler) componentVersion(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string, config *corev1.ConfigMap) (string, error) {
+	desired, err := desiredVersion(stack, component)
+	if err != nil {
+		return "", err
+	}
+
+	workload, current, err := r.runningVersion(ctx, stack, component)
+	if err != nil {
+		return "", err
+	}
+
+	entry := monitoringv1alpha1.ComponentVersion{Name: component}
+	if status := findComponentVersion(stack.Status.Components, component); status != nil {
+		entry = *status
+	}
+	upgrading := entry.Phase == monitoringv1alpha1.UpgradePhaseUpgrading
+
+	switch {
+	case current == "" || (current == desired && !upgrading):
+		entry = monitoringv1alpha1.ComponentVersion{
+			Name:    component,
+			Current: desired,
+			Desired: desired,
+			Phase:   monitoringv1alpha1.UpgradePhaseCurrent,
+		}
+		return desired, r.setComponentVersion(ctx, stack, entry, "", "")
+
+	case current == desired:
+		if workloadRolledOut(workload) {
+			entry.Current = desired
+			entry.Phase = monitoringv1alpha1.UpgradePhaseCurrent
+			entry.Message = ""
+			return desired, r.setComponentVersion(ctx, stack, entry, reasonUpgraded,
+				fmt.Sprintf("Upgraded %s from %s to %s", component, entry.Previous, desired))
+		}
+		if entry.Previous == "" || entry.LastTransitionTime == nil || r.now().Sub(entry.LastTransitionTime.Time) < upgradeTimeout {
+			return desired, nil
+		}
+
+		entry.Phase = monitoringv1alpha1.UpgradePhaseRolledBack
+		entry.Message = fmt.Sprintf("%s %s was not ready within %s; rolled back to %s", component, desired, upgradeTimeout, entry.Previous)
+		return entry.Previous, r.setComponentVersion(ctx, stack, entry, reasonRolledBack, entry.Message)
+
+	case entry.Phase == monitoringv1alpha1.UpgradePhaseRolledBack && entry.Desired == desired:
+		// Stay on the restored version until the spec asks for another one
+		return current, nil
+	}
+
+	entry.Current = current
+	entry.Desired = desired
+
+	earlier, err := r.pendingUpgrade(ctx, stack, component)
+	if err != nil {
+		return "", err
+	}
+	if earlier != "" {
+		entry.Phase = monitoringv1alpha1.UpgradePhaseWaiting
+		entry.Message = fmt.Sprintf("Waiting for %s to be upgraded first", earlier)
+		return current, r.setComponentVersion(ctx, stack, entry, "", "")
+	}
+
+	if err := checkCompatibility(component, current, desired, config); err != nil {
+		entry.Phase = monitoringv1alpha1.UpgradePhaseBlocked
+		entry.Message = err.Error()
+		return current, r.setComponentVersion(ctx, stack, entry, reasonUpgradeBlocked,
+			fmt.Sprintf("Not upgrading %s to %s: %v", component, desired, err))
+	}
+
+	entry.Previous = current
+	entry.Phase = monitoringv1alpha1.UpgradePhaseUpgrading
+	entry.Message = ""
+	return desired, r.setComponentVersion(ctx, stack, entry, reasonUpgrading,
+		fmt.Sprintf("Upgrading %s from %s to %s", component, current, desired))
+}
+
+// runningVersion returns a component's workload and the version of its image,
+// or nil and "" before the workload exists
+func (r *ObservabilityStackReconciler) runningVersion(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string) (client.Object, string, error) {
+	workload := componentWorkload(stack, component)
+	key := client.ObjectKey{Namespace: stack.Namespace, Name: fmt.Sprintf("%s-%s", stack.Name, component)}
+	if err := r.Get(ctx, key, workload); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("failed to get %s workload: %w", component, err)
+	}
+
+	template := workloadTemplate(workload)
+	if template == nil || len(template.Spec.Containers) == 0 {
+		return workload, "", nil
+	}
+	return workload, imageVersion(template.Spec.Containers[0].Image), nil
+}
+
+// pendingUpgrade returns the first component before component in
+// upgradeOrder that does not run its desired version yet, or "" if there is
+// none.
+func (r *ObservabilityStackReconciler) pendingUpgrade(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, component string) (string, error) {
+	enabled := enabledComponents(stack)
+	for _, earlier := range upgradeOrder {
+		if upgradeTiers[earlier] >= upgradeTiers[component] {
+			break
+		}
+		if !enabled[earlier] || unmanaged(stack, earlier) {
+			continue
+		}
+
+		status := findComponentVersion(stack.Status.Components, earlier)
+		if status != nil && status.Phase == monitoringv1alpha1.UpgradePhaseUpgrading {
+			return earlier, nil
+		}
+
+		desired, err := desiredVersion(stack, earlier)
+		if err != nil {
+			// Its own reconciliation reports the invalid version
+			return earlier, nil
+		}
+		_, current, err := r.runningVersion(ctx, stack, earlier)
+		if err != nil {
+			return "", err
+		}
+		if current != "" && current != desired {
+			return earlier, nil
+		}
+	}
+	return "", nil
+}
+
+// setComponentVersion stores a component's entry in the stack's status,
+// stamping the transition time and emitting the event when its phase changed.
+// The status is written right away, so an upgrade in progress is not
+// forgotten when a later component fails to reconcile.
+func (r *ObservabilityStackReconciler) setComponentVersion(ctx context.Context, stack *monitoringv1alpha1.ObservabilityStack, entry monitoringv1alpha1.ComponentVersion, reason, message string) error {
+	existing := findComponentVersion(stack.Status.Components, entry.Name)
+	phaseChanged := existing == nil || existing.Phase != entry.Phase
+	if !phaseChanged {
+		entry.LastTransitionTime = existing.LastTransitionTime
+		if equality.Semantic.DeepEqual(*existing, entry) {
+			return nil
+		}
+	} else {
+		now := metav1.NewTime(r.now())
+		entry.LastTransitionTime = &now
+	}
+
+	if existing != nil {
+		*existing = entry
+	} else {
+		stack.Status.Components = append(stack.Status.Components, entry)
+	}
+
+	if err := r.Status().Update(ctx, stack); err != nil {
+		return fmt.Errorf("failed to update ObservabilityStack status: %w", err)
+	}
+
+	if phaseChanged && reason != "" {
+		eventType := corev1.EventTypeNormal
+		if entry.Phase == monitoringv1alpha1.UpgradePhaseBlocked || entry.Phase == monitoringv1alpha1.UpgradePhaseRolledBack {
+			eventType = corev1.EventTypeWarning
+		}
+		r.Recorder.Event(stack, eventType, reason, message)
+	}
+	return nil
+}
+
+// now returns the time on the reconciler's clock
+func (r *ObservabilityStackReconciler) now() time.Time {
+	if r.Clock == nil {
+		return time.Now()
+	}
+	return r.Clock.Now()
+}
+
+func findComponentVersion(versions []monitoringv1alpha1.ComponentVersion, component string) *monitoringv1alpha1.ComponentVersion {
+	for i := range versions {
+		if versions[i].Name == component {
+			return &versions[i]
+		}
+	}
+	return nil
+}
+
+// upgrading reports whether any component of the stack is rolling out a new
+// version
+func upgrading(stack *monitoringv1alpha1.ObservabilityStack) bool {
+	for _, entry := range stack.Status.Components {
+		if entry.Phase == monitoringv1alpha1.UpgradePhaseUpgrading {
+			return true
+		}
+	}
+	return false
+}
+
+// workloadTemplate returns the pod template of a workload
+func workloadTemplate(workload client.Object) *corev1.PodTemplateSpec {
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		return &w.Spec.Template
+	case *appsv1.Deployment:
+		return &w.Spec.Template
+	case *appsv1.DaemonSet:
+		return &w.Spec.Template
+	}
+	return nil
+}
+
+// setContainerImage sets the image of the component's container, the first
+// of the pod
+func setContainerImage(template *corev1.PodTemplateSpec, image string) {
+	if len(template.Spec.Containers) > 0 {
+		template.Spec.Containers[0].Image = image
+	}
+}
+
+// workloadRolledOut reports whether a workload's current template runs on
+// every replica and all of them are ready
+func workloadRolledOut(workload client.Object) bool {
+	if !workloadReady(workload) {
+		return false
+	}
+
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		desired := int32(1)
+		if w.Spec.Replicas != nil {
+			desired = *w.Spec.Replicas
+		}
+		return w.Status.ObservedGeneration >= w.Generation && w.Status.UpdatedReplicas >= desired
+	case *appsv1.Deployment:
+		desired := int32(1)
+		if w.Spec.Replicas != nil {
+			desired = *w.Spec.Replicas
+		}
+		return w.Status.ObservedGeneration >= w.Generation && w.Status.UpdatedReplicas >= desired
+	case *appsv1.DaemonSet:
+		return w.Status.ObservedGeneration >= w.Generation && w.Status.UpdatedNumberScheduled >= w.Status.DesiredNumberScheduled
+	}
+	return false
+}
+
+// compatibilityCheck guards version changes across a release that changed how
+// a component stores its data
+type compatibilityCheck struct {
+	component string
+	boundary  string
+	// check returns why moving from one side of boundary to the other is not
+	// safe with the configuration
+	check func(upgrade bool, config map[string]interface{}) error
+}
+
+var compatibilityChecks = []compatibilityCheck{
+	{
+		// Loki 3 refuses to start with structured metadata, on by default,
+		// unless the newest schema period uses the v13 schema on TSDB
+		component: componentLoki,
+		boundary:  "3.0.0",
+		check: func(upgrade bool, config map[string]interface{}) error {
+			if !upgrade {
+				return fmt.Errorf("downgrading Loki below 3.0 is not supported")
+			}
+			if limits, ok := config["limits_config"].(map[string]interface{}); ok && limits["allow_structured_metadata"] == false {
+				return nil
+			}
+
+			schema, _ := config["schema_config"].(map[string]interface{})
+			periods, _ := schema["configs"].([]interface{})
+			if len(periods) > 0 {
+				if latest, ok := periods[len(periods)-1].(map[string]interface{}); ok && latest["schema"] == "v13" && latest["store"] == "tsdb" {
+					return nil
+				}
+			}
+			return fmt.Errorf("Loki 3 needs a v13 schema period on the tsdb store, or limits_config.allow_structured_metadata set to false")
+		},
+	},
+	{
+		// Tempo 2.3 introduced the vParquet3 block format, which earlier
+		// versions cannot read
+		component: componentTempo,
+		boundary:  "2.3.0",
+		check: func(upgrade bool, config map[string]interface{}) error {
+			if upgrade {
+				return nil
+			}
+
+			storage, _ := config["storage"].(map[string]interface{})
+			trace, _ := storage["trace"].(map[string]interface{})
+			block, _ := trace["block"].(map[string]interface{})
+			if version, _ := block["version"].(string); version == "vParquet2" || version == "vParquet" || version == "v2" {
+				return nil
+			}
+			return fmt.Errorf("Tempo before 2.3 cannot read vParquet3 blocks; pin storage.trace.block.version to vParquet2 before downgrading")
+		},
+	},
+}
+
+// checkCompatibility runs the compatibility checks a version change from
+// current to desired crosses against the component's configuration
+func checkCompatibility(component, current, desired string, configMap *corev1.ConfigMap) error {
+	from, err := parseVersion(current)
+	if err != nil {
+		// A version the operator did not deploy; nothing is known about it
+		return nil
+	}
+	to, err := parseVersion(desired)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range compatibilityChecks {
+		if c.component != component {
+			continue
+		}
+		boundary, err := parseVersion(c.boundary)
+		if err != nil {
+			return err
+		}
+
+		upgrade := compareVersions(from, boundary) < 0 && compareVersions(to, boundary) >= 0
+		downgrade := compareVersions(from, boundary) >= 0 && compareVersions(to, boundary) < 0
+		if !upgrade && !downgrade {
+			continue
+		}
+
+		config, err := parseConfigs(configMap)
+		if err != nil {
+			return err
+		}
+		if err := c.check(upgrade, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseConfigs merges the top-level keys of every YAML file of a ConfigMap
+func parseConfigs(configMap *corev1.ConfigMap) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+	if configMap == nil {
+		return merged, nil
+	}
+
+	for _, key := range yamlConfigKeys(configMap) {
+		config := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(configMap.Data[key]), &config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", key, err)
+		}
+		for k, v := range config {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+func parseVersion(version string) ([3]int, error) {
+	var parsed [3]int
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) != 3 {
+		return parsed, fmt.Errorf("invalid version %q", version)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return parsed, fmt.Errorf("invalid version %q: %w", version, err)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
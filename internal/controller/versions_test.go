@@ -0,0 +1,190 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	monitoringv1alpha1 "github.com/johnwroge/kube-insight-operator/api/v1alpha1"
+)
+
+var _ = Describe("Component versions", func() {
+	It("should only deploy versions from the matrix", func() {
+		version, err := resolveVersion(componentPrometheus, "spec.prometheus.version", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(componentImage(componentPrometheus, version)).To(Equal("prom/prometheus:v2.45.0"))
+
+		version, err = resolveVersion(componentPrometheus, "spec.prometheus.version", "v2.47.2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(version).To(Equal("2.47.2"))
+
+		_, err = resolveVersion(componentLoki, "spec.loki.version", "3.1.0")
+		var versionErr *UnsupportedVersionError
+		Expect(err).To(BeAssignableToTypeOf(versionErr))
+		Expect(err.Error()).To(ContainSubstring("spec.loki.version"))
+	})
+
+	It("should read the version from an image tag", func() {
+		Expect(imageVersion("prom/prometheus:v2.45.0")).To(Equal("2.45.0"))
+		Expect(imageVersion("registry:5000/grafana/loki:2.8.4@sha256:abc")).To(Equal("2.8.4"))
+		Expect(imageVersion("registry:5000/grafana/loki")).To(BeEmpty())
+	})
+
+	It("should block Loki 3 until the schema supports it", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"loki.yaml": `schema_config:
+  configs:
+  - from: "2023-01-01"
+    schema: v12
+    store: boltdb-shipper
+`}}
+		Expect(checkCompatibility(componentLoki, "2.9.8", "3.0.0", configMap)).To(MatchError(ContainSubstring("v13")))
+		Expect(checkCompatibility(componentLoki, "2.8.4", "2.9.8", configMap)).To(Succeed())
+
+		configMap.Data["loki.yaml"] += `  - from: "2024-06-01"
+    schema: v13
+    store: tsdb
+`
+		Expect(checkCompatibility(componentLoki, "2.9.8", "3.0.0", configMap)).To(Succeed())
+		Expect(checkCompatibility(componentLoki, "3.0.0", "2.9.8", configMap)).NotTo(Succeed())
+	})
+
+	It("should block Tempo downgrades that cannot read vParquet3 blocks", func() {
+		configMap := &corev1.ConfigMap{Data: map[string]string{"tempo.yaml": "storage:\n  trace:\n    backend: local\n"}}
+		Expect(checkCompatibility(componentTempo, "2.2.0", "2.4.1", configMap)).To(Succeed())
+		Expect(checkCompatibility(componentTempo, "2.4.1", "2.2.0", configMap)).NotTo(Succeed())
+
+		configMap.Data["tempo.yaml"] = "storage:\n  trace:\n    block:\n      version: vParquet2\n"
+		Expect(checkCompatibility(componentTempo, "2.4.1", "2.2.0", configMap)).To(Succeed())
+	})
+
+	Context("when a component's version changes", func() {
+		var (
+			stack    *monitoringv1alpha1.ObservabilityStack
+			clock    *clocktesting.FakeClock
+			recorder *record.FakeRecorder
+		)
+
+		BeforeEach(func() {
+			stack = &monitoringv1alpha1.ObservabilityStack{
+				ObjectMeta: metav1.ObjectMeta{Name: "upgrade-test", Namespace: "default"},
+			}
+			stack.Spec.Prometheus.Enabled = true
+			stack.Spec.Prometheus.KubeStateMetrics.Enabled = true
+			stack.Spec.Loki.Enabled = true
+			clock = clocktesting.NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+			recorder = record.NewFakeRecorder(10)
+		})
+
+		reconciler := func(objs ...client.Object) *ObservabilityStackReconciler {
+			c := newFakeClient(append(objs, stack)...)
+			return &ObservabilityStackReconciler{Client: c, Scheme: c.Scheme(), Recorder: recorder, Clock: clock}
+		}
+
+		It("should wait for the components of an earlier tier", func() {
+			stack.Spec.Prometheus.KubeStateMetrics.Version = "2.12.0"
+			stack.Spec.Prometheus.Version = "2.47.2"
+			r := reconciler(
+				versionedWorkload(&appsv1.Deployment{}, "upgrade-test-kube-state-metrics", componentKubeStateMetrics, "2.10.0"),
+				versionedWorkload(&appsv1.StatefulSet{}, "upgrade-test-prometheus", componentPrometheus, "2.45.0"),
+			)
+
+			version, err := r.componentVersion(context.Background(), stack, componentPrometheus, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal("2.45.0"))
+
+			entry := findComponentVersion(stack.Status.Components, componentPrometheus)
+			Expect(entry.Phase).To(Equal(monitoringv1alpha1.UpgradePhaseWaiting))
+			Expect(entry.Message).To(ContainSubstring(componentKubeStateMetrics))
+			Expect(entry.LastTransitionTime.Time).To(BeTemporally("==", clock.Now()))
+		})
+
+		It("should block an upgrade the configuration does not support", func() {
+			stack.Spec.Loki.Version = "3.0.0"
+			r := reconciler(versionedWorkload(&appsv1.StatefulSet{}, "upgrade-test-loki", componentLoki, "2.9.8"))
+			config := &corev1.ConfigMap{Data: map[string]string{"loki.yaml": "schema_config:\n  configs:\n  - schema: v12\n    store: boltdb-shipper\n"}}
+
+			version, err := r.componentVersion(context.Background(), stack, componentLoki, config)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal("2.9.8"))
+			Expect(findComponentVersion(stack.Status.Components, componentLoki).Phase).To(Equal(monitoringv1alpha1.UpgradePhaseBlocked))
+			Expect(<-recorder.Events).To(HavePrefix("Warning UpgradeBlocked"))
+		})
+
+		It("should roll back an upgrade that is not ready in time", func() {
+			stack.Spec.Prometheus.Version = "2.47.2"
+			started := metav1.NewTime(clock.Now())
+			stack.Status.Components = []monitoringv1alpha1.ComponentVersion{{
+				Name:               componentPrometheus,
+				Current:            "2.45.0",
+				Desired:            "2.47.2",
+				Previous:           "2.45.0",
+				Phase:              monitoringv1alpha1.UpgradePhaseUpgrading,
+				LastTransitionTime: &started,
+			}}
+			workload := versionedWorkload(&appsv1.StatefulSet{}, "upgrade-test-prometheus", componentPrometheus, "2.47.2")
+			r := reconciler(workload)
+
+			clock.Step(upgradeTimeout / 2)
+			version, err := r.componentVersion(context.Background(), stack, componentPrometheus, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal("2.47.2"))
+			Expect(recorder.Events).To(BeEmpty())
+
+			clock.Step(upgradeTimeout)
+			version, err = r.componentVersion(context.Background(), stack, componentPrometheus, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal("2.45.0"))
+			Expect(findComponentVersion(stack.Status.Components, componentPrometheus).Phase).To(Equal(monitoringv1alpha1.UpgradePhaseRolledBack))
+			Expect(<-recorder.Events).To(HavePrefix("Warning RolledBack"))
+
+			// The restored version stays until the spec asks for another one
+			Expect(r.Get(context.Background(), client.ObjectKeyFromObject(workload), workload)).To(Succeed())
+			setContainerImage(workloadTemplate(workload), componentImage(componentPrometheus, "2.45.0"))
+			Expect(r.Update(context.Background(), workload)).To(Succeed())
+			version, err = r.componentVersion(context.Background(), stack, componentPrometheus, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal("2.45.0"))
+		})
+	})
+})
+
+// versionedWorkload names workload and gives its pods the image of a
+// component at version, with one replica that is not ready
+func versionedWorkload(workload client.Object, name, component, version string) client.Object {
+	workload.SetName(name)
+	workload.SetNamespace("default")
+	template := workloadTemplate(workload)
+	template.Spec.Containers = []corev1.Container{{Name: component, Image: componentImage(component, version)}}
+	switch w := workload.(type) {
+	case *appsv1.StatefulSet:
+		w.Spec.Replicas = pointer.Int32(1)
+	case *appsv1.Deployment:
+		w.Spec.Replicas = pointer.Int32(1)
+	}
+	return workload
+}